@@ -0,0 +1,367 @@
+package agent
+
+import (
+	"accompany-sdk/ai/catalog"
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/ternary"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrToolExists     = errors.New("agent: tool already registered")
+	ErrMaxSteps       = errors.New("agent: max steps exceeded")
+	ErrTokenBudget    = errors.New("agent: token budget exceeded")
+	ErrCostBudget     = errors.New("agent: cost budget exceeded")
+	ErrUnknownPrice   = errors.New("agent: model price is unknown, cost budget can not be enforced")
+	ErrCurrency       = errors.New("agent: model price currency does not match the cost budget")
+	ErrEmptyMessages  = errors.New("agent: messages is empty")
+	ErrInvalidTool    = errors.New("agent: tool name and handler are required")
+	ErrEmptyModelTurn = errors.New("agent: model returned neither text nor tool calls")
+)
+
+const (
+	// DefaultMaxSteps 默认的最大模型调用次数
+	DefaultMaxSteps = 8
+	// DefaultTimeout 默认的整体超时时间
+	DefaultTimeout = 5 * time.Minute
+)
+
+// Handler 工具的执行函数，arguments 为模型生成的 JSON 格式参数，返回值作为工具调用结果发送给模型
+type Handler func(ctx context.Context, arguments string) (string, error)
+
+// Tool 注册到 Agent 中的工具
+type Tool struct {
+	// Name 工具名称，模型通过该名称调用工具
+	Name string
+	// Description 工具描述，模型根据描述决定何时调用该工具
+	Description string
+	// Parameters 工具参数，JSON Schema 格式
+	Parameters any
+	// Handler 工具的执行函数
+	Handler Handler
+}
+
+// Options Agent 的运行限制，值为 0 时使用默认值或者不限制
+type Options struct {
+	// MaxSteps 最大模型调用次数，为 0 时使用 DefaultMaxSteps
+	MaxSteps int
+	// Timeout 整体超时时间，为 0 时使用 DefaultTimeout
+	Timeout time.Duration
+	// MaxTokens 输入 + 输出的最大 token 数，为 0 时不限制
+	MaxTokens int
+	// MaxCost 最大费用，根据模型目录中的价格计算，为 0 时不限制
+	// 设置后模型价格未知时返回 ErrUnknownPrice，不同货币单位的价格不会累加，返回 ErrCurrency
+	MaxCost float64
+	// Currency MaxCost 的货币单位，为空时使用请求模型价格的货币单位
+	Currency string
+	// ResolveModel 把请求中的模型名称解析为模型目录中的名称（如去掉 openai: 前缀），为 nil 时直接使用请求中的模型名称
+	ResolveModel func(model string) string
+}
+
+const (
+	StepTypeModel = "model"
+	StepTypeTool  = "tool"
+)
+
+// Step Agent 运行过程中的一个步骤，每次模型调用和工具调用都会产生一个步骤
+type Step struct {
+	// Index 步骤序号，从 1 开始
+	Index int `json:"index"`
+	// Type 步骤类型，可选值为 model/tool
+	Type string `json:"type"`
+	// Text 模型输出的文本内容
+	Text string `json:"text,omitempty"`
+	// ToolCalls 模型发起的工具调用
+	ToolCalls []chat.ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID 工具调用 ID，只有 tool 步骤有值
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// ToolName 工具名称，只有 tool 步骤有值
+	ToolName string `json:"tool_name,omitempty"`
+	// Output 工具调用结果
+	Output string `json:"output,omitempty"`
+	// Error 工具执行失败的原因
+	Error string `json:"error,omitempty"`
+	// InputTokens 本次模型调用的输入 token 数
+	InputTokens int `json:"input_tokens,omitempty"`
+	// OutputTokens 本次模型调用的输出 token 数
+	OutputTokens int `json:"output_tokens,omitempty"`
+}
+
+// Observer 接收 Agent 运行过程中的步骤通知
+type Observer interface {
+	OnStep(step Step)
+}
+
+// ObserverFunc 函数形式的 Observer
+type ObserverFunc func(step Step)
+
+func (f ObserverFunc) OnStep(step Step) {
+	f(step)
+}
+
+// Result Agent 运行结果
+type Result struct {
+	// Text 模型最终输出的文本内容
+	Text string `json:"text"`
+	// FinishReason 模型最终的结束原因
+	FinishReason string `json:"finish_reason,omitempty"`
+	// Messages 完整的对话过程，包含工具调用以及工具调用结果
+	Messages chat.Messages `json:"messages"`
+	// Steps 运行的步骤数
+	Steps        int     `json:"steps"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost,omitempty"`
+	// Currency Cost 的货币单位，价格为其它货币单位的模型调用不计入 Cost
+	Currency string `json:"currency,omitempty"`
+}
+
+// Agent 在 chat.Chat 之上执行 模型 -> 工具 -> 模型 的循环，直到模型不再调用工具
+type Agent struct {
+	backend chat.Chat
+	opts    Options
+
+	lock  sync.RWMutex
+	tools map[string]Tool
+}
+
+func New(backend chat.Chat, opts Options) *Agent {
+	if opts.MaxSteps <= 0 {
+		opts.MaxSteps = DefaultMaxSteps
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	return &Agent{
+		backend: backend,
+		opts:    opts,
+		tools:   make(map[string]Tool),
+	}
+}
+
+// Register 注册工具，同名工具已存在时返回 ErrToolExists
+func (a *Agent) Register(tools ...Tool) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, tool := range tools {
+		if tool.Name == "" || tool.Handler == nil {
+			return ErrInvalidTool
+		}
+
+		if _, ok := a.tools[tool.Name]; ok {
+			return fmt.Errorf("%w: %s", ErrToolExists, tool.Name)
+		}
+
+		a.tools[tool.Name] = tool
+	}
+
+	return nil
+}
+
+// Tools 返回已注册工具的定义，按名称排序
+func (a *Agent) Tools() []chat.Tool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	tools := make([]chat.Tool, 0, len(a.tools))
+	for _, tool := range a.tools {
+		tools = append(tools, chat.Tool{
+			Type: chat.ToolTypeFunction,
+			Function: &chat.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Function.Name < tools[j].Function.Name })
+	return tools
+}
+
+func (a *Agent) tool(name string) (Tool, bool) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	tool, ok := a.tools[name]
+	return tool, ok
+}
+
+// Run 运行 Agent，observer 可以为 nil；超出限制时返回已经产生的结果以及对应的错误
+func (a *Agent) Run(ctx context.Context, req chat.Request, observer Observer) (*Result, error) {
+	if len(req.Messages) == 0 {
+		return nil, ErrEmptyMessages
+	}
+
+	if observer == nil {
+		observer = ObserverFunc(func(Step) {})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.opts.Timeout)
+	defer cancel()
+
+	req = req.Clone()
+	req.Stream = false
+	req.Tools = append(req.Tools, a.Tools()...)
+
+	result := &Result{Messages: req.Messages, Currency: a.opts.Currency}
+	if _, err := a.price(req.ActualModel(), result); err != nil {
+		return nil, err
+	}
+
+	for step := 0; step < a.opts.MaxSteps; step++ {
+		// 工具调用结果会不断累积，每一步都按照模型的上下文长度缩减发送的历史消息，result 中保留完整的对话
+		req.Messages = result.Messages
+		stepReq, _, err := req.Fix(ctx, a.backend, nil, int64(len(req.Messages)), a.backend.MaxContextLength(req.ActualModel()))
+		if err != nil {
+			return result, err
+		}
+
+		res, err := a.backend.Chat(ctx, *stepReq)
+		if err != nil {
+			return result, err
+		}
+
+		result.Steps++
+		result.InputTokens += res.InputTokens
+		result.OutputTokens += res.OutputTokens
+
+		// 经过降级链时每一步实际使用的模型可能不同，优先使用响应中的模型计算费用
+		price, err := a.price(ternary.If(res.Model != "", res.Model, req.ActualModel()), result)
+		if err != nil {
+			return result, err
+		}
+		result.Cost += float64(res.InputTokens)/1000*price.InputPrice + float64(res.OutputTokens)/1000*price.OutputPrice
+		result.Text, result.FinishReason = res.Text, res.FinishReason
+
+		observer.OnStep(Step{
+			Index:        result.Steps,
+			Type:         StepTypeModel,
+			Text:         res.Text,
+			ToolCalls:    res.ToolCalls,
+			InputTokens:  res.InputTokens,
+			OutputTokens: res.OutputTokens,
+		})
+
+		result.Messages = append(result.Messages, chat.Message{Role: "assistant", Content: res.Text, ToolCalls: res.ToolCalls})
+		if len(res.ToolCalls) == 0 {
+			if res.Text == "" {
+				return result, ErrEmptyModelTurn
+			}
+
+			return result, nil
+		}
+
+		if err := a.checkBudget(result); err != nil {
+			return result, err
+		}
+
+		for _, call := range res.ToolCalls {
+			output, err := a.invoke(ctx, call)
+			s := Step{
+				Index:      result.Steps,
+				Type:       StepTypeTool,
+				ToolCallID: call.ID,
+				ToolName:   call.Function.Name,
+				Output:     output,
+			}
+
+			// 工具执行失败时把错误信息作为工具调用结果返回给模型，由模型决定如何处理
+			if err != nil {
+				s.Error = err.Error()
+				output = "error: " + err.Error()
+			}
+
+			observer.OnStep(s)
+			result.Messages = append(result.Messages, chat.Message{
+				Role:       "tool",
+				Content:    output,
+				ToolCallID: call.ID,
+				Name:       call.Function.Name,
+			})
+		}
+
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+	}
+
+	return result, ErrMaxSteps
+}
+
+// price 查询模型价格，第一个有价格的模型决定 result.Currency
+// 设置了 MaxCost 时价格未知或者货币单位不一致返回错误，否则这些模型调用的费用按 0 计算
+func (a *Agent) price(model string, result *Result) (catalog.Model, error) {
+	if a.opts.ResolveModel != nil {
+		model = a.opts.ResolveModel(model)
+	}
+
+	m, ok := catalog.Lookup(model)
+	if !ok || (m.InputPrice == 0 && m.OutputPrice == 0) {
+		if a.opts.MaxCost > 0 {
+			return catalog.Model{}, fmt.Errorf("%w: %s", ErrUnknownPrice, model)
+		}
+
+		return catalog.Model{}, nil
+	}
+
+	if result.Currency == "" {
+		result.Currency = m.Currency
+	}
+
+	if m.Currency != result.Currency {
+		if a.opts.MaxCost > 0 {
+			return catalog.Model{}, fmt.Errorf("%w: %s is priced in %s, budget is in %s", ErrCurrency, model, m.Currency, result.Currency)
+		}
+
+		return catalog.Model{}, nil
+	}
+
+	return m, nil
+}
+
+func (a *Agent) checkBudget(result *Result) error {
+	if a.opts.MaxTokens > 0 && result.InputTokens+result.OutputTokens >= a.opts.MaxTokens {
+		return ErrTokenBudget
+	}
+
+	if a.opts.MaxCost > 0 && result.Cost >= a.opts.MaxCost {
+		return ErrCostBudget
+	}
+
+	return nil
+}
+
+type toolCallKey struct{}
+
+// ToolCallFromContext 在 Handler 中获取当前的工具调用
+func ToolCallFromContext(ctx context.Context) (chat.ToolCall, bool) {
+	call, ok := ctx.Value(toolCallKey{}).(chat.ToolCall)
+	return call, ok
+}
+
+// invoke 执行工具调用，handler panic 时转换为错误
+func (a *Agent) invoke(ctx context.Context, call chat.ToolCall) (output string, err error) {
+	tool, ok := a.tool(call.Function.Name)
+	if !ok {
+		return "", fmt.Errorf("tool %s not found", call.Function.Name)
+	}
+
+	ctx = context.WithValue(ctx, toolCallKey{}, call)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("tool %s panic: %v", call.Function.Name, r)
+		}
+	}()
+
+	return tool.Handler(ctx, call.Function.Arguments)
+}
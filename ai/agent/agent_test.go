@@ -0,0 +1,289 @@
+package agent
+
+import (
+	"accompany-sdk/ai/chat"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// scriptedChat 按顺序返回预设响应的 chat.Chat，同时记录收到的请求
+type scriptedChat struct {
+	responses  []chat.Response
+	requests   []chat.Request
+	maxContext int
+}
+
+func (c *scriptedChat) Chat(_ context.Context, req chat.Request) (*chat.Response, error) {
+	c.requests = append(c.requests, req)
+	if len(c.requests) > len(c.responses) {
+		return nil, errors.New("no more scripted responses")
+	}
+
+	res := c.responses[len(c.requests)-1]
+	return &res, nil
+}
+
+func (c *scriptedChat) ChatStream(context.Context, chat.Request) (<-chan chat.Response, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *scriptedChat) MaxContextLength(string) int {
+	if c.maxContext > 0 {
+		return c.maxContext
+	}
+
+	return 4096
+}
+
+func toolCall(id, name, arguments string) chat.ToolCall {
+	return chat.ToolCall{ID: id, Type: chat.ToolTypeFunction, Function: chat.FunctionCall{Name: name, Arguments: arguments}}
+}
+
+func weatherTool(calls *[]string) Tool {
+	return Tool{
+		Name:        "get_weather",
+		Description: "查询城市天气",
+		Parameters:  map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}},
+		Handler: func(_ context.Context, arguments string) (string, error) {
+			*calls = append(*calls, arguments)
+			return `{"weather":"晴"}`, nil
+		},
+	}
+}
+
+func newRequest() chat.Request {
+	return chat.Request{Model: "gpt-4", Messages: chat.Messages{{Role: "user", Content: "北京天气怎么样？"}}}
+}
+
+func TestAgent_Run(t *testing.T) {
+	backend := &scriptedChat{responses: []chat.Response{
+		{ToolCalls: []chat.ToolCall{toolCall("call_1", "get_weather", `{"city":"北京"}`)}, FinishReason: chat.FinishReasonToolCalls, InputTokens: 10, OutputTokens: 5},
+		{Text: "北京今天晴。", FinishReason: "stop", InputTokens: 20, OutputTokens: 6},
+	}}
+
+	var calls []string
+	a := New(backend, Options{})
+	if err := a.Register(weatherTool(&calls)); err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []Step
+	res, err := a.Run(context.Background(), newRequest(), ObserverFunc(func(step Step) { steps = append(steps, step) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Text != "北京今天晴。" || res.Steps != 2 || res.InputTokens != 30 || res.OutputTokens != 11 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	if res.Cost <= 0 {
+		t.Errorf("cost should be calculated from catalog price, got %v", res.Cost)
+	}
+
+	if len(calls) != 1 || calls[0] != `{"city":"北京"}` {
+		t.Errorf("unexpected tool calls: %v", calls)
+	}
+
+	wantTypes := []string{StepTypeModel, StepTypeTool, StepTypeModel}
+	if len(steps) != len(wantTypes) {
+		t.Fatalf("unexpected steps: %+v", steps)
+	}
+	for i, typ := range wantTypes {
+		if steps[i].Type != typ {
+			t.Errorf("step %d: want type %s, got %s", i, typ, steps[i].Type)
+		}
+	}
+
+	if len(backend.requests[0].Tools) != 1 || backend.requests[0].Tools[0].Function.Name != "get_weather" {
+		t.Errorf("tools should be sent to model: %+v", backend.requests[0].Tools)
+	}
+
+	second := backend.requests[1].Messages
+	if len(second) != 3 || second[1].Role != "assistant" || len(second[1].ToolCalls) != 1 ||
+		second[2].Role != "tool" || second[2].ToolCallID != "call_1" || second[2].Content != `{"weather":"晴"}` {
+		t.Errorf("unexpected messages in second request: %+v", second)
+	}
+}
+
+func TestAgent_ToolError(t *testing.T) {
+	backend := &scriptedChat{responses: []chat.Response{
+		{ToolCalls: []chat.ToolCall{toolCall("call_1", "unknown", `{}`), toolCall("call_2", "broken", `{}`)}},
+		{Text: "抱歉，无法完成。"},
+	}}
+
+	a := New(backend, Options{})
+	_ = a.Register(Tool{Name: "broken", Handler: func(context.Context, string) (string, error) {
+		return "", fmt.Errorf("service unavailable")
+	}})
+
+	var errs []string
+	res, err := a.Run(context.Background(), newRequest(), ObserverFunc(func(step Step) {
+		if step.Type == StepTypeTool {
+			errs = append(errs, step.Error)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(errs) != 2 || errs[0] == "" || errs[1] != "service unavailable" {
+		t.Errorf("tool errors should be reported: %v", errs)
+	}
+
+	if res.Messages[3].Content != "error: service unavailable" {
+		t.Errorf("tool error should be sent back to model: %+v", res.Messages[3])
+	}
+}
+
+func TestAgent_Limits(t *testing.T) {
+	loop := chat.Response{ToolCalls: []chat.ToolCall{toolCall("call", "get_weather", `{}`)}, InputTokens: 100, OutputTokens: 100}
+
+	tests := []struct {
+		name string
+		opts Options
+		want error
+	}{
+		{name: "max steps", opts: Options{MaxSteps: 3}, want: ErrMaxSteps},
+		{name: "token budget", opts: Options{MaxTokens: 300}, want: ErrTokenBudget},
+		{name: "cost budget", opts: Options{MaxCost: 0.001}, want: ErrCostBudget},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &scriptedChat{responses: []chat.Response{loop, loop, loop, loop, loop}}
+
+			var calls []string
+			a := New(backend, tt.opts)
+			_ = a.Register(weatherTool(&calls))
+
+			res, err := a.Run(context.Background(), newRequest(), nil)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, err)
+			}
+
+			if res == nil || res.Steps == 0 {
+				t.Errorf("partial result should be returned: %+v", res)
+			}
+		})
+	}
+}
+
+func TestAgent_ReduceContext(t *testing.T) {
+	backend := &scriptedChat{maxContext: 300, responses: []chat.Response{
+		{ToolCalls: []chat.ToolCall{toolCall("call_1", "get_weather", `{"city":"北京"}`)}},
+		{Text: "北京今天晴。"},
+	}}
+
+	var calls []string
+	a := New(backend, Options{})
+	_ = a.Register(weatherTool(&calls))
+
+	long := strings.Repeat("很久以前的对话内容。", 100)
+	req := chat.Request{Model: "gpt-4", Messages: chat.Messages{
+		{Role: "system", Content: "你是天气助手"},
+		{Role: "user", Content: long},
+		{Role: "assistant", Content: long},
+		{Role: "user", Content: "北京天气怎么样？"},
+	}}
+
+	res, err := a.Run(context.Background(), req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 超出上下文长度的历史消息不发送给模型，system 消息保留
+	first := backend.requests[0].Messages
+	if len(first) != 2 || first[0].Role != "system" || first[1].Content != "北京天气怎么样？" {
+		t.Errorf("unexpected messages in first request: %+v", first)
+	}
+
+	second := backend.requests[1].Messages
+	if len(second) != 4 || second[2].Role != "assistant" || second[3].Role != "tool" {
+		t.Errorf("unexpected messages in second request: %+v", second)
+	}
+
+	// 结果中保留完整的对话
+	if len(res.Messages) != 7 || res.Messages[1].Content != long {
+		t.Errorf("result should keep the full conversation: %+v", res.Messages)
+	}
+}
+
+func TestAgent_Register(t *testing.T) {
+	a := New(&scriptedChat{}, Options{})
+
+	var calls []string
+	if err := a.Register(weatherTool(&calls)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Register(weatherTool(&calls)); !errors.Is(err, ErrToolExists) {
+		t.Errorf("duplicated tool should be rejected, got %v", err)
+	}
+
+	if err := a.Register(Tool{Name: "nil_handler"}); !errors.Is(err, ErrInvalidTool) {
+		t.Errorf("tool without handler should be rejected, got %v", err)
+	}
+}
+
+func TestAgent_CostBudgetPricing(t *testing.T) {
+	loop := chat.Response{ToolCalls: []chat.ToolCall{toolCall("call", "get_weather", `{}`)}, InputTokens: 100, OutputTokens: 100}
+	trimPrefix := func(model string) string { return strings.TrimPrefix(model, "openai:") }
+
+	tests := []struct {
+		name      string
+		model     string
+		opts      Options
+		responses []chat.Response
+		want      error
+	}{
+		{
+			name:  "prefixed model",
+			model: "openai:gpt-4",
+			opts:  Options{MaxCost: 0.001, ResolveModel: trimPrefix},
+			want:  ErrCostBudget,
+		},
+		{
+			name:  "unknown price",
+			model: "openai:gpt-4",
+			opts:  Options{MaxCost: 0.001},
+			want:  ErrUnknownPrice,
+		},
+		{
+			name:  "budget currency",
+			model: "gpt-4",
+			opts:  Options{MaxCost: 0.001, Currency: "CNY"},
+			want:  ErrCurrency,
+		},
+		{
+			// 降级链切换到了价格为人民币的模型
+			name:      "mixed currencies",
+			model:     "gpt-4",
+			opts:      Options{MaxCost: 1},
+			responses: []chat.Response{loop, func() chat.Response { res := loop; res.Model = "glm-4"; return res }()},
+			want:      ErrCurrency,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses := tt.responses
+			if responses == nil {
+				responses = []chat.Response{loop, loop}
+			}
+
+			var calls []string
+			a := New(&scriptedChat{responses: responses}, tt.opts)
+			_ = a.Register(weatherTool(&calls))
+
+			req := newRequest()
+			req.Model = tt.model
+			if _, err := a.Run(context.Background(), req, nil); !errors.Is(err, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
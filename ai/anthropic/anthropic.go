@@ -0,0 +1,317 @@
+package anthropic
+
+import (
+	"accompany-sdk/pkg/misc"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultServer Anthropic 默认服务地址
+	DefaultServer = "https://api.anthropic.com"
+	// DefaultVersion Anthropic API 版本
+	DefaultVersion = "2023-06-01"
+)
+
+type Anthropic struct {
+	server  string
+	apiKey  string
+	version string
+	client  *http.Client
+}
+
+// New 创建 Anthropic 客户端，server 为空时使用默认服务地址，client 为空时使用默认的 HTTP 客户端
+func New(server, apiKey string, client *http.Client) *Anthropic {
+	if server == "" {
+		server = DefaultServer
+	}
+
+	if client == nil {
+		client = misc.StreamHTTPClient(nil)
+	}
+
+	return &Anthropic{
+		server:  strings.TrimSuffix(server, "/"),
+		apiKey:  apiKey,
+		version: DefaultVersion,
+		client:  client,
+	}
+}
+
+type MessageRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	// System 系统提示语，Anthropic 不支持 system 角色的消息，需要通过该字段传递
+	System string `json:"system,omitempty"`
+	// MaxTokens 最大输出 token 数，必填
+	MaxTokens int  `json:"max_tokens"`
+	Stream    bool `json:"stream,omitempty"`
+	// Temperature 采样温度，取值范围 [0, 1]
+	Temperature *float64 `json:"temperature,omitempty"`
+	// TopP 核采样概率，取值范围 [0, 1]
+	TopP *float64 `json:"top_p,omitempty"`
+	// StopSequences 停止词
+	StopSequences []string `json:"stop_sequences,omitempty"`
+	// Tools 模型可以调用的工具
+	Tools []Tool `json:"tools,omitempty"`
+	// ToolChoice 工具调用策略，为空时由模型决定
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+}
+
+// Tool 工具定义
+// https://docs.anthropic.com/claude/docs/tool-use
+type Tool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// InputSchema 工具参数，JSON Schema 格式
+	InputSchema any `json:"input_schema"`
+}
+
+type ToolChoice struct {
+	// Type 可选值为 auto/any/tool，any 表示必须调用至少一个工具，tool 表示必须调用 Name 指定的工具
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type Message struct {
+	// Role 可选值为 user/assistant
+	Role    string    `json:"role"`
+	Content []Content `json:"content"`
+}
+
+type Content struct {
+	// Type 可选值为 text/image/tool_use/tool_result
+	Type   string  `json:"type"`
+	Text   string  `json:"text,omitempty"`
+	Source *Source `json:"source,omitempty"`
+
+	// ID 工具调用 ID，只有 tool_use 有值
+	ID string `json:"id,omitempty"`
+	// Name 工具名称，只有 tool_use 有值
+	Name string `json:"name,omitempty"`
+	// Input 工具参数，只有 tool_use 有值
+	Input json.RawMessage `json:"input,omitempty"`
+	// ToolUseID 工具执行结果对应的工具调用 ID，只有 tool_result 有值
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	// Content 工具执行结果，只有 tool_result 有值
+	Content string `json:"content,omitempty"`
+}
+
+type Source struct {
+	// Type 目前只支持 base64
+	Type string `json:"type"`
+	// MediaType 图片类型，支持 image/jpeg、image/png、image/gif、image/webp
+	MediaType string `json:"media_type"`
+	// Data base64 编码的图片数据，不包含 data:image/xxx;base64, 前缀
+	Data string `json:"data"`
+}
+
+type MessageResponse struct {
+	ID      string    `json:"id,omitempty"`
+	Type    string    `json:"type,omitempty"`
+	Role    string    `json:"role,omitempty"`
+	Content []Content `json:"content,omitempty"`
+	Model   string    `json:"model,omitempty"`
+	// StopReason 可选值为 end_turn/max_tokens/stop_sequence/tool_use
+	StopReason string `json:"stop_reason,omitempty"`
+	Usage      Usage  `json:"usage,omitempty"`
+	Error      *Error `json:"error,omitempty"`
+}
+
+// Text 返回响应中的文本内容
+func (resp MessageResponse) Text() string {
+	var text strings.Builder
+	for _, content := range resp.Content {
+		if content.Type == "text" {
+			text.WriteString(content.Text)
+		}
+	}
+
+	return text.String()
+}
+
+// ToolUses 返回响应中的工具调用
+func (resp MessageResponse) ToolUses() []Content {
+	var uses []Content
+	for _, content := range resp.Content {
+		if content.Type == "tool_use" {
+			uses = append(uses, content)
+		}
+	}
+
+	return uses
+}
+
+type Usage struct {
+	InputTokens  int `json:"input_tokens,omitempty"`
+	OutputTokens int `json:"output_tokens,omitempty"`
+}
+
+type Error struct {
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("anthropic: [%s] %s", e.Type, e.Message)
+}
+
+// StreamEvent 流式响应事件
+// https://docs.anthropic.com/claude/reference/messages-streaming
+type StreamEvent struct {
+	// Type 可选值为 message_start/content_block_start/content_block_delta/content_block_stop/message_delta/message_stop/ping/error
+	Type    string           `json:"type"`
+	Message *MessageResponse `json:"message,omitempty"`
+	Index   int              `json:"index,omitempty"`
+	Delta   *StreamDelta     `json:"delta,omitempty"`
+	Usage   *Usage           `json:"usage,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+	// ContentBlock content_block_start 事件中开始的内容块，工具调用的 ID 和名称在该事件中返回
+	ContentBlock *Content `json:"content_block,omitempty"`
+}
+
+type StreamDelta struct {
+	// Type 可选值为 text_delta/input_json_delta，message_delta 事件中为空
+	Type string `json:"type,omitempty"`
+	Text string `json:"text,omitempty"`
+	// PartialJSON 工具参数的增量片段，只有 input_json_delta 有值
+	PartialJSON string `json:"partial_json,omitempty"`
+	StopReason  string `json:"stop_reason,omitempty"`
+}
+
+func (ai *Anthropic) newRequest(ctx context.Context, req MessageRequest) (*http.Request, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, ai.server+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", ai.apiKey)
+	httpReq.Header.Set("anthropic-version", ai.version)
+
+	return httpReq, nil
+}
+
+func parseErrorResponse(resp *http.Response) error {
+	data, _ := io.ReadAll(resp.Body)
+
+	var ret MessageResponse
+	if err := json.Unmarshal(data, &ret); err == nil && ret.Error != nil {
+		return ret.Error
+	}
+
+	return fmt.Errorf("anthropic: request failed, status code: %d, body: %s", resp.StatusCode, string(data))
+}
+
+// Chat 以请求-响应的方式调用 Messages API
+func (ai *Anthropic) Chat(ctx context.Context, req MessageRequest) (*MessageResponse, error) {
+	req.Stream = false
+	httpReq, err := ai.newRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpResp, err := ai.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, parseErrorResponse(httpResp)
+	}
+
+	var ret MessageResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&ret); err != nil {
+		return nil, fmt.Errorf("anthropic: decode response failed: %w", err)
+	}
+
+	return &ret, nil
+}
+
+// ChatStream 以流的方式调用 Messages API，返回的 channel 在流结束或者出错后关闭
+func (ai *Anthropic) ChatStream(ctx context.Context, req MessageRequest) (<-chan StreamEvent, error) {
+	req.Stream = true
+	httpReq, err := ai.newRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("Cache-Control", "no-cache")
+
+	httpResp, err := ai.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		defer httpResp.Body.Close()
+		return nil, parseErrorResponse(httpResp)
+	}
+
+	res := make(chan StreamEvent)
+	go func() {
+		defer func() {
+			_ = httpResp.Body.Close()
+			close(res)
+		}()
+
+		reader := bufio.NewReader(httpResp.Body)
+		for {
+			data, err := reader.ReadBytes('\n')
+			if err != nil {
+				if err == io.EOF {
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+				case res <- StreamEvent{Type: "error", Error: &Error{Type: "read_stream_failed", Message: err.Error()}}:
+				}
+				return
+			}
+
+			// 只处理 data 行，事件类型包含在 data 的 type 字段中
+			dataStr := strings.TrimSpace(string(data))
+			if !strings.HasPrefix(dataStr, "data:") {
+				continue
+			}
+
+			var event StreamEvent
+			if err := json.Unmarshal([]byte(strings.TrimSpace(dataStr[5:])), &event); err != nil {
+				select {
+				case <-ctx.Done():
+				case res <- StreamEvent{Type: "error", Error: &Error{Type: "invalid_stream_data", Message: err.Error()}}:
+				}
+				return
+			}
+
+			if event.Type == "ping" {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case res <- event:
+				if event.Type == "message_stop" || event.Type == "error" {
+					return
+				}
+			}
+		}
+	}()
+
+	return res, nil
+}
@@ -0,0 +1,354 @@
+package anthropic
+
+import (
+	"accompany-sdk/ai/catalog"
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/misc"
+	"accompany-sdk/pkg/ternary"
+	"accompany-sdk/pkg/uploader"
+	"accompany-sdk/pkg/utils/array"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/openimsdk/tools/log"
+)
+
+// defaultMaxTokens Anthropic 要求必须指定 max_tokens，未指定时使用该值
+const defaultMaxTokens = 4096
+
+// samplingLimits Anthropic 采样参数取值范围，不支持 presence_penalty 和 frequency_penalty
+// https://docs.anthropic.com/claude/reference/messages_post
+var samplingLimits = chat.SamplingLimits{
+	TemperatureMin: 0, TemperatureMax: 1,
+	TopPMin: 0, TopPMax: 1,
+	MaxStop: 8,
+}
+
+// AnthropicChat 基于 Messages API 实现 chat.Chat 接口
+type AnthropicChat struct {
+	ai *Anthropic
+}
+
+func NewAnthropicChat(ai *Anthropic) *AnthropicChat {
+	return &AnthropicChat{ai: ai}
+}
+
+func (ac *AnthropicChat) initRequest(ctx context.Context, req chat.Request) (*MessageRequest, error) {
+	req.Model = strings.TrimPrefix(req.Model, "anthropic:")
+	req = req.ClampSampling(samplingLimits)
+	if err := chat.CheckToolSupport(req); err != nil {
+		return nil, err
+	}
+
+	if err := chat.CheckSingleChoice(req); err != nil {
+		return nil, err
+	}
+
+	var systemMessages []string
+	var contextMessages chat.Messages
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			systemMessages = append(systemMessages, msg.Content)
+		} else {
+			contextMessages = append(contextMessages, msg)
+		}
+	}
+
+	if len(contextMessages) == 0 {
+		return nil, fmt.Errorf("anthropic: messages is empty")
+	}
+
+	// Anthropic 要求 user/assistant 轮流出现，且第一条消息必须为 user 消息
+	contextMessages = contextMessages.Fix()
+
+	messages := make([]Message, 0, len(contextMessages))
+	for _, msg := range contextMessages {
+		m := toMessage(ctx, msg)
+
+		// 工具执行结果作为 user 消息发送，同一轮的多个结果以及紧随其后的用户消息需要合并为一条消息
+		if n := len(messages); n > 0 && messages[n-1].Role == m.Role {
+			messages[n-1].Content = append(messages[n-1].Content, m.Content...)
+			continue
+		}
+
+		messages = append(messages, m)
+	}
+
+	tools, toolChoice := toTools(req)
+	return &MessageRequest{
+		Model:     req.Model,
+		Messages:  messages,
+		System:    strings.Join(systemMessages, "\n\n"),
+		MaxTokens: req.MaxTokens,
+
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+
+		Tools:      tools,
+		ToolChoice: toolChoice,
+	}, nil
+}
+
+// toMessage 转换对话消息，tool 消息转换为包含 tool_result 的 user 消息，工具调用转换为 tool_use 内容块
+func toMessage(ctx context.Context, msg chat.Message) Message {
+	if msg.Role == "tool" {
+		return Message{Role: "user", Content: []Content{{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content}}}
+	}
+
+	m := Message{Role: msg.Role}
+	if len(msg.MultipartContents) == 0 {
+		// Anthropic 不允许空的文本内容块，只包含工具调用的 assistant 消息不添加文本
+		if msg.Content != "" || len(msg.ToolCalls) == 0 {
+			m.Content = []Content{{Type: "text", Text: msg.Content}}
+		}
+	}
+
+	for _, part := range msg.MultipartContents {
+		switch part.Type {
+		case "text":
+			m.Content = append(m.Content, Content{Type: "text", Text: part.Text})
+		case "image_url":
+			if part.ImageURL == nil || part.ImageURL.URL == "" {
+				continue
+			}
+
+			source, err := imageSource(ctx, part.ImageURL.URL)
+			if err != nil {
+				log.ZWarn(ctx, "anthropic: resolve image failed", err, "url", misc.SubString(part.ImageURL.URL, 50))
+				continue
+			}
+
+			m.Content = append(m.Content, Content{Type: "image", Source: source})
+		}
+	}
+
+	for _, call := range msg.ToolCalls {
+		m.Content = append(m.Content, Content{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: toolInput(call.Function.Arguments)})
+	}
+
+	return m
+}
+
+// toolInput 工具参数，Anthropic 要求参数必须为 JSON 对象，无效的参数使用空对象代替
+func toolInput(arguments string) json.RawMessage {
+	if json.Valid([]byte(arguments)) {
+		return json.RawMessage(arguments)
+	}
+
+	return json.RawMessage("{}")
+}
+
+// toTools 转换工具定义以及工具调用策略，Anthropic 不支持 none，此时不传递工具
+func toTools(req chat.Request) ([]Tool, *ToolChoice) {
+	if req.ToolChoice == chat.ToolChoiceNone {
+		return nil, nil
+	}
+
+	tools := array.Map(
+		array.Filter(req.Tools, func(item chat.Tool, _ int) bool { return item.Function != nil }),
+		func(item chat.Tool, _ int) Tool {
+			return Tool{
+				Name:        item.Function.Name,
+				Description: item.Function.Description,
+				InputSchema: ternary.If[any](item.Function.Parameters != nil, item.Function.Parameters, map[string]any{"type": "object"}),
+			}
+		},
+	)
+	if len(tools) == 0 {
+		return nil, nil
+	}
+
+	switch req.ToolChoice {
+	case "", chat.ToolChoiceAuto:
+		return tools, nil
+	case chat.ToolChoiceRequired:
+		return tools, &ToolChoice{Type: "any"}
+	}
+
+	return tools, &ToolChoice{Type: "tool", Name: req.ToolChoice}
+}
+
+// fromToolUses 把 tool_use 内容块转换为工具调用
+func fromToolUses(uses []Content) []chat.ToolCall {
+	return array.Map(uses, func(item Content, _ int) chat.ToolCall {
+		return chat.ToolCall{
+			ID:       item.ID,
+			Type:     chat.ToolTypeFunction,
+			Function: chat.FunctionCall{Name: item.Name, Arguments: string(item.Input)},
+		}
+	})
+}
+
+// finishReason 转换结束原因，tool_use 转换为 tool_calls，其它保持不变
+func finishReason(stopReason string) string {
+	if stopReason == "tool_use" {
+		return chat.FinishReasonToolCalls
+	}
+
+	return stopReason
+}
+
+// imageSource 把图片地址转换为 base64 编码的图片数据，Anthropic 不支持直接传递图片 URL
+func imageSource(ctx context.Context, url string) (*Source, error) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		encoded, err := uploader.DownloadRemoteFileAsBase64(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		url = encoded
+	}
+
+	mimeType, err := misc.Base64ImageMediaType(url)
+	if err != nil {
+		return nil, err
+	}
+
+	data := url
+	if strings.Contains(url, ",") {
+		data = misc.RemoveImageBase64Prefix(url)
+	}
+
+	return &Source{Type: "base64", MediaType: mimeType, Data: data}, nil
+}
+
+func (ac *AnthropicChat) Chat(ctx context.Context, req chat.Request) (*chat.Response, error) {
+	anthropicReq, err := ac.initRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if anthropicReq.MaxTokens <= 0 {
+		anthropicReq.MaxTokens = defaultMaxTokens
+	}
+
+	res, err := ac.ai.Chat(ctx, *anthropicReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chat.Response{
+		Text:         res.Text(),
+		ToolCalls:    fromToolUses(res.ToolUses()),
+		FinishReason: finishReason(res.StopReason),
+		InputTokens:  res.Usage.InputTokens,
+		OutputTokens: res.Usage.OutputTokens,
+	}, nil
+}
+
+func (ac *AnthropicChat) ChatStream(ctx context.Context, req chat.Request) (<-chan chat.Response, error) {
+	anthropicReq, err := ac.initRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if anthropicReq.MaxTokens <= 0 {
+		anthropicReq.MaxTokens = defaultMaxTokens
+	}
+
+	stream, err := ac.ai.ChatStream(ctx, *anthropicReq)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(chan chat.Response)
+	go func() {
+		defer close(res)
+
+		var inputTokens int
+		// toolIndexes 内容块序号 -> 工具调用序号
+		toolIndexes := make(map[int]int)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				var data chat.Response
+				switch event.Type {
+				case "error":
+					// 服务端返回的错误事件可能没有 error 字段
+					data = chat.Response{Error: "unknown stream error", ErrorCode: "stream_error"}
+					if event.Error != nil {
+						data = chat.Response{Error: event.Error.Message, ErrorCode: event.Error.Type}
+					}
+				case "message_start":
+					if event.Message != nil {
+						inputTokens = event.Message.Usage.InputTokens
+					}
+					continue
+				case "content_block_start":
+					if event.ContentBlock == nil || event.ContentBlock.Type != "tool_use" {
+						continue
+					}
+
+					index := len(toolIndexes)
+					toolIndexes[event.Index] = index
+					data = chat.Response{ToolCalls: []chat.ToolCall{{
+						Index:    &index,
+						ID:       event.ContentBlock.ID,
+						Type:     chat.ToolTypeFunction,
+						Function: chat.FunctionCall{Name: event.ContentBlock.Name},
+					}}}
+				case "content_block_delta":
+					if event.Delta == nil {
+						continue
+					}
+
+					if index, ok := toolIndexes[event.Index]; ok {
+						if event.Delta.PartialJSON == "" {
+							continue
+						}
+						data = chat.Response{ToolCalls: []chat.ToolCall{{Index: &index, Function: chat.FunctionCall{Arguments: event.Delta.PartialJSON}}}}
+						break
+					}
+
+					if event.Delta.Text == "" {
+						continue
+					}
+					data = chat.Response{Text: event.Delta.Text}
+				case "message_delta":
+					data = chat.Response{InputTokens: inputTokens}
+					if event.Delta != nil {
+						data.FinishReason = finishReason(event.Delta.StopReason)
+					}
+					if event.Usage != nil {
+						data.OutputTokens = event.Usage.OutputTokens
+					}
+				default:
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case res <- data:
+				}
+			}
+		}
+	}()
+
+	return res, nil
+}
+
+// MaxContextLength 获取模型的最大上下文长度，优先使用模型目录中的配置
+// https://docs.anthropic.com/claude/docs/models-overview
+func (ac *AnthropicChat) MaxContextLength(model string) int {
+	model = strings.TrimPrefix(model, "anthropic:")
+	if length := catalog.ContextWindow(model, 0); length > 0 {
+		return length
+	}
+
+	switch {
+	case strings.HasPrefix(model, "claude-3"), strings.HasPrefix(model, "claude-2.1"):
+		return 200000 - defaultMaxTokens
+	}
+
+	return 100000 - defaultMaxTokens
+}
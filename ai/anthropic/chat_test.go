@@ -0,0 +1,307 @@
+package anthropic
+
+import (
+	"accompany-sdk/ai/chat"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testImage(t *testing.T) string {
+	return pngDataURL(t, 4, 4)
+}
+
+func newTestServer(t *testing.T, handler func(w http.ResponseWriter, req MessageRequest)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") != DefaultVersion {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+
+		var req MessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		handler(w, req)
+	}))
+}
+
+func TestAnthropicChat_Chat(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, req MessageRequest) {
+		if req.System != "你是一个助手" {
+			t.Errorf("system message should be moved to top-level field, got %q", req.System)
+		}
+
+		if req.Model != "claude-3-haiku-20240307" || req.MaxTokens != defaultMaxTokens || req.Stream {
+			t.Errorf("unexpected request: %+v", req)
+		}
+
+		if len(req.Messages) != 1 || req.Messages[0].Role != "user" || len(req.Messages[0].Content) != 2 {
+			t.Fatalf("unexpected messages: %+v", req.Messages)
+		}
+
+		img := req.Messages[0].Content[1]
+		if img.Type != "image" || img.Source == nil || img.Source.Type != "base64" || img.Source.MediaType != "image/png" {
+			t.Errorf("unexpected image block: %+v", img)
+		}
+
+		if strings.HasPrefix(img.Source.Data, "data:") {
+			t.Errorf("image data should not contain data url prefix")
+		}
+
+		_, _ = w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"你好"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`))
+	})
+	defer server.Close()
+
+	ac := NewAnthropicChat(New(server.URL, "test-key", nil))
+	res, err := ac.Chat(context.Background(), chat.Request{
+		Model: "anthropic:claude-3-haiku-20240307",
+		Messages: chat.Messages{
+			{Role: "system", Content: "你是一个助手"},
+			{Role: "user", MultipartContents: []*chat.MultipartContent{
+				{Type: "text", Text: "这是什么？"},
+				{Type: "image_url", ImageURL: &chat.ImageURL{URL: testImage(t)}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Text != "你好" || res.FinishReason != "end_turn" || res.InputTokens != 12 || res.OutputTokens != 3 {
+		t.Errorf("unexpected response: %+v", res)
+	}
+}
+
+func TestAnthropicChat_ChatStream(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":25,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"ping"}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"你"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"好"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":15}}`,
+		`{"type":"message_stop"}`,
+	}
+
+	server := newTestServer(t, func(w http.ResponseWriter, req MessageRequest) {
+		if !req.Stream {
+			t.Errorf("stream should be enabled")
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			var typ struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal([]byte(event), &typ)
+			_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ.Type, event)
+		}
+	})
+	defer server.Close()
+
+	ac := NewAnthropicChat(New(server.URL, "test-key", nil))
+	stream, err := ac.ChatStream(context.Background(), chat.Request{
+		Model:    "claude-3-haiku-20240307",
+		Messages: chat.Messages{{Role: "user", Content: "你好"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text string
+	var last chat.Response
+	for data := range stream {
+		if data.ErrorCode != "" {
+			t.Fatalf("unexpected error: %+v", data)
+		}
+
+		text += data.Text
+		last = data
+	}
+
+	if text != "你好" {
+		t.Errorf("unexpected text: %q", text)
+	}
+
+	if last.FinishReason != "end_turn" || last.InputTokens != 25 || last.OutputTokens != 15 {
+		t.Errorf("unexpected usage: %+v", last)
+	}
+}
+
+func TestAnthropicChat_ChatStreamErrorEvent(t *testing.T) {
+	cases := []struct {
+		event string
+		code  string
+	}{
+		{event: `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, code: "overloaded_error"},
+		// 没有 error 字段的错误事件使用通用的错误码
+		{event: `{"type":"error"}`, code: "stream_error"},
+	}
+
+	for _, c := range cases {
+		server := newTestServer(t, func(w http.ResponseWriter, req MessageRequest) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprintf(w, "event: content_block_delta\ndata: %s\n\n", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"你"}}`)
+			_, _ = fmt.Fprintf(w, "event: error\ndata: %s\n\n", c.event)
+		})
+
+		ac := NewAnthropicChat(New(server.URL, "test-key", nil))
+		stream, err := ac.ChatStream(context.Background(), chat.Request{
+			Model:    "claude-3-haiku-20240307",
+			Messages: chat.Messages{{Role: "user", Content: "你好"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var last chat.Response
+		for data := range stream {
+			last = data
+		}
+		server.Close()
+
+		if last.ErrorCode != c.code || last.Error == "" {
+			t.Errorf("expect error code %s, got %+v", c.code, last)
+		}
+	}
+}
+
+func TestAnthropicChat_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+	}))
+	defer server.Close()
+
+	ac := NewAnthropicChat(New(server.URL, "test-key", nil))
+	_, err := ac.Chat(context.Background(), chat.Request{
+		Model:    "claude-3-haiku-20240307",
+		Messages: chat.Messages{{Role: "user", Content: "你好"}},
+	})
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Type != "authentication_error" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAnthropicChat_Tools(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, req MessageRequest) {
+		if len(req.Tools) != 1 || req.Tools[0].Name != "get_weather" || req.Tools[0].InputSchema == nil {
+			t.Errorf("unexpected tools: %+v", req.Tools)
+		}
+
+		if req.ToolChoice == nil || req.ToolChoice.Type != "any" {
+			t.Errorf("required should be mapped to any, got %+v", req.ToolChoice)
+		}
+
+		// user -> assistant(tool_use) -> user(tool_result + 用户消息)
+		if len(req.Messages) != 3 {
+			t.Fatalf("unexpected messages: %+v", req.Messages)
+		}
+
+		use := req.Messages[1].Content
+		if len(use) != 1 || use[0].Type != "tool_use" || use[0].ID != "toolu_1" || string(use[0].Input) != `{"city":"北京"}` {
+			t.Errorf("unexpected tool use: %+v", use)
+		}
+
+		result := req.Messages[2]
+		if result.Role != "user" || len(result.Content) != 2 || result.Content[0].Type != "tool_result" || result.Content[0].ToolUseID != "toolu_1" || result.Content[0].Content != "晴" {
+			t.Errorf("unexpected tool result: %+v", result)
+		}
+
+		_, _ = w.Write([]byte(`{"content":[{"type":"tool_use","id":"toolu_2","name":"get_weather","input":{"city":"上海"}}],"stop_reason":"tool_use","usage":{"input_tokens":30,"output_tokens":10}}`))
+	})
+	defer server.Close()
+
+	ac := NewAnthropicChat(New(server.URL, "test-key", nil))
+	res, err := ac.Chat(context.Background(), chat.Request{
+		Model: "claude-3-haiku-20240307",
+		Messages: chat.Messages{
+			{Role: "user", Content: "北京天气怎么样？"},
+			{Role: "assistant", ToolCalls: []chat.ToolCall{{ID: "toolu_1", Type: chat.ToolTypeFunction, Function: chat.FunctionCall{Name: "get_weather", Arguments: `{"city":"北京"}`}}}},
+			{Role: "tool", ToolCallID: "toolu_1", Content: "晴"},
+			{Role: "user", Content: "上海呢？"},
+		},
+		Tools:      []chat.Tool{{Type: chat.ToolTypeFunction, Function: &chat.FunctionDefinition{Name: "get_weather"}}},
+		ToolChoice: chat.ToolChoiceRequired,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.FinishReason != chat.FinishReasonToolCalls || len(res.ToolCalls) != 1 || res.ToolCalls[0].ID != "toolu_2" || res.ToolCalls[0].Function.Arguments != `{"city":"上海"}` {
+		t.Errorf("unexpected response: %+v", res)
+	}
+}
+
+func TestAnthropicChat_ChatStreamTools(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"usage":{"input_tokens":25}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"查询中"}}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"北京\"}"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":15}}`,
+		`{"type":"message_stop"}`,
+	}
+
+	server := newTestServer(t, func(w http.ResponseWriter, req MessageRequest) {
+		for _, event := range events {
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	})
+	defer server.Close()
+
+	ac := NewAnthropicChat(New(server.URL, "test-key", nil))
+	stream, err := ac.ChatStream(context.Background(), chat.Request{
+		Model:    "claude-3-haiku-20240307",
+		Messages: chat.Messages{{Role: "user", Content: "北京天气怎么样？"}},
+		Tools:    []chat.Tool{{Type: chat.ToolTypeFunction, Function: &chat.FunctionDefinition{Name: "get_weather"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text, reason string
+	var calls []chat.ToolCall
+	for data := range stream {
+		text += data.Text
+		calls = chat.MergeToolCallDeltas(calls, data.ToolCalls)
+		if data.FinishReason != "" {
+			reason = data.FinishReason
+		}
+	}
+
+	if text != "查询中" || reason != chat.FinishReasonToolCalls {
+		t.Errorf("unexpected text %q or finish reason %q", text, reason)
+	}
+
+	if len(calls) != 1 || calls[0].ID != "toolu_1" || calls[0].Function.Name != "get_weather" || calls[0].Function.Arguments != `{"city":"北京"}` {
+		t.Errorf("unexpected tool calls: %+v", calls)
+	}
+}
+
+func TestAnthropicChat_ToolNotSupported(t *testing.T) {
+	ac := NewAnthropicChat(New("http://127.0.0.1:0", "test-key", nil))
+	_, err := ac.Chat(context.Background(), chat.Request{
+		Model:    "claude-2.1",
+		Messages: chat.Messages{{Role: "user", Content: "你好"}},
+		Tools:    []chat.Tool{{Type: chat.ToolTypeFunction, Function: &chat.FunctionDefinition{Name: "get_weather"}}},
+	})
+	if !errors.Is(err, chat.ErrToolNotSupported) {
+		t.Errorf("expect ErrToolNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package anthropic
+
+import (
+	"accompany-sdk/ai/chat"
+	"math"
+)
+
+const (
+	// maxImageEdge 图片长边超过该值时会被等比缩放
+	maxImageEdge = 1568
+	// defaultImageTokens 无法获取图片尺寸时的 token 数量
+	defaultImageTokens = 1000
+)
+
+// Tokenizer Claude 模型的 Tokenizer，文本使用 tiktoken 近似计算，图片按照 (宽 * 高) / 750 计算
+// https://docs.anthropic.com/claude/docs/vision#image-costs
+type Tokenizer struct {
+	chat.TiktokenTokenizer
+}
+
+func (Tokenizer) CountImage(_ string, img *chat.ImageURL) int {
+	width, height, ok := chat.ImageDimensions(img)
+	if !ok {
+		return defaultImageTokens
+	}
+
+	w, h := float64(width), float64(height)
+	if scale := maxImageEdge / math.Max(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+
+	return int(math.Ceil(w * h / 750))
+}
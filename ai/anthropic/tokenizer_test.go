@@ -0,0 +1,54 @@
+package anthropic
+
+import (
+	"accompany-sdk/ai/chat"
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func pngDataURL(t *testing.T, width, height int) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestTokenizer_CountImage(t *testing.T) {
+	cases := []struct {
+		width, height int
+		want          int
+	}{
+		{width: 750, height: 1, want: 1},
+		{width: 1000, height: 1000, want: 1334},
+		// 长边超过 1568 时等比缩放
+		{width: 3136, height: 1568, want: 1640},
+	}
+
+	for _, c := range cases {
+		got := Tokenizer{}.CountImage("claude-3-opus-20240229", &chat.ImageURL{URL: pngDataURL(t, c.width, c.height)})
+		if got != c.want {
+			t.Errorf("%dx%d: want %d, got %d", c.width, c.height, c.want, got)
+		}
+	}
+
+	if got := (Tokenizer{}).CountImage("", &chat.ImageURL{URL: "data:image/png;base64,invalid"}); got != defaultImageTokens {
+		t.Errorf("unknown image size should use default tokens, got %d", got)
+	}
+}
+
+func TestTokenizer_CountText(t *testing.T) {
+	// 文本使用 tiktoken 近似计算
+	got, err := Tokenizer{}.CountText("claude-3-haiku-20240307", "hello world")
+	if err != nil {
+		t.Skipf("encoding is not available: %v", err)
+	}
+
+	if want, _ := (chat.TiktokenTokenizer{}).CountText("claude-3-haiku-20240307", "hello world"); got != want || got == 0 {
+		t.Errorf("want %d, got %d", want, got)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"sync"
 
+	"accompany-sdk/ai/catalog"
 	"accompany-sdk/pkg/utils/array"
 	"github.com/openimsdk/tools/log"
 	"gopkg.in/resty.v1"
@@ -21,15 +22,21 @@ type BaiduAI interface {
 	ChatStream(ctx context.Context, model Model, req ChatRequest) (<-chan ChatResponse, error)
 }
 
+// DefaultServer 百度智能云默认服务地址
+const DefaultServer = "https://aip.baidubce.com"
+
 type BaiduAIImpl struct {
+	Server      string
 	APIKey      string
 	APISecret   string
 	accessToken string
 	lock        sync.RWMutex
 }
 
-func NewBaiduAI(apiKey, apiSecret string) *BaiduAIImpl {
+// NewBaiduAI 创建百度文心千帆客户端，server 为空时使用默认服务地址
+func NewBaiduAI(server, apiKey, apiSecret string) *BaiduAIImpl {
 	ai := &BaiduAIImpl{
+		Server:    resolveServer(server),
 		APIKey:    apiKey,
 		APISecret: apiSecret,
 	}
@@ -56,7 +63,7 @@ func (ai *BaiduAIImpl) RefreshAccessToken() error {
 		SetQueryParam("grant_type", "client_credentials").
 		SetQueryParam("client_id", ai.APIKey).
 		SetQueryParam("client_secret", ai.APISecret).
-		Post("https://aip.baidubce.com/oauth/2.0/token")
+		Post(ai.Server + "/oauth/2.0/token")
 	if err != nil {
 		return err
 	}
@@ -79,6 +86,14 @@ func (ai *BaiduAIImpl) RefreshAccessToken() error {
 	return nil
 }
 
+func resolveServer(server string) string {
+	if server == "" {
+		return DefaultServer
+	}
+
+	return strings.TrimSuffix(server, "/")
+}
+
 func (ai *BaiduAIImpl) getAccessToken() string {
 	ai.lock.RLock()
 	defer ai.lock.RUnlock()
@@ -97,13 +112,13 @@ type ChatRequest struct {
 	//    （2）默认0.95，范围 (0, 1.0]，不能为0
 	//    （3）建议该参数和top_p只设置1个
 	//    （4）建议top_p和temperature不要同时更改
-	Temperature float64 `json:"temperature,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
 	// TopP 说明：
 	//    （1）影响输出文本的多样性，取值越大，生成文本的多样性越强
 	//    （2）默认0.8，取值范围 [0, 1.0]
 	//    （3）建议该参数和temperature只设置1个
 	//    （4）建议top_p和temperature不要同时更改
-	TopP float64 `json:"top_p,omitempty"`
+	TopP *float64 `json:"top_p,omitempty"`
 	// PenaltyScore 通过对已生成的token增加惩罚，减少重复生成的现象。说明：
 	//    （1）值越大表示惩罚越大
 	//    （2）默认1.0，取值范围：[1.0, 2.0]
@@ -118,6 +133,47 @@ type ChatRequest struct {
 	System string `json:"system,omitempty"`
 	// ExtraParameters 第三方大模型推理高级参数，依据第三方大模型厂商不同而变化
 	ExtraParameters any `json:"extra_parameters,omitempty"`
+	// Stop 生成停止标识，当模型生成结果以stop中某个元素结尾时，停止文本生成。说明：
+	//    （1）每个元素长度不超过20字符
+	//    （2）最多4个元素
+	Stop []string `json:"stop,omitempty"`
+	// ResponseFormat 指定响应内容的格式，可选值为 json_object/text
+	ResponseFormat string `json:"response_format,omitempty"`
+	// Functions 一个可触发函数的描述列表，目前只有 ERNIE-Bot 系列模型支持
+	Functions []Function `json:"functions,omitempty"`
+	// ToolChoice 在函数调用场景下，提示大模型选择指定的函数（非强制），说明：指定的函数名必须在 functions 中存在
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+}
+
+type Function struct {
+	// Name 函数名
+	Name string `json:"name"`
+	// Description 函数描述
+	Description string `json:"description"`
+	// Parameters 函数请求参数，JSON Schema 格式
+	Parameters any `json:"parameters"`
+	// Responses 函数响应参数，JSON Schema 格式
+	Responses any `json:"responses,omitempty"`
+}
+
+type ToolChoice struct {
+	// Type 指定工具类型，固定为 function
+	Type     string             `json:"type"`
+	Function ToolChoiceFunction `json:"function"`
+}
+
+type ToolChoiceFunction struct {
+	// Name 指定的函数名
+	Name string `json:"name"`
+}
+
+type FunctionCall struct {
+	// Name 触发的函数名
+	Name string `json:"name"`
+	// Arguments 请求参数，JSON 格式
+	Arguments string `json:"arguments"`
+	// Thoughts 模型思考过程
+	Thoughts string `json:"thoughts,omitempty"`
 }
 
 type ExtraParametersForChatLaw struct {
@@ -147,13 +203,15 @@ func (req ChatRequest) Fix(model Model) ChatRequest {
 const (
 	ChatMessageRoleUser      = "user"
 	ChatMessageRoleAssistant = "assistant"
+	ChatMessageRoleFunction  = "function"
 )
 
 type ChatMessages []ChatMessage
 
 func (ms ChatMessages) Fix() ChatMessages {
+	// 最后一条消息必须是用户消息或者函数调用结果
 	last := ms[len(ms)-1]
-	if last.Role != ChatMessageRoleUser {
+	if last.Role != ChatMessageRoleUser && last.Role != ChatMessageRoleFunction {
 		last = ChatMessage{
 			Role:    ChatMessageRoleUser,
 			Content: "继续",
@@ -173,20 +231,27 @@ func (ms ChatMessages) Fix() ChatMessages {
 		finalMessages = append(finalMessages, m)
 	}
 
-	if len(finalMessages)%2 == 0 {
-		finalMessages = finalMessages[:len(finalMessages)-1]
+	// 第一条消息必须是用户消息
+	finalMessages = array.Reverse(finalMessages)
+	for len(finalMessages) > 1 && finalMessages[0].Role != ChatMessageRoleUser {
+		finalMessages = finalMessages[1:]
 	}
 
-	return array.Reverse(finalMessages)
+	return finalMessages
 }
 
 type ChatMessage struct {
 	// Role 当前支持以下：
 	//   user: 表示用户
 	//   assistant: 表示对话助手
+	//   function: 表示函数
 	Role string `json:"role,omitempty"`
-	// Content 对话内容，不能为空
+	// Content 对话内容，当前 message 存在 function_call 时可以为空，其他场景不能为空
 	Content string `json:"content,omitempty"`
+	// Name message 作者，当 role=function 时必填，且必须是 function_call 中的 name
+	Name string `json:"name,omitempty"`
+	// FunctionCall 函数调用，function call 场景下第一轮对话的返回，第二轮对话作为历史信息在 message 中传入
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
 }
 
 type ChatResponse struct {
@@ -214,6 +279,8 @@ type ChatResponse struct {
 	BanRound int `json:"ban_round,omitempty"`
 	// Usage token统计信息，token数 = 汉字数+单词数*1.3 （仅为估算逻辑）
 	Usage Usage `json:"usage,omitempty"`
+	// FunctionCall 由模型生成的函数调用，包含函数名称和请求参数等
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
 }
 
 type Usage struct {
@@ -227,7 +294,8 @@ type Usage struct {
 
 // SupportSystemMessage 是否支持系统消息
 func SupportSystemMessage(model Model) bool {
-	return model == ModelErnieBot || model == ModelErnieBotTurbo || model == ModelErnieBot4
+	m, ok := catalog.Lookup(string(model))
+	return ok && m.SystemMessage
 }
 
 type Model string
@@ -236,47 +304,41 @@ const (
 	ModelErnieSpeed8K   Model = "model_ernie_speed_8k"
 	ModelErnieSpeed128K Model = "model_ernie_speed_128k"
 	// ModelErnieBot ERNIE-Bot是百度自行研发的大语言模型，覆盖海量中文数据，具有更强的对话问答、内容创作生成等能力。
-	// ¥0.012元/千tokens
 	ModelErnieBot Model = "model_ernie_bot"
 	// ModelErnieBotTurbo ERNIE-Bot-turbo是百度自行研发的大语言模型，覆盖海量中文数据，具有更强的对话问答、内容创作生成等能力，响应速度更快。
-	// ¥0.008元/千tokens
 	ModelErnieBotTurbo = "model_ernie_bot_turbo"
 	// ModelErnieBot4 文心一言 4.0
-	// ¥0.12元/千tokens
 	ModelErnieBot4 = "model_ernie_bot_4"
 	// ModelLlama2_70b Llama-2-70b-chat由Meta AI研发并开源，在编码、推理及知识应用等场景表现优秀
-	// ¥0.044元/千tokens
 	ModelLlama2_70b = "model_badiu_llama2_70b"
 	// ModelLlama2_13b Llama-2-13b-chat由Meta AI研发并开源，在编码、推理及知识应用等场景表现优秀
-	// ¥0.008元/千tokens
 	ModelLlama2_13b = "model_baidu_llama2_13b"
 	// ModelLlama2_7b_CN Qianfan-Chinese-Llama-2-7B是千帆团队在Llama-2-7b基础上的中文增强版本，在CMMLU、C-EVAL等中文数据集上表现优异
-	// ¥0.006元/千tokens
 	ModelLlama2_7b_CN = "model_baidu_llama2_7b_cn"
 	// ModelLlama2_13b_CN Qianfan-Chinese-Llama-2-13B是千帆团队在Llama-2-13b基础上的中文增强版本，在CMMLU、C-EVAL等中文数据集上表现优异
-	// ¥0.006元/千tokens
 	ModelLlama2_13b_CN = "model_baidu_llama2_13b_cn"
 	// ModelChatGLM2_6B_32K ChatGLM2-6B是由智谱AI与清华KEG实验室发布的中英双语对话模型，具备强大的推理性能、效果、较低的部署门槛及更长的上下文，在MMLU、CEval等数据集上相比初代有大幅的性能提升。
-	// ¥0.006元/千tokens
 	ModelChatGLM2_6B_32K = "model_baidu_chatglm2_6b_32k"
 	// ModelAquilaChat7B AquilaChat-7B是由智源研究院研发，基于Aquila-7B训练的对话模型，支持流畅的文本对话及多种语言类生成任务，通过定义可扩展的特殊指令规范，实现 AquilaChat对其它模型和工具的调用，且易于扩展
-	// ¥0.006元/千tokens
 	ModelAquilaChat7B = "model_baidu_aquila_chat7b"
 	// ModelBloomz7B BLOOMZ-7B是业内知名的⼤语⾔模型，由BigScience研发并开源，能够以46种语⾔和13种编程语⾔输出⽂本
-	// ¥0.006元/千tokens
 	ModelBloomz7B = "model_baidu_bloomz_7b"
 	// ModelXuanYuan70B XuanYuan-70B-Chat-4bit由度小满开发，基于Llama2-70B模型进行中文增强的金融行业大模型，通用能力显著提升，在CMMLU/CEVAL等各项榜单中排名前列；金融域任务超越领先通用模型，支持金融知识问答、金融计算、金融分析等各项任务
-	// ¥0.035元/千tokens
 	ModelXuanYuan70B = "model_baidu_xuanyuan_70b"
 	// ModelChatLaw ChatLaw由壹万卷公司与北大深研院研发的法律行业大模型，在开源版本基础上进行了进一步架构升级，融入了法律意图识别、法律关键词提取、CoT推理增强等模块，实现了效果提升，以满足法律问答、法条检索等应用需求。
-	// ¥0.008元/千tokens
 	ModelChatLaw = "model_baidu_chat_law"
 	// ModelMixtral8x7bInstruct 由Mistral AI发布的首个高质量稀疏专家混合模型 (MOE)，模型由8个70亿参数专家模型组成，在多个基准测试中表现优于Llama-2-70B及GPT3.5，能够处理32K上下文，在代码生成任务中表现尤为优异
-	// ¥0.035元/千tokens
 	ModelMixtral8x7bInstruct = "model_baidu_mixtral_8x7b_instruct"
 	ModelGemma7B             = "model_baidu_gemma_7b"
 )
 
+// SupportedModels 模型目录中百度文心千帆的全部模型
+func SupportedModels() []Model {
+	return array.Map(catalog.List(catalog.ProviderBaidu), func(item catalog.Model, _ int) Model {
+		return Model(item.ID)
+	})
+}
+
 func (ai *BaiduAIImpl) Chat(ctx context.Context, model Model, req ChatRequest) (*ChatResponse, error) {
 	req.Stream = false
 	body, err := json.Marshal(req.Fix(model))
@@ -284,7 +346,10 @@ func (ai *BaiduAIImpl) Chat(ctx context.Context, model Model, req ChatRequest) (
 		return nil, err
 	}
 
-	url := ai.modelURL(model)
+	url, err := ai.modelURL(model)
+	if err != nil {
+		return nil, err
+	}
 
 	resp, err := resty.R().SetQueryParam("access_token", ai.getAccessToken()).
 		SetHeader("Content-Type", "application/json").
@@ -307,46 +372,18 @@ func (ai *BaiduAIImpl) Chat(ctx context.Context, model Model, req ChatRequest) (
 	return &chatResponse, nil
 }
 
-func (ai *BaiduAIImpl) modelURL(model Model) string {
-	var url string
-	switch model {
-	case ModelErnieSpeed8K:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/ernie_speed"
-	case ModelErnieSpeed128K:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/ernie-speed-128k"
-	case ModelErnieBot:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/completions"
-	case ModelErnieBotTurbo:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/eb-instant"
-	case ModelErnieBot4:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/completions_pro"
-	case ModelLlama2_70b:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/llama_2_70b"
-	case ModelLlama2_13b:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/llama_2_13b"
-	case ModelLlama2_7b_CN:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/qianfan_chinese_llama_2_7b"
-	case ModelLlama2_13b_CN:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/qianfan_chinese_llama_2_13b"
-	case ModelChatGLM2_6B_32K:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/chatglm2_6b_32k"
-	case ModelAquilaChat7B:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/aquilachat_7b"
-	case ModelBloomz7B:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/bloomz_7b1"
-	case ModelXuanYuan70B:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/xuanyuan_70b_chat"
-	case ModelChatLaw:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/chatlaw"
-	case ModelMixtral8x7bInstruct:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/mixtral_8x7b_instruct"
-	case ModelGemma7B:
-		url = "https://aip.baidubce.com/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/gemma_7b_it"
-	default:
-		panic("invalid model")
+// modelURL 根据模型目录中的服务端点获取模型的请求地址
+func (ai *BaiduAIImpl) modelURL(model Model) (string, error) {
+	m, ok := catalog.Lookup(string(model))
+	if !ok || m.Endpoint == "" {
+		return "", fmt.Errorf("baidu: unsupported model %s", model)
 	}
 
-	return url
+	if strings.HasPrefix(m.Endpoint, "http://") || strings.HasPrefix(m.Endpoint, "https://") {
+		return m.Endpoint, nil
+	}
+
+	return ai.Server + "/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/" + m.Endpoint, nil
 }
 
 func (ai *BaiduAIImpl) ChatStream(ctx context.Context, model Model, req ChatRequest) (<-chan ChatResponse, error) {
@@ -356,7 +393,10 @@ func (ai *BaiduAIImpl) ChatStream(ctx context.Context, model Model, req ChatRequ
 		return nil, err
 	}
 
-	url := ai.modelURL(model)
+	url, err := ai.modelURL(model)
+	if err != nil {
+		return nil, err
+	}
 
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", url+"?access_token="+ai.getAccessToken(), bytes.NewReader(body))
 	if err != nil {
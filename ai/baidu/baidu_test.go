@@ -0,0 +1,118 @@
+package baidu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"accompany-sdk/ai/catalog"
+)
+
+// qianfanServer 模拟文心千帆服务，记录收到的请求路径
+type qianfanServer struct {
+	*httptest.Server
+
+	lock  sync.Mutex
+	paths []string
+}
+
+func newQianfanServer(t *testing.T) *qianfanServer {
+	s := &qianfanServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.paths = append(s.paths, r.URL.Path)
+		s.lock.Unlock()
+
+		if r.URL.Path == "/oauth/2.0/token" {
+			if r.URL.Query().Get("client_id") != "test-key" || r.URL.Query().Get("client_secret") != "test-secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"access_token":"test-token","expires_in":2592000}`))
+			return
+		}
+
+		if r.URL.Query().Get("access_token") != "test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+
+		if !req.Stream {
+			_, _ = w.Write([]byte(`{"id":"as-1","result":"你好","usage":{"prompt_tokens":3,"completion_tokens":2}}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i, text := range []string{"床前", "明月光"} {
+			_, _ = fmt.Fprintf(w, "data: {\"sentence_id\":%d,\"result\":%q,\"is_end\":%v}\n\n", i, text, i == 1)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestNewBaiduAI_Server(t *testing.T) {
+	if server := resolveServer(""); server != DefaultServer {
+		t.Errorf("expect the default server, got %s", server)
+	}
+
+	server := newQianfanServer(t)
+
+	// 配置的服务地址末尾的 / 被去掉，获取 AccessToken 和对话都使用配置的服务地址
+	ai := NewBaiduAI(server.URL+"/", "test-key", "test-secret")
+	if ai.Server != server.URL || ai.getAccessToken() != "test-token" {
+		t.Fatalf("expect the access token from the configured server, got %s %q", ai.Server, ai.getAccessToken())
+	}
+
+	req := ChatRequest{Messages: ChatMessages{{Role: ChatMessageRoleUser, Content: "你好"}}}
+	res, err := ai.Chat(context.Background(), ModelErnieBot, req)
+	if err != nil || res.Result != "你好" || res.Usage.PromptTokens != 3 {
+		t.Fatalf("unexpected response %+v %v", res, err)
+	}
+
+	m, _ := catalog.Lookup(string(ModelErnieBot))
+	if path := server.paths[len(server.paths)-1]; path != "/rpc/2.0/ai_custom/v1/wenxinworkshop/chat/"+m.Endpoint {
+		t.Errorf("unexpected chat path %s", path)
+	}
+}
+
+func TestBaiduAI_ChatStream(t *testing.T) {
+	server := newQianfanServer(t)
+	ai := NewBaiduAI(server.URL, "test-key", "test-secret")
+
+	req := ChatRequest{Messages: ChatMessages{{Role: ChatMessageRoleUser, Content: "静夜思"}}}
+	stream, err := ai.ChatStream(context.Background(), ModelErnieBot, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var texts []string
+	for data := range stream {
+		if data.ErrorCode != 0 {
+			t.Fatalf("unexpected error %+v", data)
+		}
+
+		texts = append(texts, data.Result)
+	}
+
+	if strings.Join(texts, "") != "床前明月光" {
+		t.Fatalf("unexpected stream %v", texts)
+	}
+
+	// 不支持的模型在发送请求之前返回错误
+	if _, err := ai.ChatStream(context.Background(), "unknown-model", req); err == nil {
+		t.Fatal("expect an error for an unsupported model")
+	}
+}
@@ -14,14 +14,17 @@ import (
 )
 
 type BaiduImageAI struct {
+	Server      string
 	APIKey      string
 	APISecret   string
 	accessToken string
 	lock        sync.RWMutex
 }
 
-func NewBaiduImageAI(apiKey, apiSecret string) *BaiduImageAI {
+// NewBaiduImageAI 创建百度图像处理客户端，server 为空时使用默认服务地址
+func NewBaiduImageAI(server, apiKey, apiSecret string) *BaiduImageAI {
 	ai := &BaiduImageAI{
+		Server:    resolveServer(server),
 		APIKey:    apiKey,
 		APISecret: apiSecret,
 	}
@@ -39,7 +42,7 @@ func (ai *BaiduImageAI) RefreshAccessToken() error {
 		SetQueryParam("grant_type", "client_credentials").
 		SetQueryParam("client_id", ai.APIKey).
 		SetQueryParam("client_secret", ai.APISecret).
-		Post("https://aip.baidubce.com/oauth/2.0/token")
+		Post(ai.Server + "/oauth/2.0/token")
 	if err != nil {
 		return err
 	}
@@ -130,7 +133,7 @@ func (ai *BaiduImageAI) ImageStyleTrans(ctx context.Context, req ImageStyleTrans
 		SetFormData(req.ToFormData()).
 		SetQueryParam("access_token", ai.getAccessToken()).
 		SetContext(ctx).
-		Post("https://aip.baidubce.com/rest/2.0/image-process/v1/style_trans")
+		Post(ai.Server + "/rest/2.0/image-process/v1/style_trans")
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %v", err)
 	}
@@ -193,7 +196,7 @@ func (ai *BaiduImageAI) SelfieAnime(ctx context.Context, req SelfieAnimeRequest)
 		SetFormData(req.ToFormData()).
 		SetQueryParam("access_token", ai.getAccessToken()).
 		SetContext(ctx).
-		Post("https://aip.baidubce.com/rest/2.0/image-process/v1/selfie_anime")
+		Post(ai.Server + "/rest/2.0/image-process/v1/selfie_anime")
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %v", err)
 	}
@@ -243,7 +246,7 @@ func (ai *BaiduImageAI) Colourize(ctx context.Context, req SimpleImageRequest) (
 		SetFormData(req.ToFormData()).
 		SetQueryParam("access_token", ai.getAccessToken()).
 		SetContext(ctx).
-		Post("https://aip.baidubce.com/rest/2.0/image-process/v1/colourize")
+		Post(ai.Server + "/rest/2.0/image-process/v1/colourize")
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %v", err)
 	}
@@ -270,7 +273,7 @@ func (ai *BaiduImageAI) QualityEnhance(ctx context.Context, req SimpleImageReque
 		SetFormData(req.ToFormData()).
 		SetQueryParam("access_token", ai.getAccessToken()).
 		SetContext(ctx).
-		Post("https://aip.baidubce.com/rest/2.0/image-process/v1/image_quality_enhance")
+		Post(ai.Server + "/rest/2.0/image-process/v1/image_quality_enhance")
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %v", err)
 	}
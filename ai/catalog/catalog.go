@@ -0,0 +1,229 @@
+package catalog
+
+import (
+	"accompany-sdk/ai_struct"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	ProviderOpenAI    = "openai"
+	ProviderBaidu     = "baidu"
+	ProviderAnthropic = "anthropic"
+	ProviderZhipu     = "zhipu"
+)
+
+const (
+	CurrencyUSD = "USD"
+	CurrencyCNY = "CNY"
+)
+
+// Model 模型元数据
+type Model struct {
+	// ID 模型名称，不包含服务提供商前缀
+	ID string `json:"id"`
+	// Provider 服务提供商
+	Provider string `json:"provider"`
+	// ContextWindow 可用于输入的最大上下文长度（已经为输出预留了 token）
+	ContextWindow int `json:"context_window"`
+	// MaxOutput 最大输出 token 数，为 0 表示未知
+	MaxOutput int `json:"max_output,omitempty"`
+	// Vision 是否支持图片输入
+	Vision bool `json:"vision,omitempty"`
+	// SystemMessage 是否支持系统消息
+	SystemMessage bool `json:"system_message,omitempty"`
+	// Tools 是否支持工具调用
+	Tools bool `json:"tools,omitempty"`
+	// Endpoint 模型对应的服务端点，目前只有百度文心千帆使用，可以为完整的 URL
+	Endpoint string `json:"endpoint,omitempty"`
+	// InputPrice 每千输入 tokens 的价格
+	InputPrice float64 `json:"input_price,omitempty"`
+	// OutputPrice 每千输出 tokens 的价格
+	OutputPrice float64 `json:"output_price,omitempty"`
+	// Currency 价格的货币单位，USD 或者 CNY
+	Currency string `json:"currency,omitempty"`
+	// Aliases 模型别名，使用别名查询时返回该模型
+	Aliases []string `json:"aliases,omitempty"`
+	// Downgrade 上下文较短时可以替换使用的更便宜的模型
+	Downgrade string `json:"downgrade,omitempty"`
+	// DowngradeThreshold token 数不超过该值时使用替换模型，为 0 时使用替换模型的上下文长度
+	DowngradeThreshold int `json:"downgrade_threshold,omitempty"`
+}
+
+// Catalog 模型目录，并发安全
+type Catalog struct {
+	lock    sync.RWMutex
+	models  map[string]Model
+	aliases map[string]string
+}
+
+func New(models ...Model) *Catalog {
+	c := &Catalog{
+		models:  make(map[string]Model),
+		aliases: make(map[string]string),
+	}
+
+	c.Register(models...)
+	return c
+}
+
+// Register 注册模型，已经存在的同名模型会被覆盖
+func (c *Catalog) Register(models ...Model) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, m := range models {
+		if m.ID == "" {
+			continue
+		}
+
+		if old, ok := c.models[m.ID]; ok {
+			for _, alias := range old.Aliases {
+				delete(c.aliases, alias)
+			}
+		}
+
+		c.models[m.ID] = m
+		delete(c.aliases, m.ID)
+		for _, alias := range m.Aliases {
+			c.aliases[alias] = m.ID
+		}
+	}
+}
+
+// Lookup 根据模型名称或者别名查询模型
+func (c *Catalog) Lookup(id string) (Model, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if m, ok := c.models[id]; ok {
+		return m, true
+	}
+
+	if real, ok := c.aliases[id]; ok {
+		m, ok := c.models[real]
+		return m, ok
+	}
+
+	return Model{}, false
+}
+
+// List 返回服务提供商的全部模型，provider 为空时返回全部模型，按服务提供商和模型名称排序
+func (c *Catalog) List(provider string) []Model {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	models := make([]Model, 0, len(c.models))
+	for _, m := range c.models {
+		if provider == "" || m.Provider == provider {
+			models = append(models, m)
+		}
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		if models[i].Provider != models[j].Provider {
+			return models[i].Provider < models[j].Provider
+		}
+
+		return models[i].ID < models[j].ID
+	})
+
+	return models
+}
+
+// ContextWindow 查询模型可用的上下文长度，模型不存在时返回 fallback
+func (c *Catalog) ContextWindow(id string, fallback int) int {
+	if m, ok := c.Lookup(id); ok && m.ContextWindow > 0 {
+		return m.ContextWindow
+	}
+
+	return fallback
+}
+
+// SelectBestModel 根据 token 数量选择最合适的模型，如果 token 数不超过替换阈值，则使用替换模型。
+// 未注册的模型按照最长的模型名称前缀匹配，如 gpt-3.5-turbo-16k-0301 使用 gpt-3.5-turbo-16k 的替换规则
+func (c *Catalog) SelectBestModel(id string, tokenCount int) string {
+	m, ok := c.Lookup(id)
+	if !ok {
+		m, ok = c.lookupPrefix(id)
+	}
+
+	if !ok || m.Downgrade == "" {
+		return id
+	}
+
+	threshold := m.DowngradeThreshold
+	if threshold <= 0 {
+		threshold = c.ContextWindow(m.Downgrade, 0)
+	}
+
+	if tokenCount <= threshold {
+		return m.Downgrade
+	}
+
+	return id
+}
+
+// lookupPrefix 查询名称是 id 前缀的模型中名称最长的可替换模型
+func (c *Catalog) lookupPrefix(id string) (Model, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var matched Model
+	for _, m := range c.models {
+		if m.Downgrade != "" && strings.HasPrefix(id, m.ID) && len(m.ID) > len(matched.ID) {
+			matched = m
+		}
+	}
+
+	return matched, matched.ID != ""
+}
+
+// FromConfig 把配置文件中的模型配置转换为模型元数据
+func FromConfig(conf ai_struct.ModelConfig) Model {
+	return Model{
+		ID:                 strings.TrimSpace(conf.ID),
+		Provider:           conf.Provider,
+		ContextWindow:      conf.ContextWindow,
+		MaxOutput:          conf.MaxOutput,
+		Vision:             conf.Vision,
+		SystemMessage:      conf.SystemMessage,
+		Tools:              conf.Tools,
+		Endpoint:           conf.Endpoint,
+		InputPrice:         conf.InputPrice,
+		OutputPrice:        conf.OutputPrice,
+		Currency:           conf.Currency,
+		Aliases:            conf.Aliases,
+		Downgrade:          conf.Downgrade,
+		DowngradeThreshold: conf.DowngradeThreshold,
+	}
+}
+
+// Default 默认的模型目录，包含内置的全部模型
+var Default = New(builtinModels...)
+
+// Register 注册模型到默认模型目录
+func Register(models ...Model) {
+	Default.Register(models...)
+}
+
+// Lookup 从默认模型目录中查询模型
+func Lookup(id string) (Model, bool) {
+	return Default.Lookup(id)
+}
+
+// List 返回默认模型目录中服务提供商的全部模型
+func List(provider string) []Model {
+	return Default.List(provider)
+}
+
+// ContextWindow 从默认模型目录中查询模型可用的上下文长度
+func ContextWindow(id string, fallback int) int {
+	return Default.ContextWindow(id, fallback)
+}
+
+// SelectBestModel 根据默认模型目录选择最合适的模型
+func SelectBestModel(id string, tokenCount int) string {
+	return Default.SelectBestModel(id, tokenCount)
+}
@@ -0,0 +1,108 @@
+package catalog
+
+import (
+	"accompany-sdk/ai_struct"
+	"testing"
+)
+
+func TestSelectBestModel(t *testing.T) {
+	cases := []struct {
+		model      string
+		tokenCount int
+		expect     string
+	}{
+		{model: "gpt-3.5-turbo-16k", tokenCount: 3500, expect: "gpt-3.5-turbo"},
+		{model: "gpt-3.5-turbo-16k", tokenCount: 4000, expect: "gpt-3.5-turbo"},
+		{model: "gpt-3.5-turbo-16k", tokenCount: 4001, expect: "gpt-3.5-turbo-16k"},
+		{model: "gpt-3.5-turbo-16k-0613", tokenCount: 3800, expect: "gpt-3.5-turbo"},
+		// 未注册的变体按照前缀匹配
+		{model: "gpt-3.5-turbo-16k-0301", tokenCount: 3800, expect: "gpt-3.5-turbo"},
+		{model: "gpt-3.5-turbo-16k-0301", tokenCount: 5000, expect: "gpt-3.5-turbo-16k-0301"},
+		{model: "gpt-4-32k", tokenCount: 8000, expect: "gpt-4"},
+		{model: "gpt-4-32k", tokenCount: 8001, expect: "gpt-4-32k"},
+		{model: "gpt-4-32k-0314", tokenCount: 7800, expect: "gpt-4"},
+		{model: "gpt-4", tokenCount: 100, expect: "gpt-4"},
+		{model: "gpt-4-1106-preview", tokenCount: 100, expect: "gpt-4-1106-preview"},
+		{model: "unknown-model", tokenCount: 100, expect: "unknown-model"},
+	}
+
+	for _, c := range cases {
+		if got := SelectBestModel(c.model, c.tokenCount); got != c.expect {
+			t.Errorf("SelectBestModel(%q, %d): expect %q, got %q", c.model, c.tokenCount, c.expect, got)
+		}
+	}
+}
+
+func TestCatalog_SelectBestModelThreshold(t *testing.T) {
+	c := New(
+		Model{ID: "small", ContextWindow: 1000},
+		Model{ID: "large", ContextWindow: 10000, Downgrade: "small"},
+		Model{ID: "large-pinned", ContextWindow: 10000, Downgrade: "small", DowngradeThreshold: 500},
+	)
+
+	// 没有配置阈值时使用替换模型的上下文长度
+	if got := c.SelectBestModel("large", 1000); got != "small" {
+		t.Errorf("expect small, got %q", got)
+	}
+	if got := c.SelectBestModel("large", 1001); got != "large" {
+		t.Errorf("expect large, got %q", got)
+	}
+
+	// 最长前缀优先
+	if got := c.SelectBestModel("large-pinned-v2", 800); got != "large-pinned-v2" {
+		t.Errorf("expect large-pinned-v2, got %q", got)
+	}
+	if got := c.SelectBestModel("large-pinned-v2", 500); got != "small" {
+		t.Errorf("expect small, got %q", got)
+	}
+}
+
+func TestCatalog_Register(t *testing.T) {
+	c := New(Model{ID: "model-a", ContextWindow: 1000, Aliases: []string{"alias-a"}})
+
+	if m, ok := c.Lookup("alias-a"); !ok || m.ID != "model-a" {
+		t.Fatalf("expect alias lookup, got %+v %v", m, ok)
+	}
+
+	// 覆盖同名模型时删除旧的别名
+	c.Register(Model{ID: "model-a", ContextWindow: 2000, Aliases: []string{"alias-b"}})
+	if _, ok := c.Lookup("alias-a"); ok {
+		t.Error("expect the old alias removed")
+	}
+	if got := c.ContextWindow("alias-b", 0); got != 2000 {
+		t.Errorf("expect 2000, got %d", got)
+	}
+	if got := c.ContextWindow("unknown", 42); got != 42 {
+		t.Errorf("expect fallback 42, got %d", got)
+	}
+
+	// 模型名称为空时忽略
+	c.Register(Model{Provider: ProviderOpenAI})
+	if models := c.List(""); len(models) != 1 {
+		t.Errorf("expect 1 model, got %+v", models)
+	}
+}
+
+func TestCatalog_List(t *testing.T) {
+	c := New(
+		Model{ID: "b", Provider: ProviderOpenAI},
+		Model{ID: "a", Provider: ProviderOpenAI},
+		Model{ID: "c", Provider: ProviderBaidu},
+	)
+
+	models := c.List("")
+	if len(models) != 3 || models[0].ID != "c" || models[1].ID != "a" || models[2].ID != "b" {
+		t.Errorf("unexpected order %+v", models)
+	}
+
+	if models := c.List(ProviderOpenAI); len(models) != 2 {
+		t.Errorf("expect 2 openai models, got %+v", models)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	m := FromConfig(ai_struct.ModelConfig{ID: " custom ", Provider: ProviderOpenAI, Downgrade: "gpt-4", DowngradeThreshold: 6000})
+	if m.ID != "custom" || m.Downgrade != "gpt-4" || m.DowngradeThreshold != 6000 {
+		t.Errorf("unexpected model %+v", m)
+	}
+}
@@ -0,0 +1,162 @@
+package catalog
+
+// builtinModels 内置的模型元数据，配置文件中的模型会覆盖同名的内置模型
+var builtinModels = []Model{
+	// OpenAI
+	// https://platform.openai.com/docs/models/overview
+	// https://openai.com/pricing
+	{
+		ID: "gpt-3.5-turbo", Provider: ProviderOpenAI, ContextWindow: 3500, MaxOutput: 4096,
+		SystemMessage: true, Tools: true, InputPrice: 0.0015, OutputPrice: 0.002, Currency: CurrencyUSD,
+		Aliases: []string{"gpt-3.5-turbo-0613"},
+	},
+	{
+		ID: "gpt-3.5-turbo-instruct", Provider: ProviderOpenAI, ContextWindow: 3500, MaxOutput: 4096,
+		SystemMessage: true, InputPrice: 0.0015, OutputPrice: 0.002, Currency: CurrencyUSD,
+	},
+	{
+		ID: "gpt-3.5-turbo-16k", Provider: ProviderOpenAI, ContextWindow: 3500 * 4, MaxOutput: 4096,
+		SystemMessage: true, Tools: true, InputPrice: 0.003, OutputPrice: 0.004, Currency: CurrencyUSD,
+		Aliases: []string{"gpt-3.5-turbo-16k-0613"}, Downgrade: "gpt-3.5-turbo",
+		DowngradeThreshold: 4000,
+	},
+	{
+		ID: "gpt-3.5-turbo-1106", Provider: ProviderOpenAI, ContextWindow: 16385 - 4096, MaxOutput: 4096,
+		SystemMessage: true, Tools: true, InputPrice: 0.001, OutputPrice: 0.002, Currency: CurrencyUSD,
+	},
+	{
+		ID: "gpt-4", Provider: ProviderOpenAI, ContextWindow: 7500, MaxOutput: 8192,
+		SystemMessage: true, Tools: true, InputPrice: 0.03, OutputPrice: 0.06, Currency: CurrencyUSD,
+		Aliases: []string{"gpt-4-0613"},
+	},
+	{
+		ID: "gpt-4-32k", Provider: ProviderOpenAI, ContextWindow: 3500 * 8, MaxOutput: 8192,
+		SystemMessage: true, Tools: true, InputPrice: 0.06, OutputPrice: 0.12, Currency: CurrencyUSD,
+		Aliases: []string{"gpt-4-32k-0613"}, Downgrade: "gpt-4",
+		DowngradeThreshold: 8000,
+	},
+	{
+		ID: "gpt-4-1106-preview", Provider: ProviderOpenAI, ContextWindow: 128000 - 4096, MaxOutput: 4096,
+		SystemMessage: true, Tools: true, InputPrice: 0.01, OutputPrice: 0.03, Currency: CurrencyUSD,
+	},
+	{
+		ID: "gpt-4-vision-preview", Provider: ProviderOpenAI, ContextWindow: 128000 - 4096, MaxOutput: 4096,
+		Vision: true, SystemMessage: true, InputPrice: 0.01, OutputPrice: 0.03, Currency: CurrencyUSD,
+	},
+
+	// 百度文心千帆
+	// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/Nlks5zkzu
+	{
+		ID: "model_ernie_speed_8k", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "ernie_speed", Currency: CurrencyCNY,
+	},
+	{
+		ID: "model_ernie_speed_128k", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "ernie-speed-128k", Currency: CurrencyCNY,
+	},
+	{
+		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/jlil56u11
+		ID: "model_ernie_bot", Provider: ProviderBaidu, ContextWindow: 3000, SystemMessage: true, Tools: true,
+		Endpoint: "completions", InputPrice: 0.012, OutputPrice: 0.012, Currency: CurrencyCNY,
+	},
+	{
+		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/4lilb2lpf
+		ID: "model_ernie_bot_turbo", Provider: ProviderBaidu, ContextWindow: 7000, SystemMessage: true,
+		Endpoint: "eb-instant", InputPrice: 0.008, OutputPrice: 0.008, Currency: CurrencyCNY,
+	},
+	{
+		ID: "model_ernie_bot_4", Provider: ProviderBaidu, ContextWindow: 3000, SystemMessage: true, Tools: true,
+		Endpoint: "completions_pro", InputPrice: 0.12, OutputPrice: 0.12, Currency: CurrencyCNY,
+	},
+	{
+		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/8lkjfhiyt
+		ID: "model_badiu_llama2_70b", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "llama_2_70b", InputPrice: 0.044, OutputPrice: 0.044, Currency: CurrencyCNY,
+	},
+	{
+		ID: "model_baidu_llama2_13b", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "llama_2_13b", InputPrice: 0.008, OutputPrice: 0.008, Currency: CurrencyCNY,
+	},
+	{
+		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/Sllyztytp
+		ID: "model_baidu_llama2_7b_cn", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "qianfan_chinese_llama_2_7b", InputPrice: 0.006, OutputPrice: 0.006, Currency: CurrencyCNY,
+	},
+	{
+		ID: "model_baidu_llama2_13b_cn", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "qianfan_chinese_llama_2_13b", InputPrice: 0.006, OutputPrice: 0.006, Currency: CurrencyCNY,
+	},
+	{
+		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/Bllz001ff
+		ID: "model_baidu_chatglm2_6b_32k", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "chatglm2_6b_32k", InputPrice: 0.006, OutputPrice: 0.006, Currency: CurrencyCNY,
+	},
+	{
+		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/ollz02e7i
+		ID: "model_baidu_aquila_chat7b", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "aquilachat_7b", InputPrice: 0.006, OutputPrice: 0.006, Currency: CurrencyCNY,
+	},
+	{
+		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/Jljcadglj
+		ID: "model_baidu_bloomz_7b", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "bloomz_7b1", InputPrice: 0.006, OutputPrice: 0.006, Currency: CurrencyCNY,
+	},
+	{
+		ID: "model_baidu_xuanyuan_70b", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "xuanyuan_70b_chat", InputPrice: 0.035, OutputPrice: 0.035, Currency: CurrencyCNY,
+	},
+	{
+		ID: "model_baidu_chat_law", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "chatlaw", InputPrice: 0.008, OutputPrice: 0.008, Currency: CurrencyCNY,
+	},
+	{
+		ID: "model_baidu_mixtral_8x7b_instruct", Provider: ProviderBaidu, ContextWindow: 30000,
+		Endpoint: "mixtral_8x7b_instruct", InputPrice: 0.035, OutputPrice: 0.035, Currency: CurrencyCNY,
+	},
+	{
+		ID: "model_baidu_gemma_7b", Provider: ProviderBaidu, ContextWindow: 3000,
+		Endpoint: "gemma_7b_it", Currency: CurrencyCNY,
+	},
+
+	// Anthropic
+	// https://docs.anthropic.com/claude/docs/models-overview
+	{
+		ID: "claude-3-opus-20240229", Provider: ProviderAnthropic, ContextWindow: 200000 - 4096, MaxOutput: 4096,
+		Vision: true, SystemMessage: true, Tools: true, InputPrice: 0.015, OutputPrice: 0.075, Currency: CurrencyUSD,
+	},
+	{
+		ID: "claude-3-sonnet-20240229", Provider: ProviderAnthropic, ContextWindow: 200000 - 4096, MaxOutput: 4096,
+		Vision: true, SystemMessage: true, Tools: true, InputPrice: 0.003, OutputPrice: 0.015, Currency: CurrencyUSD,
+	},
+	{
+		ID: "claude-3-haiku-20240307", Provider: ProviderAnthropic, ContextWindow: 200000 - 4096, MaxOutput: 4096,
+		Vision: true, SystemMessage: true, Tools: true, InputPrice: 0.00025, OutputPrice: 0.00125, Currency: CurrencyUSD,
+	},
+	{
+		ID: "claude-2.1", Provider: ProviderAnthropic, ContextWindow: 200000 - 4096, MaxOutput: 4096,
+		SystemMessage: true, InputPrice: 0.008, OutputPrice: 0.024, Currency: CurrencyUSD,
+	},
+	{
+		ID: "claude-2.0", Provider: ProviderAnthropic, ContextWindow: 100000 - 4096, MaxOutput: 4096,
+		SystemMessage: true, InputPrice: 0.008, OutputPrice: 0.024, Currency: CurrencyUSD,
+	},
+	{
+		ID: "claude-instant-1.2", Provider: ProviderAnthropic, ContextWindow: 100000 - 4096, MaxOutput: 4096,
+		SystemMessage: true, InputPrice: 0.0008, OutputPrice: 0.0024, Currency: CurrencyUSD,
+	},
+
+	// 智谱 AI
+	// https://open.bigmodel.cn/dev/howuse/model
+	{
+		ID: "glm-4", Provider: ProviderZhipu, ContextWindow: 128000 - 4096, MaxOutput: 4096,
+		SystemMessage: true, Tools: true, InputPrice: 0.1, OutputPrice: 0.1, Currency: CurrencyCNY,
+	},
+	{
+		ID: "glm-4v", Provider: ProviderZhipu, ContextWindow: 2000, MaxOutput: 1024,
+		Vision: true, InputPrice: 0.1, OutputPrice: 0.1, Currency: CurrencyCNY,
+	},
+	{
+		ID: "glm-3-turbo", Provider: ProviderZhipu, ContextWindow: 128000 - 4096, MaxOutput: 4096,
+		SystemMessage: true, Tools: true, InputPrice: 0.005, OutputPrice: 0.005, Currency: CurrencyCNY,
+	},
+}
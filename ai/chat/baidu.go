@@ -2,8 +2,12 @@ package chat
 
 import (
 	"accompany-sdk/ai/baidu"
+	"accompany-sdk/ai/catalog"
+	"accompany-sdk/pkg/ternary"
+	"accompany-sdk/pkg/utils/array"
 	"context"
 	"fmt"
+	"math"
 	"strings"
 )
 
@@ -15,18 +19,35 @@ func NewBaiduAIChat(bai baidu.BaiduAI) *BaiduAIChat {
 	return &BaiduAIChat{bai: bai}
 }
 
-func (chat *BaiduAIChat) initRequest(req Request) baidu.ChatRequest {
-	req.Model = strings.TrimPrefix(req.Model, "文心千帆:")
+func (chat *BaiduAIChat) initRequest(req Request) (baidu.ChatRequest, error) {
+	req = req.ClampSampling(BaiduSamplingLimits)
+	if err := CheckToolSupport(req); err != nil {
+		return baidu.ChatRequest{}, err
+	}
 
 	var systemMessages baidu.ChatMessages
 	var contextMessages baidu.ChatMessages
 
+	// 工具调用 ID 到函数名称的映射，用于补全工具调用结果消息中的函数名称
+	toolNames := make(map[string]string)
 	for _, msg := range req.Messages {
 		m := baidu.ChatMessage{
 			Role:    msg.Role,
 			Content: msg.Content,
 		}
 
+		// 文心千帆每次只会调用一个函数，且没有工具调用 ID 的概念
+		if len(msg.ToolCalls) > 0 {
+			call := msg.ToolCalls[0]
+			toolNames[call.ID] = call.Function.Name
+			m.FunctionCall = &baidu.FunctionCall{Name: call.Function.Name, Arguments: call.Function.Arguments}
+		}
+
+		if msg.Role == "tool" {
+			m.Role = baidu.ChatMessageRoleFunction
+			m.Name = ternary.If(msg.Name != "", msg.Name, toolNames[msg.ToolCallID])
+		}
+
 		if msg.Role == "system" {
 			systemMessages = append(systemMessages, m)
 		} else {
@@ -34,12 +55,34 @@ func (chat *BaiduAIChat) initRequest(req Request) baidu.ChatRequest {
 		}
 	}
 
-	res := baidu.ChatRequest{}
+	res := baidu.ChatRequest{
+		Temperature:    req.Temperature,
+		TopP:           req.TopP,
+		PenaltyScore:   baiduPenaltyScore(req),
+		Stop:           req.Stop,
+		ResponseFormat: req.ResponseFormat,
+		Functions: array.Map(
+			array.Filter(req.Tools, func(item Tool, _ int) bool { return item.Function != nil }),
+			func(item Tool, _ int) baidu.Function {
+				return baidu.Function{
+					Name:        item.Function.Name,
+					Description: item.Function.Description,
+					Parameters:  ternary.If[any](item.Function.Parameters != nil, item.Function.Parameters, map[string]any{"type": "object"}),
+				}
+			},
+		),
+	}
+
+	// 文心千帆只支持提示模型选择指定的函数
+	if len(res.Functions) > 0 && req.ToolChoice != "" && !array.In(req.ToolChoice, []string{ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired}) {
+		res.ToolChoice = &baidu.ToolChoice{Type: ToolTypeFunction, Function: baidu.ToolChoiceFunction{Name: req.ToolChoice}}
+	}
 
 	contextMessages = contextMessages.Fix()
 	if len(systemMessages) > 0 {
 		systemMessage := systemMessages[0]
-		if baidu.SupportSystemMessage(baidu.Model(req.Model)) {
+		// 使用 functions 参数时，不支持设定人设 system
+		if baidu.SupportSystemMessage(baidu.Model(req.Model)) && len(res.Functions) == 0 {
 			res.System = systemMessage.Content
 			if len(res.System) > 1024 {
 				res.System = res.System[:1024]
@@ -62,11 +105,21 @@ func (chat *BaiduAIChat) initRequest(req Request) baidu.ChatRequest {
 	}
 
 	res.Messages = contextMessages
-	return res
+	return res, nil
 }
 
 func (chat *BaiduAIChat) Chat(ctx context.Context, req Request) (*Response, error) {
-	res, err := chat.bai.Chat(ctx, baidu.Model(req.Model), chat.initRequest(req))
+	if err := CheckSingleChoice(req); err != nil {
+		return nil, err
+	}
+
+	req.Model = strings.TrimPrefix(req.Model, "文心千帆:")
+	baiduReq, err := chat.initRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := chat.bai.Chat(ctx, baidu.Model(req.Model), baiduReq)
 	if err != nil {
 		return nil, err
 	}
@@ -77,13 +130,24 @@ func (chat *BaiduAIChat) Chat(ctx context.Context, req Request) (*Response, erro
 
 	return &Response{
 		Text:         res.Result,
+		FinishReason: ternary.If(res.FunctionCall != nil, FinishReasonToolCalls, ""),
 		InputTokens:  res.Usage.PromptTokens,
 		OutputTokens: res.Usage.CompletionTokens,
+		ToolCalls:    fromBaiduFunctionCall(res.Id, res.FunctionCall),
 	}, nil
 }
 
 func (chat *BaiduAIChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
-	baiduReq := chat.initRequest(req)
+	if err := CheckSingleChoice(req); err != nil {
+		return nil, err
+	}
+
+	req.Model = strings.TrimPrefix(req.Model, "文心千帆:")
+	baiduReq, err := chat.initRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
 	baiduReq.Stream = true
 
 	stream, err := chat.bai.ChatStream(ctx, baidu.Model(req.Model), baiduReq)
@@ -116,9 +180,15 @@ func (chat *BaiduAIChat) ChatStream(ctx context.Context, req Request) (<-chan Re
 				case <-ctx.Done():
 					return
 				case res <- Response{
-					Text:         data.Result,
+					Text: data.Result,
+					FinishReason: ternary.If(
+						data.FunctionCall != nil,
+						FinishReasonToolCalls,
+						ternary.If(data.IsEND, "stop", ""),
+					),
 					InputTokens:  data.Usage.PromptTokens,
 					OutputTokens: data.Usage.TotalTokens - data.Usage.PromptTokens,
+					ToolCalls:    fromBaiduFunctionCall(data.Id, data.FunctionCall),
 				}:
 				}
 			}
@@ -128,36 +198,40 @@ func (chat *BaiduAIChat) ChatStream(ctx context.Context, req Request) (<-chan Re
 	return res, nil
 }
 
-func (chat *BaiduAIChat) MaxContextLength(model string) int {
-	switch baidu.Model(model) {
-	case baidu.ModelErnieBot:
-		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/jlil56u11
-		return 3000
-	case baidu.ModelErnieBotTurbo:
-		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/4lilb2lpf
-		return 7000
-	case baidu.ModelLlama2_70b, baidu.ModelLlama2_13b:
-		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/8lkjfhiyt
-		return 3000
-	case baidu.ModelLlama2_7b_CN, baidu.ModelLlama2_13b_CN:
-		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/Sllyztytp
-		return 3000
-	case baidu.ModelChatGLM2_6B_32K:
-		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/Bllz001ff
-		return 3000
-	case baidu.ModelAquilaChat7B:
-		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/ollz02e7i
-		return 3000
-	case baidu.ModelBloomz7B:
-		// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/Jljcadglj
-		return 3000
-	case baidu.ModelChatLaw:
-		return 3000
-	case baidu.ModelXuanYuan70B:
-		return 3000
-	case baidu.ModelMixtral8x7bInstruct:
-		return 30000
+// baiduPenaltyScore 文心千帆只有 penalty_score 一个惩罚参数，取值范围为 [1.0, 2.0]，
+// 这里取 presence_penalty 和 frequency_penalty 中较大的一个，把 [0, 2] 映射到 [1, 2]
+// 两个参数都未设置时返回 0，使用服务提供商的默认值
+func baiduPenaltyScore(req Request) float64 {
+	if req.PresencePenalty == nil && req.FrequencyPenalty == nil {
+		return 0
 	}
 
-	return 3000
+	penalty := math.Inf(-1)
+	for _, value := range []*float64{req.PresencePenalty, req.FrequencyPenalty} {
+		if value != nil {
+			penalty = math.Max(penalty, *value)
+		}
+	}
+
+	return clamp(1+penalty/2, 1, 2)
+}
+
+// fromBaiduFunctionCall 把文心千帆的函数调用转换为工具调用，使用响应 ID 作为工具调用 ID
+func fromBaiduFunctionCall(id string, call *baidu.FunctionCall) []ToolCall {
+	if call == nil {
+		return nil
+	}
+
+	index := 0
+	return []ToolCall{{
+		Index:    &index,
+		ID:       "call_" + id,
+		Type:     ToolTypeFunction,
+		Function: FunctionCall{Name: call.Name, Arguments: call.Arguments},
+	}}
+}
+
+// MaxContextLength 从模型目录中获取模型的最大上下文长度，未知模型返回 3000
+func (chat *BaiduAIChat) MaxContextLength(model string) int {
+	return catalog.ContextWindow(strings.TrimPrefix(model, "文心千帆:"), 3000)
 }
@@ -8,17 +8,28 @@ import (
 	"strings"
 
 	"accompany-sdk/pkg/utils/array"
+	"github.com/openimsdk/tools/log"
 )
 
 var (
 	ErrContextExceedLimit = errors.New("上下文长度超过最大限制")
 	ErrContentFilter      = errors.New("请求或响应内容包含敏感词")
+	// ErrMultipleChoices 服务提供商不支持一次生成多个候选回复
+	ErrMultipleChoices = errors.New("服务提供商不支持生成多个候选回复")
 )
 
 type Message struct {
+	// Role 可选值为 system/user/assistant/tool
 	Role              string              `json:"role"`
 	Content           string              `json:"content"`
 	MultipartContents []*MultipartContent `json:"multipart_content,omitempty"`
+
+	// ToolCalls assistant 消息中模型发起的工具调用
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID tool 消息对应的工具调用 ID
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// Name tool 消息对应的函数名称
+	Name string `json:"name,omitempty"`
 }
 
 func (m Message) UploadedFile() *FileURL {
@@ -118,6 +129,83 @@ func (ms Messages) ToLogEntry() Messages {
 	return ret
 }
 
+// withImageDetail 为没有指定 detail 的图片根据图片尺寸自动选择 detail，返回新的消息列表，不修改原消息
+// 远程图片的尺寸在这里查询并缓存，之后计算 token 数量时直接使用缓存
+func (ms Messages) withImageDetail(ctx context.Context) Messages {
+	return array.Map(ms, func(item Message, _ int) Message {
+		if len(item.MultipartContents) == 0 {
+			return item
+		}
+
+		item.MultipartContents = array.Map(item.MultipartContents, func(part *MultipartContent, _ int) *MultipartContent {
+			if part.ImageURL == nil || part.ImageURL.URL == "" || part.ImageURL.Detail != "" {
+				return part
+			}
+
+			width, height, _ := ImageDimensionsContext(ctx, part.ImageURL)
+			imageURL := *part.ImageURL
+			imageURL.Detail = AutoImageDetail(width, height)
+
+			p := *part
+			p.ImageURL = &imageURL
+			return &p
+		})
+
+		return item
+	})
+}
+
+// Transcript 把对话拼接为 "role: content" 格式的文本
+func (ms Messages) Transcript() string {
+	var msgs []string
+	for _, msg := range ms {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", msg.Role, msg.Content))
+	}
+
+	return strings.Join(msgs, "\n\n")
+}
+
+// LastUserText 最后一条用户消息的文本内容，包括多模态消息中的文本部分
+func (ms Messages) LastUserText() string {
+	for i := len(ms) - 1; i >= 0; i-- {
+		if ms[i].Role != "user" {
+			continue
+		}
+
+		texts := []string{ms[i].Content}
+		for _, part := range ms[i].MultipartContents {
+			if part.Type == "text" {
+				texts = append(texts, part.Text)
+			}
+		}
+
+		return strings.TrimSpace(strings.Join(texts, "\n"))
+	}
+
+	return ""
+}
+
+// MergeSystem 把 text 追加到第一条 system 消息的末尾，第一条 system 消息是多模态消息或者没有 system 消息时，
+// 在最前面插入新的 system 消息，兼容只读取第一条 system 消息的服务提供商，不修改原消息
+func (ms Messages) MergeSystem(text string) Messages {
+	merged := make(Messages, 0, len(ms)+1)
+	for i, msg := range ms {
+		if msg.Role != "system" {
+			continue
+		}
+
+		if len(msg.MultipartContents) > 0 {
+			break
+		}
+
+		msg.Content = strings.TrimSpace(msg.Content + "\n\n" + text)
+		merged = append(append(append(merged, ms[:i]...), msg), ms[i+1:]...)
+		return merged
+	}
+
+	return append(append(merged, Message{Role: "system", Content: text}), ms...)
+}
+
 func (ms Messages) HasImage() bool {
 	for _, msg := range ms {
 		for _, part := range msg.MultipartContents {
@@ -131,14 +219,14 @@ func (ms Messages) HasImage() bool {
 }
 
 // Fix 模型上下文预处理：
-// 1. 强制上下文为 user/assistant 轮流出现
+// 1. 强制上下文为 user/assistant 轮流出现，tool 消息跟随在发起工具调用的 assistant 消息之后
 // 2. 第一个普通消息必须是用户消息
-// 3. 最后一条消息必须是用户消息
+// 3. 最后一条消息必须是用户消息或者工具调用结果
 func (ms Messages) Fix() Messages {
 	msgs := ms
 	// 如果最后一条消息不是用户消息，则补充一条用户消息
 	last := msgs[len(msgs)-1]
-	if last.Role != "user" {
+	if last.Role != "user" && last.Role != "tool" {
 		last = Message{
 			Role:    "user",
 			Content: "继续",
@@ -156,7 +244,8 @@ func (ms Messages) Fix() Messages {
 	var lastRole string
 
 	for _, m := range array.Reverse(msgs) {
-		if m.Role == lastRole {
+		// 同一轮工具调用可能有多个 tool 消息，需要全部保留
+		if m.Role == lastRole && m.Role != "tool" {
 			continue
 		}
 
@@ -164,11 +253,13 @@ func (ms Messages) Fix() Messages {
 		finalMessages = append(finalMessages, m)
 	}
 
-	if len(finalMessages)%2 == 0 {
-		finalMessages = finalMessages[:len(finalMessages)-1]
+	// 第一个普通消息必须是用户消息
+	finalMessages = array.Reverse(finalMessages)
+	for len(finalMessages) > 1 && finalMessages[0].Role != "user" {
+		finalMessages = finalMessages[1:]
 	}
 
-	return append(systemMsgs, array.Reverse(finalMessages)...)
+	return append(systemMsgs, finalMessages...)
 }
 
 // Request represents a request structure for chat completion API.
@@ -177,26 +268,71 @@ type Request struct {
 	Model     string   `json:"model"`
 	Messages  Messages `json:"messages"`
 	MaxTokens int      `json:"max_tokens,omitempty"`
-	N         int      `json:"n,omitempty"` // 复用作为 room_id
+	// N 生成的候选回复数量，为 0 时只生成一个
+	// 目前只有 OpenAI（以及兼容 OpenAI 接口的本地服务）支持多个候选回复，百度文心千帆、Anthropic 和智谱 AI 返回 ErrMultipleChoices
+	N int `json:"n,omitempty"`
 
 	// 业务定制字段
-	RoomID    int64 `json:"-"`
+	//
+	// RoomID 对话所属的会话 ID
+	RoomID    int64 `json:"room_id,omitempty"`
 	WebSocket bool  `json:"-"`
 
 	// TempModel 用户可以指定临时模型来进行当前对话，实现临时切换模型的功能
 	TempModel string `json:"temp_model,omitempty"`
+
+	// Tools 模型可以调用的工具列表
+	Tools []Tool `json:"tools,omitempty"`
+	// ToolChoice 工具调用策略，可选值为 auto/none/required 或者指定的函数名称
+	ToolChoice string `json:"tool_choice,omitempty"`
+
+	// 采样参数，未设置（nil）时使用服务提供商的默认值，超出服务提供商支持范围的值会被修正
+	//
+	// Temperature 采样温度，值越大输出越随机，0 表示尽可能确定的输出
+	Temperature *float64 `json:"temperature,omitempty"`
+	// TopP 核采样概率，建议和 Temperature 只设置一个
+	TopP *float64 `json:"top_p,omitempty"`
+	// Stop 停止词，模型输出停止词时停止生成
+	Stop []string `json:"stop,omitempty"`
+	// Seed 随机种子，相同的种子和参数尽可能返回相同的结果，目前只有 OpenAI 支持
+	Seed *int `json:"seed,omitempty"`
+	// PresencePenalty 存在惩罚，值越大越倾向于谈论新的话题
+	PresencePenalty *float64 `json:"presence_penalty,omitempty"`
+	// FrequencyPenalty 频率惩罚，值越大越不容易重复相同的内容
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	// ResponseFormat 输出格式，可选值为 text/json_object
+	ResponseFormat string `json:"response_format,omitempty"`
+
+	// ContextStrategy 上下文超出模型限制时的处理策略，可选值为 truncate/summarize，默认为 truncate
+	ContextStrategy string `json:"context_strategy,omitempty"`
+
+	// RetrievalTopK 从本地知识库中检索的资料数量，为 0 时使用配置的默认值，为负数时不检索
+	RetrievalTopK int `json:"retrieval_top_k,omitempty"`
 }
 
 func (req Request) Clone() Request {
 	return Request{
-		Stream:    req.Stream,
-		Model:     req.Model,
-		Messages:  array.Map(req.Messages, func(item Message, _ int) Message { return item }),
-		MaxTokens: req.MaxTokens,
-		N:         req.N,
-		RoomID:    req.RoomID,
-		WebSocket: req.WebSocket,
-		TempModel: req.TempModel,
+		Stream:     req.Stream,
+		Model:      req.Model,
+		Messages:   array.Map(req.Messages, func(item Message, _ int) Message { return item }),
+		MaxTokens:  req.MaxTokens,
+		N:          req.N,
+		RoomID:     req.RoomID,
+		WebSocket:  req.WebSocket,
+		TempModel:  req.TempModel,
+		Tools:      array.Map(req.Tools, func(item Tool, _ int) Tool { return item }),
+		ToolChoice: req.ToolChoice,
+
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		Stop:             array.Map(req.Stop, func(item string, _ int) string { return item }),
+		Seed:             req.Seed,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		ResponseFormat:   req.ResponseFormat,
+
+		ContextStrategy: req.ContextStrategy,
+		RetrievalTopK:   req.RetrievalTopK,
 	}
 }
 
@@ -206,12 +342,7 @@ func (req Request) Purification() Request {
 }
 
 func (req Request) assembleMessage() string {
-	var msgs []string
-	for _, msg := range req.Messages {
-		msgs = append(msgs, fmt.Sprintf("%s: %s", msg.Role, msg.Content))
-	}
-
-	return strings.Join(msgs, "\n\n")
+	return req.Messages.Transcript()
 }
 
 func (req Request) Init() Request {
@@ -223,70 +354,199 @@ func (req Request) Init() Request {
 
 	req.Model = strings.Join(modelSegs, ":")
 
-	// 获取 room id
-	// 这里复用了参数 N
-	req.RoomID = int64(req.N)
-	if req.N != 0 {
-		req.N = 0
-	}
-
-	// 过滤掉内容为空的 message
-	req.Messages = array.Filter(req.Messages, func(item Message, _ int) bool { return strings.TrimSpace(item.Content) != "" })
+	// 过滤掉内容为空的 message，发起工具调用的 assistant 消息和工具调用结果需要保留
+	req.Messages = array.Filter(req.Messages, func(item Message, _ int) bool {
+		return strings.TrimSpace(item.Content) != "" || len(item.ToolCalls) > 0 || item.Role == "tool"
+	})
 
 	return req
 }
 
 // Fix 修复请求内容，注意：上下文长度修复后，最终的上下文数量不包含 system 消息和用户最后一条消息
-func (req Request) Fix(chat Chat, maxContextLength int64, maxTokenCount int) (*Request, int64, error) {
+// ContextStrategy 为 summarize 且 summarizer 不为 nil 时，因为 token 数量超出限制被丢弃的历史消息会被压缩为摘要，
+// 超出对话轮数限制的消息直接丢弃，生成摘要失败时退化为直接丢弃
+func (req Request) Fix(ctx context.Context, chat Chat, summarizer *ContextSummarizer, maxContextLength int64, maxTokenCount int) (*Request, int64, error) {
+	plan, err := req.planContext(ctx, chat, summarizer, maxContextLength, maxTokenCount)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	systemMessages, messages, inputTokens := plan.system, plan.truncated, plan.truncatedTokens
+	if plan.summarize {
+		summary, err := summarizer.Summarize(ctx, req.RoomID, plan.summarized)
+		if err == nil && summary != "" {
+			systemMessages = systemMessages.MergeSystem(summaryMessagePrefix + summary)
+			messages, inputTokens = plan.kept, plan.keptTokens
+		} else {
+			log.ZWarn(ctx, "summarize context failed, fallback to truncate", err, "model", req.Model, "room_id", req.RoomID, "dropped", len(plan.summarized))
+		}
+	}
+
+	req.Messages = append(systemMessages, messages...)
+	return &req, int64(inputTokens), nil
+}
+
+// contextPlan 上下文缩减方案，Fix 和 Estimate 共用，保证预估结果和实际发送的内容一致
+type contextPlan struct {
+	// system 需要保留的 system 消息
+	system Messages
+	// truncated/truncatedTokens 直接丢弃历史消息时保留的消息以及对应的 token 数量
+	truncated       Messages
+	truncatedTokens int
+	// summarize 是否需要把被丢弃的消息压缩为摘要，为 true 时 kept 为预留摘要空间后保留的消息
+	summarize  bool
+	kept       Messages
+	keptTokens int
+	// dropped 被丢弃的历史消息
+	dropped Messages
+	// summarized 需要压缩为摘要的消息，只包含因为 token 数量超出限制被丢弃的消息，
+	// 超出对话轮数限制的消息直接丢弃，否则对话超过轮数限制后每一轮都需要重新生成摘要
+	summarized Messages
+}
+
+// planContext 计算上下文缩减方案，不会调用 summarizer 生成摘要
+func (req Request) planContext(ctx context.Context, chat Chat, summarizer *ContextSummarizer, maxContextLength int64, maxTokenCount int) (*contextPlan, error) {
+	// 先确定图片的 detail，保证 token 数量按照实际发送的 detail 计算
+	req.Messages = req.Messages.withImageDetail(ctx)
+
 	// 自动缩减上下文长度至满足模型要求的最大长度，尽可能避免出现超过模型上下文长度的问题
 	systemMessages := array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role == "system" })
-	systemMessageLen, _ := MessageTokenCount(systemMessages, req.Model)
+	systemMessageLen, _ := MessageTokenCount(systemMessages, req.ActualModel())
 
 	// 模型允许的 Tokens 数量和请求参数指定的 Tokens 数量，取最小值
-	modelTokenLimit := chat.MaxContextLength(req.Model) - systemMessageLen
+	modelTokenLimit := chat.MaxContextLength(req.ActualModel()) - systemMessageLen
 	if modelTokenLimit < maxTokenCount {
 		maxTokenCount = modelTokenLimit
 	}
 
-	messages, inputTokens, err := ReduceMessageContext(
-		ReduceMessageContextUpToContextWindow(
-			array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role != "system" }),
-			int(maxContextLength),
-		),
-		req.Model,
-		maxTokenCount,
-	)
+	history := array.Filter(req.Messages, func(item Message, _ int) bool { return item.Role != "system" })
+	window := ReduceMessageContextUpToContextWindow(history, int(maxContextLength))
+	messages, inputTokens, err := ReduceMessageContext(window, req.ActualModel(), maxTokenCount)
 	if err != nil {
-		return nil, 0, errors.New("超过模型最大允许的上下文长度限制，请尝试“新对话”或缩短输入内容长度")
+		return nil, errors.New("超过模型最大允许的上下文长度限制，请尝试“新对话”或缩短输入内容长度")
 	}
 
-	req.Messages = array.Map(append(systemMessages, messages...), func(item Message, _ int) Message {
-		if len(item.MultipartContents) > 0 {
-			item.MultipartContents = array.Map(item.MultipartContents, func(part *MultipartContent, _ int) *MultipartContent {
-				if part.ImageURL != nil && part.ImageURL.URL != "" && part.ImageURL.Detail == "" {
-					part.ImageURL.Detail = "low"
-				}
+	// 缩减后的上下文总是 history 的后缀，前面的部分就是被丢弃的消息
+	plan := &contextPlan{
+		system:          systemMessages,
+		truncated:       messages,
+		truncatedTokens: inputTokens,
+		kept:            messages,
+		keptTokens:      inputTokens,
+		dropped:         history[:len(history)-len(messages)],
+	}
 
-				return part
-			})
+	if req.ContextStrategy == ContextStrategySummarize && summarizer != nil && len(window) > len(messages) {
+		// 为摘要预留空间后重新缩减上下文
+		if kept, tokens, err := ReduceMessageContext(window, req.ActualModel(), maxTokenCount-summarizer.MaxTokens()); err == nil {
+			plan.summarize = true
+			plan.kept, plan.keptTokens = kept, tokens
+			plan.dropped = history[:len(history)-len(kept)]
+			plan.summarized = window[:len(window)-len(kept)]
 		}
-		return item
-	})
+	}
 
-	return &req, int64(inputTokens), nil
+	return plan, nil
 }
 
-func (req Request) ResolveCalFeeModel() string {
+// ActualModel 返回本次对话实际使用的模型，指定了临时模型时优先使用临时模型
+func (req Request) ActualModel() string {
+	if req.TempModel != "" {
+		return req.TempModel
+	}
+
 	return req.Model
 }
 
+func (req Request) ResolveCalFeeModel() string {
+	return req.ActualModel()
+}
+
 type Response struct {
-	Error        string `json:"error,omitempty"`
-	ErrorCode    string `json:"error_code,omitempty"`
-	Text         string `json:"text,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+	// Text 第一个候选回复的文本内容
+	Text string `json:"text,omitempty"`
+	// FinishReason 第一个候选回复的结束原因
 	FinishReason string `json:"finish_reason,omitempty"`
 	InputTokens  int    `json:"input_tokens,omitempty"`
 	OutputTokens int    `json:"output_tokens,omitempty"`
+
+	// ToolCalls 模型发起的工具调用，流式响应中为工具调用的增量，需要使用 MergeToolCallDeltas 合并
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+
+	// Choices 全部候选回复，流式响应中为各个候选回复的增量，不支持多个候选回复的服务提供商只返回 Text
+	Choices []Choice `json:"choices,omitempty"`
+
+	// Citations 从本地知识库中检索并注入到对话中的资料，编号和回复中的 [编号] 引用对应
+	Citations []Citation `json:"citations,omitempty"`
+
+	// Model 回复使用的模型（不包含服务提供商前缀），由 Router 在非流式对话中填充
+	Model string `json:"model,omitempty"`
+	// Tier 返回结果的降级链层级名称，没有经过降级链时为空
+	Tier string `json:"tier,omitempty"`
+	// Recovered 流式对话中途连接中断，由降级链的下一层续写了剩余的内容
+	Recovered bool `json:"recovered,omitempty"`
+}
+
+// Citation 回复引用的知识库资料
+type Citation struct {
+	// Index 资料编号，从 1 开始
+	Index        int    `json:"index"`
+	DocumentID   string `json:"document_id"`
+	DocumentName string `json:"document_name"`
+	// ChunkIndex 资料在文档中的片段序号
+	ChunkIndex int    `json:"chunk_index"`
+	Text       string `json:"text"`
+	// Score 资料和用户问题的余弦相似度
+	Score float64 `json:"score"`
+}
+
+// Choice 候选回复
+type Choice struct {
+	Index        int        `json:"index"`
+	Text         string     `json:"text,omitempty"`
+	FinishReason string     `json:"finish_reason,omitempty"`
+	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
+}
+
+// CheckSingleChoice 不支持多个候选回复的服务提供商在请求多个候选回复时返回 ErrMultipleChoices
+func CheckSingleChoice(req Request) error {
+	if req.N > 1 {
+		return ErrMultipleChoices
+	}
+
+	return nil
+}
+
+// AllChoices 返回全部候选回复，服务提供商没有返回 Choices 时，使用 Text 作为唯一的候选回复
+func (res Response) AllChoices() []Choice {
+	if len(res.Choices) > 0 {
+		return res.Choices
+	}
+
+	return []Choice{{Text: res.Text, FinishReason: res.FinishReason, ToolCalls: res.ToolCalls}}
+}
+
+// MergeChoiceDeltas 把流式响应中候选回复的增量按照 Index 合并到已有的候选回复中
+func MergeChoiceDeltas(choices []Choice, deltas []Choice) []Choice {
+	for _, delta := range deltas {
+		for len(choices) <= delta.Index {
+			choices = append(choices, Choice{Index: len(choices)})
+		}
+
+		choice := &choices[delta.Index]
+		choice.Text += delta.Text
+		if delta.FinishReason != "" {
+			choice.FinishReason = delta.FinishReason
+		}
+
+		if len(delta.ToolCalls) > 0 {
+			choice.ToolCalls = MergeToolCallDeltas(choice.ToolCalls, delta.ToolCalls)
+		}
+	}
+
+	return choices
 }
 
 type Chat interface {
@@ -297,8 +557,3 @@ type Chat interface {
 	// MaxContextLength 获取模型的最大上下文长度
 	MaxContextLength(model string) int
 }
-
-type AI struct {
-	OpenAI *OpenAIChat
-	Baidu  *BaiduAIChat
-}
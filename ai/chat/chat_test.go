@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestResponse_AllChoices(t *testing.T) {
+	res := Response{Text: "你好", FinishReason: "stop", ToolCalls: []ToolCall{{ID: "call_1"}}}
+	choices := res.AllChoices()
+	if len(choices) != 1 || choices[0].Text != "你好" || choices[0].FinishReason != "stop" || len(choices[0].ToolCalls) != 1 {
+		t.Errorf("text should be used as the only choice, got %+v", choices)
+	}
+
+	res.Choices = []Choice{{Index: 0, Text: "A"}, {Index: 1, Text: "B"}}
+	if choices := res.AllChoices(); len(choices) != 2 || choices[1].Text != "B" {
+		t.Errorf("choices should be returned as is, got %+v", choices)
+	}
+}
+
+func TestMergeChoiceDeltas(t *testing.T) {
+	zero, one := 0, 1
+	chunks := [][]Choice{
+		{{Index: 0, Text: "床前"}, {Index: 1, Text: "举头"}},
+		// 候选回复的增量可以乱序到达，也可以跳过序号
+		{{Index: 2, Text: "低头"}, {Index: 0, Text: "明月光"}},
+		{{Index: 1, ToolCalls: []ToolCall{{Index: &zero, ID: "call_1", Function: FunctionCall{Name: "search", Arguments: `{"q":`}}}}},
+		{{Index: 1, ToolCalls: []ToolCall{{Index: &zero, Function: FunctionCall{Arguments: `"月"}`}}, {Index: &one, ID: "call_2", Function: FunctionCall{Name: "noop"}}}}},
+		{{Index: 0, FinishReason: "stop"}, {Index: 1, FinishReason: FinishReasonToolCalls}},
+	}
+
+	var choices []Choice
+	for _, deltas := range chunks {
+		choices = MergeChoiceDeltas(choices, deltas)
+	}
+
+	if len(choices) != 3 {
+		t.Fatalf("expect 3 choices, got %+v", choices)
+	}
+
+	for i, choice := range choices {
+		if choice.Index != i {
+			t.Errorf("choice %d has index %d", i, choice.Index)
+		}
+	}
+
+	if choices[0].Text != "床前明月光" || choices[0].FinishReason != "stop" {
+		t.Errorf("unexpected first choice: %+v", choices[0])
+	}
+
+	calls := choices[1].ToolCalls
+	if choices[1].FinishReason != FinishReasonToolCalls || len(calls) != 2 || calls[0].Function.Arguments != `{"q":"月"}` || calls[1].ID != "call_2" {
+		t.Errorf("unexpected tool calls in second choice: %+v", choices[1])
+	}
+
+	if choices[2].Text != "低头" || choices[2].FinishReason != "" {
+		t.Errorf("unexpected third choice: %+v", choices[2])
+	}
+}
+
+func TestCheckSingleChoice(t *testing.T) {
+	if err := CheckSingleChoice(Request{N: 1}); err != nil {
+		t.Errorf("single choice should be allowed, got %v", err)
+	}
+
+	baiduChat := &BaiduAIChat{}
+	if _, err := baiduChat.Chat(context.Background(), Request{N: 2}); !errors.Is(err, ErrMultipleChoices) {
+		t.Errorf("baidu should reject multiple choices, got %v", err)
+	}
+
+	if _, err := baiduChat.ChatStream(context.Background(), Request{N: 2}); !errors.Is(err, ErrMultipleChoices) {
+		t.Errorf("baidu should reject multiple choices in stream, got %v", err)
+	}
+}
+
+func TestMessages_LastUserText(t *testing.T) {
+	messages := Messages{
+		{Role: "user", Content: "第一个问题"},
+		{Role: "user", Content: " 看看这张图 ", MultipartContents: []*MultipartContent{{Type: "image_url", ImageURL: &ImageURL{URL: "https://example.com/a.png"}}, {Type: "text", Text: "图里是什么？"}}},
+		{Role: "assistant", Content: "回答"},
+	}
+
+	if got := messages.LastUserText(); got != "看看这张图 \n图里是什么？" {
+		t.Errorf("unexpected text %q", got)
+	}
+
+	if got := (Messages{{Role: "system", Content: "你是一个助手"}}).LastUserText(); got != "" {
+		t.Errorf("expect empty text, got %q", got)
+	}
+}
@@ -0,0 +1,86 @@
+package chat
+
+import (
+	"accompany-sdk/ai/catalog"
+	"accompany-sdk/pkg/utils/array"
+	"context"
+)
+
+// Estimate 发送请求前的 token 数量以及费用预估
+type Estimate struct {
+	Model string `json:"model"`
+	// SystemTokens system 消息的 token 数量
+	SystemTokens int `json:"system_tokens"`
+	// HistoryTokens 保留的历史消息的 token 数量
+	HistoryTokens int `json:"history_tokens"`
+	// InputTokens 最后一条消息（本次新输入）的 token 数量
+	InputTokens int `json:"input_tokens"`
+	// SummaryTokens 为被丢弃消息的摘要预留的 token 数量，只有 ContextStrategy 为 summarize 时有值
+	SummaryTokens int `json:"summary_tokens,omitempty"`
+	// TotalTokens 本次请求预计的输入 token 总数
+	TotalTokens int `json:"total_tokens"`
+	// ContextWindow 模型的上下文窗口大小
+	ContextWindow int `json:"context_window"`
+	// Headroom 上下文窗口中剩余的 token 数量，也就是模型最多可以输出的 token 数量
+	Headroom int `json:"headroom"`
+	// Trimmed 超出上下文限制，发送时会被丢弃（或者压缩为摘要）的消息
+	Trimmed Messages `json:"trimmed,omitempty"`
+	// Summarized 被丢弃的消息是否会被压缩为摘要
+	Summarized bool `json:"summarized,omitempty"`
+	// Cost 根据模型目录中的价格计算的输入部分的预估费用，模型没有价格信息时为 0
+	Cost     float64 `json:"cost"`
+	Currency string  `json:"currency,omitempty"`
+}
+
+// Estimate 预估请求的 token 数量以及费用，参数和 Fix 一致，使用和 Fix 相同的上下文缩减方案，但是不会生成摘要
+func (req Request) Estimate(ctx context.Context, chat Chat, summarizer *ContextSummarizer, maxContextLength int64, maxTokenCount int) (*Estimate, error) {
+	plan, err := req.planContext(ctx, chat, summarizer, maxContextLength, maxTokenCount)
+	if err != nil {
+		return nil, err
+	}
+
+	model := req.ActualModel()
+	counts, err := messageTokenCounts(plan.kept, model)
+	if err != nil {
+		return nil, err
+	}
+
+	systemCounts, err := messageTokenCounts(plan.system, model)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := func(items []int) int {
+		return array.Reduce(items, func(carry int, item int) int { return carry + item }, 0)
+	}
+
+	est := &Estimate{
+		Model:         model,
+		SystemTokens:  sum(systemCounts),
+		ContextWindow: chat.MaxContextLength(model),
+		Trimmed:       plan.dropped,
+		Summarized:    plan.summarize,
+	}
+
+	if len(counts) > 0 {
+		est.InputTokens = counts[len(counts)-1]
+		est.HistoryTokens = sum(counts[:len(counts)-1])
+	}
+
+	if plan.summarize {
+		est.SummaryTokens = summarizer.MaxTokens()
+	}
+
+	// keptTokens 已经包含了模型回复的起始标记占用的 token 数量
+	est.TotalTokens = est.SystemTokens + plan.keptTokens + est.SummaryTokens
+	est.Headroom = max(est.ContextWindow-est.TotalTokens, 0)
+
+	// 模型目录中的模型名称不包含前缀，使用和计算 token 相同的方式解析实际的模型
+	_, _, realModel := resolveTokenizer(model)
+	if m, ok := catalog.Lookup(realModel); ok {
+		est.Cost = float64(est.TotalTokens) / 1000 * m.InputPrice
+		est.Currency = m.Currency
+	}
+
+	return est, nil
+}
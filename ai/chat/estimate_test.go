@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"accompany-sdk/ai/catalog"
+)
+
+func TestRequest_EstimateMatchesFix(t *testing.T) {
+	skipWithoutEncoding(t)
+
+	for _, strategy := range []string{ContextStrategyTruncate, ContextStrategySummarize} {
+		t.Run(strategy, func(t *testing.T) {
+			req := summarizeRequest(40)
+			req.ContextStrategy = strategy
+			backend := &fakeChat{contextLength: 300}
+			summarizer := NewContextSummarizer(&recordSummarizer{}, 100, 0)
+
+			est, err := req.Estimate(context.Background(), backend, summarizer, 100, 300)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fixed, tokens, err := req.Fix(context.Background(), backend, summarizer, 100, 300)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			history := len(req.Messages) - 1
+			kept := len(fixed.Messages) - 1
+			if len(est.Trimmed) == 0 || history-len(est.Trimmed) != kept {
+				t.Errorf("estimate trims %d of %d messages, fix keeps %d", len(est.Trimmed), history, kept)
+			}
+
+			if est.Summarized != (strategy == ContextStrategySummarize) {
+				t.Errorf("unexpected summarized flag %v", est.Summarized)
+			}
+
+			// Fix 返回的 token 数量不包含 system 消息，预估的总数还包括 system 消息和为摘要预留的空间
+			if est.TotalTokens-est.SystemTokens-est.SummaryTokens != int(tokens) || est.HistoryTokens+est.InputTokens+replyPrimingTokens != int(tokens) {
+				t.Errorf("estimate %+v does not match fix tokens %d", est, tokens)
+			}
+		})
+	}
+}
+
+func TestRequest_EstimateCost(t *testing.T) {
+	skipWithoutEncoding(t)
+
+	catalog.Register(catalog.Model{ID: "estimate-test-model", Provider: ProviderOpenAI, InputPrice: 1, Currency: catalog.CurrencyUSD})
+
+	SetTokenizerRouter(NewRouter().Register(ProviderOpenAI, &fakeChat{}, "openai:").SetDefault(ProviderOpenAI))
+	defer SetTokenizerRouter(nil)
+
+	// 带前缀的模型也按照模型目录中的价格计算
+	req := Request{Model: "openai:estimate-test-model", Messages: Messages{{Role: "user", Content: "你好"}}}
+	est, err := req.Estimate(context.Background(), &fakeChat{contextLength: 1000}, nil, 10, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if est.Cost != float64(est.TotalTokens)/1000 || est.Currency != catalog.CurrencyUSD {
+		t.Errorf("unexpected cost %v %s for %d tokens", est.Cost, est.Currency, est.TotalTokens)
+	}
+}
@@ -0,0 +1,113 @@
+package chat
+
+import (
+	openai2 "accompany-sdk/ai/openai"
+	"accompany-sdk/pkg/ai/fallback"
+	"context"
+	"errors"
+)
+
+// FallbackTier 对话降级链中的一层
+type FallbackTier struct {
+	fallback.Tier
+	// Model 本层使用的模型，可以带服务提供商前缀，如 anthropic:claude-3-5-sonnet-20240620，为空时使用请求中的模型
+	Model string
+}
+
+// FallbackChain 对话降级链，按顺序使用不同的模型（可以属于不同的服务提供商），前一层失败并且满足该层的切换条件时使用下一层
+// 所有层级都失败时返回 *fallback.ExhaustedError，响应中的 Tier 为返回结果的层级名称
+type FallbackChain struct {
+	backend Chat
+	tiers   []FallbackTier
+}
+
+// NewFallbackChain 创建对话降级链，backend 通常为 Router，负责根据每一层的模型选择服务提供商
+func NewFallbackChain(backend Chat, tiers ...FallbackTier) *FallbackChain {
+	return &FallbackChain{backend: backend, tiers: tiers}
+}
+
+// ErrorClass 对话错误的分类，取值为 fallback.Class* 常量
+func ErrorClass(err error) string {
+	if errors.Is(err, ErrContextExceedLimit) || errors.Is(err, ErrContentFilter) || errors.Is(err, ErrProviderNotFound) ||
+		errors.Is(err, ErrToolNotSupported) || errors.Is(err, ErrMultipleChoices) {
+		return fallback.ClassClient
+	}
+
+	return openai2.ErrorClass(err)
+}
+
+func (c *FallbackChain) fallbackTiers() []fallback.Tier {
+	tiers := make([]fallback.Tier, len(c.tiers))
+	for i, tier := range c.tiers {
+		tiers[i] = tier.Tier
+	}
+
+	return tiers
+}
+
+// request 第 index 层使用的请求
+func (c *FallbackChain) request(req Request, index int) Request {
+	if model := c.tiers[index].Model; model != "" {
+		req.Model, req.TempModel = model, ""
+	}
+
+	return req
+}
+
+func (c *FallbackChain) Chat(ctx context.Context, req Request) (*Response, error) {
+	tiers := c.fallbackTiers()
+	res, tier, err := fallback.Run(ctx, tiers, fallback.Start(ctx, tiers), ErrorClass, func(ctx context.Context, index int) (*Response, error) {
+		return c.backend.Chat(ctx, c.request(req, index))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res.Tier = tier
+	return res, nil
+}
+
+func (c *FallbackChain) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	tiers := c.fallbackTiers()
+	stream, tier, err := fallback.RunStream(ctx, tiers, fallback.Start(ctx, tiers), ErrorClass, func(ctx context.Context, index int) (<-chan Response, error) {
+		return c.backend.ChatStream(ctx, c.request(req, index))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(chan Response)
+	go func() {
+		defer close(res)
+
+		for data := range stream {
+			data.Tier = tier
+			select {
+			case <-ctx.Done():
+				// 继续读取剩余的响应，保证写入流的协程可以退出
+				for range stream {
+				}
+				return
+			case res <- data:
+			}
+		}
+	}()
+
+	return res, nil
+}
+
+// MaxContextLength 所有层级中最小的上下文长度，保证降级后的模型也可以处理缩减后的上下文
+func (c *FallbackChain) MaxContextLength(model string) int {
+	length := c.backend.MaxContextLength(model)
+	for _, tier := range c.tiers {
+		if tier.Model == "" {
+			continue
+		}
+
+		if l := c.backend.MaxContextLength(tier.Model); l > 0 && (length <= 0 || l < length) {
+			length = l
+		}
+	}
+
+	return length
+}
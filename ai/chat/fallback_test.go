@@ -0,0 +1,148 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"accompany-sdk/pkg/ai/fallback"
+)
+
+// modelChat 按照请求中的模型返回预设的错误和上下文长度
+type modelChat struct {
+	errs     map[string]error
+	lengths  map[string]int
+	requests []Request
+}
+
+func (c *modelChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	c.requests = append(c.requests, req)
+	if err := c.errs[req.Model]; err != nil {
+		return nil, err
+	}
+
+	return &Response{Text: req.Model}, nil
+}
+
+func (c *modelChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	c.requests = append(c.requests, req)
+	if err := c.errs[req.Model]; err != nil {
+		return nil, err
+	}
+
+	res := make(chan Response, 2)
+	res <- Response{Text: req.Model}
+	res <- Response{Text: "done"}
+	close(res)
+	return res, nil
+}
+
+func (c *modelChat) MaxContextLength(model string) int {
+	return c.lengths[model]
+}
+
+func TestFallbackChain_Chat(t *testing.T) {
+	backend := &modelChat{errs: map[string]error{"main-model": errors.New("connection reset")}}
+	chain := NewFallbackChain(backend,
+		FallbackTier{Tier: fallback.Tier{Name: "main"}, Model: "main-model"},
+		FallbackTier{Tier: fallback.Tier{Name: "backup"}, Model: "backup-model"},
+	)
+
+	res, err := chain.Chat(context.Background(), Request{Model: "gpt-4", TempModel: "gpt-4o"})
+	if err != nil || res.Text != "backup-model" || res.Tier != "backup" {
+		t.Fatalf("expect the backup tier to answer, got %+v %v", res, err)
+	}
+
+	// 每一层使用该层配置的模型，忽略请求中的临时模型
+	if len(backend.requests) != 2 || backend.requests[0].Model != "main-model" || backend.requests[1].TempModel != "" {
+		t.Errorf("unexpected requests %+v", backend.requests)
+	}
+}
+
+func TestFallbackChain_ClientError(t *testing.T) {
+	backend := &modelChat{errs: map[string]error{"gpt-4": ErrContextExceedLimit}}
+	chain := NewFallbackChain(backend, FallbackTier{Tier: fallback.Tier{Name: "main"}}, FallbackTier{Tier: fallback.Tier{Name: "backup"}, Model: "backup-model"})
+
+	// 请求参数错误在其它层级也会失败，不切换
+	if _, err := chain.Chat(context.Background(), Request{Model: "gpt-4"}); !errors.Is(err, ErrContextExceedLimit) || len(backend.requests) != 1 {
+		t.Fatalf("client error should not fall back, got %v after %d requests", err, len(backend.requests))
+	}
+
+	// 所有层级都失败
+	backend = &modelChat{errs: map[string]error{"gpt-4": errors.New("connection reset"), "backup-model": errors.New("connection reset")}}
+	chain = NewFallbackChain(backend, FallbackTier{Tier: fallback.Tier{Name: "main"}}, FallbackTier{Tier: fallback.Tier{Name: "backup"}, Model: "backup-model"})
+
+	var exhausted *fallback.ExhaustedError
+	if _, err := chain.Chat(context.Background(), Request{Model: "gpt-4"}); !errors.As(err, &exhausted) || len(exhausted.Attempts) != 2 {
+		t.Fatalf("expect an exhausted error, got %v", err)
+	}
+}
+
+func TestFallbackChain_ChatStream(t *testing.T) {
+	backend := &modelChat{errs: map[string]error{"main-model": errors.New("connection reset")}}
+	chain := NewFallbackChain(backend,
+		FallbackTier{Tier: fallback.Tier{Name: "main", LatencyBudget: time.Second}, Model: "main-model"},
+		FallbackTier{Tier: fallback.Tier{Name: "backup", LatencyBudget: time.Second}, Model: "backup-model"},
+	)
+
+	stream, err := chain.ChatStream(context.Background(), Request{Model: "gpt-4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var texts []string
+	for data := range stream {
+		if data.Tier != "backup" {
+			t.Errorf("expect every response from the backup tier, got %q", data.Tier)
+		}
+
+		texts = append(texts, data.Text)
+	}
+
+	if len(texts) != 2 || texts[0] != "backup-model" || texts[1] != "done" {
+		t.Fatalf("unexpected stream %v", texts)
+	}
+}
+
+func TestFallbackChain_MaxContextLength(t *testing.T) {
+	backend := &modelChat{lengths: map[string]int{"gpt-4": 8192, "small-model": 4096, "large-model": 128000}}
+	cases := []struct {
+		name   string
+		model  string
+		tiers  []FallbackTier
+		length int
+	}{
+		{
+			name:   "min of all tiers",
+			model:  "gpt-4",
+			tiers:  []FallbackTier{{Model: "large-model"}, {Model: "small-model"}},
+			length: 4096,
+		},
+		{
+			name:   "tier without model uses request model",
+			model:  "gpt-4",
+			tiers:  []FallbackTier{{}, {Model: "large-model"}},
+			length: 8192,
+		},
+		{
+			// 未知模型的上下文长度为 0，不参与比较
+			name:   "unknown tier model",
+			model:  "gpt-4",
+			tiers:  []FallbackTier{{Model: "unknown-model"}},
+			length: 8192,
+		},
+		{
+			name:   "unknown request model",
+			model:  "unknown-model",
+			tiers:  []FallbackTier{{}, {Model: "large-model"}},
+			length: 128000,
+		},
+	}
+
+	for _, c := range cases {
+		if length := NewFallbackChain(backend, c.tiers...).MaxContextLength(c.model); length != c.length {
+			t.Errorf("%s: expect %d, got %d", c.name, c.length, length)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package chat
 
 import (
 	openai2 "accompany-sdk/ai/openai"
+	"accompany-sdk/pkg/ai/fallback"
 	"accompany-sdk/pkg/misc"
+	"accompany-sdk/pkg/ternary"
 	"accompany-sdk/pkg/uploader"
 	"context"
+	"math"
 	"strings"
 
 	"accompany-sdk/pkg/utils/array"
@@ -22,14 +25,27 @@ func NewOpenAIChat(oai openai2.Client) *OpenAIChat {
 
 func (chat *OpenAIChat) initRequest(req Request) (*openai.ChatCompletionRequest, error) {
 	req.Model = strings.TrimPrefix(req.Model, "openai:")
+	req = req.ClampSampling(OpenAISamplingLimits)
+	if err := CheckToolSupport(req); err != nil {
+		return nil, err
+	}
 
 	var systemMessages []openai.ChatCompletionMessage
 	var contextMessages []openai.ChatCompletionMessage
 
 	for _, msg := range req.Messages {
 		m := openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
+			Role:       msg.Role,
+			Content:    msg.Content,
+			Name:       msg.Name,
+			ToolCallID: msg.ToolCallID,
+			ToolCalls: array.Map(msg.ToolCalls, func(item ToolCall, _ int) openai.ToolCall {
+				return openai.ToolCall{
+					ID:       item.ID,
+					Type:     openai.ToolType(ternary.If(item.Type == "", ToolTypeFunction, item.Type)),
+					Function: openai.FunctionCall{Name: item.Function.Name, Arguments: item.Function.Arguments},
+				}
+			}),
 		}
 
 		if len(msg.MultipartContents) > 0 {
@@ -74,18 +90,90 @@ func (chat *OpenAIChat) initRequest(req Request) (*openai.ChatCompletionRequest,
 
 	messages := append(systemMessages, contextMessages...)
 	return &openai.ChatCompletionRequest{
-		Model:     req.Model,
-		Messages:  messages,
-		MaxTokens: req.MaxTokens,
+		Model:      req.Model,
+		Messages:   messages,
+		MaxTokens:  req.MaxTokens,
+		N:          req.N,
+		Tools:      openaiTools(req.Tools),
+		ToolChoice: openaiToolChoice(req),
+
+		Temperature:      openaiSampling(req.Temperature),
+		TopP:             openaiSampling(req.TopP),
+		Stop:             req.Stop,
+		Seed:             req.Seed,
+		PresencePenalty:  openaiSampling(req.PresencePenalty),
+		FrequencyPenalty: openaiSampling(req.FrequencyPenalty),
+		ResponseFormat: ternary.IfLazy(
+			req.ResponseFormat != "",
+			func() *openai.ChatCompletionResponseFormat {
+				return &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatType(req.ResponseFormat)}
+			},
+			func() *openai.ChatCompletionResponseFormat { return nil },
+		),
 	}, nil
 }
 
+// openaiSampling 转换采样参数，go-openai 的采样参数为 0 时会被忽略（使用默认值），
+// 因此明确设置为 0 的参数使用最小的正数代替
+func openaiSampling(value *float64) float32 {
+	if value == nil {
+		return 0
+	}
+
+	if *value == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+
+	return float32(*value)
+}
+
+func openaiTools(tools []Tool) []openai.Tool {
+	return array.Map(
+		array.Filter(tools, func(item Tool, _ int) bool { return item.Function != nil }),
+		func(item Tool, _ int) openai.Tool {
+			return openai.Tool{
+				Type: openai.ToolTypeFunction,
+				Function: &openai.FunctionDefinition{
+					Name:        item.Function.Name,
+					Description: item.Function.Description,
+					Parameters:  item.Function.Parameters,
+				},
+			}
+		},
+	)
+}
+
+// openaiToolChoice 转换工具调用策略，指定函数名称时转换为 ToolChoice 对象
+func openaiToolChoice(req Request) any {
+	if len(req.Tools) == 0 || req.ToolChoice == "" {
+		return nil
+	}
+
+	if array.In(req.ToolChoice, []string{ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired}) {
+		return req.ToolChoice
+	}
+
+	return openai.ToolChoice{Type: openai.ToolTypeFunction, Function: openai.ToolFunction{Name: req.ToolChoice}}
+}
+
+func fromOpenAIToolCalls(calls []openai.ToolCall) []ToolCall {
+	return array.Map(calls, func(item openai.ToolCall, _ int) ToolCall {
+		return ToolCall{
+			Index:    item.Index,
+			ID:       item.ID,
+			Type:     string(item.Type),
+			Function: FunctionCall{Name: item.Function.Name, Arguments: item.Function.Arguments},
+		}
+	})
+}
+
 func (chat *OpenAIChat) Chat(ctx context.Context, req Request) (*Response, error) {
 	openaiReq, err := chat.initRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
+	ctx, record := fallback.WithRecord(ctx)
 	res, err := chat.oai.CreateChatCompletion(ctx, *openaiReq)
 	if err != nil {
 		if strings.Contains(err.Error(), "content management policy") {
@@ -96,17 +184,27 @@ func (chat *OpenAIChat) Chat(ctx context.Context, req Request) (*Response, error
 		return nil, err
 	}
 
-	return &Response{
-		Text: array.Reduce(
-			res.Choices,
-			func(carry string, item openai.ChatCompletionChoice) string {
-				return carry + "\n" + item.Message.Content
-			},
-			"",
-		),
+	ret := &Response{
+		Tier:         record.Tier(),
 		InputTokens:  res.Usage.PromptTokens,
 		OutputTokens: res.Usage.CompletionTokens,
-	}, nil
+		Choices: array.Map(res.Choices, func(item openai.ChatCompletionChoice, _ int) Choice {
+			return Choice{
+				Index:        item.Index,
+				Text:         item.Message.Content,
+				FinishReason: string(item.FinishReason),
+				ToolCalls:    fromOpenAIToolCalls(item.Message.ToolCalls),
+			}
+		}),
+	}
+
+	for _, choice := range ret.Choices {
+		if choice.Index == 0 {
+			ret.Text, ret.FinishReason, ret.ToolCalls = choice.Text, choice.FinishReason, choice.ToolCalls
+		}
+	}
+
+	return ret, nil
 }
 
 func (chat *OpenAIChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
@@ -117,6 +215,7 @@ func (chat *OpenAIChat) ChatStream(ctx context.Context, req Request) (<-chan Res
 
 	openaiReq.Stream = true
 
+	ctx, record := fallback.WithRecord(ctx)
 	stream, err := chat.oai.ChatStream(ctx, *openaiReq)
 	if err != nil {
 		if strings.Contains(err.Error(), "content management policy") {
@@ -127,6 +226,7 @@ func (chat *OpenAIChat) ChatStream(ctx context.Context, req Request) (<-chan Res
 		return nil, err
 	}
 
+	tier := record.Tier()
 	res := make(chan Response)
 	go func() {
 		defer close(res)
@@ -148,15 +248,27 @@ func (chat *OpenAIChat) ChatStream(ctx context.Context, req Request) (<-chan Res
 					return
 				}
 
-				res <- Response{
-					Text: array.Reduce(
-						data.ChatResponse.Choices,
-						func(carry string, item openai.ChatCompletionStreamChoice) string {
-							return carry + item.Delta.Content
-						},
-						"",
-					),
+				chunk := Response{
+					Tier:      ternary.If(data.Tier != "", data.Tier, tier),
+					Recovered: data.Recovered,
+					Choices: array.Map(data.ChatResponse.Choices, func(item openai.ChatCompletionStreamChoice, _ int) Choice {
+						return Choice{
+							Index:        item.Index,
+							Text:         item.Delta.Content,
+							FinishReason: string(item.FinishReason),
+							ToolCalls:    fromOpenAIToolCalls(item.Delta.ToolCalls),
+						}
+					}),
 				}
+
+				// Text/FinishReason/ToolCalls 只包含第一个候选回复的增量
+				for _, choice := range chunk.Choices {
+					if choice.Index == 0 {
+						chunk.Text, chunk.FinishReason, chunk.ToolCalls = choice.Text, choice.FinishReason, choice.ToolCalls
+					}
+				}
+
+				res <- chunk
 			}
 		}
 
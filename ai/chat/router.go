@@ -0,0 +1,215 @@
+package chat
+
+import (
+	"accompany-sdk/ai/catalog"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var ErrProviderNotFound = errors.New("未找到模型对应的服务提供商")
+
+const (
+	ProviderOpenAI    = catalog.ProviderOpenAI
+	ProviderBaidu     = catalog.ProviderBaidu
+	ProviderAnthropic = catalog.ProviderAnthropic
+	ProviderZhipu     = catalog.ProviderZhipu
+)
+
+// Router 根据模型名称将请求分发到对应的服务提供商，Router 本身也实现了 Chat 接口
+//
+// 模型解析顺序：
+// 1. 模型名称前缀，如 openai:gpt-4，前缀会在转发前去掉，多个前缀都匹配时使用最长的前缀
+// 2. 精确匹配已注册的模型名称
+// 3. 模型目录中该模型所属的服务提供商（需要已注册），如通过配置添加的模型
+// 4. 通配符匹配已注册的模型名称，如 claude-*，优先匹配最长的通配符
+// 5. 默认服务提供商
+type Router struct {
+	lock      sync.RWMutex
+	providers map[string]Chat
+	// prefixes 模型前缀到服务提供商的映射
+	prefixes map[string]string
+	// models 模型名称到服务提供商的映射，支持 * 结尾的通配符
+	models   map[string]string
+	fallback string
+}
+
+func NewRouter() *Router {
+	return &Router{
+		providers: make(map[string]Chat),
+		prefixes:  make(map[string]string),
+		models:    make(map[string]string),
+	}
+}
+
+// Register 注册服务提供商，prefixes 为该服务提供商的模型前缀，如 openai:
+func (r *Router) Register(provider string, backend Chat, prefixes ...string) *Router {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.providers[provider] = backend
+	for _, prefix := range prefixes {
+		r.prefixes[prefix] = provider
+	}
+
+	return r
+}
+
+// RegisterModels 注册服务提供商支持的模型，支持 * 结尾的通配符，如 claude-*
+func (r *Router) RegisterModels(provider string, models ...string) *Router {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, model := range models {
+		r.models[model] = provider
+	}
+
+	return r
+}
+
+// SetDefault 设置默认服务提供商，无法根据模型名称确定服务提供商时使用
+func (r *Router) SetDefault(provider string) *Router {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.fallback = provider
+	return r
+}
+
+// Has 是否已注册指定的服务提供商
+func (r *Router) Has(provider string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	_, ok := r.providers[provider]
+	return ok
+}
+
+// Provider 返回指定的服务提供商
+func (r *Router) Provider(provider string) (Chat, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	backend, ok := r.providers[provider]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+
+	return backend, nil
+}
+
+// Resolve 根据模型名称解析服务提供商，返回服务提供商名称、对应的 Chat 实现以及去掉前缀后的模型名称
+func (r *Router) Resolve(model string) (string, Chat, string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	provider, realModel := r.resolveProvider(model)
+	if provider == "" {
+		return "", nil, model, ErrProviderNotFound
+	}
+
+	backend, ok := r.providers[provider]
+	if !ok {
+		return "", nil, model, ErrProviderNotFound
+	}
+
+	return provider, backend, realModel, nil
+}
+
+func (r *Router) resolveProvider(model string) (string, string) {
+	// 多个前缀都匹配时使用最长的前缀，结果不依赖 map 的遍历顺序
+	var matchedPrefix, prefixProvider string
+	for prefix, provider := range r.prefixes {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matchedPrefix) {
+			matchedPrefix, prefixProvider = prefix, provider
+		}
+	}
+
+	if prefixProvider != "" {
+		return prefixProvider, strings.TrimPrefix(model, matchedPrefix)
+	}
+
+	if provider, ok := r.models[model]; ok {
+		return provider, model
+	}
+
+	if m, ok := catalog.Lookup(model); ok && m.Provider != "" {
+		if _, ok := r.providers[m.Provider]; ok {
+			return m.Provider, model
+		}
+	}
+
+	var matched, provider string
+	for pattern, p := range r.models {
+		if !strings.HasSuffix(pattern, "*") {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(pattern, "*")
+		if strings.HasPrefix(model, prefix) && len(prefix) >= len(matched) {
+			matched, provider = prefix, p
+		}
+	}
+
+	if provider != "" {
+		return provider, model
+	}
+
+	return r.fallback, model
+}
+
+// resolveRequest 解析请求对应的服务提供商，如果指定了临时模型，则使用临时模型
+func (r *Router) resolveRequest(req Request) (Chat, Request, error) {
+	model := req.Model
+	if req.TempModel != "" {
+		model = req.TempModel
+	}
+
+	_, backend, realModel, err := r.Resolve(model)
+	if err != nil {
+		return nil, req, err
+	}
+
+	req.Model = realModel
+	req.TempModel = ""
+
+	return backend, req, nil
+}
+
+func (r *Router) Chat(ctx context.Context, req Request) (*Response, error) {
+	backend, req, err := r.resolveRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := backend.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Model == "" {
+		res.Model = req.Model
+	}
+
+	return res, nil
+}
+
+func (r *Router) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	backend, req, err := r.resolveRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.ChatStream(ctx, req)
+}
+
+// MaxContextLength 获取模型的最大上下文长度，无法确定服务提供商时返回 0
+func (r *Router) MaxContextLength(model string) int {
+	_, backend, realModel, err := r.Resolve(model)
+	if err != nil {
+		return 0
+	}
+
+	return backend.MaxContextLength(realModel)
+}
@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"accompany-sdk/ai/catalog"
+)
+
+// fakeChat 记录收到的请求，返回预设的结果
+type fakeChat struct {
+	name          string
+	contextLength int
+	err           error
+	requests      []Request
+}
+
+func (c *fakeChat) Chat(ctx context.Context, req Request) (*Response, error) {
+	c.requests = append(c.requests, req)
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return &Response{Text: c.name}, nil
+}
+
+func (c *fakeChat) ChatStream(ctx context.Context, req Request) (<-chan Response, error) {
+	c.requests = append(c.requests, req)
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	res := make(chan Response, 1)
+	res <- Response{Text: c.name}
+	close(res)
+	return res, nil
+}
+
+func (c *fakeChat) MaxContextLength(model string) int {
+	return c.contextLength
+}
+
+func TestRouter_Resolve(t *testing.T) {
+	catalog.Register(catalog.Model{ID: "router-test-model", Provider: ProviderZhipu})
+
+	router := NewRouter().
+		Register(ProviderOpenAI, &fakeChat{name: ProviderOpenAI}, "openai:").
+		Register(ProviderAnthropic, &fakeChat{name: ProviderAnthropic}, "anthropic:").
+		Register(ProviderZhipu, &fakeChat{name: ProviderZhipu}, "zhipu:").
+		RegisterModels(ProviderAnthropic, "claude-*", "router-*").
+		SetDefault(ProviderOpenAI)
+
+	cases := []struct {
+		model    string
+		provider string
+		real     string
+	}{
+		{model: "anthropic:gpt-4", provider: ProviderAnthropic, real: "gpt-4"},
+		{model: "claude-3-haiku-20240307", provider: ProviderAnthropic, real: "claude-3-haiku-20240307"},
+		// 模型目录中的服务提供商优先于通配符
+		{model: "router-test-model", provider: ProviderZhipu, real: "router-test-model"},
+		{model: "unknown-model", provider: ProviderOpenAI, real: "unknown-model"},
+	}
+
+	for _, c := range cases {
+		provider, _, real, err := router.Resolve(c.model)
+		if err != nil || provider != c.provider || real != c.real {
+			t.Errorf("%s: expect %s/%s, got %s/%s %v", c.model, c.provider, c.real, provider, real, err)
+		}
+	}
+}
+
+func TestRouter_LongestPrefix(t *testing.T) {
+	router := NewRouter().
+		Register("local", &fakeChat{name: "local"}, "local:").
+		Register("ollama", &fakeChat{name: "ollama"}, "local:ollama:")
+
+	// 多次解析，结果不依赖 map 的遍历顺序
+	for i := 0; i < 20; i++ {
+		provider, _, real, err := router.Resolve("local:ollama:qwen2")
+		if err != nil || provider != "ollama" || real != "qwen2" {
+			t.Fatalf("expect the longest prefix to win, got %s/%s %v", provider, real, err)
+		}
+
+		if provider, _, real, _ = router.Resolve("local:llama3"); provider != "local" || real != "llama3" {
+			t.Fatalf("expect the short prefix, got %s/%s", provider, real)
+		}
+	}
+}
+
+func TestRouter_Chat(t *testing.T) {
+	openai, anthropic := &fakeChat{name: ProviderOpenAI, contextLength: 8192}, &fakeChat{name: ProviderAnthropic, contextLength: 200000}
+	router := NewRouter().
+		Register(ProviderOpenAI, openai, "openai:").
+		Register(ProviderAnthropic, anthropic, "anthropic:")
+
+	// 临时模型优先，转发前去掉前缀
+	res, err := router.Chat(context.Background(), Request{Model: "openai:gpt-4", TempModel: "anthropic:claude-3-haiku-20240307"})
+	if err != nil || res.Text != ProviderAnthropic || res.Model != "claude-3-haiku-20240307" {
+		t.Fatalf("unexpected response %+v %v", res, err)
+	}
+
+	if req := anthropic.requests[0]; req.Model != "claude-3-haiku-20240307" || req.TempModel != "" {
+		t.Errorf("unexpected forwarded request %+v", req)
+	}
+
+	if length := router.MaxContextLength("openai:gpt-4"); length != 8192 {
+		t.Errorf("expect the context length of the resolved provider, got %d", length)
+	}
+
+	// 没有默认服务提供商时无法解析的模型返回 ErrProviderNotFound
+	if _, err := router.ChatStream(context.Background(), Request{Model: "unknown-model"}); !errors.Is(err, ErrProviderNotFound) {
+		t.Errorf("expect ErrProviderNotFound, got %v", err)
+	}
+
+	if length := router.MaxContextLength("unknown-model"); length != 0 {
+		t.Errorf("expect 0 for an unknown model, got %d", length)
+	}
+}
@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"fmt"
+	"math"
+
+	"accompany-sdk/pkg/utils/array"
+)
+
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+)
+
+// SamplingLimits 服务提供商支持的采样参数取值范围，超出范围的参数会被修正到范围内
+type SamplingLimits struct {
+	TemperatureMin float64
+	TemperatureMax float64
+	TopPMin        float64
+	TopPMax        float64
+	// PenaltyMin/PenaltyMax presence_penalty 和 frequency_penalty 的取值范围
+	PenaltyMin float64
+	PenaltyMax float64
+	// MaxStop 最多支持的停止词数量，为 0 时表示不支持停止词
+	MaxStop int
+}
+
+// OpenAISamplingLimits OpenAI 采样参数取值范围
+// https://platform.openai.com/docs/api-reference/chat/create
+var OpenAISamplingLimits = SamplingLimits{
+	TemperatureMin: 0, TemperatureMax: 2,
+	TopPMin: 0, TopPMax: 1,
+	PenaltyMin: -2, PenaltyMax: 2,
+	MaxStop: 4,
+}
+
+// BaiduSamplingLimits 百度文心千帆采样参数取值范围，temperature 的范围为 (0, 1]，不能为 0
+// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/jlil56u11
+var BaiduSamplingLimits = SamplingLimits{
+	TemperatureMin: 0.01, TemperatureMax: 1,
+	TopPMin: 0, TopPMax: 1,
+	PenaltyMin: 0, PenaltyMax: 2,
+	MaxStop: 4,
+}
+
+// Float 返回 v 的指针，用于设置采样参数
+func Float(v float64) *float64 {
+	return &v
+}
+
+// ValidateSampling 校验采样参数，超出范围的参数在转发到服务提供商时会被修正，这里只校验无法修正的参数
+func (req Request) ValidateSampling() error {
+	for name, value := range map[string]*float64{
+		"temperature":       req.Temperature,
+		"top_p":             req.TopP,
+		"presence_penalty":  req.PresencePenalty,
+		"frequency_penalty": req.FrequencyPenalty,
+	} {
+		if value != nil && (math.IsNaN(*value) || math.IsInf(*value, 0)) {
+			return fmt.Errorf("invalid %s: %v", name, *value)
+		}
+	}
+
+	if req.ResponseFormat != "" && !array.In(req.ResponseFormat, []string{ResponseFormatText, ResponseFormatJSONObject}) {
+		return fmt.Errorf("invalid response_format: %s", req.ResponseFormat)
+	}
+
+	return nil
+}
+
+// ClampSampling 把采样参数修正到服务提供商支持的范围内，未设置的参数表示使用服务提供商的默认值，不做修正
+func (req Request) ClampSampling(limits SamplingLimits) Request {
+	req.Temperature = clampPtr(req.Temperature, limits.TemperatureMin, limits.TemperatureMax)
+	req.TopP = clampPtr(req.TopP, limits.TopPMin, limits.TopPMax)
+	req.PresencePenalty = clampPtr(req.PresencePenalty, limits.PenaltyMin, limits.PenaltyMax)
+	req.FrequencyPenalty = clampPtr(req.FrequencyPenalty, limits.PenaltyMin, limits.PenaltyMax)
+
+	req.Stop = array.Filter(req.Stop, func(item string, _ int) bool { return item != "" })
+	if len(req.Stop) > limits.MaxStop {
+		req.Stop = req.Stop[:limits.MaxStop]
+	}
+
+	return req
+}
+
+// clampPtr 返回修正后的新指针，不修改调用方的参数，nil 保持不变
+func clampPtr(value *float64, min, max float64) *float64 {
+	if value == nil {
+		return nil
+	}
+
+	return Float(clamp(*value, min, max))
+}
+
+func clamp(value, min, max float64) float64 {
+	return math.Max(min, math.Min(max, value))
+}
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func floatValue(value *float64) any {
+	if value == nil {
+		return nil
+	}
+
+	return *value
+}
+
+func TestRequest_ClampSampling(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    Request
+		limits SamplingLimits
+		want   Request
+	}{
+		{
+			name:   "unset values keep provider defaults",
+			req:    Request{},
+			limits: BaiduSamplingLimits,
+			want:   Request{},
+		},
+		{
+			name:   "zero temperature is kept",
+			req:    Request{Temperature: Float(0), TopP: Float(0)},
+			limits: OpenAISamplingLimits,
+			want:   Request{Temperature: Float(0), TopP: Float(0)},
+		},
+		{
+			name:   "zero temperature is raised to the provider minimum",
+			req:    Request{Temperature: Float(0)},
+			limits: BaiduSamplingLimits,
+			want:   Request{Temperature: Float(0.01)},
+		},
+		{
+			name:   "out of range values are clamped",
+			req:    Request{Temperature: Float(3), TopP: Float(-1), PresencePenalty: Float(-3), FrequencyPenalty: Float(5)},
+			limits: OpenAISamplingLimits,
+			want:   Request{Temperature: Float(2), TopP: Float(0), PresencePenalty: Float(-2), FrequencyPenalty: Float(2)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.req.ClampSampling(tt.limits)
+			for name, pair := range map[string][2]*float64{
+				"temperature":       {got.Temperature, tt.want.Temperature},
+				"top_p":             {got.TopP, tt.want.TopP},
+				"presence_penalty":  {got.PresencePenalty, tt.want.PresencePenalty},
+				"frequency_penalty": {got.FrequencyPenalty, tt.want.FrequencyPenalty},
+			} {
+				if floatValue(pair[0]) != floatValue(pair[1]) {
+					t.Errorf("%s: want %v, got %v", name, floatValue(pair[1]), floatValue(pair[0]))
+				}
+			}
+		})
+	}
+
+	// 修正参数时不能修改调用方的请求
+	temperature := Float(3)
+	Request{Temperature: temperature}.ClampSampling(OpenAISamplingLimits)
+	if *temperature != 3 {
+		t.Errorf("caller's value should not be modified, got %v", *temperature)
+	}
+
+	req := Request{Stop: []string{"a", "", "b", "c", "d", "e"}}.ClampSampling(OpenAISamplingLimits)
+	if len(req.Stop) != 4 || req.Stop[1] != "b" {
+		t.Errorf("unexpected stop words: %v", req.Stop)
+	}
+}
+
+func TestRequest_ZeroTemperatureJSON(t *testing.T) {
+	var req Request
+	if err := json.Unmarshal([]byte(`{"model":"gpt-4","temperature":0}`), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Temperature == nil || *req.Temperature != 0 || req.TopP != nil {
+		t.Fatalf("temperature 0 should be distinguished from unset, got %v %v", floatValue(req.Temperature), floatValue(req.TopP))
+	}
+
+	if openaiSampling(req.Temperature) == 0 || openaiSampling(req.TopP) != 0 {
+		t.Errorf("explicit zero should be sent to OpenAI, unset should be omitted")
+	}
+
+	if err := (Request{Temperature: Float(math.NaN())}).ValidateSampling(); err == nil {
+		t.Errorf("NaN temperature should be rejected")
+	}
+}
+
+func TestBaiduPenaltyScore(t *testing.T) {
+	tests := []struct {
+		presence  *float64
+		frequency *float64
+		want      float64
+	}{
+		{want: 0},
+		{presence: Float(0), want: 1},
+		{presence: Float(1), want: 1.5},
+		{presence: Float(0.5), frequency: Float(2), want: 2},
+		{frequency: Float(-2), want: 1},
+		{presence: Float(4), want: 2},
+	}
+
+	for _, tt := range tests {
+		got := baiduPenaltyScore(Request{PresencePenalty: tt.presence, FrequencyPenalty: tt.frequency})
+		if got != tt.want {
+			t.Errorf("presence %v frequency %v: want %v, got %v", floatValue(tt.presence), floatValue(tt.frequency), tt.want, got)
+		}
+	}
+}
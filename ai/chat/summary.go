@@ -0,0 +1,221 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// ContextStrategyTruncate 上下文超出限制时直接丢弃最早的消息
+	ContextStrategyTruncate = "truncate"
+	// ContextStrategySummarize 上下文超出限制时把被丢弃的消息压缩为摘要，作为 system 消息保留
+	ContextStrategySummarize = "summarize"
+)
+
+const (
+	// DefaultSummaryMaxTokens 摘要默认的最大 token 数，上下文缩减时会为摘要预留同样大小的空间
+	DefaultSummaryMaxTokens = 500
+	// DefaultSummaryCacheSize 默认最多缓存的会话摘要数量
+	DefaultSummaryCacheSize = 1000
+	// DefaultSummaryInputTokens 无法获取摘要模型的上下文长度时，发送给摘要模型的对话最多占用的 token 数量
+	DefaultSummaryInputTokens = 3000
+)
+
+// summaryPromptReserve 为摘要提示语和消息格式预留的 token 数量
+const summaryPromptReserve = 200
+
+// SummaryPrompt 生成对话摘要使用的提示语
+const SummaryPrompt = "你是一个对话摘要助手。请用简洁的中文总结下面的对话，保留人物、偏好、约定、事实以及未完成的事项等后续对话需要的关键信息，不要添加对话中没有的内容，直接输出摘要。"
+
+// summaryMessagePrefix 摘要作为 system 消息发送给模型时的前缀
+const summaryMessagePrefix = "以下是之前对话的摘要：\n"
+
+// Summarizer 把一段对话压缩为摘要
+type Summarizer interface {
+	Summarize(ctx context.Context, messages Messages) (string, error)
+}
+
+// SummarizerFunc 函数形式的 Summarizer
+type SummarizerFunc func(ctx context.Context, messages Messages) (string, error)
+
+func (f SummarizerFunc) Summarize(ctx context.Context, messages Messages) (string, error) {
+	return f(ctx, messages)
+}
+
+// SummaryInputLimit 根据摘要模型的上下文长度计算发送给摘要模型的对话最多占用的 token 数量
+func SummaryInputLimit(contextLength int, maxTokens int) int {
+	limit := contextLength - maxTokens - summaryPromptReserve
+	if limit <= 0 {
+		return DefaultSummaryInputTokens
+	}
+
+	return limit
+}
+
+// TranscriptWithin 把对话拼接为 "role: content" 格式的文本，超过 maxTokens 时丢弃最早的消息
+// 增量生成摘要时第一条 system 消息是之前的摘要，总是保留；最近的一条消息本身超过限制时只保留它末尾的内容
+func TranscriptWithin(messages Messages, model string, maxTokens int) string {
+	tokenizer, model := ResolveTokenizer(model)
+	count := func(msg Message) int {
+		tokens, err := tokenizer.CountText(model, msg.Content)
+		if err != nil {
+			// 无法计算时按照每个字符一个 token 估算
+			tokens = utf8.RuneCountInString(msg.Content)
+		}
+
+		return tokens + tokenizer.MessageOverhead(model)
+	}
+
+	var head Messages
+	if len(messages) > 0 && messages[0].Role == "system" {
+		head, messages = messages[:1], messages[1:]
+		maxTokens -= count(head[0])
+	}
+
+	start := len(messages)
+	for start > 0 {
+		tokens := count(messages[start-1])
+		if tokens > maxTokens {
+			break
+		}
+
+		maxTokens -= tokens
+		start--
+	}
+
+	kept := append(Messages{}, messages[start:]...)
+	if len(kept) == 0 && len(messages) > 0 {
+		last := messages[len(messages)-1]
+		runes := []rune(last.Content)
+		for len(runes) > 0 && count(last) > maxTokens {
+			runes = runes[len(runes)/2:]
+			last.Content = string(runes)
+		}
+
+		kept = Messages{last}
+	}
+
+	return append(head, kept...).Transcript()
+}
+
+// NewChatSummarizer 使用指定的模型生成摘要，发送给摘要模型的对话不会超过模型的上下文长度
+func NewChatSummarizer(backend Chat, model string, maxTokens int) Summarizer {
+	return SummarizerFunc(func(ctx context.Context, messages Messages) (string, error) {
+		limit := SummaryInputLimit(backend.MaxContextLength(model), maxTokens)
+		res, err := backend.Chat(ctx, Request{
+			Model: model,
+			Messages: Messages{
+				{Role: "system", Content: SummaryPrompt},
+				{Role: "user", Content: TranscriptWithin(messages, model, limit)},
+			},
+			MaxTokens:   maxTokens,
+			Temperature: Float(0.2),
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return res.Text, nil
+	})
+}
+
+// ContextSummarizer 对话上下文摘要，按照会话缓存摘要，同一会话后续的对话只需要对新丢弃的消息增量生成摘要
+type ContextSummarizer struct {
+	summarizer Summarizer
+	maxTokens  int
+
+	lock    sync.Mutex
+	size    int
+	entries map[int64]summaryEntry
+	// rooms 按照写入顺序记录会话 ID，缓存满时淘汰最早写入的会话
+	rooms []int64
+}
+
+type summaryEntry struct {
+	// count 摘要覆盖的消息数量
+	count int
+	// digest 摘要覆盖的消息的摘要值，用于判断会话历史是否被修改
+	digest  string
+	summary string
+}
+
+func NewContextSummarizer(summarizer Summarizer, maxTokens int, cacheSize int) *ContextSummarizer {
+	if maxTokens <= 0 {
+		maxTokens = DefaultSummaryMaxTokens
+	}
+
+	if cacheSize <= 0 {
+		cacheSize = DefaultSummaryCacheSize
+	}
+
+	return &ContextSummarizer{
+		summarizer: summarizer,
+		maxTokens:  maxTokens,
+		size:       cacheSize,
+		entries:    make(map[int64]summaryEntry),
+	}
+}
+
+// MaxTokens 摘要的最大 token 数
+func (s *ContextSummarizer) MaxTokens() int {
+	return s.maxTokens
+}
+
+// Summarize 生成 messages 的摘要，roomID 不为 0 时优先复用缓存的摘要
+func (s *ContextSummarizer) Summarize(ctx context.Context, roomID int64, messages Messages) (string, error) {
+	if roomID == 0 {
+		return s.summarizer.Summarize(ctx, messages)
+	}
+
+	entry, ok := s.entry(roomID)
+	input := messages
+	if ok && entry.count <= len(messages) && digestMessages(messages[:entry.count]) == entry.digest {
+		if entry.count == len(messages) {
+			return entry.summary, nil
+		}
+
+		// 已有摘要覆盖了前面的消息，只需要把已有摘要和新丢弃的消息合并生成新的摘要
+		input = append(Messages{{Role: "system", Content: summaryMessagePrefix + entry.summary}}, messages[entry.count:]...)
+	}
+
+	summary, err := s.summarizer.Summarize(ctx, input)
+	if err != nil {
+		return "", err
+	}
+
+	s.store(roomID, summaryEntry{count: len(messages), digest: digestMessages(messages), summary: summary})
+	return summary, nil
+}
+
+func (s *ContextSummarizer) entry(roomID int64) (summaryEntry, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry, ok := s.entries[roomID]
+	return entry, ok
+}
+
+func (s *ContextSummarizer) store(roomID int64, entry summaryEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.entries[roomID]; !ok {
+		s.rooms = append(s.rooms, roomID)
+	}
+
+	s.entries[roomID] = entry
+	for len(s.rooms) > s.size {
+		delete(s.entries, s.rooms[0])
+		s.rooms = s.rooms[1:]
+	}
+}
+
+func digestMessages(messages Messages) string {
+	data, _ := json.Marshal(messages)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,204 @@
+package chat
+
+import (
+	"accompany-sdk/pkg/ternary"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// recordSummarizer 记录每次生成摘要的输入，返回预设的摘要
+type recordSummarizer struct {
+	inputs []Messages
+	err    error
+}
+
+func (s *recordSummarizer) Summarize(ctx context.Context, messages Messages) (string, error) {
+	s.inputs = append(s.inputs, messages)
+	if s.err != nil {
+		return "", s.err
+	}
+
+	return fmt.Sprintf("summary-%d", len(s.inputs)), nil
+}
+
+func summaryHistory(n int) Messages {
+	messages := make(Messages, 0, n)
+	for i := 0; i < n; i++ {
+		messages = append(messages, Message{Role: ternary.If(i%2 == 0, "user", "assistant"), Content: fmt.Sprintf("第 %d 条消息", i)})
+	}
+
+	return messages
+}
+
+func TestContextSummarizer_RoomCache(t *testing.T) {
+	recorder := &recordSummarizer{}
+	summarizer := NewContextSummarizer(recorder, 0, 0)
+	history := summaryHistory(6)
+
+	first, err := summarizer.Summarize(context.Background(), 1, history[:4])
+	if err != nil || first != "summary-1" {
+		t.Fatalf("unexpected summary %q %v", first, err)
+	}
+
+	// 同一会话同样的消息直接使用缓存
+	if cached, _ := summarizer.Summarize(context.Background(), 1, history[:4]); cached != first || len(recorder.inputs) != 1 {
+		t.Errorf("expect cached summary, got %q after %d calls", cached, len(recorder.inputs))
+	}
+
+	// 新丢弃的消息和已有摘要合并生成新的摘要
+	second, _ := summarizer.Summarize(context.Background(), 1, history)
+	input := recorder.inputs[len(recorder.inputs)-1]
+	if second != "summary-2" || len(input) != 3 || input[0].Role != "system" || !strings.HasSuffix(input[0].Content, first) || input[1].Content != history[4].Content {
+		t.Errorf("expect incremental summary input, got %q %+v", second, input)
+	}
+
+	// roomID 为 0 时不使用缓存
+	_, _ = summarizer.Summarize(context.Background(), 0, history)
+	_, _ = summarizer.Summarize(context.Background(), 0, history)
+	if len(recorder.inputs) != 4 {
+		t.Errorf("expect no cache without room id, got %d calls", len(recorder.inputs))
+	}
+}
+
+func TestContextSummarizer_Digest(t *testing.T) {
+	recorder := &recordSummarizer{}
+	summarizer := NewContextSummarizer(recorder, 0, 0)
+	history := summaryHistory(4)
+
+	_, _ = summarizer.Summarize(context.Background(), 1, history)
+
+	// 历史消息被修改后缓存失效，重新对全部消息生成摘要
+	edited := append(Messages{}, history...)
+	edited[1].Content = "被修改的消息"
+	_, _ = summarizer.Summarize(context.Background(), 1, append(edited, Message{Role: "user", Content: "新消息"}))
+	if len(recorder.inputs) != 2 || len(recorder.inputs[1]) != 5 || recorder.inputs[1][0].Role != "user" {
+		t.Errorf("expect full summary after history changed, got %+v", recorder.inputs)
+	}
+}
+
+func TestContextSummarizer_Evict(t *testing.T) {
+	recorder := &recordSummarizer{}
+	summarizer := NewContextSummarizer(recorder, 0, 2)
+	history := summaryHistory(2)
+
+	for _, roomID := range []int64{1, 2, 3, 1} {
+		_, _ = summarizer.Summarize(context.Background(), roomID, history)
+	}
+
+	// 会话 1 最早写入，缓存满时被淘汰，需要重新生成摘要
+	if len(recorder.inputs) != 4 || len(summarizer.entries) != 2 {
+		t.Errorf("expect room 1 to be evicted, got %d calls and %d entries", len(recorder.inputs), len(summarizer.entries))
+	}
+}
+
+func summarizeRequest(n int) Request {
+	return Request{
+		Model:           "gpt-4",
+		ContextStrategy: ContextStrategySummarize,
+		Messages:        append(Messages{{Role: "system", Content: "你是一个助手"}}, append(summaryHistory(n), Message{Role: "user", Content: strings.Repeat("问题", 10)})...),
+	}
+}
+
+func TestRequest_FixSummary(t *testing.T) {
+	skipWithoutEncoding(t)
+
+	req := summarizeRequest(40)
+	backend := &fakeChat{contextLength: 300}
+	summarizer := NewContextSummarizer(&recordSummarizer{}, 100, 0)
+
+	fixed, _, err := req.Fix(context.Background(), backend, summarizer, 100, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 摘要合并到第一条 system 消息中，只读取第一条 system 消息的服务提供商也能收到摘要
+	systems := 0
+	for _, msg := range fixed.Messages {
+		if msg.Role == "system" {
+			systems++
+		}
+	}
+
+	if systems != 1 || !strings.HasPrefix(fixed.Messages[0].Content, "你是一个助手") || !strings.Contains(fixed.Messages[0].Content, summaryMessagePrefix+"summary-1") {
+		t.Errorf("expect summary merged into the first system message, got %+v", fixed.Messages[:2])
+	}
+}
+
+func TestRequest_FixSummaryFallback(t *testing.T) {
+	skipWithoutEncoding(t)
+
+	req := summarizeRequest(40)
+	backend := &fakeChat{contextLength: 300}
+
+	truncated, truncatedTokens, err := req.Fix(context.Background(), backend, nil, 100, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 生成摘要失败时退化为直接丢弃最早的消息
+	summarizer := NewContextSummarizer(&recordSummarizer{err: errors.New("unavailable")}, 100, 0)
+	fixed, tokens, err := req.Fix(context.Background(), backend, summarizer, 100, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tokens != truncatedTokens || len(fixed.Messages) != len(truncated.Messages) || fixed.Messages[0].Content != "你是一个助手" {
+		t.Errorf("expect truncated messages without summary, got %d messages %d tokens", len(fixed.Messages), tokens)
+	}
+}
+
+func TestRequest_FixSummaryRoundWindow(t *testing.T) {
+	skipWithoutEncoding(t)
+
+	// 只超出对话轮数限制时直接丢弃，不生成摘要
+	recorder := &recordSummarizer{}
+	summarizer := NewContextSummarizer(recorder, 100, 0)
+	backend := &fakeChat{contextLength: 100000}
+
+	fixed, _, err := summarizeRequest(40).Fix(context.Background(), backend, summarizer, 10, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.inputs) != 0 || len(fixed.Messages) != 22 || fixed.Messages[0].Content != "你是一个助手" {
+		t.Errorf("expect round window truncation without summary, got %d calls and %d messages", len(recorder.inputs), len(fixed.Messages))
+	}
+
+	// 同时超出 token 数量限制时，只有轮数限制以内被丢弃的消息压缩为摘要
+	backend = &fakeChat{contextLength: 200}
+	if _, _, err := summarizeRequest(40).Fix(context.Background(), backend, summarizer, 10, 200); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.inputs) != 1 || len(recorder.inputs[0]) == 0 || recorder.inputs[0][0].Content != "第 20 条消息" {
+		t.Errorf("expect only token-dropped messages summarized, got %+v", recorder.inputs)
+	}
+}
+
+func TestTranscriptWithin(t *testing.T) {
+	skipWithoutEncoding(t)
+
+	history := append(Messages{{Role: "system", Content: summaryMessagePrefix + "之前的摘要"}}, summaryHistory(50)...)
+
+	transcript := TranscriptWithin(history, "gpt-4", 100)
+	if !strings.HasPrefix(transcript, "system: "+summaryMessagePrefix) || !strings.HasSuffix(transcript, "第 49 条消息") || strings.Contains(transcript, "第 0 条消息") {
+		t.Errorf("expect previous summary and latest messages, got %q", transcript)
+	}
+
+	if tokens, _ := TokenizerForModel("gpt-4").CountText("gpt-4", transcript); tokens > 120 {
+		t.Errorf("transcript is too long: %d tokens", tokens)
+	}
+
+	// 最近的一条消息超过限制时只保留末尾的内容
+	long := Messages{{Role: "user", Content: strings.Repeat("很长的消息", 200) + "结尾"}}
+	if transcript := TranscriptWithin(long, "gpt-4", 50); !strings.HasSuffix(transcript, "结尾") || len([]rune(transcript)) >= 1000 {
+		t.Errorf("expect the tail of the last message, got %d runes", len([]rune(transcript)))
+	}
+
+	if transcript := TranscriptWithin(history[1:3], "gpt-4", 1000); transcript != history[1:3].Transcript() {
+		t.Errorf("short transcript should not be changed, got %q", transcript)
+	}
+}
@@ -1,11 +1,15 @@
 package chat
 
 import (
+	_ "accompany-sdk/pkg/bpe"
 	"accompany-sdk/pkg/utils/array"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/pkoukk/tiktoken-go"
 	"strings"
+	"sync"
 )
 
 // ReduceMessageContextUpToContextWindow 减少对话上下文到指定的上下文窗口大小
@@ -19,89 +23,204 @@ func ReduceMessageContextUpToContextWindow(messages Messages, maxContext int) Me
 	return messages
 }
 
-// ReduceMessageContext 递归减少对话上下文
+// ReduceMessageContext 从最早的消息开始丢弃，直到对话上下文的 token 数量不超过 maxTokens
+// 每条消息的 token 数量只计算一次，通过后缀和找到满足限制的最早的起始位置
 func ReduceMessageContext(messages Messages, model string, maxTokens int) (reducedMessages Messages, tokenCount int, err error) {
-	num, err := MessageTokenCount(messages, model)
+	counts, err := messageTokenCounts(messages, model)
 	if err != nil {
 		return nil, 0, fmt.Errorf("MessageTokenCount: %v", err)
 	}
 
-	if num <= maxTokens {
-		// 第一个消息应该是 user 消息
-		if len(messages) > 1 && messages[0].Role == "assistant" {
-			return messages[1:], num, nil
-		}
+	// suffix[i] 为 messages[i:] 的 token 数量
+	suffix := make([]int, len(messages)+1)
+	suffix[len(messages)] = replyPrimingTokens
+	for i := len(messages) - 1; i >= 0; i-- {
+		suffix[i] = suffix[i+1] + counts[i]
+	}
 
-		return messages, num, nil
+	start := 0
+	for start < len(messages) && suffix[start] > maxTokens {
+		start++
 	}
 
-	if len(messages) <= 1 {
+	// 至少需要保留最后一条消息
+	if suffix[start] > maxTokens || (start == len(messages) && len(messages) > 0) {
 		return nil, 0, errors.New("对话上下文过长，无法继续生成")
 	}
 
-	return ReduceMessageContext(messages[1:], model, maxTokens)
+	// 第一个消息应该是 user 消息，上下文被截断后遗留的工具调用结果也需要去掉
+	for len(messages)-start > 1 && (messages[start].Role == "assistant" || messages[start].Role == "tool") {
+		start++
+	}
+
+	return messages[start:], suffix[start], nil
 }
 
-// MessageTokenCount 计算对话上下文的 token 数量
-// TODO 不通厂商模型的 Token 计算方式可能不同，需要根据厂商模型进行区分
+// replyPrimingTokens 每次请求中模型回复的起始标记占用的 token 数量
+const replyPrimingTokens = 3
+
+// maxTokenCacheSize 最多缓存的消息 token 数量
+const maxTokenCacheSize = 8192
+
+var (
+	// encodings 已加载的编码器，模型名称 -> *tiktoken.Tiktoken，编码器加载时需要解析 BPE 文件，只加载一次
+	encodings sync.Map
+
+	tokenCache = newTokenCountCache(maxTokenCacheSize)
+)
+
+// MessageTokenCount 计算对话上下文的 token 数量，使用模型所属服务提供商注册的 Tokenizer
 func MessageTokenCount(messages Messages, model string) (numTokens int, err error) {
-	_model := model
+	counts, err := messageTokenCounts(messages, model)
+	if err != nil {
+		return 0, err
+	}
+
+	return array.Reduce(counts, func(carry int, item int) int { return carry + item }, replyPrimingTokens), nil
+}
+
+// messageTokenCounts 计算每条消息的 token 数量，已经计算过的消息直接从缓存中读取
+func messageTokenCounts(messages Messages, model string) ([]int, error) {
+	provider, tokenizer, realModel := resolveTokenizer(model)
+	counts := make([]int, len(messages))
+	for i, message := range messages {
+		key := messageCacheKey(provider+"/"+realModel, message)
+		if count, ok := tokenCache.get(key); ok {
+			counts[i] = count
+			continue
+		}
 
-	// 所有非 gpt-3.5-turbo/gpt-4 的模型，都按照 gpt-3.5 的方式处理
-	if !array.In(_model, []string{"gpt-3.5-turbo", "gpt-4"}) {
-		_model = "gpt-3.5-turbo"
+		count, err := messageTokens(tokenizer, realModel, message)
+		if err != nil {
+			return nil, err
+		}
+
+		counts[i] = count
+		tokenCache.set(key, count)
+	}
+
+	return counts, nil
+}
+
+// encodingModel 返回计算 token 时使用的模型名称
+func encodingModel(model string) string {
+	// gpt-4o 系列模型使用 o200k_base 编码
+	if strings.HasPrefix(model, "gpt-4o") {
+		return "gpt-4o"
+	}
+
+	// 其它非 gpt-3.5-turbo/gpt-4 的模型，都按照 gpt-3.5 的方式处理
+	if !array.In(model, []string{"gpt-3.5-turbo", "gpt-4"}) {
+		return "gpt-3.5-turbo"
 	}
 
-	tkm, err := tiktoken.EncodingForModel(_model)
+	return model
+}
+
+func encodingForModel(model string) (*tiktoken.Tiktoken, error) {
+	if tkm, ok := encodings.Load(model); ok {
+		return tkm.(*tiktoken.Tiktoken), nil
+	}
+
+	tkm, err := tiktoken.EncodingForModel(model)
 	if err != nil {
-		return 0, fmt.Errorf("EncodingForModel: %v", err)
+		return nil, err
 	}
 
-	var tokensPerMessage int
-	if strings.HasPrefix(_model, "gpt-3.5-turbo") {
-		tokensPerMessage = 4
-	} else if strings.HasPrefix(_model, "gpt-4") {
-		tokensPerMessage = 3
-	} else {
-		tokensPerMessage = 3
-	}
-
-	for _, message := range messages {
-		numTokens += tokensPerMessage
-		if len(message.MultipartContents) > 0 {
-			for _, content := range message.MultipartContents {
-				if content.Type == "image_url" {
-					// 智谱的 GLM 4V 模型，图片的 token 计算方式不同
-					if model == "glm-4v" {
-						numTokens += 1047
-					} else if strings.HasPrefix(model, "claude-") {
-						// Anthropic 的 claude 系列模型，图片的 token 计算方式不同，这里简单处理
-						// tokens = (width px * height px)/750
-						// https://docs.anthropic.com/claude/docs/vision#image-costs
-						numTokens += 1000
-					} else {
-						if content.ImageURL.Detail == "low" {
-							numTokens += 65
-						} else {
-							// TODO 【价格昂贵，尽量避免】这里可能为 high 或者 auto，简单起见，auto 按照 high 处理
-							// 简单起见，这里假设 high 时大图为 2048x2048，切割为 16 个小图
-							//
-							// high will enable “high res” mode, which first allows the _model to see the low res image
-							// and then creates detailed crops of input images as 512px squares based on the input image size.
-							// Each of the detailed crops uses twice the token budget (65 tokens) for a total of 129 tokens
-							numTokens += 129 * 16
-						}
-					}
-
-				} else {
-					numTokens += len(tkm.Encode(content.Text, nil, nil))
+	actual, _ := encodings.LoadOrStore(model, tkm)
+	return actual.(*tiktoken.Tiktoken), nil
+}
+
+// messageTokens 计算单条消息的 token 数量
+func messageTokens(tokenizer Tokenizer, model string, message Message) (int, error) {
+	texts := []string{message.Role}
+	numTokens := tokenizer.MessageOverhead(model)
+	if len(message.MultipartContents) > 0 {
+		for _, content := range message.MultipartContents {
+			if content.Type == "image_url" {
+				if content.ImageURL != nil {
+					numTokens += tokenizer.CountImage(model, content.ImageURL)
 				}
+			} else {
+				texts = append(texts, content.Text)
 			}
-		} else {
-			numTokens += len(tkm.Encode(message.Content, nil, nil))
 		}
-		numTokens += len(tkm.Encode(message.Role, nil, nil))
+	} else {
+		texts = append(texts, message.Content)
+	}
+
+	for _, call := range message.ToolCalls {
+		texts = append(texts, call.Function.Name, call.Function.Arguments)
+	}
+
+	for _, text := range texts {
+		count, err := tokenizer.CountText(model, text)
+		if err != nil {
+			return 0, err
+		}
+
+		numTokens += count
 	}
-	numTokens += 3
+
 	return numTokens, nil
 }
+
+// messageCacheKey 消息 token 数量的缓存 key，服务提供商和模型决定了使用的 Tokenizer，所以 key 中包含两者
+func messageCacheKey(model string, message Message) [sha256.Size]byte {
+	data, _ := json.Marshal(message)
+	return sha256.Sum256(append([]byte(model+"\x00"), data...))
+}
+
+// tokenCountCache 消息 token 数量缓存，缓存满时淘汰最早写入的消息
+type tokenCountCache struct {
+	lock   sync.RWMutex
+	size   int
+	counts map[[sha256.Size]byte]int
+	// keys 按照写入顺序记录缓存 key 的环形缓冲区
+	keys [][sha256.Size]byte
+	next int
+}
+
+func newTokenCountCache(size int) *tokenCountCache {
+	return &tokenCountCache{
+		size:   size,
+		counts: make(map[[sha256.Size]byte]int, size),
+		keys:   make([][sha256.Size]byte, 0, size),
+	}
+}
+
+func (c *tokenCountCache) get(key [sha256.Size]byte) (int, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	count, ok := c.counts[key]
+	return count, ok
+}
+
+func (c *tokenCountCache) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.counts = make(map[[sha256.Size]byte]int, c.size)
+	c.keys = c.keys[:0]
+	c.next = 0
+}
+
+func (c *tokenCountCache) set(key [sha256.Size]byte, count int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.counts[key]; ok {
+		return
+	}
+
+	if len(c.keys) < c.size {
+		c.keys = append(c.keys, key)
+	} else {
+		delete(c.counts, c.keys[c.next])
+		c.keys[c.next] = key
+		c.next = (c.next + 1) % c.size
+	}
+
+	c.counts[key] = count
+}
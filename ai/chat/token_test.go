@@ -0,0 +1,127 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// legacyReduceMessageContext 重构前的递归实现，每次递归都重新计算剩余全部消息的 token 数量，用于对比测试
+func legacyReduceMessageContext(messages Messages, model string, maxTokens int) (Messages, int, error) {
+	num, err := legacyMessageTokenCount(messages, model)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if num <= maxTokens {
+		if len(messages) > 1 && (messages[0].Role == "assistant" || messages[0].Role == "tool") {
+			return legacyReduceMessageContext(messages[1:], model, maxTokens)
+		}
+
+		return messages, num, nil
+	}
+
+	if len(messages) <= 1 {
+		return nil, 0, errors.New("对话上下文过长，无法继续生成")
+	}
+
+	return legacyReduceMessageContext(messages[1:], model, maxTokens)
+}
+
+// legacyEncoding 重构前的实现每次调用都重新加载编码器，对比测试中替换为已加载的编码器以缩短耗时，不影响计算结果
+var legacyEncoding = tiktoken.EncodingForModel
+
+// legacyMessageTokenCount 重构前的实现（只保留文本部分），每次调用都重新获取编码器并编码全部消息
+func legacyMessageTokenCount(messages Messages, model string) (int, error) {
+	_model := encodingModel(model)
+	tkm, err := legacyEncoding(_model)
+	if err != nil {
+		return 0, err
+	}
+
+	tokensPerMessage := 3
+	if strings.HasPrefix(_model, "gpt-3.5-turbo") {
+		tokensPerMessage = 4
+	}
+
+	numTokens := replyPrimingTokens
+	for _, message := range messages {
+		numTokens += tokensPerMessage
+		numTokens += len(tkm.Encode(message.Content, nil, nil))
+		numTokens += len(tkm.Encode(message.Role, nil, nil))
+		for _, call := range message.ToolCalls {
+			numTokens += len(tkm.Encode(call.Function.Name, nil, nil))
+			numTokens += len(tkm.Encode(call.Function.Arguments, nil, nil))
+		}
+	}
+
+	return numTokens, nil
+}
+
+// skipWithoutEncoding BPE 文件不可用（如无法访问网络）时跳过测试
+func skipWithoutEncoding(tb testing.TB) {
+	if _, err := encodingForModel(encodingModel("gpt-4")); err != nil {
+		tb.Skipf("encoding is not available: %v", err)
+	}
+}
+
+func longConversation(turns int) Messages {
+	messages := make(Messages, 0, turns*2+1)
+	for i := 0; i < turns; i++ {
+		messages = append(messages,
+			Message{Role: "user", Content: fmt.Sprintf("第 %d 个问题：%s", i, strings.Repeat("今天天气怎么样？", 20))},
+			Message{Role: "assistant", Content: fmt.Sprintf("第 %d 个回答：%s", i, strings.Repeat("今天天气晴朗，适合出门。", 30))},
+		)
+	}
+
+	return append(messages, Message{Role: "user", Content: "最后一个问题"})
+}
+
+func TestReduceMessageContext(t *testing.T) {
+	skipWithoutEncoding(t)
+
+	legacyEncoding = encodingForModel
+	defer func() { legacyEncoding = tiktoken.EncodingForModel }()
+
+	messages := longConversation(50)
+	messages = append(messages[:len(messages)-1],
+		Message{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Type: ToolTypeFunction, Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"北京"}`}}}},
+		Message{Role: "tool", Content: `{"weather":"晴"}`, ToolCallID: "call_1"},
+	)
+
+	for _, maxTokens := range []int{0, 10, 100, 1000, 5000, 100000} {
+		want, wantTokens, wantErr := legacyReduceMessageContext(messages, "gpt-4", maxTokens)
+		got, gotTokens, gotErr := ReduceMessageContext(messages, "gpt-4", maxTokens)
+
+		if (wantErr == nil) != (gotErr == nil) {
+			t.Fatalf("maxTokens %d: want error %v, got %v", maxTokens, wantErr, gotErr)
+		}
+
+		if len(got) != len(want) || gotTokens != wantTokens {
+			t.Errorf("maxTokens %d: want %d messages/%d tokens, got %d messages/%d tokens", maxTokens, len(want), wantTokens, len(got), gotTokens)
+		}
+	}
+}
+
+func BenchmarkReduceMessageContext(b *testing.B) {
+	skipWithoutEncoding(b)
+
+	for _, turns := range []int{20, 100} {
+		messages := longConversation(turns)
+
+		b.Run(fmt.Sprintf("legacy/turns=%d", turns), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _, _ = legacyReduceMessageContext(messages, "gpt-4", 2000)
+			}
+		})
+
+		b.Run(fmt.Sprintf("cached/turns=%d", turns), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _, _ = ReduceMessageContext(messages, "gpt-4", 2000)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package chat
+
+import (
+	"accompany-sdk/ai/catalog"
+	"accompany-sdk/pkg/misc"
+	"accompany-sdk/pkg/uploader"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Tokenizer 计算消息占用的 token 数量，不同服务提供商的计算方式不同，通过 RegisterTokenizer 按照服务提供商注册
+type Tokenizer interface {
+	// CountText 计算文本的 token 数量
+	CountText(model string, text string) (int, error)
+	// CountImage 计算图片的 token 数量
+	CountImage(model string, img *ImageURL) int
+	// MessageOverhead 每条消息除内容以外固定占用的 token 数量
+	MessageOverhead(model string) int
+}
+
+var (
+	tokenizerLock sync.RWMutex
+	// tokenizers 服务提供商 -> Tokenizer
+	tokenizers = map[string]Tokenizer{
+		ProviderOpenAI: TiktokenTokenizer{},
+		ProviderBaidu:  BaiduTokenizer{},
+	}
+	// tokenizerRouter 根据模型名称解析服务提供商，未设置时使用模型目录中模型所属的服务提供商
+	tokenizerRouter *Router
+)
+
+// RegisterTokenizer 注册服务提供商的 Tokenizer，已缓存的 token 数量会被清空
+func RegisterTokenizer(provider string, tokenizer Tokenizer) {
+	tokenizerLock.Lock()
+	defer tokenizerLock.Unlock()
+
+	tokenizers[provider] = tokenizer
+	tokenCache.reset()
+}
+
+// SetTokenizerRouter 设置解析模型所属服务提供商的 Router，带前缀或者按通配符路由的模型也能使用对应服务提供商的 Tokenizer
+func SetTokenizerRouter(router *Router) {
+	tokenizerLock.Lock()
+	defer tokenizerLock.Unlock()
+
+	tokenizerRouter = router
+	tokenCache.reset()
+}
+
+// TokenizerForModel 返回模型所属服务提供商的 Tokenizer，未注册的服务提供商使用 TiktokenTokenizer
+func TokenizerForModel(model string) Tokenizer {
+	_, tokenizer, _ := resolveTokenizer(model)
+	return tokenizer
+}
+
+// ResolveTokenizer 返回模型所属服务提供商的 Tokenizer 以及去掉前缀后的模型名称，计算 token 时需要使用去掉前缀后的模型名称
+func ResolveTokenizer(model string) (Tokenizer, string) {
+	_, tokenizer, realModel := resolveTokenizer(model)
+	return tokenizer, realModel
+}
+
+// resolveTokenizer 优先使用 Router 解析模型所属的服务提供商，其次使用模型目录，返回服务提供商、Tokenizer 以及去掉前缀后的模型名称
+func resolveTokenizer(model string) (string, Tokenizer, string) {
+	tokenizerLock.RLock()
+	router := tokenizerRouter
+	tokenizerLock.RUnlock()
+
+	provider, realModel := "", model
+	if router != nil {
+		if p, _, m, err := router.Resolve(model); err == nil {
+			provider, realModel = p, m
+		}
+	}
+
+	if provider == "" {
+		if m, ok := catalog.Lookup(model); ok {
+			provider = m.Provider
+		}
+	}
+
+	tokenizerLock.RLock()
+	defer tokenizerLock.RUnlock()
+
+	if tokenizer, ok := tokenizers[provider]; ok {
+		return provider, tokenizer, realModel
+	}
+
+	return provider, TiktokenTokenizer{}, realModel
+}
+
+// TiktokenTokenizer 使用 OpenAI tiktoken 计算 token 数量，图片按照 OpenAI 的规则根据图片尺寸计算
+type TiktokenTokenizer struct{}
+
+func (TiktokenTokenizer) CountText(model string, text string) (int, error) {
+	tkm, err := encodingForModel(encodingModel(model))
+	if err != nil {
+		return 0, fmt.Errorf("EncodingForModel: %v", err)
+	}
+
+	return len(tkm.Encode(text, nil, nil)), nil
+}
+
+func (TiktokenTokenizer) CountImage(_ string, img *ImageURL) int {
+	width, height, _ := ImageDimensions(img)
+	return OpenAIImageTokens(width, height, img.Detail)
+}
+
+func (TiktokenTokenizer) MessageOverhead(model string) int {
+	if strings.HasPrefix(encodingModel(model), "gpt-3.5-turbo") {
+		return 4
+	}
+
+	return 3
+}
+
+// BaiduTokenizer 百度文心千帆的 token 估算方式：汉字数 + 单词数 * 1.3
+// https://cloud.baidu.com/doc/WENXINWORKSHOP/s/Nlks5zkzu
+type BaiduTokenizer struct{}
+
+func (BaiduTokenizer) CountText(_ string, text string) (int, error) {
+	var hans, words int
+	inWord := false
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			hans++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if !inWord {
+				words++
+			}
+			inWord = true
+		default:
+			inWord = false
+		}
+	}
+
+	return hans + int(math.Ceil(float64(words)*1.3)), nil
+}
+
+func (BaiduTokenizer) CountImage(string, *ImageURL) int {
+	return 0
+}
+
+func (BaiduTokenizer) MessageOverhead(string) int {
+	return 0
+}
+
+const (
+	// imageInfoTimeout 查询远程图片尺寸的超时时间，超时后按照尺寸未知处理
+	imageInfoTimeout = 3 * time.Second
+	// imageInfoRetryInterval 查询失败的图片在这段时间内不会重新查询
+	imageInfoRetryInterval = 5 * time.Minute
+	// maxImageSizeCacheSize 最多缓存的远程图片尺寸数量
+	maxImageSizeCacheSize = 1024
+)
+
+// remoteImageSizes 远程图片的尺寸缓存，查询失败的图片在 imageInfoRetryInterval 内也会使用缓存，避免重复查询
+var remoteImageSizes = newImageSizeCache(maxImageSizeCacheSize)
+
+type imageSize struct {
+	width, height int
+	// failedAt 查询失败的时间，查询成功时为零值
+	failedAt time.Time
+}
+
+// ImageDimensions 获取图片的尺寸，base64 编码的图片在本地解析图片头部，远程图片通过图片服务查询
+func ImageDimensions(img *ImageURL) (width int, height int, ok bool) {
+	return ImageDimensionsContext(context.Background(), img)
+}
+
+// ImageDimensionsContext 和 ImageDimensions 相同，查询远程图片尺寸的请求受 ctx 控制，最多等待 imageInfoTimeout
+func ImageDimensionsContext(ctx context.Context, img *ImageURL) (width int, height int, ok bool) {
+	if img == nil || img.URL == "" {
+		return 0, 0, false
+	}
+
+	if !strings.HasPrefix(img.URL, "http://") && !strings.HasPrefix(img.URL, "https://") {
+		width, height, err := misc.Base64ImageSize(img.URL)
+		return width, height, err == nil
+	}
+
+	if size, ok := remoteImageSizes.get(img.URL); ok && (size.failedAt.IsZero() || time.Since(size.failedAt) < imageInfoRetryInterval) {
+		return size.width, size.height, size.width > 0
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, imageInfoTimeout)
+	defer cancel()
+
+	size := imageSize{failedAt: time.Now()}
+	if info, err := uploader.QueryImageInfoContext(ctx, img.URL); err == nil {
+		size = imageSize{width: int(info.Width), height: int(info.Height)}
+	}
+
+	remoteImageSizes.set(img.URL, size)
+	return size.width, size.height, size.width > 0
+}
+
+// imageSizeCache 远程图片的尺寸缓存，缓存满时淘汰最早写入的图片
+type imageSizeCache struct {
+	lock  sync.Mutex
+	size  int
+	sizes map[string]imageSize
+	// keys 按照写入顺序记录图片地址
+	keys []string
+}
+
+func newImageSizeCache(size int) *imageSizeCache {
+	return &imageSizeCache{size: size, sizes: make(map[string]imageSize, size)}
+}
+
+func (c *imageSizeCache) get(url string) (imageSize, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	size, ok := c.sizes[url]
+	return size, ok
+}
+
+func (c *imageSizeCache) set(url string, size imageSize) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.sizes[url]; !ok {
+		c.keys = append(c.keys, url)
+	}
+
+	c.sizes[url] = size
+	for len(c.keys) > c.size {
+		delete(c.sizes, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+}
+
+// AutoImageDetail 根据图片尺寸选择 detail，不超过一个 512x512 切片的小图或者尺寸未知的图片使用 low，否则使用 high
+func AutoImageDetail(width int, height int) string {
+	if width <= 0 || height <= 0 || max(width, height) <= 512 {
+		return "low"
+	}
+
+	return "high"
+}
+
+const (
+	// openAIImageBaseTokens 每张图片固定占用的 token 数量，detail 为 low 时只有这部分
+	openAIImageBaseTokens = 85
+	// openAIImageTileTokens detail 为 high 时每个 512x512 切片占用的 token 数量
+	openAIImageTileTokens = 170
+	// defaultImageSize 无法获取图片尺寸时假设的图片边长
+	defaultImageSize = 2048
+)
+
+// OpenAIImageTokens 按照 OpenAI 的规则计算图片的 token 数量，width/height 为 0 时按照 2048x2048 的大图处理
+// 图片先缩放到 2048x2048 以内，再把短边缩放到 768，然后按照 512x512 切片计算
+// https://platform.openai.com/docs/guides/vision/calculating-costs
+func OpenAIImageTokens(width int, height int, detail string) int {
+	if detail == "low" {
+		return openAIImageBaseTokens
+	}
+
+	// detail 为 auto 时由 OpenAI 根据图片尺寸选择，这里按照 high 估算
+	if width <= 0 || height <= 0 {
+		width, height = defaultImageSize, defaultImageSize
+	}
+
+	w, h := float64(width), float64(height)
+	if scale := math.Min(2048/w, 2048/h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+
+	if scale := 768 / math.Min(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+
+	tiles := int(math.Ceil(w/512) * math.Ceil(h/512))
+	return openAIImageBaseTokens + openAIImageTileTokens*tiles
+}
@@ -0,0 +1,171 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"accompany-sdk/ai/catalog"
+)
+
+func TestBaiduTokenizer_CountText(t *testing.T) {
+	cases := []struct {
+		text string
+		want int
+	}{
+		{text: "", want: 0},
+		{text: "你好世界", want: 4},
+		// 单词数 * 1.3 向上取整
+		{text: "hello world", want: 3},
+		{text: "今天 weather 很好，temperature 25 度", want: 5 + 4},
+		{text: "gpt-4o", want: 3},
+	}
+
+	for _, c := range cases {
+		if got, _ := (BaiduTokenizer{}).CountText("", c.text); got != c.want {
+			t.Errorf("%q: want %d, got %d", c.text, c.want, got)
+		}
+	}
+
+	if (BaiduTokenizer{}).MessageOverhead("") != 0 || (BaiduTokenizer{}).CountImage("", &ImageURL{URL: "https://example.com/a.png"}) != 0 {
+		t.Errorf("baidu tokenizer should not count overhead or images")
+	}
+}
+
+func TestResolveTokenizer(t *testing.T) {
+	catalog.Register(catalog.Model{ID: "tokenizer-test-model", Provider: ProviderBaidu})
+
+	router := NewRouter().
+		Register(ProviderOpenAI, &fakeChat{}, "openai:").
+		Register(ProviderBaidu, &fakeChat{}, "文心千帆:").
+		RegisterModels(ProviderBaidu, "ernie-*").
+		SetDefault(ProviderOpenAI)
+
+	SetTokenizerRouter(router)
+	defer SetTokenizerRouter(nil)
+
+	cases := []struct {
+		model string
+		baidu bool
+		real  string
+	}{
+		{model: "文心千帆:ERNIE-Bot", baidu: true, real: "ERNIE-Bot"},
+		{model: "ernie-speed-128k", baidu: true, real: "ernie-speed-128k"},
+		{model: "tokenizer-test-model", baidu: true, real: "tokenizer-test-model"},
+		{model: "openai:gpt-4o", real: "gpt-4o"},
+		{model: "unknown-model", real: "unknown-model"},
+	}
+
+	for _, c := range cases {
+		tokenizer, real := ResolveTokenizer(c.model)
+		if _, ok := tokenizer.(BaiduTokenizer); ok != c.baidu || real != c.real {
+			t.Errorf("%s: expect baidu %v/%s, got %T/%s", c.model, c.baidu, c.real, tokenizer, real)
+		}
+	}
+
+	// 带前缀的模型按照实际模型计算，缓存不会和其它服务提供商混用
+	prefixed, _ := MessageTokenCount(Messages{{Role: "user", Content: "你好世界"}}, "文心千帆:ERNIE-Bot")
+	// user 按一个单词计算为 2，内容 4 个汉字
+	if prefixed != 2+4+replyPrimingTokens {
+		t.Errorf("unexpected baidu token count %d", prefixed)
+	}
+}
+
+func TestOpenAIImageTokens(t *testing.T) {
+	cases := []struct {
+		width, height int
+		detail        string
+		want          int
+	}{
+		{width: 4096, height: 4096, detail: "low", want: 85},
+		{width: 512, height: 512, detail: "high", want: 255},
+		{width: 1024, height: 1024, detail: "high", want: 765},
+		// 先缩放到 2048x2048 以内，再把短边缩放到 768：768x1536，6 个切片
+		{width: 2048, height: 4096, detail: "high", want: 1105},
+		// 尺寸未知时按照 2048x2048 的大图处理
+		{detail: "auto", want: 765},
+	}
+
+	for _, c := range cases {
+		if got := OpenAIImageTokens(c.width, c.height, c.detail); got != c.want {
+			t.Errorf("%dx%d %s: want %d, got %d", c.width, c.height, c.detail, c.want, got)
+		}
+	}
+}
+
+func TestAutoImageDetail(t *testing.T) {
+	cases := []struct {
+		width, height int
+		want          string
+	}{
+		{want: "low"},
+		{width: 512, height: 300, want: "low"},
+		{width: 513, height: 10, want: "high"},
+		{width: 10, height: 2048, want: "high"},
+	}
+
+	for _, c := range cases {
+		if got := AutoImageDetail(c.width, c.height); got != c.want {
+			t.Errorf("%dx%d: want %s, got %s", c.width, c.height, c.want, got)
+		}
+	}
+}
+
+func TestImageDimensionsContext(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/ok.png":
+			_, _ = w.Write([]byte(`{"format":"png","width":640,"height":480}`))
+		case "/slow.png":
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	if width, height, ok := ImageDimensionsContext(context.Background(), &ImageURL{URL: server.URL + "/ok.png"}); !ok || width != 640 || height != 480 {
+		t.Errorf("unexpected size %dx%d %v", width, height, ok)
+	}
+
+	// 查询受 ctx 控制，不会一直等待响应
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, _, ok := ImageDimensionsContext(ctx, &ImageURL{URL: server.URL + "/slow.png"}); ok || time.Since(start) > time.Second {
+		t.Errorf("slow image host should time out, took %v", time.Since(start))
+	}
+
+	// 查询失败的图片在一段时间内使用缓存，过期后重新查询
+	missing := &ImageURL{URL: server.URL + "/missing.png"}
+	ImageDimensionsContext(context.Background(), missing)
+	ImageDimensionsContext(context.Background(), missing)
+	if requests.Load() != 3 {
+		t.Errorf("failed query should be cached, got %d requests", requests.Load())
+	}
+
+	size, _ := remoteImageSizes.get(missing.URL)
+	size.failedAt = time.Now().Add(-imageInfoRetryInterval)
+	remoteImageSizes.set(missing.URL, size)
+	ImageDimensionsContext(context.Background(), missing)
+	if requests.Load() != 4 {
+		t.Errorf("expired failure should be queried again, got %d requests", requests.Load())
+	}
+}
+
+func TestImageSizeCache(t *testing.T) {
+	cache := newImageSizeCache(2)
+	for _, url := range []string{"a", "b", "a", "c"} {
+		cache.set(url, imageSize{width: 1, height: 1})
+	}
+
+	if _, ok := cache.get("a"); ok || len(cache.sizes) != 2 {
+		t.Errorf("the earliest image should be evicted, got %v", cache.sizes)
+	}
+}
@@ -0,0 +1,105 @@
+package chat
+
+import (
+	"accompany-sdk/ai/catalog"
+	"errors"
+)
+
+// ErrToolNotSupported 请求中包含工具，但是模型不支持工具调用
+var ErrToolNotSupported = errors.New("模型不支持工具调用")
+
+const (
+	// ToolTypeFunction 目前只支持函数类型的工具
+	ToolTypeFunction = "function"
+
+	// ToolChoiceAuto 由模型决定是否调用工具
+	ToolChoiceAuto = "auto"
+	// ToolChoiceNone 不调用任何工具
+	ToolChoiceNone = "none"
+	// ToolChoiceRequired 必须调用至少一个工具
+	ToolChoiceRequired = "required"
+
+	// FinishReasonToolCalls 模型因为需要调用工具而停止输出
+	FinishReasonToolCalls = "tool_calls"
+)
+
+// Tool 模型可以调用的工具定义
+type Tool struct {
+	// Type 工具类型，目前只支持 function
+	Type     string              `json:"type"`
+	Function *FunctionDefinition `json:"function,omitempty"`
+}
+
+type FunctionDefinition struct {
+	// Name 函数名称
+	Name string `json:"name"`
+	// Description 函数描述，模型根据描述决定何时调用以及如何调用该函数
+	Description string `json:"description,omitempty"`
+	// Parameters 函数参数，JSON Schema 格式
+	Parameters any `json:"parameters,omitempty"`
+}
+
+// ToolCall 模型发起的工具调用
+type ToolCall struct {
+	// Index 流式响应中工具调用的序号，同一序号的多个增量需要合并为一个完整的工具调用
+	Index *int `json:"index,omitempty"`
+	// ID 工具调用 ID，工具执行结果消息中需要通过 tool_call_id 引用
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name string `json:"name,omitempty"`
+	// Arguments JSON 格式的函数参数，流式响应中为参数的增量片段
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// MergeToolCallDeltas 把流式响应中的工具调用增量合并到已有的工具调用中
+func MergeToolCallDeltas(calls []ToolCall, deltas []ToolCall) []ToolCall {
+	for i, delta := range deltas {
+		index := len(calls)
+		if delta.Index != nil {
+			index = *delta.Index
+		} else if delta.ID == "" && i == 0 && len(calls) > 0 {
+			// 没有序号和 ID 的增量属于最后一个工具调用
+			index = len(calls) - 1
+		}
+
+		for len(calls) <= index {
+			calls = append(calls, ToolCall{})
+		}
+
+		call := &calls[index]
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+
+		if delta.Type != "" {
+			call.Type = delta.Type
+		}
+
+		call.Function.Name += delta.Function.Name
+		call.Function.Arguments += delta.Function.Arguments
+	}
+
+	for i := range calls {
+		calls[i].Index = nil
+	}
+
+	return calls
+}
+
+// CheckToolSupport 请求中包含工具并且模型目录中的模型不支持工具调用时返回 ErrToolNotSupported，
+// 模型目录中没有的模型不检查，由服务提供商返回错误
+func CheckToolSupport(req Request) error {
+	if len(req.Tools) == 0 {
+		return nil
+	}
+
+	if m, ok := catalog.Lookup(req.Model); ok && !m.Tools {
+		return ErrToolNotSupported
+	}
+
+	return nil
+}
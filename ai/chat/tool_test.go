@@ -0,0 +1,54 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+)
+
+func toolRequest(model string) Request {
+	return Request{
+		Model:    model,
+		Messages: Messages{{Role: "user", Content: "今天天气怎么样"}},
+		Tools:    []Tool{{Type: ToolTypeFunction, Function: &FunctionDefinition{Name: "get_weather"}}},
+	}
+}
+
+func TestCheckToolSupport(t *testing.T) {
+	cases := []struct {
+		name   string
+		req    Request
+		expect error
+	}{
+		{name: "no tools", req: Request{Model: "gpt-4-vision-preview"}},
+		{name: "supported", req: toolRequest("gpt-4")},
+		{name: "not supported", req: toolRequest("gpt-4-vision-preview"), expect: ErrToolNotSupported},
+		{name: "ernie bot", req: toolRequest("model_ernie_bot")},
+		{name: "ernie speed", req: toolRequest("model_ernie_speed_8k"), expect: ErrToolNotSupported},
+		// 模型目录中没有的模型由服务提供商检查
+		{name: "unknown model", req: toolRequest("unknown-model")},
+	}
+
+	for _, c := range cases {
+		if err := CheckToolSupport(c.req); !errors.Is(err, c.expect) {
+			t.Errorf("%s: expect %v, got %v", c.name, c.expect, err)
+		}
+	}
+}
+
+func TestInitRequest_ToolSupport(t *testing.T) {
+	oai := &OpenAIChat{}
+	if _, err := oai.initRequest(toolRequest("openai:gpt-4-vision-preview")); !errors.Is(err, ErrToolNotSupported) {
+		t.Errorf("expect ErrToolNotSupported, got %v", err)
+	}
+	if req, err := oai.initRequest(toolRequest("openai:gpt-4")); err != nil || len(req.Tools) != 1 {
+		t.Errorf("expect tools passed through, got %+v %v", req, err)
+	}
+
+	bai := &BaiduAIChat{}
+	if _, err := bai.initRequest(toolRequest("model_ernie_speed_8k")); !errors.Is(err, ErrToolNotSupported) {
+		t.Errorf("expect ErrToolNotSupported, got %v", err)
+	}
+	if req, err := bai.initRequest(toolRequest("model_ernie_bot_4")); err != nil || len(req.Functions) != 1 {
+		t.Errorf("expect functions passed through, got %+v %v", req, err)
+	}
+}
@@ -0,0 +1,145 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+var ErrUnsupportedType = errors.New("document: unsupported file type")
+
+// Extract 根据文件扩展名从文件内容中提取文本，支持 txt/markdown/csv/pdf/docx
+func Extract(name string, data []byte) (string, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".txt", ".md", ".markdown":
+		return string(data), nil
+	case ".csv":
+		return extractCSV(data)
+	case ".pdf":
+		return extractPDF(data)
+	case ".docx":
+		return extractDOCX(data)
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedType, name)
+}
+
+// Supported 是否支持从该文件中提取文本
+func Supported(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".txt", ".md", ".markdown", ".csv", ".pdf", ".docx":
+		return true
+	}
+
+	return false
+}
+
+// extractCSV 每行数据转换为一行以 " | " 分隔的文本
+func extractCSV(data []byte) (string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var lines []string
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("parse csv: %v", err)
+		}
+
+		lines = append(lines, strings.Join(record, " | "))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func extractPDF(data []byte) (text string, err error) {
+	// pdf 库解析格式异常的文件时可能会 panic
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parse pdf: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("parse pdf: %v", err)
+	}
+
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("parse pdf: %v", err)
+	}
+
+	content, err := io.ReadAll(plain)
+	if err != nil {
+		return "", fmt.Errorf("parse pdf: %v", err)
+	}
+
+	return string(content), nil
+}
+
+// extractDOCX 读取 word/document.xml 中的文本，每个段落（w:p）为一行
+func extractDOCX(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("parse docx: %v", err)
+	}
+
+	file, err := archive.Open("word/document.xml")
+	if err != nil {
+		return "", fmt.Errorf("parse docx: %v", err)
+	}
+	defer file.Close()
+
+	var text strings.Builder
+	inText := false
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("parse docx: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteString("\t")
+			case "br":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+
+	return text.String(), nil
+}
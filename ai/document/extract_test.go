@@ -0,0 +1,107 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// minimalPDF 生成只有一页、一行文本的 PDF 文件
+func minimalPDF(text string) []byte {
+	return buildPDF(pdfObjects(fmt.Sprintf("BT /F1 12 Tf 72 712 Td (%s) Tj ET", text)))
+}
+
+// pdfObjects 只有一页的 PDF 文件的全部对象，content 为页面内容
+func pdfObjects(content string) []string {
+	return []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+}
+
+// malformedPDF 页面列表对象的数组没有结束，pdf 库解析时会 panic
+func malformedPDF() []byte {
+	objects := pdfObjects("BT /F1 12 Tf (Hi) Tj ET")
+	objects[1] = "<< /Type /Pages /Kids [3 0 R /Count 1 >>"
+	return buildPDF(objects)
+}
+
+// buildPDF 根据对象生成 PDF 文件，对象编号从 1 开始
+func buildPDF(objects []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// minimalDOCX 生成只包含 word/document.xml 的 docx 文件
+func minimalDOCX(t *testing.T, body string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	file, err := archive.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = file.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` + body + `</w:body></w:document>`))
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "text", file: "a.TXT", data: []byte("你好"), want: "你好"},
+		{name: "csv", file: "a.csv", data: []byte("name,age\n\"张三\",18\n李四,\"2\"0\n"), want: "name | age\n张三 | 18\n李四 | 2\"0"},
+		{name: "pdf", file: "a.pdf", data: minimalPDF("Hello PDF"), want: "Hello PDF"},
+		{name: "truncated pdf", file: "a.pdf", data: minimalPDF("Hello")[:120], wantErr: true},
+		// pdf 库解析格式异常的对象时会 panic，需要转换为错误
+		{name: "malformed pdf", file: "a.pdf", data: malformedPDF(), wantErr: true},
+		{name: "docx", file: "a.docx", data: minimalDOCX(t, `<w:p><w:r><w:t>第一段</w:t><w:tab/><w:t>表格</w:t></w:r></w:p><w:p><w:r><w:t>第二段</w:t><w:br/><w:t>换行</w:t></w:r></w:p>`), want: "第一段\t表格\n第二段\n换行\n"},
+		{name: "invalid docx", file: "a.docx", data: []byte("not a zip"), wantErr: true},
+		{name: "unsupported", file: "a.xlsx", data: []byte("x"), wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Extract(c.file, c.data)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("want error %v, got %v", c.wantErr, err)
+			}
+
+			if !c.wantErr && strings.TrimSpace(got) != strings.TrimSpace(c.want) {
+				t.Errorf("want %q, got %q", c.want, got)
+			}
+		})
+	}
+
+	if _, err := Extract("a.xlsx", nil); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expect ErrUnsupportedType, got %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+// Package document 把用户上传的文件转换为对话上下文：下载文件、提取文本、按照 token 数量切分，
+// 然后选择和用户问题最相关的片段注入到对话中
+package document
+
+import (
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/ternary"
+	"accompany-sdk/pkg/uploader"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openimsdk/tools/log"
+)
+
+const (
+	// DefaultChunkTokens 默认每个片段的最大 token 数量
+	DefaultChunkTokens = 500
+	// DefaultCacheSize 默认最多缓存的文档数量
+	DefaultCacheSize = 32
+	// MaxFileSize 最大支持的文件大小
+	MaxFileSize = 20 * 1024 * 1024
+	// DownloadTimeout 下载文件的超时时间
+	DownloadTimeout = 60 * time.Second
+)
+
+// ErrFileTooLarge 文件超过 MaxFileSize
+var ErrFileTooLarge = uploader.ErrFileTooLarge
+
+// Pipeline 文档处理流水线，切分后的文档按照文件地址缓存
+type Pipeline struct {
+	chunkTokens int
+	client      *http.Client
+
+	lock  sync.Mutex
+	size  int
+	cache map[string][]Chunk
+	// keys 按照写入顺序记录缓存 key，缓存满时淘汰最早写入的文档
+	keys []string
+}
+
+// NewPipeline 创建文档处理流水线，client 用于下载文件，为 nil 时使用 http.DefaultClient
+func NewPipeline(chunkTokens int, cacheSize int, client *http.Client) *Pipeline {
+	if chunkTokens <= 0 {
+		chunkTokens = DefaultChunkTokens
+	}
+
+	if cacheSize <= 0 {
+		cacheSize = DefaultCacheSize
+	}
+
+	return &Pipeline{
+		chunkTokens: chunkTokens,
+		client:      client,
+		size:        cacheSize,
+		cache:       make(map[string][]Chunk),
+	}
+}
+
+// HasFiles 对话中是否包含上传的文件
+func HasFiles(messages chat.Messages) bool {
+	for _, msg := range messages {
+		if msg.UploadedFile() != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Load 下载文件并切分为片段
+func (p *Pipeline) Load(ctx context.Context, file *chat.FileURL, model string) ([]Chunk, error) {
+	key := model + "|" + file.URL
+	if chunks, ok := p.cached(key); ok {
+		return chunks, nil
+	}
+
+	text, err := Fetch(ctx, p.client, file)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := Split(text, model, p.chunkTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	p.store(key, chunks)
+	return chunks, nil
+}
+
+// Fetch 使用 client 下载文件并提取文件中的文本，client 为 nil 时使用 http.DefaultClient
+// 下载最多等待 DownloadTimeout，文件超过 MaxFileSize 时返回 ErrFileTooLarge
+func Fetch(ctx context.Context, client *http.Client, file *chat.FileURL) (string, error) {
+	name := FileName(file)
+	if !Supported(name) {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedType, name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DownloadTimeout)
+	defer cancel()
+
+	data, err := uploader.DownloadRemoteFileLimited(ctx, client, file.URL, MaxFileSize)
+	if err != nil {
+		return "", fmt.Errorf("download file: %w", err)
+	}
+
+	return Extract(name, data)
+}
+
+// Expand 把对话中的文件替换为文件中和用户问题最相关的文本，所有文件的文本总 token 数量不超过 budget
+// 越新的消息中的文件越优先，返回新的消息列表，不修改原消息
+func (p *Pipeline) Expand(ctx context.Context, messages chat.Messages, model string, budget int) chat.Messages {
+	return p.expand(ctx, messages, model, budget, p.Load)
+}
+
+// ExpandCached 和 Expand 相同，但是只使用已经缓存的文件内容，不会下载文件，用于预估请求的 token 数量
+// 没有缓存的文件替换为占位文本，不计入文件内容的 token 数量
+func (p *Pipeline) ExpandCached(ctx context.Context, messages chat.Messages, model string, budget int) chat.Messages {
+	return p.expand(ctx, messages, model, budget, func(_ context.Context, file *chat.FileURL, model string) ([]Chunk, error) {
+		if chunks, ok := p.cached(model + "|" + file.URL); ok {
+			return chunks, nil
+		}
+
+		return nil, errNotCached
+	})
+}
+
+// loader 加载文件并切分为片段
+type loader func(ctx context.Context, file *chat.FileURL, model string) ([]Chunk, error)
+
+// errNotCached 预估时文件还没有被下载
+var errNotCached = errors.New("document is not cached")
+
+func (p *Pipeline) expand(ctx context.Context, messages chat.Messages, model string, budget int, load loader) chat.Messages {
+	query := messages.LastUserText()
+	result := make(chat.Messages, len(messages))
+	copy(result, messages)
+
+	for i := len(result) - 1; i >= 0; i-- {
+		msg := result[i]
+		if msg.UploadedFile() == nil {
+			continue
+		}
+
+		parts := make([]*chat.MultipartContent, 0, len(msg.MultipartContents))
+		for _, part := range msg.MultipartContents {
+			if part.Type != "file" || part.FileURL == nil || part.FileURL.URL == "" {
+				parts = append(parts, part)
+				continue
+			}
+
+			var text string
+			text, budget = fileText(ctx, load, part.FileURL, model, query, budget)
+			parts = append(parts, &chat.MultipartContent{Type: "text", Text: text})
+		}
+
+		result[i] = collapse(msg, parts)
+	}
+
+	return result
+}
+
+// fileText 生成文件对应的文本内容，返回剩余的 token 预算
+func fileText(ctx context.Context, load loader, file *chat.FileURL, model string, query string, budget int) (string, int) {
+	name := FileName(file)
+	chunks, err := load(ctx, file, model)
+	if errors.Is(err, errNotCached) {
+		return fmt.Sprintf("[文件《%s》]", name), budget
+	}
+
+	if err != nil {
+		log.ZWarn(ctx, "load document failed", err, "url", file.URL, "name", name)
+		return fmt.Sprintf("[文件《%s》读取失败]", name), budget
+	}
+
+	selected := Select(chunks, query, budget)
+	if len(selected) == 0 {
+		return fmt.Sprintf("[文件《%s》内容过长，已省略]", name), budget
+	}
+
+	texts := make([]string, 0, len(selected))
+	for i, chunk := range selected {
+		// 不连续的片段之间使用省略号分隔
+		if i > 0 && chunk.Index != selected[i-1].Index+1 {
+			texts = append(texts, "……")
+		}
+
+		texts = append(texts, chunk.Text)
+		budget -= chunk.Tokens
+	}
+
+	title := ternary.If(len(selected) == len(chunks), "以下是文件《%s》的内容：", "以下是文件《%s》中的相关内容：")
+	return fmt.Sprintf(title, name) + "\n" + strings.Join(texts, "\n"), budget
+}
+
+// collapse 替换消息的 multipart 内容，只剩下文本内容时合并到 Content 中，兼容不支持 multipart 的服务提供商
+func collapse(msg chat.Message, parts []*chat.MultipartContent) chat.Message {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != "text" {
+			msg.MultipartContents = parts
+			return msg
+		}
+
+		texts = append(texts, part.Text)
+	}
+
+	if msg.Content != "" {
+		texts = append(texts, msg.Content)
+	}
+
+	msg.Content = strings.Join(texts, "\n\n")
+	msg.MultipartContents = nil
+	return msg
+}
+
+// FileName 文件名称，未指定名称时使用文件地址中的文件名
+func FileName(file *chat.FileURL) string {
+	if file.Name != "" {
+		return file.Name
+	}
+
+	return path.Base(strings.SplitN(file.URL, "?", 2)[0])
+}
+
+func (p *Pipeline) cached(key string) ([]Chunk, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	chunks, ok := p.cache[key]
+	return chunks, ok
+}
+
+func (p *Pipeline) store(key string, chunks []Chunk) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.cache[key]; !ok {
+		p.keys = append(p.keys, key)
+	}
+
+	p.cache[key] = chunks
+	for len(p.keys) > p.size {
+		delete(p.cache, p.keys[0])
+		p.keys = p.keys[1:]
+	}
+}
@@ -0,0 +1,93 @@
+package document
+
+import (
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/uploader"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a.csv":
+			_, _ = w.Write([]byte("name,age\n张三,18\n"))
+		case "/large.txt":
+			w.Header().Set("Content-Length", "30000000")
+		case "/stream.txt":
+			// 没有 Content-Length 的响应读取到 MaxFileSize 后停止
+			chunk := []byte(strings.Repeat("a", 1024*1024))
+			for i := 0; i <= MaxFileSize/len(chunk); i++ {
+				if _, err := w.Write(chunk); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+			}
+		case "/forbidden.txt":
+			w.WriteHeader(http.StatusForbidden)
+		case "/slow.txt":
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	text, err := Fetch(context.Background(), nil, &chat.FileURL{URL: server.URL + "/a.csv"})
+	if err != nil || text != "name | age\n张三 | 18" {
+		t.Errorf("unexpected text %q %v", text, err)
+	}
+
+	for _, name := range []string{"large.txt", "stream.txt"} {
+		if _, err := Fetch(context.Background(), nil, &chat.FileURL{URL: server.URL + "/" + name}); !errors.Is(err, ErrFileTooLarge) {
+			t.Errorf("%s: expect ErrFileTooLarge, got %v", name, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := Fetch(ctx, nil, &chat.FileURL{URL: server.URL + "/slow.txt"}); err == nil || time.Since(start) > time.Second {
+		t.Errorf("download should stop when ctx is done, got %v after %v", err, time.Since(start))
+	}
+
+	if _, err := Fetch(context.Background(), nil, &chat.FileURL{URL: server.URL + "/forbidden.txt"}); !errors.Is(err, uploader.ErrFileForbidden) {
+		t.Errorf("expect ErrFileForbidden, got %v", err)
+	}
+
+	if _, err := Fetch(context.Background(), nil, &chat.FileURL{URL: server.URL + "/missing.txt"}); err == nil {
+		t.Errorf("expect error for missing file")
+	}
+
+	if _, err := Fetch(context.Background(), nil, &chat.FileURL{URL: server.URL + "/a.xlsx"}); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expect ErrUnsupportedType, got %v", err)
+	}
+}
+
+func TestFetch_Proxy(t *testing.T) {
+	var requested []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.String())
+		_, _ = w.Write([]byte("通过代理下载"))
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	text, err := Fetch(context.Background(), client, &chat.FileURL{URL: "http://files.example.com/a.txt"})
+	if err != nil || text != "通过代理下载" {
+		t.Fatalf("unexpected text %q %v", text, err)
+	}
+
+	if len(requested) != 1 || requested[0] != "http://files.example.com/a.txt" {
+		t.Errorf("expect the download sent through the proxy, got %v", requested)
+	}
+}
@@ -0,0 +1,157 @@
+package document
+
+import (
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/misc"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Chunk 文档按照 token 数量切分后的片段
+type Chunk struct {
+	// Index 片段在文档中的序号
+	Index  int    `json:"index"`
+	Text   string `json:"text"`
+	Tokens int    `json:"tokens"`
+}
+
+// Split 按照段落把文本切分为不超过 maxTokens 的片段，超长的段落使用 misc.TextSplit 按照字符数继续切分
+func Split(text string, model string, maxTokens int) ([]Chunk, error) {
+	tokenizer, model := chat.ResolveTokenizer(model)
+	count := func(s string) (int, error) { return tokenizer.CountText(model, s) }
+
+	var chunks []Chunk
+	var current []string
+	currentTokens := 0
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, Chunk{Index: len(chunks), Text: strings.Join(current, "\n"), Tokens: currentTokens})
+			current, currentTokens = nil, 0
+		}
+	}
+
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+
+		tokens, err := count(paragraph)
+		if err != nil {
+			return nil, err
+		}
+
+		pieces, counts := []string{paragraph}, []int{tokens}
+		if tokens > maxTokens {
+			if pieces, counts, err = splitLong(paragraph, tokens, maxTokens, count); err != nil {
+				return nil, err
+			}
+		}
+
+		for i, piece := range pieces {
+			if currentTokens+counts[i] > maxTokens {
+				flush()
+			}
+
+			current = append(current, piece)
+			currentTokens += counts[i]
+		}
+	}
+
+	flush()
+	return chunks, nil
+}
+
+// splitLong 把超长的段落按照字符数切分，每个片段的字符数按照 token 和字符数的比例估算，估算偏大时逐步缩小
+func splitLong(paragraph string, tokens int, maxTokens int, count func(string) (int, error)) ([]string, []int, error) {
+	size := max(utf8.RuneCountInString(paragraph)*maxTokens/tokens, 1)
+	for {
+		pieces := misc.TextSplit(paragraph, size)
+		counts := make([]int, len(pieces))
+		fit := true
+		for i, piece := range pieces {
+			n, err := count(piece)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			counts[i] = n
+			fit = fit && n <= maxTokens
+		}
+
+		if fit || size == 1 {
+			return pieces, counts, nil
+		}
+
+		size = max(size*9/10, 1)
+	}
+}
+
+// Select 从 chunks 中选择和 query 最相关的片段，总 token 数量不超过 budget，返回的片段按照在文档中的顺序排列
+// query 为空时按照文档顺序选择
+func Select(chunks []Chunk, query string, budget int) []Chunk {
+	queryTerms := terms(query)
+	scores := make([]int, len(chunks))
+	for i, chunk := range chunks {
+		for term := range terms(chunk.Text) {
+			if _, ok := queryTerms[term]; ok {
+				scores[i]++
+			}
+		}
+	}
+
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+
+	var selected []Chunk
+	for _, i := range order {
+		if chunks[i].Tokens <= budget {
+			selected = append(selected, chunks[i])
+			budget -= chunks[i].Tokens
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Index < selected[j].Index })
+	return selected
+}
+
+// terms 提取文本中的检索词：中文按照相邻两个字切分，其它语言按照单词切分
+func terms(text string) map[string]struct{} {
+	result := make(map[string]struct{})
+
+	var prev rune
+	var word strings.Builder
+	flushWord := func() {
+		if word.Len() > 1 {
+			result[strings.ToLower(word.String())] = struct{}{}
+		}
+		word.Reset()
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			if prev != 0 {
+				result[string([]rune{prev, r})] = struct{}{}
+			}
+			prev = r
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(r)
+		default:
+			flushWord()
+		}
+
+		prev = 0
+	}
+
+	flushWord()
+	return result
+}
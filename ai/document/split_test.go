@@ -0,0 +1,82 @@
+package document
+
+import (
+	"strings"
+	"testing"
+)
+
+// splitModel 使用百度的 Tokenizer，每个汉字一个 token，方便构造测试数据
+const splitModel = "model_ernie_bot"
+
+func TestSplit(t *testing.T) {
+	long := strings.Repeat("长", 25)
+	cases := []struct {
+		name   string
+		text   string
+		max    int
+		chunks []string
+	}{
+		{name: "empty", text: "\n \r\n", max: 10},
+		{name: "merge paragraphs", text: "第一段内容\r\n\r\n第二段\n", max: 10, chunks: []string{"第一段内容\n第二段"}},
+		{name: "flush when full", text: "第一段内容\n第二段内容\n第三段", max: 10, chunks: []string{"第一段内容\n第二段内容", "第三段"}},
+		// 超长的段落按照字符数继续切分
+		{name: "long paragraph", text: "开头\n" + long, max: 10, chunks: []string{"开头", strings.Repeat("长", 10), strings.Repeat("长", 10), strings.Repeat("长", 5)}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chunks, err := Split(c.text, splitModel, c.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(chunks) != len(c.chunks) {
+				t.Fatalf("want %d chunks, got %+v", len(c.chunks), chunks)
+			}
+
+			for i, chunk := range chunks {
+				if chunk.Index != i || chunk.Text != c.chunks[i] || chunk.Tokens > c.max || chunk.Tokens != len([]rune(strings.ReplaceAll(chunk.Text, "\n", ""))) {
+					t.Errorf("chunk %d: want %q, got %+v", i, c.chunks[i], chunk)
+				}
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	chunks := []Chunk{
+		{Index: 0, Text: "公司简介", Tokens: 4},
+		{Index: 1, Text: "年假规定：员工每年有十天年假", Tokens: 12},
+		{Index: 2, Text: "报销流程", Tokens: 4},
+		{Index: 3, Text: "病假和年假不能合并使用", Tokens: 10},
+	}
+
+	cases := []struct {
+		name   string
+		query  string
+		budget int
+		want   []int
+	}{
+		{name: "all chunks fit", query: "年假", budget: 100, want: []int{0, 1, 2, 3}},
+		// 相关的片段优先，结果按照文档顺序排列
+		{name: "relevant first", query: "年假有几天？", budget: 22, want: []int{1, 3}},
+		{name: "skip chunks over budget", query: "年假", budget: 11, want: []int{3}},
+		{name: "empty query keeps document order", query: "", budget: 8, want: []int{0, 2}},
+		{name: "no budget", query: "年假", budget: 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			selected := Select(chunks, c.query, c.budget)
+			if len(selected) != len(c.want) {
+				t.Fatalf("want %v, got %+v", c.want, selected)
+			}
+
+			for i, chunk := range selected {
+				if chunk.Index != c.want[i] {
+					t.Errorf("want %v, got %+v", c.want, selected)
+				}
+			}
+		})
+	}
+}
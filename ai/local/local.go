@@ -0,0 +1,191 @@
+package local
+
+import (
+	"accompany-sdk/ai/chat"
+	openai2 "accompany-sdk/ai/openai"
+	"accompany-sdk/ai_struct"
+	"accompany-sdk/pkg/misc"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// defaultContextLength 服务未返回模型上下文长度且没有配置默认值时使用
+const defaultContextLength = 4096
+
+// Model 本地服务提供的模型信息
+type Model struct {
+	ID string `json:"id"`
+	// ContextLength 模型的上下文窗口大小（输入 + 输出），来自模型发现或者默认配置
+	ContextLength int `json:"context_length"`
+}
+
+// Provider 兼容 OpenAI 接口的本地/私有化部署模型服务，如 Ollama、vLLM、LM Studio 等
+//
+// 登录时通过 Discover 调用 {server}/models 获取模型列表以及上下文窗口大小并缓存，
+// 对话请求复用 chat.OpenAIChat 的实现，只重写了 MaxContextLength
+type Provider struct {
+	*chat.OpenAIChat
+
+	name          string
+	server        string
+	key           string
+	httpClient    *http.Client
+	defaultLength int
+
+	lock   sync.RWMutex
+	models map[string]Model
+}
+
+// New 创建本地模型服务，httpClient 为空时使用默认的 HTTP 客户端
+func New(conf ai_struct.LocalProvider, httpClient *http.Client) *Provider {
+	if httpClient == nil {
+		httpClient = misc.StreamHTTPClient(nil)
+	}
+
+	server := strings.TrimSuffix(conf.Server, "/")
+
+	openaiConf := openai.DefaultConfig(conf.Key)
+	openaiConf.BaseURL = server
+	openaiConf.HTTPClient = httpClient
+
+	p := &Provider{
+		OpenAIChat: chat.NewOpenAIChat(openai2.New(
+			&openai2.Config{Enable: true, OpenAIServers: []string{server}, OpenAIKeys: []string{conf.Key}, AutoProxy: conf.AutoProxy},
+			[]*openai.Client{openai.NewClientWithConfig(openaiConf)},
+		)),
+		name:          conf.Name,
+		server:        server,
+		key:           conf.Key,
+		httpClient:    httpClient,
+		defaultLength: conf.DefaultContextLength,
+		models:        make(map[string]Model),
+	}
+
+	if p.defaultLength <= 0 {
+		p.defaultLength = defaultContextLength
+	}
+
+	for _, id := range conf.Models {
+		p.models[id] = Model{ID: id, ContextLength: p.defaultLength}
+	}
+
+	return p
+}
+
+// Name 服务提供商名称
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// Prefix 服务提供商的模型前缀
+func (p *Provider) Prefix() string {
+	return p.name + ":"
+}
+
+// modelsResponse {server}/models 接口的响应，兼容不同服务返回的上下文长度字段
+type modelsResponse struct {
+	Data []struct {
+		ID string `json:"id"`
+		// MaxModelLen vLLM 返回的上下文长度
+		MaxModelLen int `json:"max_model_len,omitempty"`
+		// ContextLength LM Studio、OpenRouter 等返回的上下文长度
+		ContextLength int `json:"context_length,omitempty"`
+		// MaxContextLength LM Studio 返回的最大上下文长度
+		MaxContextLength int `json:"max_context_length,omitempty"`
+	} `json:"data"`
+}
+
+// Discover 调用 {server}/models 获取服务提供的模型列表并缓存，静态配置的模型会被保留
+func (p *Provider) Discover(ctx context.Context) ([]Model, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.server+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.key != "" {
+		req.Header.Set("Authorization", "Bearer "+p.key)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("local: list models failed, status code: %d", resp.StatusCode)
+	}
+
+	var ret modelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return nil, fmt.Errorf("local: decode models response failed: %w", err)
+	}
+
+	p.lock.Lock()
+	for _, item := range ret.Data {
+		if item.ID == "" {
+			continue
+		}
+
+		length := p.defaultLength
+		for _, l := range []int{item.MaxModelLen, item.ContextLength, item.MaxContextLength} {
+			if l > 0 {
+				length = l
+				break
+			}
+		}
+
+		p.models[item.ID] = Model{ID: item.ID, ContextLength: length}
+	}
+	p.lock.Unlock()
+
+	return p.Models(), nil
+}
+
+// Models 返回缓存的模型列表，按模型名称排序
+func (p *Provider) Models() []Model {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	models := make([]Model, 0, len(p.models))
+	for _, m := range p.models {
+		models = append(models, m)
+	}
+
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models
+}
+
+// ModelIDs 返回缓存的模型名称列表
+func (p *Provider) ModelIDs() []string {
+	models := p.Models()
+	ids := make([]string, 0, len(models))
+	for _, m := range models {
+		ids = append(ids, m.ID)
+	}
+
+	return ids
+}
+
+// MaxContextLength 获取模型的最大上下文长度，会为模型输出预留部分 token（最多 4096）
+func (p *Provider) MaxContextLength(model string) int {
+	model = strings.TrimPrefix(model, p.Prefix())
+
+	p.lock.RLock()
+	m, ok := p.models[model]
+	p.lock.RUnlock()
+
+	length := p.defaultLength
+	if ok {
+		length = m.ContextLength
+	}
+
+	return length - min(length/4, 4096)
+}
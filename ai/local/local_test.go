@@ -0,0 +1,140 @@
+package local
+
+import (
+	"accompany-sdk/ai_struct"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newModelsServer(t *testing.T, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestProvider_Discover(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		model  string
+		length int
+	}{
+		{
+			// Ollama 不返回上下文长度，使用默认值
+			name:   "ollama",
+			body:   `{"object":"list","data":[{"id":"qwen2:7b","object":"model","created":1718000000,"owned_by":"library"}]}`,
+			model:  "qwen2:7b",
+			length: 8192,
+		},
+		{
+			name:   "vllm",
+			body:   `{"object":"list","data":[{"id":"Qwen/Qwen2-7B-Instruct","object":"model","owned_by":"vllm","max_model_len":32768}]}`,
+			model:  "Qwen/Qwen2-7B-Instruct",
+			length: 32768,
+		},
+		{
+			name:   "lm studio",
+			body:   `{"object":"list","data":[{"id":"llama-3-8b-instruct","object":"model","type":"llm","max_context_length":16384}]}`,
+			model:  "llama-3-8b-instruct",
+			length: 16384,
+		},
+		{
+			name:   "context_length",
+			body:   `{"data":[{"id":"mistral-7b","context_length":4096}]}`,
+			model:  "mistral-7b",
+			length: 4096,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := newModelsServer(t, c.body)
+			p := New(ai_struct.LocalProvider{Name: "local", Server: server.URL + "/v1/", Key: "test-key", Models: []string{"static-model"}, DefaultContextLength: 8192}, nil)
+
+			models, err := p.Discover(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 静态配置的模型被保留，按名称排序
+			if len(models) != 2 {
+				t.Fatalf("expect discovered and static models, got %+v", models)
+			}
+
+			for _, m := range models {
+				if m.ID == c.model && m.ContextLength != c.length {
+					t.Errorf("expect context length %d, got %d", c.length, m.ContextLength)
+				}
+			}
+
+			if ids := p.ModelIDs(); ids[0] > ids[1] {
+				t.Errorf("models should be sorted, got %v", ids)
+			}
+		})
+	}
+}
+
+func TestProvider_DiscoverFailed(t *testing.T) {
+	server := newModelsServer(t, `{"data":[]}`)
+
+	// 服务不可达
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	cases := []ai_struct.LocalProvider{
+		{Name: "local", Server: unreachable.URL + "/v1", Models: []string{"static-model"}},
+		// 服务返回错误状态码
+		{Name: "local", Server: server.URL + "/v1", Key: "wrong-key", Models: []string{"static-model"}},
+		{Name: "local", Server: server.URL + "/api", Key: "test-key", Models: []string{"static-model"}},
+	}
+
+	for _, conf := range cases {
+		p := New(conf, nil)
+		if _, err := p.Discover(context.Background()); err == nil {
+			t.Errorf("%s: expect an error", conf.Server)
+		}
+
+		// 发现失败时只使用静态配置的模型
+		if ids := p.ModelIDs(); len(ids) != 1 || ids[0] != "static-model" {
+			t.Errorf("%s: expect only static models, got %v", conf.Server, ids)
+		}
+	}
+}
+
+func TestProvider_MaxContextLength(t *testing.T) {
+	server := newModelsServer(t, `{"data":[{"id":"small","max_model_len":2048},{"id":"large","max_model_len":131072}]}`)
+	p := New(ai_struct.LocalProvider{Name: "ollama", Server: server.URL + "/v1", Key: "test-key"}, nil)
+	if _, err := p.Discover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 为模型输出预留四分之一的上下文，最多 4096，带前缀的模型名称也可以识别
+	cases := map[string]int{
+		"small":          2048 - 512,
+		"ollama:large":   131072 - 4096,
+		"unknown":        defaultContextLength - defaultContextLength/4,
+		"ollama:unknown": defaultContextLength - defaultContextLength/4,
+	}
+
+	for model, length := range cases {
+		if l := p.MaxContextLength(model); l != length {
+			t.Errorf("%s: expect %d, got %d", model, length, l)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package openai
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openimsdk/tools/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// healthDecay 成功率和延迟的指数移动平均系数，值越大越看重最近的请求
+	healthDecay = 0.2
+	// minSuccessRate 成功率的下限，避免成功率很低但是仍然可用的 Client 完全分配不到请求
+	minSuccessRate = 0.05
+	// minLatencyFactor 延迟系数的下限，最快的 Client 延迟系数为 1
+	minLatencyFactor = 0.1
+	// failureThreshold 连续失败多少次后熔断
+	failureThreshold = 3
+	// baseCooldown 第一次熔断的冷却时间，之后每次连续熔断翻倍
+	baseCooldown = 30 * time.Second
+	// maxCooldown 熔断的最长冷却时间
+	maxCooldown = 5 * time.Minute
+	// rateLimitCooldown 请求频率超限（429）后的最短冷却时间，服务端返回了等待时间时使用两者中较长的一个
+	rateLimitCooldown = 10 * time.Second
+	// ejectCooldown 密钥无效、没有权限（401/403）或者额度用完时的冷却时间，只用于不记录密钥状态的 Client，
+	// 记录了状态的密钥会被禁用或者暂停，见 apiKey
+	ejectCooldown = 30 * time.Minute
+)
+
+// breakerState 熔断器状态
+type breakerState int
+
+const (
+	// breakerClosed 正常状态，可以分配请求
+	breakerClosed breakerState = iota
+	// breakerOpen 熔断状态，冷却时间内不分配请求
+	breakerOpen
+	// breakerHalfOpen 冷却时间结束，只允许一个探测请求，探测成功后恢复正常，失败后重新熔断
+	breakerHalfOpen
+)
+
+// endpoint 一个服务器和密钥组合对应的 OpenAI Client 及其健康状况
+type endpoint struct {
+	client *openai.Client
+	server string
+	// key 脱敏后的密钥，只用于日志
+	key string
+	// credential 密钥状态，同一个密钥的所有 endpoint 共享，为 nil 时不记录密钥状态
+	credential *apiKey
+	// weight 配置的权重
+	weight int
+	// models 可以使用的模型，支持 * 结尾的通配符，为空时可以使用所有模型
+	models []string
+
+	lock                sync.Mutex
+	successRate         float64
+	latency             time.Duration
+	consecutiveFailures int
+	// trips 连续熔断的次数，用于计算冷却时间
+	trips    int
+	state    breakerState
+	cooldown time.Time
+	// probing 半开状态下是否已经分配了探测请求
+	probing bool
+}
+
+func newEndpoint(client *openai.Client, server string, credential *apiKey, weight int, models []string) *endpoint {
+	var key string
+	if credential != nil {
+		key = maskKey(credential.key)
+	}
+
+	return &endpoint{
+		client:      client,
+		server:      server,
+		key:         key,
+		credential:  credential,
+		weight:      max(weight, 1),
+		models:      models,
+		successRate: 1,
+	}
+}
+
+// serves 是否可以使用指定的模型，model 为空时表示不限模型
+func (e *endpoint) serves(model string) bool {
+	if len(e.models) == 0 || model == "" {
+		return true
+	}
+
+	for _, pattern := range e.models {
+		if pattern == model || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(model, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// available 是否可以分配请求，密钥被禁用或者暂停时不可以分配请求，冷却时间结束的熔断 Client 转为半开状态
+func (e *endpoint) available(now time.Time) bool {
+	if !e.credential.usable(now) {
+		return false
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	switch e.state {
+	case breakerOpen:
+		if now.Before(e.cooldown) {
+			return false
+		}
+
+		e.state, e.probing = breakerHalfOpen, false
+		return true
+	case breakerHalfOpen:
+		return !e.probing
+	}
+
+	return true
+}
+
+// score 按照权重、成功率和延迟计算的选择权重，fastest 为可用 Client 中最低的平均延迟
+func (e *endpoint) score(fastest time.Duration) float64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	latencyFactor := 1.0
+	if e.latency > 0 && fastest > 0 {
+		latencyFactor = math.Max(float64(fastest)/float64(e.latency), minLatencyFactor)
+	}
+
+	return float64(e.weight) * math.Max(e.successRate, minSuccessRate) * latencyFactor
+}
+
+func (e *endpoint) averageLatency() time.Duration {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.latency
+}
+
+// acquire 分配请求，半开状态下标记已经分配了探测请求
+func (e *endpoint) acquire() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.state == breakerHalfOpen {
+		e.probing = true
+	}
+}
+
+// report 记录请求结果，更新健康状况以及熔断器状态，返回本次请求是否导致熔断
+// 服务端返回配额已经用完时，即使请求成功也会在配额恢复前暂停分配请求
+func (e *endpoint) report(now time.Time, latency time.Duration, err error, hint *rateLimitHint) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	retryAfter, exhausted, reset := hint.values()
+	kind := classifyError(err)
+	switch kind {
+	case kindCanceled, kindClient:
+		// 请求参数错误、主动取消等和 Client 健康状况无关的错误，只释放探测请求
+		e.probing = false
+		return false
+	case kindNone:
+		e.successRate = (1-healthDecay)*e.successRate + healthDecay
+		if e.latency == 0 {
+			e.latency = latency
+		} else {
+			e.latency = time.Duration((1-healthDecay)*float64(e.latency) + healthDecay*float64(latency))
+		}
+
+		e.consecutiveFailures, e.trips = 0, 0
+		e.state, e.probing = breakerClosed, false
+		if exhausted && reset > 0 {
+			e.trip(now, min(reset, maxCooldown))
+			return true
+		}
+
+		return false
+	}
+
+	e.successRate = (1 - healthDecay) * e.successRate
+	e.consecutiveFailures++
+	switch {
+	case (kind == kindAuth || kind == kindQuotaExceeded) && e.credential == nil:
+		// 记录了状态的密钥由 apiKey.fail 决定是否禁用或者暂停，Client 按照连续失败次数熔断
+		e.trip(now, ejectCooldown)
+	case kind == kindRateLimited:
+		e.trip(now, min(max(retryAfter, reset, rateLimitCooldown), maxCooldown))
+	case e.state == breakerHalfOpen || e.consecutiveFailures >= failureThreshold:
+		e.trip(now, min(baseCooldown<<e.trips, maxCooldown))
+	default:
+		return false
+	}
+
+	return true
+}
+
+// trip 熔断，冷却时间内不再分配请求
+func (e *endpoint) trip(now time.Time, cooldown time.Duration) {
+	e.state, e.probing = breakerOpen, false
+	e.cooldown = now.Add(cooldown)
+	e.trips++
+}
+
+// balancer 在多个服务器和密钥组合之间分配请求，按照权重和健康状况加权随机选择，连续失败的 Client 会被熔断
+type balancer struct {
+	endpoints []*endpoint
+	// now 当前时间，测试时可以替换
+	now func() time.Time
+}
+
+func newBalancer(endpoints []*endpoint) *balancer {
+	return &balancer{endpoints: endpoints, now: time.Now}
+}
+
+// pick 选择一个可以使用 model 的 Client，优先选择配置了该模型的 Client，其次是不限模型的 Client
+// 所有候选 Client 都不可用时，选择密钥没有被禁用并且最早恢复的 Client，保证请求不会因为熔断全部失败
+func (b *balancer) pick(model string) *endpoint {
+	candidates := b.candidates(model)
+	now := b.now()
+
+	available := make([]*endpoint, 0, len(candidates))
+	for _, e := range candidates {
+		if e.available(now) {
+			available = append(available, e)
+		}
+	}
+
+	if len(available) == 0 {
+		soonest := candidates[0]
+		for _, e := range candidates[1:] {
+			if disabled := e.credential.disabled(); disabled != soonest.credential.disabled() {
+				if !disabled {
+					soonest = e
+				}
+				continue
+			}
+
+			if e.resumeAt().Before(soonest.resumeAt()) {
+				soonest = e
+			}
+		}
+
+		return soonest
+	}
+
+	var fastest time.Duration
+	for _, e := range available {
+		if latency := e.averageLatency(); latency > 0 && (fastest == 0 || latency < fastest) {
+			fastest = latency
+		}
+	}
+
+	scores := make([]float64, len(available))
+	var total float64
+	for i, e := range available {
+		scores[i] = e.score(fastest)
+		total += scores[i]
+	}
+
+	selected := available[len(available)-1]
+	for i, r := 0, rand.Float64()*total; i < len(available); i++ {
+		if r -= scores[i]; r < 0 {
+			selected = available[i]
+			break
+		}
+	}
+
+	selected.acquire()
+	return selected
+}
+
+// candidates 可以使用 model 的 Client，没有 Client 可以使用该模型时返回全部 Client，由服务端返回错误
+func (b *balancer) candidates(model string) []*endpoint {
+	var dedicated, shared []*endpoint
+	for _, e := range b.endpoints {
+		switch {
+		case len(e.models) == 0:
+			shared = append(shared, e)
+		case e.serves(model):
+			dedicated = append(dedicated, e)
+		}
+	}
+
+	switch {
+	case len(dedicated) > 0:
+		return dedicated
+	case len(shared) > 0:
+		return shared
+	}
+
+	return b.endpoints
+}
+
+// done 记录请求结果，需要在请求结束后调用
+func (b *balancer) done(ctx context.Context, e *endpoint, start time.Time, err error, hint *rateLimitHint) {
+	now := b.now()
+	if e.report(now, now.Sub(start), err, hint) {
+		log.ZWarn(ctx, "openai client is temporarily ejected", err, "server", e.server, "key", e.key, "until", e.cooldownEnd())
+	}
+
+	if err == nil {
+		if e.credential.succeed(now) {
+			log.ZInfo(ctx, "openai key is enabled again", "pool", e.credential.pool, "key", e.key, "server", e.server)
+		}
+
+		return
+	}
+
+	// 密钥无效或者额度用完时，使用同一个密钥的所有 Client 都不再分配请求
+	kind := classifyError(err)
+	if !e.credential.fail(now, kind, errorReason(err)) {
+		return
+	}
+
+	if kind == kindAuth {
+		log.ZError(ctx, "openai key is disabled", err, "pool", e.credential.pool, "key", e.key, "server", e.server)
+	} else {
+		log.ZWarn(ctx, "openai key is paused", err, "pool", e.credential.pool, "key", e.key, "until", e.credential.resumeAt())
+	}
+}
+
+func (e *endpoint) cooldownEnd() time.Time {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.cooldown
+}
+
+// resumeAt 熔断冷却时间以及密钥暂停时间中较晚结束的一个
+func (e *endpoint) resumeAt() time.Time {
+	cooldown, paused := e.cooldownEnd(), e.credential.resumeAt()
+	if paused.After(cooldown) {
+		return paused
+	}
+
+	return cooldown
+}
+
+// withEndpoint 选择一个 Client 执行 fn，并把执行结果记录到 Client 的健康状况中，同时返回服务端返回的限流信息
+func withEndpoint[T any](ctx context.Context, b *balancer, model string, fn func(ctx context.Context, client *openai.Client) (T, error)) (T, *rateLimitHint, error) {
+	e := b.pick(model)
+	hctx, hint := withRateLimitHint(ctx)
+	start := b.now()
+	res, err := fn(hctx, e.client)
+	b.done(ctx, e, start, err, hint)
+	return res, hint, err
+}
+
+// maskKey 密钥脱敏，只保留前后 4 位
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+
+	return key[:4] + "****" + key[len(key)-4:]
+}
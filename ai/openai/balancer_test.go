@@ -0,0 +1,527 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// testServer 模拟 OpenAI 服务，status 为 0 时正常响应，否则返回对应的错误状态码
+type testServer struct {
+	*httptest.Server
+	hits   atomic.Int32
+	status atomic.Int32
+	// succeedAfter 大于 0 时，只有前 succeedAfter 个请求返回 status
+	succeedAfter atomic.Int32
+	// revoked 返回 401 的密钥
+	revoked string
+	// exhausted 返回 429 insufficient_quota 的密钥
+	exhausted string
+	// header 所有响应都会返回的响应头
+	header http.Header
+
+	lock   sync.Mutex
+	models []string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+
+		hit := s.hits.Add(1)
+
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+
+		s.lock.Lock()
+		s.models = append(s.models, req.Model)
+		s.lock.Unlock()
+
+		status := int(s.status.Load())
+		if after := s.succeedAfter.Load(); after > 0 && hit > after {
+			status = 0
+		}
+
+		if s.revoked != "" && r.Header.Get("Authorization") == "Bearer "+s.revoked {
+			status = http.StatusUnauthorized
+		}
+
+		for key, values := range s.header {
+			w.Header()[key] = values
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if s.exhausted != "" && r.Header.Get("Authorization") == "Bearer "+s.exhausted {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":{"message":"quota exceeded","type":"insufficient_quota"}}`))
+			return
+		}
+
+		if status != 0 {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"error":{"message":"test error","type":"test"}}`))
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Model:   req.Model,
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: "assistant", Content: "ok"}}},
+		})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) requestedModels() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string(nil), s.models...)
+}
+
+// noRetry 负载均衡的测试不重试，每次调用只发送一个请求
+var noRetry = RetryPolicy{MaxAttempts: 1}
+
+// testClock 可以手动推进的时钟
+type testClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// newTestClient 每个测试使用单独的配置名称，测试结束后清除密钥状态
+func newTestClient(t *testing.T, conf *Config) (*realClientImpl, *testClock) {
+	conf.Name = t.Name()
+	t.Cleanup(func() {
+		registry.lock.Lock()
+		defer registry.lock.Unlock()
+
+		delete(registry.configs, conf.Name)
+		for id := range registry.keys {
+			if id.pool == conf.Name {
+				delete(registry.keys, id)
+			}
+		}
+	})
+
+	client, ok := NewOpenAIClient(conf, nil).(*realClientImpl)
+	if !ok {
+		t.Fatal("unexpected client type")
+	}
+
+	clock := &testClock{now: time.Now()}
+	client.balancer.now = clock.Now
+	return client, clock
+}
+
+func chatOnce(client Client, model string) error {
+	_, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    model,
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "hi"}},
+	})
+	return err
+}
+
+func findEndpoint(t *testing.T, client *realClientImpl, server string) *endpoint {
+	for _, e := range client.balancer.endpoints {
+		if e.server == server {
+			return e
+		}
+	}
+
+	t.Fatalf("endpoint not found: %s", server)
+	return nil
+}
+
+func TestBalancer_EjectsFailingServer(t *testing.T) {
+	healthy, broken := newTestServer(t), newTestServer(t)
+	broken.status.Store(http.StatusInternalServerError)
+
+	client, clock := newTestClient(t, &Config{
+		OpenAIServers: []string{healthy.URL + "/v1", broken.URL + "/v1"},
+		OpenAIKeys:    []string{"test-key"},
+		Retry:         noRetry,
+	})
+
+	var failures int
+	for i := 0; i < 50; i++ {
+		if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+			failures++
+		}
+	}
+
+	if hits := int(broken.hits.Load()); hits > failureThreshold || hits != failures {
+		t.Fatalf("broken server should be ejected after %d failures, got %d hits and %d failures", failureThreshold, hits, failures)
+	}
+
+	if e := findEndpoint(t, client, broken.URL+"/v1"); failures == failureThreshold && e.state != breakerOpen {
+		t.Fatalf("broken server should be open, got %d", e.state)
+	}
+
+	// 冷却时间结束后服务恢复，探测成功后重新分配请求
+	broken.status.Store(0)
+	clock.Advance(baseCooldown + time.Second)
+	before := broken.hits.Load()
+	for i := 0; i < 200 && broken.hits.Load() == before; i++ {
+		if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if broken.hits.Load() == before {
+		t.Fatal("recovered server should receive traffic again")
+	}
+
+	if e := findEndpoint(t, client, broken.URL+"/v1"); e.state != breakerClosed {
+		t.Fatalf("recovered server should be closed, got %d", e.state)
+	}
+}
+
+func TestBalancer_HalfOpenProbeFailure(t *testing.T) {
+	broken := newTestServer(t)
+	broken.status.Store(http.StatusBadGateway)
+
+	client, clock := newTestClient(t, &Config{
+		OpenAIServers: []string{broken.URL + "/v1"},
+		OpenAIKeys:    []string{"test-key"},
+		Retry:         noRetry,
+	})
+
+	for i := 0; i < failureThreshold; i++ {
+		_ = chatOnce(client, "gpt-4o-mini")
+	}
+
+	e := findEndpoint(t, client, broken.URL+"/v1")
+	first := e.cooldownEnd()
+	if e.state != breakerOpen || !first.Equal(clock.Now().Add(baseCooldown)) {
+		t.Fatalf("server should be open for %s, got state %d until %s", baseCooldown, e.state, first)
+	}
+
+	// 探测失败后重新熔断，冷却时间翻倍
+	clock.Advance(baseCooldown)
+	_ = chatOnce(client, "gpt-4o-mini")
+	if e.state != breakerOpen || !e.cooldownEnd().Equal(clock.Now().Add(2*baseCooldown)) {
+		t.Fatalf("failed probe should double the cooldown, got state %d until %s", e.state, e.cooldownEnd())
+	}
+}
+
+func TestBalancer_RevokedKey(t *testing.T) {
+	server := newTestServer(t)
+	server.revoked = "sk-revoked-key"
+
+	client, clock := newTestClient(t, &Config{
+		OpenAIServers: []string{server.URL + "/v1"},
+		OpenAIKeys:    []string{"sk-valid-key", "sk-revoked-key"},
+		Retry:         noRetry,
+	})
+
+	// 单次 401 不会让 Client 长时间熔断，不推进时间也会继续分配请求，连续失败 authFailureThreshold 次后禁用密钥
+	var failures int
+	for i := 0; i < 500 && failures < authFailureThreshold; i++ {
+		if status := poolStatuses(t)[maskKey("sk-revoked-key")]; status.State != KeyActive {
+			t.Fatalf("key should not be disabled after %d failures, got %+v", failures, status)
+		}
+
+		if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+			failures++
+		}
+	}
+
+	statuses := poolStatuses(t)
+	if failures != authFailureThreshold || statuses[maskKey("sk-revoked-key")].State != KeyDisabled || statuses[maskKey("sk-valid-key")].State != KeyActive {
+		t.Fatalf("revoked key should be disabled after %d failures, got %d failures and %v", authFailureThreshold, failures, statuses)
+	}
+
+	// 禁用的密钥在重新尝试之前不再使用，即使 Client 的熔断已经结束
+	clock.Advance(maxCooldown)
+	for i := 0; i < 30; i++ {
+		if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 重新尝试成功后恢复使用
+	server.revoked = ""
+	clock.Advance(authProbeInterval)
+	for i := 0; i < 30 && poolStatuses(t)[maskKey("sk-revoked-key")].State == KeyDisabled; i++ {
+		if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if status := poolStatuses(t)[maskKey("sk-revoked-key")]; status.State != KeyActive {
+		t.Fatalf("key should be enabled after a successful probe, got %+v", status)
+	}
+}
+
+func TestBalancer_RevokedKeyProbeFails(t *testing.T) {
+	server := newTestServer(t)
+	server.revoked = "sk-revoked-key"
+
+	client, clock := newTestClient(t, &Config{
+		OpenAIServers: []string{server.URL + "/v1"},
+		OpenAIKeys:    []string{"sk-revoked-key"},
+		Retry:         noRetry,
+	})
+
+	for i := 0; i < authFailureThreshold; i++ {
+		_ = chatOnce(client, "gpt-4o-mini")
+	}
+
+	// 重新尝试仍然失败，等待下一次重新尝试
+	clock.Advance(authProbeInterval)
+	if err := chatOnce(client, "gpt-4o-mini"); classifyError(err) != kindAuth {
+		t.Fatalf("expect the probe to fail, got %v", err)
+	}
+
+	key := client.balancer.endpoints[0].credential
+	if status := key.status(); status.State != KeyDisabled || key.usable(clock.Now()) || !key.usable(clock.Now().Add(authProbeInterval)) {
+		t.Fatalf("failed probe should keep the key disabled until the next probe, got %+v", status)
+	}
+}
+
+func TestRegisterPool_ConfigChanged(t *testing.T) {
+	conf := &Config{Name: t.Name(), OpenAIServers: []string{"https://api.example.com/v1"}, OpenAIKeys: []string{"sk-old-key"}}
+	t.Cleanup(func() {
+		registry.lock.Lock()
+		defer registry.lock.Unlock()
+
+		delete(registry.configs, conf.Name)
+		for id := range registry.keys {
+			if id.pool == conf.Name {
+				delete(registry.keys, id)
+			}
+		}
+	})
+
+	registerPool(conf, false)
+	key := registerKey(conf.Name, "sk-old-key")
+	for i := 0; i < authFailureThreshold; i++ {
+		key.fail(time.Now(), kindAuth, "invalid api key")
+	}
+
+	// 相同的配置重新初始化时保留密钥状态
+	registerPool(conf, false)
+	if registerKey(conf.Name, "sk-old-key") != key || !key.disabled() {
+		t.Fatal("key state should be kept when the config is unchanged")
+	}
+
+	// 更换代理后清除密钥状态，旧配置中的密钥不再出现在 KeyStatuses 中
+	registerPool(conf, true)
+	if len(poolStatuses(t)) != 0 || registerKey(conf.Name, "sk-old-key").disabled() {
+		t.Fatalf("key states should be reset when the config changes, got %v", poolStatuses(t))
+	}
+
+	conf.OpenAIKeys = []string{"sk-new-key"}
+	registerPool(conf, true)
+	registerKey(conf.Name, "sk-new-key")
+	if statuses := poolStatuses(t); len(statuses) != 1 || statuses[maskKey("sk-new-key")].State != KeyActive {
+		t.Fatalf("only keys in the new config should be kept, got %v", statuses)
+	}
+}
+
+func TestBalancer_QuotaExceededKey(t *testing.T) {
+	first, second := newTestServer(t), newTestServer(t)
+	first.exhausted, second.exhausted = "sk-poor-key", "sk-poor-key"
+
+	var notified []KeyStatus
+	SetKeyStateListener(func(status KeyStatus) {
+		if status.Pool == t.Name() {
+			notified = append(notified, status)
+		}
+	})
+	t.Cleanup(func() { SetKeyStateListener(nil) })
+
+	client, clock := newTestClient(t, &Config{
+		OpenAIServers: []string{first.URL + "/v1", second.URL + "/v1"},
+		OpenAIKeys:    []string{"sk-rich-key", "sk-poor-key"},
+		Retry:         noRetry,
+	})
+
+	var failures int
+	for i := 0; i < 50; i++ {
+		if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+			failures++
+		}
+	}
+
+	// 同一个密钥在所有服务器上共享状态，暂停后其它服务器也不再使用
+	if failures != 1 {
+		t.Fatalf("over-quota key should be paused on every server after the first failure, got %d failures", failures)
+	}
+
+	status := poolStatuses(t)[maskKey("sk-poor-key")]
+	if status.State != KeyPaused || status.PausedUntil != clock.Now().Add(quotaPause).UnixMilli() {
+		t.Fatalf("over-quota key should be paused for %s, got %+v", quotaPause, status)
+	}
+
+	// 暂停时间结束后恢复使用
+	first.exhausted, second.exhausted = "", ""
+	clock.Advance(quotaPause)
+	if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notified) != 2 || notified[0].State != KeyPaused || notified[1].State != KeyActive {
+		t.Fatalf("listener should be notified when the key is paused and resumed, got %+v", notified)
+	}
+}
+
+// poolStatuses 当前测试中所有密钥的状态，脱敏后的密钥 -> 状态
+func poolStatuses(t *testing.T) map[string]KeyStatus {
+	statuses := make(map[string]KeyStatus)
+	for _, status := range KeyStatuses() {
+		if status.Pool == t.Name() {
+			statuses[status.Key] = status
+		}
+	}
+
+	return statuses
+}
+
+func TestBalancer_RateLimitCooldown(t *testing.T) {
+	limited, healthy := newTestServer(t), newTestServer(t)
+	limited.status.Store(http.StatusTooManyRequests)
+
+	client, clock := newTestClient(t, &Config{
+		OpenAIServers: []string{limited.URL + "/v1", healthy.URL + "/v1"},
+		OpenAIKeys:    []string{"test-key"},
+		// 保证第一个请求分配到请求频率超限的服务器
+		ServerWeights: map[string]int{limited.URL + "/v1": 1000000},
+		Retry:         noRetry,
+	})
+
+	_ = chatOnce(client, "gpt-4o-mini")
+	if limited.hits.Load() != 1 {
+		t.Fatalf("first request should go to the heavier server, got %d hits", limited.hits.Load())
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if limited.hits.Load() != 1 {
+		t.Fatalf("rate limited server should cool down, got %d hits", limited.hits.Load())
+	}
+
+	limited.status.Store(0)
+	clock.Advance(rateLimitCooldown)
+	if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+		t.Fatal(err)
+	}
+
+	if limited.hits.Load() != 2 {
+		t.Fatalf("rate limited server should receive traffic after the cooldown, got %d hits", limited.hits.Load())
+	}
+}
+
+func TestBalancer_ModelAware(t *testing.T) {
+	gpt4o, general := newTestServer(t), newTestServer(t)
+
+	client, _ := newTestClient(t, &Config{
+		OpenAIServers: []string{gpt4o.URL + "/v1", general.URL + "/v1"},
+		OpenAIKeys:    []string{"test-key"},
+		ServerModels:  map[string][]string{gpt4o.URL + "/v1": {"gpt-4o*"}},
+		Retry:         noRetry,
+	})
+
+	for i := 0; i < 10; i++ {
+		if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := chatOnce(client, "gpt-3.5-turbo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, model := range gpt4o.requestedModels() {
+		if model != "gpt-4o-mini" {
+			t.Errorf("gpt-4o server should only serve gpt-4o models, got %s", model)
+		}
+	}
+
+	for _, model := range general.requestedModels() {
+		if model != "gpt-3.5-turbo" {
+			t.Errorf("general server should not serve models with a dedicated server, got %s", model)
+		}
+	}
+
+	if gpt4o.hits.Load() != 10 || general.hits.Load() != 10 {
+		t.Fatalf("unexpected hits: gpt-4o %d, general %d", gpt4o.hits.Load(), general.hits.Load())
+	}
+}
+
+func TestBalancer_WeightedSelection(t *testing.T) {
+	heavy := newEndpoint(nil, "heavy", nil, 9, nil)
+	light := newEndpoint(nil, "light", nil, 1, nil)
+	slow := newEndpoint(nil, "slow", nil, 9, nil)
+
+	b := newBalancer([]*endpoint{heavy, light, slow})
+	now := b.now()
+	heavy.report(now, 100*time.Millisecond, nil, nil)
+	light.report(now, 100*time.Millisecond, nil, nil)
+	// 延迟是其它服务器的 10 倍，选择权重降低为 1/10
+	slow.report(now, time.Second, nil, nil)
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[b.pick("").server]++
+	}
+
+	// 期望比例为 9:1:0.9
+	if counts["heavy"] < 7000 || counts["light"] < 500 || counts["slow"] > 1500 {
+		t.Fatalf("unexpected distribution: %v", counts)
+	}
+}
+
+func TestEndpoint_ReportAuthFailure(t *testing.T) {
+	now := time.Now()
+	unauthorized := &openai.APIError{HTTPStatusCode: http.StatusUnauthorized}
+
+	// 不记录密钥状态的 Client 第一次认证失败就熔断 ejectCooldown
+	untracked := newEndpoint(nil, "untracked", nil, 1, nil)
+	if !untracked.report(now, time.Millisecond, unauthorized, nil) || untracked.cooldownEnd() != now.Add(ejectCooldown) {
+		t.Fatalf("untracked key should be ejected for %s, got cooldown %v", ejectCooldown, untracked.cooldownEnd().Sub(now))
+	}
+
+	// 记录了状态的密钥由 apiKey 决定是否禁用，Client 连续失败 failureThreshold 次后按照普通熔断处理
+	tracked := newEndpoint(nil, "tracked", &apiKey{key: "sk-tracked-key"}, 1, nil)
+	for i := 1; i < failureThreshold; i++ {
+		if tracked.report(now, time.Millisecond, unauthorized, nil) {
+			t.Fatalf("tracked key should not be ejected after %d failures", i)
+		}
+	}
+
+	if !tracked.report(now, time.Millisecond, unauthorized, nil) || tracked.cooldownEnd() != now.Add(baseCooldown) {
+		t.Fatalf("tracked key should trip for %s, got cooldown %v", baseCooldown, tracked.cooldownEnd().Sub(now))
+	}
+}
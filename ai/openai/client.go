@@ -1,9 +1,9 @@
 package openai
 
 import (
-	"accompany-sdk/pkg/ai/control"
+	"accompany-sdk/pkg/ai/fallback"
+	"accompany-sdk/pkg/utils/array"
 	"context"
-	"github.com/openimsdk/tools/log"
 	"github.com/sashabaranov/go-openai"
 	"io"
 )
@@ -16,148 +16,128 @@ type Client interface {
 	CreateTranscription(ctx context.Context, request openai.AudioRequest) (response openai.AudioResponse, err error)
 	CreateSpeech(ctx context.Context, request openai.CreateSpeechRequest) (response io.ReadCloser, err error)
 	QuickAsk(ctx context.Context, prompt string, question string, maxTokenCount int) (string, error)
+	CreateEmbeddings(ctx context.Context, request openai.EmbeddingRequest) (response openai.EmbeddingResponse, err error)
 }
 
-type ClientImpl struct {
-	main   Client
-	backup Client
+// Tier 降级链中的一层 Client
+type Tier struct {
+	fallback.Tier
+	Client Client
 }
 
-func (proxy *ClientImpl) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (response openai.ChatCompletionResponse, err error) {
-	ctl := control.FromContext(ctx)
-	if ctl.PreferBackup && proxy.backup != nil {
-		return proxy.backup.CreateChatCompletion(ctx, request)
-	}
+// ClientImpl 按顺序使用多个 Client 的降级链，前一层失败并且满足该层的切换条件时使用下一层
+// 上下文中的 control.Control 可以指定从哪一层开始，返回结果的层级可以通过 fallback.WithRecord 获取
+// 所有层级都失败时返回 *fallback.ExhaustedError，没有任何 Client 时错误为 fallback.ErrNoTier
+type ClientImpl struct {
+	tiers   []fallback.Tier
+	clients []Client
+}
 
-	if proxy.main != nil {
-		response, err = proxy.main.CreateChatCompletion(ctx, request)
-		if err == nil {
-			return response, nil
+// NewClientChain 使用多层 Client 创建降级链，Client 为 nil 的层级会被忽略
+func NewClientChain(tiers ...Tier) Client {
+	chain := &ClientImpl{}
+	for _, tier := range tiers {
+		if tier.Client != nil {
+			chain.tiers = append(chain.tiers, tier.Tier)
+			chain.clients = append(chain.clients, tier.Client)
 		}
 	}
 
-	if proxy.backup != nil {
-		log.ZError(ctx, "use backup openai client", err, "request", request)
-		return proxy.backup.CreateChatCompletion(ctx, request)
-	}
-
-	return response, err
+	return chain
 }
 
-func (proxy *ClientImpl) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (stream *openai.ChatCompletionStream, err error) {
-	ctl := control.FromContext(ctx)
-	if ctl.PreferBackup && proxy.backup != nil {
-		return proxy.backup.CreateChatCompletionStream(ctx, request)
-	}
-
-	if proxy.main != nil {
-		stream, err = proxy.main.CreateChatCompletionStream(ctx, request)
-		if err == nil {
-			return stream, nil
-		}
-	}
-
-	if proxy.backup != nil {
-		log.ZError(ctx, "use backup openai client", err, "request", request)
-		return proxy.backup.CreateChatCompletionStream(ctx, request)
-	}
+// NewOpenAIProxy 使用主服务和备用服务创建两层的降级链，主服务的任何错误（调用方取消和请求参数错误除外）都会切换到备用服务
+func NewOpenAIProxy(main Client, backup Client) Client {
+	return NewClientChain(
+		Tier{Tier: fallback.Tier{Name: TierMain}, Client: main},
+		Tier{Tier: fallback.Tier{Name: TierBackup}, Client: backup},
+	)
+}
 
-	return stream, err
+// withChain 在降级链中依次调用 fn，直到某一层成功或者错误不满足切换条件，适用于一次性返回结果的请求
+func withChain[T any](ctx context.Context, proxy *ClientImpl, fn func(ctx context.Context, client Client) (T, error)) (T, error) {
+	res, _, err := fallback.Run(ctx, proxy.tiers, fallback.Start(ctx, proxy.tiers), ErrorClass, func(ctx context.Context, index int) (T, error) {
+		return fn(ctx, proxy.clients[index])
+	})
+	return res, err
 }
 
-func (proxy *ClientImpl) ChatStream(ctx context.Context, request openai.ChatCompletionRequest) (<-chan ChatStreamResponse, error) {
-	ctl := control.FromContext(ctx)
-	if ctl.PreferBackup && proxy.backup != nil {
-		return proxy.backup.ChatStream(ctx, request)
-	}
+// withClosingChain 和 withChain 相同，fn 返回需要关闭的结果，bind 把取消本层上下文的 cancel 绑定到结果上
+func withClosingChain[T io.Closer](ctx context.Context, proxy *ClientImpl, fn func(ctx context.Context, client Client) (T, error), bind func(res T, cancel context.CancelFunc) T) (T, error) {
+	res, _, err := fallback.RunCloser(ctx, proxy.tiers, fallback.Start(ctx, proxy.tiers), ErrorClass, func(ctx context.Context, index int) (T, error) {
+		return fn(ctx, proxy.clients[index])
+	}, bind)
+	return res, err
+}
 
-	var stream <-chan ChatStreamResponse
-	var err error
+// cancelReadCloser 关闭时取消读取使用的上下文
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
 
-	if proxy.main != nil {
-		stream, err = proxy.main.ChatStream(ctx, request)
-		if err == nil {
-			return stream, nil
-		}
-	}
+func (r *cancelReadCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
 
-	if proxy.backup != nil {
-		log.ZError(ctx, "use backup openai client", err, "request", request)
-		return proxy.backup.ChatStream(ctx, request)
-	}
+func (proxy *ClientImpl) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (response openai.ChatCompletionResponse, err error) {
+	return withChain(ctx, proxy, func(ctx context.Context, client Client) (openai.ChatCompletionResponse, error) {
+		return client.CreateChatCompletion(ctx, request)
+	})
+}
 
+func (proxy *ClientImpl) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (stream *openai.ChatCompletionStream, err error) {
+	// go-openai 的流关闭时无法通知，不能把本层上下文的 cancel 绑定到流上，因此不使用延迟预算，直接使用 ctx 发起请求
+	tiers := array.Map(proxy.tiers, func(item fallback.Tier, _ int) fallback.Tier {
+		item.LatencyBudget = 0
+		return item
+	})
+
+	stream, _, err = fallback.Run(ctx, tiers, fallback.Start(ctx, tiers), ErrorClass, func(ctx context.Context, index int) (*openai.ChatCompletionStream, error) {
+		return proxy.clients[index].CreateChatCompletionStream(ctx, request)
+	})
 	return stream, err
 }
 
 func (proxy *ClientImpl) CreateImage(ctx context.Context, request openai.ImageRequest) (response openai.ImageResponse, err error) {
-	ctl := control.FromContext(ctx)
-	if ctl.PreferBackup && proxy.backup != nil {
-		return proxy.backup.CreateImage(ctx, request)
-	}
-
-	if proxy.main != nil {
-		return proxy.main.CreateImage(ctx, request)
-	}
-
-	if proxy.backup != nil {
-		return proxy.backup.CreateImage(ctx, request)
-	}
-
-	panic("no openai client available")
+	return withChain(ctx, proxy, func(ctx context.Context, client Client) (openai.ImageResponse, error) {
+		return client.CreateImage(ctx, request)
+	})
 }
 
 func (proxy *ClientImpl) CreateTranscription(ctx context.Context, request openai.AudioRequest) (response openai.AudioResponse, err error) {
-	ctl := control.FromContext(ctx)
-	if ctl.PreferBackup && proxy.backup != nil {
-		return proxy.backup.CreateTranscription(ctx, request)
-	}
-
-	if proxy.main != nil {
-		return proxy.main.CreateTranscription(ctx, request)
+	rewind, err := rewindableAudio(request)
+	if err != nil {
+		return response, err
 	}
 
-	if proxy.backup != nil {
-		return proxy.backup.CreateTranscription(ctx, request)
-	}
+	return withChain(ctx, proxy, func(ctx context.Context, client Client) (openai.AudioResponse, error) {
+		req, err := rewind()
+		if err != nil {
+			return openai.AudioResponse{}, err
+		}
 
-	panic("no openai client available")
+		return client.CreateTranscription(ctx, req)
+	})
 }
 
 func (proxy *ClientImpl) CreateSpeech(ctx context.Context, request openai.CreateSpeechRequest) (response io.ReadCloser, err error) {
-	ctl := control.FromContext(ctx)
-	if ctl.PreferBackup && proxy.backup != nil {
-		return proxy.backup.CreateSpeech(ctx, request)
-	}
-
-	if proxy.main != nil {
-		return proxy.main.CreateSpeech(ctx, request)
-	}
-
-	if proxy.backup != nil {
-		return proxy.backup.CreateSpeech(ctx, request)
-	}
-
-	panic("no openai client available")
+	return withClosingChain(ctx, proxy, func(ctx context.Context, client Client) (io.ReadCloser, error) {
+		return client.CreateSpeech(ctx, request)
+	}, func(body io.ReadCloser, cancel context.CancelFunc) io.ReadCloser {
+		return &cancelReadCloser{ReadCloser: body, cancel: cancel}
+	})
 }
 
 func (proxy *ClientImpl) QuickAsk(ctx context.Context, prompt string, question string, maxTokenCount int) (string, error) {
-	var res string
-	var err error
-	if proxy.main != nil {
-		res, err = proxy.main.QuickAsk(ctx, prompt, question, maxTokenCount)
-		if err == nil {
-			return res, nil
-		}
-	}
-
-	if proxy.backup != nil {
-		log.ZError(ctx, "use backup openai client", err, "prompt", prompt, "question", question)
-		return proxy.backup.QuickAsk(ctx, prompt, question, maxTokenCount)
-	}
-
-	return res, err
+	return withChain(ctx, proxy, func(ctx context.Context, client Client) (string, error) {
+		return client.QuickAsk(ctx, prompt, question, maxTokenCount)
+	})
 }
 
-func NewOpenAIProxy(main Client, backup Client) Client {
-	return &ClientImpl{main: main, backup: backup}
+func (proxy *ClientImpl) CreateEmbeddings(ctx context.Context, request openai.EmbeddingRequest) (response openai.EmbeddingResponse, err error) {
+	return withChain(ctx, proxy, func(ctx context.Context, client Client) (openai.EmbeddingResponse, error) {
+		return client.CreateEmbeddings(ctx, request)
+	})
 }
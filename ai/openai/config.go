@@ -2,8 +2,12 @@ package openai
 
 import (
 	"accompany-sdk/ai_struct"
+	"accompany-sdk/pkg/ai/fallback"
+	"accompany-sdk/pkg/misc"
 	"accompany-sdk/pkg/proxy"
 	"accompany-sdk/pkg/ternary"
+	"context"
+	"github.com/openimsdk/tools/log"
 	"github.com/sashabaranov/go-openai"
 	"net"
 	"net/http"
@@ -18,51 +22,81 @@ func NewOpenAi(conf *ai_struct.OpenAiConfig) OpenAi {
 		proxyDialer = proxy.NewProxy(&conf.ProxyConfig)
 	}
 	if conf.EnableOpenAI {
-		mainClient = NewOpenAIClient(parseMainConfig(conf), proxyDialer)
+		if mainClient = NewOpenAIClient(parseMainConfig(conf), proxyDialer); mainClient == nil {
+			log.ZWarn(context.Background(), "openai is enabled without servers or keys", nil)
+		}
 	}
 
 	if conf.EnableFallbackOpenAI {
-		backupClient = NewOpenAIClient(parseBackupConfig(conf), proxyDialer)
+		if backupClient = NewOpenAIClient(parseBackupConfig(conf), proxyDialer); backupClient == nil {
+			log.ZWarn(context.Background(), "fallback openai is enabled without servers or keys", nil)
+		}
 	}
 
-	return NewOpenAIProxy(mainClient, backupClient)
+	return NewClientChain(
+		Tier{
+			Tier: fallback.Tier{
+				Name:          TierMain,
+				FallbackOn:    conf.OpenAIFallbackOn,
+				LatencyBudget: time.Duration(conf.OpenAILatencyBudget) * time.Millisecond,
+			},
+			Client: mainClient,
+		},
+		Tier{Tier: fallback.Tier{Name: TierBackup}, Client: backupClient},
+	)
 }
 
+const (
+	// TierMain 主服务在降级链中的名称
+	TierMain = "openai"
+	// TierBackup 备用服务在降级链中的名称
+	TierBackup = "fallback"
+)
+
 // OpenAi 是一个接口 Client
 type OpenAi = Client
 
+// NewOpenAIClient 使用配置中的服务器和密钥创建 Client，没有配置服务器或者密钥时返回 nil
 func NewOpenAIClient(conf *Config, pp *proxy.Proxy) Client {
-	clients := make([]*openai.Client, 0)
+	registerPool(conf, conf.AutoProxy && pp != nil)
+	endpoints := make([]*endpoint, 0)
+	newServerEndpoint := func(server string, key string, client *openai.Client) *endpoint {
+		return newEndpoint(client, server, registerKey(conf.Name, key), conf.ServerWeights[server], conf.ServerModels[server])
+	}
 
 	// 如果是 Azure API，则每一个 Server 对应一个 Key
 	// 否则 Servers 和 Keys 取笛卡尔积
 	if conf.OpenAIAzure {
 		for i, server := range conf.OpenAIServers {
-			clients = append(clients, createOpenAIClient(
+			if i >= len(conf.OpenAIKeys) {
+				break
+			}
+
+			endpoints = append(endpoints, newServerEndpoint(server, conf.OpenAIKeys[i], createOpenAIClient(
 				true,
 				conf.OpenAIAPIVersion,
 				server,
 				"",
 				conf.OpenAIKeys[i],
 				ternary.If(conf.AutoProxy, pp, nil),
-			))
+			)))
 		}
 	} else {
 		for _, server := range conf.OpenAIServers {
 			for _, key := range conf.OpenAIKeys {
-				clients = append(clients, createOpenAIClient(
+				endpoints = append(endpoints, newServerEndpoint(server, key, createOpenAIClient(
 					false,
 					"",
 					server,
 					conf.OpenAIOrganization,
 					key,
 					ternary.If(conf.AutoProxy, pp, nil),
-				))
+				)))
 			}
 		}
 	}
 
-	return New(conf, clients)
+	return newClient(conf, endpoints)
 }
 
 func createOpenAIClient(isAzure bool, apiVersion string, server, organization, key string, pp *proxy.Proxy) *openai.Client {
@@ -70,22 +104,7 @@ func createOpenAIClient(isAzure bool, apiVersion string, server, organization, k
 	openaiConf.BaseURL = server
 	openaiConf.OrgID = organization
 
-	if pp != nil {
-		openaiConf.HTTPClient = &http.Client{
-			Transport: pp.BuildTransport(),
-			Timeout:   180 * time.Second,
-		}
-
-	} else {
-		openaiConf.HTTPClient = &http.Client{
-			Transport: &http.Transport{
-				DialContext: (&net.Dialer{
-					Timeout: 120 * time.Second,
-				}).DialContext,
-			},
-			Timeout: 180 * time.Second,
-		}
-	}
+	openaiConf.HTTPClient = newHTTPClient(pp)
 
 	if isAzure {
 		openaiConf.APIType = openai.APITypeAzure
@@ -110,7 +129,26 @@ func createOpenAIClient(isAzure bool, apiVersion string, server, organization, k
 	return openai.NewClientWithConfig(openaiConf)
 }
 
+// newHTTPClient 创建访问 OpenAI 的 HTTP 客户端，pp 不为 nil 时使用代理
+// 流式响应可能持续数分钟，不设置 http.Client.Timeout，只限制建立连接和等待响应头的时间，读取过程由请求的上下文控制
+func newHTTPClient(pp *proxy.Proxy) *http.Client {
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 120 * time.Second,
+		}).DialContext,
+	}
+	if pp != nil {
+		transport = pp.BuildTransport()
+	}
+
+	client := misc.StreamHTTPClient(transport)
+	client.Transport = &rateLimitTransport{base: client.Transport}
+	return client
+}
+
 type Config struct {
+	// Name 配置名称，用于区分不同配置中的密钥状态，见 KeyStatus.Pool
+	Name               string
 	Enable             bool
 	OpenAIAzure        bool
 	OpenAIAPIVersion   string
@@ -118,10 +156,17 @@ type Config struct {
 	OpenAIServers      []string
 	OpenAIKeys         []string
 	AutoProxy          bool
+	// ServerModels 服务器地址 -> 该服务器可以使用的模型，未配置的服务器可以使用所有模型
+	ServerModels map[string][]string
+	// ServerWeights 服务器地址 -> 权重，未配置的服务器权重为 1
+	ServerWeights map[string]int
+	// Retry 请求失败后的重试策略，未设置的字段使用 DefaultRetryPolicy 中的值
+	Retry RetryPolicy
 }
 
 func parseMainConfig(conf *ai_struct.OpenAiConfig) *Config {
 	return &Config{
+		Name:               TierMain,
 		Enable:             conf.EnableOpenAI,
 		OpenAIAzure:        conf.OpenAIAzure,
 		OpenAIAPIVersion:   conf.OpenAIAPIVersion,
@@ -129,11 +174,15 @@ func parseMainConfig(conf *ai_struct.OpenAiConfig) *Config {
 		OpenAIServers:      conf.OpenAIServers,
 		OpenAIKeys:         conf.OpenAIKeys,
 		AutoProxy:          conf.OpenAIAutoProxy,
+		ServerModels:       conf.OpenAIServerModels,
+		ServerWeights:      conf.OpenAIServerWeights,
+		Retry:              parseRetryPolicy(conf),
 	}
 }
 
 func parseBackupConfig(conf *ai_struct.OpenAiConfig) *Config {
 	return &Config{
+		Name:               TierBackup,
 		Enable:             conf.EnableFallbackOpenAI,
 		OpenAIAzure:        conf.FallbackOpenAIAzure,
 		OpenAIAPIVersion:   conf.FallbackOpenAIAPIVersion,
@@ -141,12 +190,14 @@ func parseBackupConfig(conf *ai_struct.OpenAiConfig) *Config {
 		OpenAIServers:      conf.FallbackOpenAIServers,
 		OpenAIKeys:         conf.FallbackOpenAIKeys,
 		AutoProxy:          conf.FallbackOpenAIAutoProxy,
+		Retry:              parseRetryPolicy(conf),
 	}
 }
 
 func parseDalleConfig(conf *ai_struct.OpenAiConfig) *Config {
 	if conf.DalleUsingOpenAISetting {
 		return &Config{
+			Name:               "dalle",
 			Enable:             conf.EnableOpenAI && conf.EnableOpenAIDalle,
 			OpenAIAzure:        conf.OpenAIAzure,
 			OpenAIAPIVersion:   conf.OpenAIAPIVersion,
@@ -154,10 +205,12 @@ func parseDalleConfig(conf *ai_struct.OpenAiConfig) *Config {
 			OpenAIServers:      conf.OpenAIServers,
 			OpenAIKeys:         conf.OpenAIKeys,
 			AutoProxy:          conf.OpenAIAutoProxy,
+			Retry:              parseRetryPolicy(conf),
 		}
 	}
 
 	return &Config{
+		Name:               "dalle",
 		Enable:             conf.EnableOpenAIDalle,
 		OpenAIAzure:        conf.OpenAIDalleAzure,
 		OpenAIAPIVersion:   conf.OpenAIDalleAPIVersion,
@@ -165,5 +218,16 @@ func parseDalleConfig(conf *ai_struct.OpenAiConfig) *Config {
 		OpenAIServers:      conf.OpenAIDalleServers,
 		OpenAIKeys:         conf.OpenAIDalleKeys,
 		AutoProxy:          conf.OpenAIDalleAutoProxy,
+		Retry:              parseRetryPolicy(conf),
+	}
+}
+
+// parseRetryPolicy 主服务、备用服务以及 DALL·E 使用相同的重试策略
+func parseRetryPolicy(conf *ai_struct.OpenAiConfig) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    conf.OpenAIRetryMaxAttempts,
+		InitialBackoff: time.Duration(conf.OpenAIRetryInitialBackoff) * time.Millisecond,
+		MaxBackoff:     time.Duration(conf.OpenAIRetryMaxBackoff) * time.Millisecond,
+		MaxElapsed:     time.Duration(conf.OpenAIRetryMaxElapsed) * time.Second,
 	}
 }
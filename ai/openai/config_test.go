@@ -0,0 +1,35 @@
+package openai
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"accompany-sdk/pkg/proxy"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	proxyURL, _ := url.Parse("http://127.0.0.1:7890")
+	for _, pp := range []*proxy.Proxy{nil, {HttpProxy: http.ProxyURL(proxyURL)}} {
+		client := newHTTPClient(pp)
+
+		// 整体超时会截断长时间的 SSE 响应，只限制等待响应头的时间
+		if client.Timeout != 0 {
+			t.Errorf("expect no client timeout, got %s", client.Timeout)
+		}
+
+		limited, ok := client.Transport.(*rateLimitTransport)
+		if !ok {
+			t.Fatalf("expect rate limit transport, got %T", client.Transport)
+		}
+
+		transport, ok := limited.base.(*http.Transport)
+		if !ok || transport.ResponseHeaderTimeout <= 0 {
+			t.Fatalf("expect response header timeout on the base transport, got %+v", limited.base)
+		}
+
+		if (pp != nil) != (transport.Proxy != nil) {
+			t.Errorf("proxy should be used only when configured")
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// DefaultEmbeddingModel 默认的向量模型
+	DefaultEmbeddingModel = string(openai.SmallEmbedding3)
+	// MaxEmbeddingInputs embeddings 接口每次请求最多支持的输入数量
+	MaxEmbeddingInputs = 2048
+	// MaxEmbeddingBatchTokens embeddings 接口每次请求的输入 token 总数上限
+	MaxEmbeddingBatchTokens = 300000
+	// MaxEmbeddingInputTokens embeddings 接口每个输入的 token 数量上限，超出时整个请求会返回 400
+	MaxEmbeddingInputTokens = 8191
+)
+
+// ErrEmbeddingInputTooLong 输入超出 MaxEmbeddingInputTokens，请求前直接拒绝，不会重试或者切换到其它服务
+var ErrEmbeddingInputTooLong = errors.New("openai: embedding input is too long")
+
+// CreateEmbeddings 生成文本向量，字符串数组类型的输入超出单次请求的数量或者 token 限制时自动分批请求，
+// 每个批次失败后按照重试策略单独重试，返回的向量按照输入顺序排列，Usage 为所有批次的用量之和
+func (client *realClientImpl) CreateEmbeddings(ctx context.Context, request openai.EmbeddingRequest) (response openai.EmbeddingResponse, err error) {
+	inputs, ok := request.Input.([]string)
+	if !ok {
+		return client.createEmbeddings(ctx, request)
+	}
+
+	batches, err := embeddingBatches(inputs)
+	if err != nil {
+		return response, err
+	}
+
+	offset := 0
+	for i, batch := range batches {
+		req := request
+		req.Input = batch
+
+		res, err := client.createEmbeddings(ctx, req)
+		if err != nil {
+			return response, fmt.Errorf("embedding batch %d/%d: %w", i+1, len(batches), err)
+		}
+
+		if len(res.Data) != len(batch) {
+			return response, fmt.Errorf("embedding batch %d/%d: expect %d embeddings, got %d", i+1, len(batches), len(batch), len(res.Data))
+		}
+
+		for _, item := range res.Data {
+			item.Index += offset
+			response.Data = append(response.Data, item)
+		}
+
+		response.Object, response.Model = res.Object, res.Model
+		response.Usage.PromptTokens += res.Usage.PromptTokens
+		response.Usage.TotalTokens += res.Usage.TotalTokens
+		offset += len(batch)
+	}
+
+	return response, nil
+}
+
+// createEmbeddings 请求 embeddings 接口，失败后按照重试策略重试
+func (client *realClientImpl) createEmbeddings(ctx context.Context, request openai.EmbeddingRequest) (openai.EmbeddingResponse, error) {
+	return withRetry(ctx, client, string(request.Model), func(ctx context.Context, c *openai.Client) (openai.EmbeddingResponse, error) {
+		return c.CreateEmbeddings(ctx, request)
+	})
+}
+
+// embeddingBatches 按照 MaxEmbeddingInputs 和 MaxEmbeddingBatchTokens 把输入切分为多个批次，
+// 任何一个输入超出 MaxEmbeddingInputTokens 时返回 ErrEmbeddingInputTooLong
+func embeddingBatches(inputs []string) ([][]string, error) {
+	tkm, err := tiktoken.GetEncoding(tiktoken.MODEL_CL100K_BASE)
+	if err != nil {
+		return nil, fmt.Errorf("GetEncoding: %v", err)
+	}
+
+	var batches [][]string
+	start, tokens := 0, 0
+	for i, input := range inputs {
+		n := len(tkm.Encode(input, nil, nil))
+		if n > MaxEmbeddingInputTokens {
+			return nil, fmt.Errorf("%w: input %d has %d tokens, the limit is %d", ErrEmbeddingInputTooLong, i, n, MaxEmbeddingInputTokens)
+		}
+
+		if i > start && (i-start >= MaxEmbeddingInputs || tokens+n > MaxEmbeddingBatchTokens) {
+			batches = append(batches, inputs[start:i])
+			start, tokens = i, 0
+		}
+
+		tokens += n
+	}
+
+	if start < len(inputs) || len(inputs) == 0 {
+		batches = append(batches, inputs[start:])
+	}
+
+	return batches, nil
+}
@@ -0,0 +1,155 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// embeddingServer 记录每次请求的输入数量，按照输入在批次中的序号返回向量，用量为输入数量
+type embeddingServer struct {
+	*httptest.Server
+
+	lock    sync.Mutex
+	batches []int
+	// failBatch 从 1 开始的批次序号，该批次返回 500
+	failBatch int
+}
+
+func newEmbeddingServer(t *testing.T) *embeddingServer {
+	s := &embeddingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/embeddings") {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+
+		var req struct {
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+
+		s.lock.Lock()
+		s.batches = append(s.batches, len(req.Input))
+		batch := len(s.batches)
+		s.lock.Unlock()
+
+		if batch == s.failBatch {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"message":"test error","type":"test"}}`))
+			return
+		}
+
+		res := openai.EmbeddingResponse{Object: "list", Model: openai.SmallEmbedding3}
+		// 乱序返回，验证结果按照输入顺序排列
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			res.Data = append(res.Data, openai.Embedding{Object: "embedding", Index: i, Embedding: []float32{float32(batch), float32(i)}})
+		}
+		res.Usage.PromptTokens, res.Usage.TotalTokens = len(req.Input), len(req.Input)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func embeddingInputs(n int, text string) []string {
+	inputs := make([]string, n)
+	for i := range inputs {
+		inputs[i] = text
+	}
+
+	return inputs
+}
+
+func TestCreateEmbeddings_Batches(t *testing.T) {
+	// 每个输入 8000 个 token，37 个输入 296000 个 token，第 38 个输入超出单次请求的 token 上限
+	long := strings.Repeat("hello ", 8000)
+	cases := []struct {
+		name    string
+		inputs  []string
+		batches []int
+	}{
+		{name: "single batch", inputs: embeddingInputs(3, "hello"), batches: []int{3}},
+		{name: "input count limit", inputs: embeddingInputs(MaxEmbeddingInputs*2+1, "hello"), batches: []int{MaxEmbeddingInputs, MaxEmbeddingInputs, 1}},
+		{name: "token limit", inputs: embeddingInputs(38, long), batches: []int{37, 1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := newEmbeddingServer(t)
+			client, _ := newTestClient(t, &Config{OpenAIServers: []string{server.URL + "/v1"}, OpenAIKeys: []string{"test-key"}, Retry: fastRetry})
+
+			res, err := client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{Input: c.inputs, Model: openai.SmallEmbedding3})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(server.batches) != len(c.batches) {
+				t.Fatalf("want batches %v, got %v", c.batches, server.batches)
+			}
+
+			for i, size := range c.batches {
+				if server.batches[i] != size {
+					t.Errorf("want batches %v, got %v", c.batches, server.batches)
+				}
+			}
+
+			// 序号加上前面批次的偏移量，和输入一一对应
+			if len(res.Data) != len(c.inputs) {
+				t.Fatalf("want %d embeddings, got %d", len(c.inputs), len(res.Data))
+			}
+
+			offset := 0
+			for batch, size := range c.batches {
+				for i := 0; i < size; i++ {
+					item := res.Data[offset+i]
+					if item.Index != offset+(size-1-i) || item.Embedding[0] != float32(batch+1) {
+						t.Fatalf("embedding %d: unexpected %+v", offset+i, item)
+					}
+				}
+				offset += size
+			}
+
+			if res.Usage.PromptTokens != len(c.inputs) || res.Usage.TotalTokens != len(c.inputs) {
+				t.Errorf("usage should be summed, got %+v", res.Usage)
+			}
+		})
+	}
+}
+
+func TestCreateEmbeddings_BatchError(t *testing.T) {
+	server := newEmbeddingServer(t)
+	server.failBatch = 2
+	client, _ := newTestClient(t, &Config{OpenAIServers: []string{server.URL + "/v1"}, OpenAIKeys: []string{"test-key"}, Retry: fastRetry})
+
+	_, err := client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{Input: embeddingInputs(MaxEmbeddingInputs+1, "hello"), Model: openai.SmallEmbedding3})
+	if err == nil || !strings.Contains(err.Error(), "embedding batch 2/2") || classifyError(err) != kindClient {
+		t.Fatalf("expect the second batch to fail with a client error, got %v", err)
+	}
+}
+
+func TestCreateEmbeddings_InputTooLong(t *testing.T) {
+	server := newEmbeddingServer(t)
+	client, _ := newTestClient(t, &Config{OpenAIServers: []string{server.URL + "/v1"}, OpenAIKeys: []string{"test-key"}, Retry: fastRetry})
+
+	inputs := []string{"hello", strings.Repeat("hello ", MaxEmbeddingInputTokens+1)}
+	_, err := client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{Input: inputs, Model: openai.SmallEmbedding3})
+	// 降级链不会把请求切换到其它服务
+	if !errors.Is(err, ErrEmbeddingInputTooLong) || classifyError(err) != kindClient {
+		t.Fatalf("expect ErrEmbeddingInputTooLong as a client error, got %v", err)
+	}
+
+	if len(server.batches) != 0 {
+		t.Errorf("request should be rejected before sending, got %v", server.batches)
+	}
+}
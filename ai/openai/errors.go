@@ -0,0 +1,134 @@
+package openai
+
+import (
+	"accompany-sdk/pkg/ai/fallback"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// errorKind 请求错误的分类，负载均衡和重试根据分类决定如何处理
+type errorKind int
+
+const (
+	// kindNone 请求成功
+	kindNone errorKind = iota
+	// kindCanceled 调用方取消了请求
+	kindCanceled
+	// kindRateLimited 请求频率超限（429），等待后可以重试
+	kindRateLimited
+	// kindQuotaExceeded 账户额度用完（429 insufficient_quota），重试也不会成功
+	kindQuotaExceeded
+	// kindAuth 密钥无效或者没有权限（401/403）
+	kindAuth
+	// kindServer 服务端错误（5xx）
+	kindServer
+	// kindTimeout 请求超时
+	kindTimeout
+	// kindNetwork 连接失败等网络错误
+	kindNetwork
+	// kindClient 请求参数错误等其它 4xx 错误，重试也不会成功
+	kindClient
+)
+
+// classifyError 根据 go-openai 返回的 APIError/RequestError 的状态码以及网络错误的类型对错误分类
+func classifyError(err error) errorKind {
+	if err == nil {
+		return kindNone
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return kindCanceled
+	}
+
+	if errors.Is(err, ErrEmbeddingInputTooLong) {
+		return kindClient
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Type == "insufficient_quota" || apiErr.Code == "insufficient_quota" {
+			return kindQuotaExceeded
+		}
+
+		return classifyStatus(apiErr.HTTPStatusCode)
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return classifyStatus(reqErr.HTTPStatusCode)
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return kindTimeout
+	}
+
+	return kindNetwork
+}
+
+// ErrorClass 错误分类，取值为 fallback.Class* 常量，用于降级链判断是否切换到下一层
+func ErrorClass(err error) string {
+	return classifyError(err).String()
+}
+
+// errorReason 错误原因，优先使用服务端返回的错误信息
+func errorReason(err error) string {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.Message != "" {
+		return apiErr.Message
+	}
+
+	return err.Error()
+}
+
+func classifyStatus(status int) errorKind {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return kindRateLimited
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return kindAuth
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return kindTimeout
+	case status >= http.StatusInternalServerError || status == 0:
+		return kindServer
+	}
+
+	return kindClient
+}
+
+// retryable 是否可以重试，请求频率超限、服务端错误、超时以及网络错误可以重试
+func (k errorKind) retryable() bool {
+	switch k {
+	case kindRateLimited, kindServer, kindTimeout, kindNetwork:
+		return true
+	}
+
+	return false
+}
+
+func (k errorKind) String() string {
+	switch k {
+	case kindNone:
+		return "none"
+	case kindCanceled:
+		return fallback.ClassCanceled
+	case kindRateLimited:
+		return fallback.ClassRateLimited
+	case kindQuotaExceeded:
+		return fallback.ClassQuotaExceeded
+	case kindAuth:
+		return fallback.ClassAuth
+	case kindServer:
+		return fallback.ClassServer
+	case kindTimeout:
+		return fallback.ClassTimeout
+	case kindNetwork:
+		return fallback.ClassNetwork
+	}
+
+	return fallback.ClassClient
+}
@@ -0,0 +1,137 @@
+package openai
+
+import (
+	"accompany-sdk/pkg/ai/fallback"
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/openimsdk/tools/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+// continuePrompt 流式对话中途断开后，请求下一层续写已经输出的回复
+const continuePrompt = "你的上一条回复因为网络中断被截断了，请从中断的位置继续输出剩余的内容，不要重复已经输出的内容，也不要添加任何说明。"
+
+// ChatStream 流式对话，建立连接失败时按照降级链切换到下一层
+// 读取过程中连接中断时，把已经输出的内容作为 assistant 消息发送给下一层续写，续写的增量 Recovered 为 true，
+// 调用方看到的仍然是一个连续的流。多个候选回复或者模型发起了工具调用时无法续写，直接返回中断的错误
+func (proxy *ClientImpl) ChatStream(ctx context.Context, request openai.ChatCompletionRequest) (<-chan ChatStreamResponse, error) {
+	stream, index, err := proxy.openStream(ctx, request, fallback.Start(ctx, proxy.tiers))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(chan ChatStreamResponse)
+	go proxy.relayStream(ctx, request, stream, index, res)
+	return res, nil
+}
+
+// openStream 从 start 层开始建立流式连接，返回建立连接的层级序号
+func (proxy *ClientImpl) openStream(ctx context.Context, request openai.ChatCompletionRequest, start int) (<-chan ChatStreamResponse, int, error) {
+	index := -1
+	stream, _, err := fallback.RunStream(ctx, proxy.tiers, start, ErrorClass, func(ctx context.Context, i int) (<-chan ChatStreamResponse, error) {
+		stream, err := proxy.clients[i].ChatStream(ctx, request)
+		if err == nil {
+			index = i
+		}
+
+		return stream, err
+	})
+	return stream, index, err
+}
+
+// relayStream 转发 index 层的流式响应，连接中断时切换到下一层续写
+func (proxy *ClientImpl) relayStream(ctx context.Context, request openai.ChatCompletionRequest, stream <-chan ChatStreamResponse, index int, res chan<- ChatStreamResponse) {
+	defer close(res)
+
+	var partial strings.Builder
+	var tier string
+	var recovered bool
+	resumable := request.N <= 1
+	for {
+		var data ChatStreamResponse
+		var ok bool
+		select {
+		case <-ctx.Done():
+			drain(stream)
+			return
+		case data, ok = <-stream:
+			if !ok {
+				return
+			}
+		}
+
+		if data.Code != "" && resumable && index+1 < len(proxy.tiers) && proxy.tiers[index].ShouldFallback(streamErrorClass(data)) {
+			interrupted := streamError(data)
+			next, nextIndex, err := proxy.openStream(ctx, continuation(request, partial.String()), index+1)
+			if err == nil {
+				log.ZWarn(ctx, "chat stream interrupted, continue on next tier", interrupted, "tier", proxy.tiers[index].Name, "next", proxy.tiers[nextIndex].Name, "partial", partial.Len())
+				go drain(stream)
+				stream, index, tier, recovered = next, nextIndex, proxy.tiers[nextIndex].Name, true
+				continue
+			}
+
+			log.ZError(ctx, "chat stream failover failed", err, "tier", proxy.tiers[index].Name, "interrupted", interrupted)
+		}
+
+		if data.ChatResponse != nil {
+			for _, choice := range data.ChatResponse.Choices {
+				if len(choice.Delta.ToolCalls) > 0 {
+					resumable = false
+				}
+
+				if choice.Index == 0 {
+					partial.WriteString(choice.Delta.Content)
+				}
+			}
+		}
+
+		data.Tier, data.Recovered = tier, recovered
+		select {
+		case <-ctx.Done():
+			drain(stream)
+			return
+		case res <- data:
+		}
+	}
+}
+
+// streamError 流式响应中途读取失败的错误，没有原始错误时使用错误信息
+func streamError(data ChatStreamResponse) error {
+	if data.err != nil {
+		return data.err
+	}
+
+	return errors.New(data.ErrorMessage)
+}
+
+// streamErrorClass 流式响应中途读取失败的错误分类，没有原始错误时视为连接中断
+func streamErrorClass(data ChatStreamResponse) string {
+	if data.err == nil {
+		return fallback.ClassNetwork
+	}
+
+	return ErrorClass(data.err)
+}
+
+// drain 读取并丢弃流中剩余的响应，保证不再读取的流的写入协程可以退出
+func drain(stream <-chan ChatStreamResponse) {
+	for range stream {
+	}
+}
+
+// continuation 续写请求，在原请求的消息之后追加已经输出的内容以及续写的要求，还没有输出内容时直接重新请求
+func continuation(request openai.ChatCompletionRequest, partial string) openai.ChatCompletionRequest {
+	if partial == "" {
+		return request
+	}
+
+	messages := make([]openai.ChatCompletionMessage, 0, len(request.Messages)+2)
+	messages = append(messages, request.Messages...)
+	request.Messages = append(messages,
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: partial},
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: continuePrompt},
+	)
+	return request
+}
@@ -0,0 +1,227 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"accompany-sdk/ai_struct"
+	"accompany-sdk/pkg/ai/fallback"
+	"github.com/sashabaranov/go-openai"
+)
+
+// streamClient 按照预设的内容返回流式响应，interrupt 为 true 时输出完内容后返回读取失败，failure 为读取失败的原始错误
+type streamClient struct {
+	Client
+	chunks    []string
+	interrupt bool
+	failure   error
+	requests  []openai.ChatCompletionRequest
+}
+
+func (c *streamClient) ChatStream(ctx context.Context, request openai.ChatCompletionRequest) (<-chan ChatStreamResponse, error) {
+	c.requests = append(c.requests, request)
+	res := make(chan ChatStreamResponse, len(c.chunks)+1)
+	for _, chunk := range c.chunks {
+		res <- ChatStreamResponse{ChatResponse: &openai.ChatCompletionStreamResponse{
+			Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: chunk}}},
+		}}
+	}
+
+	if c.interrupt {
+		res <- ChatStreamResponse{Code: "READ_STREAM_FAILED", ErrorMessage: "connection reset", err: c.failure}
+	}
+
+	close(res)
+	return res, nil
+}
+
+func readStream(t *testing.T, client Client, request openai.ChatCompletionRequest) ([]ChatStreamResponse, string) {
+	stream, err := client.ChatStream(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks []ChatStreamResponse
+	var text string
+	for data := range stream {
+		chunks = append(chunks, data)
+		if data.ChatResponse != nil {
+			text += data.ChatResponse.Choices[0].Delta.Content
+		}
+	}
+
+	return chunks, text
+}
+
+func TestClientImpl_ChatStreamFailover(t *testing.T) {
+	main := &streamClient{chunks: []string{"床前", "明月光，"}, interrupt: true}
+	backup := &streamClient{chunks: []string{"疑是", "地上霜。"}}
+
+	request := openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "静夜思"}}}
+	chunks, text := readStream(t, NewOpenAIProxy(main, backup), request)
+	if text != "床前明月光，疑是地上霜。" {
+		t.Fatalf("unexpected text: %s", text)
+	}
+
+	for i, data := range chunks {
+		if data.Code != "" {
+			t.Fatalf("interruption should not reach the caller: %+v", data)
+		}
+
+		if recovered := i >= 2; data.Recovered != recovered || (recovered && data.Tier != TierBackup) {
+			t.Fatalf("chunk %d: expect recovered %v, got %+v", i, recovered, data)
+		}
+	}
+
+	if len(backup.requests) != 1 {
+		t.Fatalf("expect 1 continuation request, got %d", len(backup.requests))
+	}
+
+	messages := backup.requests[0].Messages
+	if len(messages) != 3 || messages[1].Role != openai.ChatMessageRoleAssistant || messages[1].Content != "床前明月光，" || messages[2].Content != continuePrompt {
+		t.Fatalf("unexpected continuation messages: %+v", messages)
+	}
+
+	if len(request.Messages) != 1 {
+		t.Fatal("continuation should not modify the original request")
+	}
+}
+
+func TestClientImpl_ChatStreamFailoverDisabled(t *testing.T) {
+	main := &streamClient{chunks: []string{"床前"}, interrupt: true}
+	backup := &streamClient{chunks: []string{"明月光"}}
+
+	// 主服务只在请求频率超限时切换，连接中断不切换
+	client := NewClientChain(
+		Tier{Tier: fallback.Tier{Name: TierMain, FallbackOn: []string{fallback.ClassRateLimited}}, Client: main},
+		Tier{Tier: fallback.Tier{Name: TierBackup}, Client: backup},
+	)
+
+	chunks, _ := readStream(t, client, openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "静夜思"}}})
+	if last := chunks[len(chunks)-1]; last.Code != "READ_STREAM_FAILED" || len(backup.requests) != 0 {
+		t.Fatalf("interruption should be returned without failover, got %+v", last)
+	}
+}
+
+func TestClientImpl_ChatStreamFailoverClass(t *testing.T) {
+	request := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "静夜思"}}}
+
+	// 中途返回的请求参数错误在下一层也会失败，不切换
+	main := &streamClient{chunks: []string{"床前"}, interrupt: true, failure: &openai.APIError{HTTPStatusCode: http.StatusBadRequest}}
+	backup := &streamClient{chunks: []string{"明月光"}}
+	chunks, _ := readStream(t, NewOpenAIProxy(main, backup), request)
+	if last := chunks[len(chunks)-1]; last.Code != "READ_STREAM_FAILED" || len(backup.requests) != 0 {
+		t.Fatalf("client error should be returned without failover, got %+v", last)
+	}
+
+	// 按照原始错误的分类判断切换条件
+	main = &streamClient{chunks: []string{"床前"}, interrupt: true, failure: &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}}
+	client := NewClientChain(
+		Tier{Tier: fallback.Tier{Name: TierMain, FallbackOn: []string{fallback.ClassRateLimited}}, Client: main},
+		Tier{Tier: fallback.Tier{Name: TierBackup}, Client: backup},
+	)
+
+	if _, text := readStream(t, client, request); text != "床前明月光" || len(backup.requests) != 1 {
+		t.Fatalf("rate limited stream should continue on the backup tier, got %q", text)
+	}
+}
+
+// blockingClient 返回不检查上下文的流，写入协程退出时关闭 exited
+type blockingClient struct {
+	Client
+	exited chan struct{}
+}
+
+func (c *blockingClient) ChatStream(ctx context.Context, request openai.ChatCompletionRequest) (<-chan ChatStreamResponse, error) {
+	res := make(chan ChatStreamResponse)
+	go func() {
+		defer close(c.exited)
+		defer close(res)
+
+		for i := 0; i < 100; i++ {
+			res <- ChatStreamResponse{ChatResponse: &openai.ChatCompletionStreamResponse{}}
+		}
+	}()
+
+	return res, nil
+}
+
+func TestClientImpl_ChatStreamCallerGone(t *testing.T) {
+	main := &blockingClient{exited: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream, err := NewOpenAIProxy(main, nil).ChatStream(ctx, openai.ChatCompletionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 调用方不再读取后继续读取上游剩余的响应，上游的写入协程可以退出
+	<-stream
+	cancel()
+
+	select {
+	case <-main.exited:
+	case <-time.After(time.Second):
+		t.Fatal("upstream producer is blocked after the caller is gone")
+	}
+}
+
+func TestClientImpl_NoClient(t *testing.T) {
+	_, err := NewOpenAIProxy(nil, nil).CreateImage(context.Background(), openai.ImageRequest{})
+	if !errors.Is(err, fallback.ErrNoTier) {
+		t.Fatalf("expect ErrNoTier, got %v", err)
+	}
+}
+
+func TestNewOpenAi_NoEndpoints(t *testing.T) {
+	for _, conf := range []*ai_struct.OpenAiConfig{
+		{EnableOpenAI: true},
+		{EnableOpenAI: true, OpenAIServers: []string{"https://api.example.com/v1"}},
+		{EnableOpenAI: true, OpenAIAzure: true, OpenAIServers: []string{"https://azure.example.com"}},
+	} {
+		// 启用了但是没有配置服务器或者密钥时忽略该层级，不会在选择 Client 时 panic
+		_, err := NewOpenAi(conf).CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: "gpt-4"})
+		if !errors.Is(err, fallback.ErrNoTier) {
+			t.Errorf("expect ErrNoTier for %+v, got %v", conf, err)
+		}
+	}
+}
+
+// completionStreamClient 延迟返回流式响应，记录发起请求使用的上下文
+type completionStreamClient struct {
+	Client
+	delay time.Duration
+	ctx   context.Context
+}
+
+func (c *completionStreamClient) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error) {
+	c.ctx = ctx
+	time.Sleep(c.delay)
+	return &openai.ChatCompletionStream{}, ctx.Err()
+}
+
+func TestClientImpl_CreateChatCompletionStream(t *testing.T) {
+	main := &completionStreamClient{delay: 50 * time.Millisecond}
+	backup := &completionStreamClient{}
+	chain := NewClientChain(
+		Tier{Tier: fallback.Tier{Name: TierMain, LatencyBudget: 10 * time.Millisecond}, Client: main},
+		Tier{Tier: fallback.Tier{Name: TierBackup}, Client: backup},
+	)
+
+	// 流关闭时无法取消本层的上下文，不使用延迟预算，直接使用调用方的上下文
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctx, record := fallback.WithRecord(ctx)
+	stream, err := chain.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{})
+	if err != nil || stream == nil || record.Tier() != TierMain {
+		t.Fatalf("expect the main tier to answer, got %v from %q", err, record.Tier())
+	}
+
+	if main.ctx != ctx || backup.ctx != nil {
+		t.Errorf("expect the caller context passed to the main tier only")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/mylxsw/aidea-server/pkg/uploader"
+	"github.com/openimsdk/tools/log"
 	"gopkg.in/resty.v1"
 	"math/rand"
 	"time"
@@ -16,6 +17,8 @@ import (
 type DalleImageClient struct {
 	conf *Config
 	http *resty.Client
+	// keys conf.OpenAIKeys 中非空密钥的状态
+	keys []*apiKey
 }
 
 func NewDalleImageClient(conf *Config, pp *proxy.Proxy) *DalleImageClient {
@@ -24,7 +27,15 @@ func NewDalleImageClient(conf *Config, pp *proxy.Proxy) *DalleImageClient {
 		restyClient.SetTransport(pp.BuildTransport())
 	}
 
-	return &DalleImageClient{conf: conf, http: restyClient}
+	registerPool(conf, conf.AutoProxy && pp != nil)
+	keys := make([]*apiKey, 0, len(conf.OpenAIKeys))
+	for _, key := range conf.OpenAIKeys {
+		if key != "" {
+			keys = append(keys, registerKey(conf.Name, key))
+		}
+	}
+
+	return &DalleImageClient{conf: conf, http: restyClient, keys: keys}
 }
 
 type ImageRequest struct {
@@ -97,8 +108,21 @@ type ErrorResponseInner struct {
 	Type    string `json:"type,omitempty"`
 }
 
-func (client *DalleImageClient) pickAPIKey() string {
-	return client.conf.OpenAIKeys[rand.Intn(len(client.conf.OpenAIKeys))]
+// pickAPIKey 从没有被禁用或者暂停的密钥中随机选择一个，所有密钥都不可用时从全部密钥中选择，由服务端返回错误
+func (client *DalleImageClient) pickAPIKey() *apiKey {
+	now := time.Now()
+	usable := make([]*apiKey, 0, len(client.keys))
+	for _, key := range client.keys {
+		if key.usable(now) {
+			usable = append(usable, key)
+		}
+	}
+
+	if len(usable) == 0 {
+		usable = client.keys
+	}
+
+	return usable[rand.Intn(len(usable))]
 }
 
 func (client *DalleImageClient) pickServer() string {
@@ -106,9 +130,10 @@ func (client *DalleImageClient) pickServer() string {
 }
 
 func (client *DalleImageClient) CreateImage(ctx context.Context, request ImageRequest) (*ImageResponse, error) {
+	key := client.pickAPIKey()
 	resp, err := client.http.R().
 		SetContext(ctx).
-		SetHeader("Authorization", "Bearer "+client.pickAPIKey()).
+		SetHeader("Authorization", "Bearer "+key.key).
 		SetBody(request).
 		Post(fmt.Sprintf("%s/images/generations", client.pickServer()))
 	if err != nil {
@@ -121,7 +146,25 @@ func (client *DalleImageClient) CreateImage(ctx context.Context, request ImageRe
 	}
 
 	if resp.IsError() {
-		return nil, fmt.Errorf("%s: %s", ret.Error.Type, ret.Error.Message)
+		if ret.Error == nil {
+			ret.Error = &ErrorResponseInner{Message: resp.Status()}
+		}
+
+		kind := classifyStatus(resp.StatusCode())
+		if ret.Error.Type == "insufficient_quota" {
+			kind = kindQuotaExceeded
+		}
+
+		err := fmt.Errorf("%s: %s", ret.Error.Type, ret.Error.Message)
+		if key.fail(time.Now(), kind, ret.Error.Message) {
+			log.ZError(ctx, "dalle key is quarantined", err, "key", maskKey(key.key), "kind", kind.String())
+		}
+
+		return nil, err
+	}
+
+	if key.succeed(time.Now()) {
+		log.ZInfo(ctx, "dalle key is enabled again", "key", maskKey(key.key))
 	}
 
 	return &ret, nil
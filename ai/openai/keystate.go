@@ -0,0 +1,298 @@
+package openai
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// KeyState 密钥状态
+type KeyState string
+
+const (
+	// KeyActive 密钥正常使用
+	KeyActive KeyState = "active"
+	// KeyPaused 账户额度用完（insufficient_quota），暂停使用，暂停时间结束后自动恢复
+	KeyPaused KeyState = "paused"
+	// KeyDisabled 密钥连续多次返回无效或者没有权限（401/403），停止使用，每隔 authProbeInterval 重新尝试，请求成功后恢复
+	KeyDisabled KeyState = "disabled"
+)
+
+const (
+	// quotaPause 额度用完的密钥暂停使用的时间，充值后最晚在暂停时间结束后恢复
+	quotaPause = time.Hour
+	// authFailureThreshold 连续返回 401/403 的次数达到该值后禁用密钥，代理或者地区限制偶尔返回的 403 不会直接禁用密钥
+	authFailureThreshold = 3
+	// authProbeInterval 被禁用的密钥重新尝试的间隔，代理或者网络恢复后密钥可以重新使用
+	authProbeInterval = 6 * time.Hour
+)
+
+// KeyStatus 密钥状态，用于通知宿主以及 GetProviderStatus 查询
+type KeyStatus struct {
+	// Pool 密钥所属的配置，openai 为主服务，fallback 为备用服务，dalle 为 DALL·E
+	Pool string `json:"pool"`
+	// Key 脱敏后的密钥
+	Key   string   `json:"key"`
+	State KeyState `json:"state"`
+	// Reason 密钥被暂停或者禁用的原因，为服务端返回的错误信息
+	Reason string `json:"reason,omitempty"`
+	// PausedUntil 暂停结束的时间，毫秒时间戳，只有 paused 状态有值
+	PausedUntil int64 `json:"paused_until,omitempty"`
+	// UpdatedAt 状态变化的时间，毫秒时间戳
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// apiKey 一个密钥的状态，同一配置中的同一个密钥在所有服务器之间共享状态
+type apiKey struct {
+	pool string
+	key  string
+
+	lock        sync.Mutex
+	state       KeyState
+	reason      string
+	pausedUntil time.Time
+	updatedAt   time.Time
+	// authFailures 连续返回 401/403 的次数，请求成功后清零
+	authFailures int
+	// probeAt 被禁用的密钥下一次重新尝试的时间
+	probeAt time.Time
+}
+
+// keyRegistry 所有配置中的密钥，配置名称和密钥 -> 密钥状态，SDK 使用相同的配置重新初始化 Client 后保留已有的状态
+type keyRegistry struct {
+	lock sync.Mutex
+	keys map[keyID]*apiKey
+	// configs 配置名称 -> 最近一次注册的配置，配置发生变化时清除该配置中的密钥状态
+	configs  map[string]string
+	listener func(status KeyStatus)
+}
+
+type keyID struct {
+	pool string
+	key  string
+}
+
+var registry = &keyRegistry{keys: make(map[keyID]*apiKey), configs: make(map[string]string)}
+
+// registerPool 记录配置中的服务器、密钥和代理，和上一次注册的配置不同时清除该配置中已有的密钥状态，
+// 旧配置中的密钥不再出现在 KeyStatuses 中，更换代理或者服务器后被禁用的密钥也重新开始使用
+func registerPool(conf *Config, proxied bool) {
+	fingerprint := fmt.Sprint(conf.OpenAIAzure, conf.OpenAIOrganization, conf.OpenAIServers, conf.OpenAIKeys, proxied)
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if previous, ok := registry.configs[conf.Name]; ok && previous == fingerprint {
+		return
+	}
+
+	registry.configs[conf.Name] = fingerprint
+	for id := range registry.keys {
+		if id.pool == conf.Name {
+			delete(registry.keys, id)
+		}
+	}
+}
+
+// registerKey 返回密钥的状态，已经注册过的密钥返回已有的状态，key 为空时不记录状态，返回 nil
+func registerKey(pool string, key string) *apiKey {
+	if key == "" {
+		return nil
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	id := keyID{pool: pool, key: key}
+	if k, ok := registry.keys[id]; ok {
+		return k
+	}
+
+	k := &apiKey{pool: pool, key: key, state: KeyActive, updatedAt: time.Now()}
+	registry.keys[id] = k
+	return k
+}
+
+// SetKeyStateListener 设置密钥状态变化的回调，密钥被暂停、禁用以及恢复时调用
+func SetKeyStateListener(listener func(status KeyStatus)) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	registry.listener = listener
+}
+
+// KeyStatuses 返回所有密钥的状态，按照配置名称和脱敏后的密钥排序
+func KeyStatuses() []KeyStatus {
+	registry.lock.Lock()
+	keys := make([]*apiKey, 0, len(registry.keys))
+	for _, k := range registry.keys {
+		keys = append(keys, k)
+	}
+	registry.lock.Unlock()
+
+	now := time.Now()
+	statuses := make([]KeyStatus, 0, len(keys))
+	for _, k := range keys {
+		k.resume(now)
+		statuses = append(statuses, k.status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Pool != statuses[j].Pool {
+			return statuses[i].Pool < statuses[j].Pool
+		}
+
+		return statuses[i].Key < statuses[j].Key
+	})
+	return statuses
+}
+
+func notifyKeyState(status KeyStatus) {
+	registry.lock.Lock()
+	listener := registry.listener
+	registry.lock.Unlock()
+
+	if listener != nil {
+		listener(status)
+	}
+}
+
+// usable 密钥是否可以使用，暂停时间已经结束的密钥恢复为正常状态，被禁用的密钥到了重新尝试的时间后可以使用，
+// nil 表示不记录状态的密钥，总是可以使用
+func (k *apiKey) usable(now time.Time) bool {
+	if k == nil {
+		return true
+	}
+
+	k.lock.Lock()
+	if k.state == KeyDisabled {
+		defer k.lock.Unlock()
+		return !now.Before(k.probeAt)
+	}
+
+	k.lock.Unlock()
+	return k.resume(now)
+}
+
+// resume 暂停时间已经结束的密钥恢复为正常状态，返回密钥是否处于正常状态
+func (k *apiKey) resume(now time.Time) bool {
+	k.lock.Lock()
+	if k.state != KeyPaused || now.Before(k.pausedUntil) {
+		defer k.lock.Unlock()
+		return k.state == KeyActive
+	}
+
+	k.set(now, KeyActive, "", time.Time{})
+	status := k.statusLocked()
+	k.lock.Unlock()
+
+	notifyKeyState(status)
+	return true
+}
+
+func (k *apiKey) disabled() bool {
+	if k == nil {
+		return false
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	return k.state == KeyDisabled
+}
+
+// resumeAt 暂停状态的密钥恢复使用的时间
+func (k *apiKey) resumeAt() time.Time {
+	if k == nil {
+		return time.Time{}
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	return k.pausedUntil
+}
+
+// fail 根据请求错误更新密钥状态，连续多次返回无效或者没有权限时禁用，额度用完时暂停，返回状态是否发生变化
+func (k *apiKey) fail(now time.Time, kind errorKind, reason string) bool {
+	if k == nil {
+		return false
+	}
+
+	k.lock.Lock()
+	switch {
+	case kind == kindAuth && k.state == KeyDisabled:
+		// 重新尝试仍然失败，等待下一次重新尝试
+		k.probeAt = now.Add(authProbeInterval)
+		k.lock.Unlock()
+		return false
+	case kind == kindAuth:
+		if k.authFailures++; k.authFailures < authFailureThreshold {
+			k.lock.Unlock()
+			return false
+		}
+
+		k.set(now, KeyDisabled, reason, time.Time{})
+		k.probeAt = now.Add(authProbeInterval)
+	case kind == kindQuotaExceeded && k.state == KeyActive:
+		k.set(now, KeyPaused, reason, now.Add(quotaPause))
+	default:
+		k.lock.Unlock()
+		return false
+	}
+
+	status := k.statusLocked()
+	k.lock.Unlock()
+
+	notifyKeyState(status)
+	return true
+}
+
+// succeed 请求成功，清除连续认证失败的次数，被禁用的密钥重新尝试成功后恢复为正常状态，返回状态是否发生变化
+func (k *apiKey) succeed(now time.Time) bool {
+	if k == nil {
+		return false
+	}
+
+	k.lock.Lock()
+	k.authFailures = 0
+	if k.state != KeyDisabled {
+		k.lock.Unlock()
+		return false
+	}
+
+	k.set(now, KeyActive, "", time.Time{})
+	status := k.statusLocked()
+	k.lock.Unlock()
+
+	notifyKeyState(status)
+	return true
+}
+
+func (k *apiKey) set(now time.Time, state KeyState, reason string, pausedUntil time.Time) {
+	k.state, k.reason, k.pausedUntil, k.updatedAt = state, reason, pausedUntil, now
+}
+
+func (k *apiKey) status() KeyStatus {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	return k.statusLocked()
+}
+
+func (k *apiKey) statusLocked() KeyStatus {
+	status := KeyStatus{
+		Pool:      k.pool,
+		Key:       maskKey(k.key),
+		State:     k.state,
+		Reason:    k.reason,
+		UpdatedAt: k.updatedAt.UnixMilli(),
+	}
+
+	if k.state == KeyPaused {
+		status.PausedUntil = k.pausedUntil.UnixMilli()
+	}
+
+	return status
+}
@@ -1,12 +1,14 @@
 package openai
 
 import (
+	"accompany-sdk/ai/catalog"
+	_ "accompany-sdk/pkg/bpe"
 	"accompany-sdk/pkg/misc"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"strings"
 
 	"accompany-sdk/pkg/utils/array"
@@ -16,36 +18,12 @@ import (
 
 // SelectBestModel 根据字数选择最合适的模型
 func SelectBestModel(model string, tokenCount int) string {
-	if strings.HasPrefix(model, "gpt-3.5-turbo-16k") && tokenCount <= 4000 {
-		return "gpt-3.5-turbo"
-	}
-
-	if strings.HasPrefix(model, "gpt-4-32k") && tokenCount <= 8000 {
-		return "gpt-4"
-	}
-
-	return model
+	return catalog.SelectBestModel(model, tokenCount)
 }
 
-// ModelMaxContextSize 模型最大上下文长度
-// https://platform.openai.com/docs/models/overview
+// ModelMaxContextSize 模型最大上下文长度，未知模型返回 3500
 func ModelMaxContextSize(model string) int {
-	switch model {
-	case "gpt-3.5-turbo", "gpt-3.5-turbo-0613", "gpt-3.5-turbo-instruct":
-		return 3500
-	case "gpt-3.5-turbo-16k", "gpt-3.5-turbo-16k-0613":
-		return 3500 * 4
-	case "gpt-4", "gpt-4-0613":
-		return 7500
-	case "gpt-4-32k", "gpt-4-32k-0613":
-		return 3500 * 8
-	case "gpt-3.5-turbo-1106":
-		return 16385 - 4096
-	case "gpt-4-1106-preview", "gpt-4-vision-preview":
-		return 128000 - 4096
-	}
-
-	return 3500
+	return catalog.ContextWindow(model, 3500)
 }
 
 // ReduceChatCompletionMessages 递归减少对话上下文
@@ -85,6 +63,12 @@ func NumTokensFromMessages(messages []openai.ChatCompletionMessage, model string
 		model = "gpt-4"
 	case "gpt-3.5-turbo", "gpt-4":
 	default:
+		// gpt-4o 系列模型使用 o200k_base 编码
+		if strings.HasPrefix(model, "gpt-4o") {
+			model = "gpt-4o"
+			break
+		}
+
 		model = "gpt-3.5-turbo"
 	}
 
@@ -120,17 +104,25 @@ func NumTokensFromMessages(messages []openai.ChatCompletionMessage, model string
 }
 
 type realClientImpl struct {
-	conf    *Config
-	clients []*openai.Client
+	conf     *Config
+	balancer *balancer
 }
 
+// New 使用多个 OpenAI Client 创建 Client，请求在 Client 之间负载均衡，所有 Client 权重相同且不限模型
+// clients 为空时返回 nil
 func New(conf *Config, clients []*openai.Client) Client {
-	return &realClientImpl{clients: clients, conf: conf}
+	return newClient(conf, array.Map(clients, func(item *openai.Client, _ int) *endpoint {
+		return newEndpoint(item, "", nil, 1, nil)
+	}))
 }
 
-// client 随机返回一个 OpenAI Client
-func (client *realClientImpl) client(model string) *openai.Client {
-	return client.clients[rand.Intn(len(client.clients))]
+// newClient 没有任何可用的服务器和密钥组合时返回 nil，降级链会忽略该层级
+func newClient(conf *Config, endpoints []*endpoint) Client {
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	return &realClientImpl{conf: conf, balancer: newBalancer(endpoints)}
 }
 
 func (client *realClientImpl) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (response openai.ChatCompletionResponse, err error) {
@@ -139,7 +131,9 @@ func (client *realClientImpl) CreateChatCompletion(ctx context.Context, request
 		request.MaxTokens = 4096
 	}
 
-	return client.client(request.Model).CreateChatCompletion(ctx, request)
+	return withRetry(ctx, client, request.Model, func(ctx context.Context, c *openai.Client) (openai.ChatCompletionResponse, error) {
+		return c.CreateChatCompletion(ctx, request)
+	})
 }
 
 func (client *realClientImpl) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (stream *openai.ChatCompletionStream, err error) {
@@ -148,13 +142,21 @@ func (client *realClientImpl) CreateChatCompletionStream(ctx context.Context, re
 		request.MaxTokens = 4096
 	}
 
-	return client.client(request.Model).CreateChatCompletionStream(ctx, request)
+	return withRetry(ctx, client, request.Model, func(ctx context.Context, c *openai.Client) (*openai.ChatCompletionStream, error) {
+		return c.CreateChatCompletionStream(ctx, request)
+	})
 }
 
 type ChatStreamResponse struct {
 	Code         string `json:"code,omitempty"`
 	ErrorMessage string `json:"error_message,omitempty"`
 	ChatResponse *openai.ChatCompletionStreamResponse
+	// Tier 返回该增量的降级链层级名称，只有中途切换过层级的增量有值
+	Tier string `json:"tier,omitempty"`
+	// Recovered 连接中断后由下一层续写的增量
+	Recovered bool `json:"recovered,omitempty"`
+	// err 读取失败的原始错误，降级链根据错误分类决定是否切换到下一层续写
+	err error
 }
 
 func (client *realClientImpl) ChatStream(ctx context.Context, request openai.ChatCompletionRequest) (<-chan ChatStreamResponse, error) {
@@ -185,7 +187,7 @@ func (client *realClientImpl) ChatStream(ctx context.Context, request openai.Cha
 			if err != nil {
 				select {
 				case <-ctx.Done():
-				case res <- ChatStreamResponse{Code: "READ_STREAM_FAILED", ErrorMessage: fmt.Errorf("read stream failed: %v", err).Error()}:
+				case res <- ChatStreamResponse{Code: "READ_STREAM_FAILED", ErrorMessage: fmt.Errorf("read stream failed: %v", err).Error(), err: err}:
 				}
 				return
 			}
@@ -202,15 +204,62 @@ func (client *realClientImpl) ChatStream(ctx context.Context, request openai.Cha
 }
 
 func (client *realClientImpl) CreateImage(ctx context.Context, request openai.ImageRequest) (response openai.ImageResponse, err error) {
-	return client.client("dall-e").CreateImage(ctx, request)
+	return withRetry(ctx, client, request.Model, func(ctx context.Context, c *openai.Client) (openai.ImageResponse, error) {
+		return c.CreateImage(ctx, request)
+	})
 }
 
 func (client *realClientImpl) CreateTranscription(ctx context.Context, request openai.AudioRequest) (response openai.AudioResponse, err error) {
-	return client.client("audio").CreateTranscription(ctx, request)
+	rewind, err := rewindableAudio(request)
+	if err != nil {
+		return response, err
+	}
+
+	return withRetry(ctx, client, request.Model, func(ctx context.Context, c *openai.Client) (openai.AudioResponse, error) {
+		req, err := rewind()
+		if err != nil {
+			return openai.AudioResponse{}, err
+		}
+
+		return c.CreateTranscription(ctx, req)
+	})
+}
+
+// rewindableAudio 返回每次发送请求前调用的函数，保证重试或者切换服务时都从头读取音频内容：
+// Reader 实现了 io.Seeker 时回到开始读取的位置，否则先把音频内容读取到内存中
+func rewindableAudio(request openai.AudioRequest) (func() (openai.AudioRequest, error), error) {
+	if request.Reader == nil {
+		return func() (openai.AudioRequest, error) { return request, nil }, nil
+	}
+
+	if seeker, ok := request.Reader.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+
+		return func() (openai.AudioRequest, error) {
+			_, err := seeker.Seek(start, io.SeekStart)
+			return request, err
+		}, nil
+	}
+
+	data, err := io.ReadAll(request.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (openai.AudioRequest, error) {
+		req := request
+		req.Reader = bytes.NewReader(data)
+		return req, nil
+	}, nil
 }
 
 func (client *realClientImpl) CreateSpeech(ctx context.Context, request openai.CreateSpeechRequest) (response io.ReadCloser, err error) {
-	return client.client("audio").CreateSpeech(ctx, request)
+	return withRetry(ctx, client, string(request.Model), func(ctx context.Context, c *openai.Client) (io.ReadCloser, error) {
+		return c.CreateSpeech(ctx, request)
+	})
 }
 
 func (client *realClientImpl) QuickAsk(ctx context.Context, prompt string, question string, maxTokenCount int) (string, error) {
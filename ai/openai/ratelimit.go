@@ -0,0 +1,101 @@
+package openai
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitHint 服务端通过响应头返回的限流信息
+// https://platform.openai.com/docs/guides/rate-limits/rate-limits-in-headers
+type rateLimitHint struct {
+	lock sync.Mutex
+	// retryAfter 服务端要求的重试等待时间，来自 retry-after-ms 或者 Retry-After
+	retryAfter time.Duration
+	// exhausted 请求数或者 token 数的配额已经用完
+	exhausted bool
+	// reset 配额恢复需要的时间
+	reset time.Duration
+}
+
+type rateLimitHintKey struct{}
+
+// withRateLimitHint 在上下文中记录本次请求的限流信息，rateLimitTransport 收到响应后写入
+func withRateLimitHint(ctx context.Context) (context.Context, *rateLimitHint) {
+	hint := &rateLimitHint{}
+	return context.WithValue(ctx, rateLimitHintKey{}, hint), hint
+}
+
+// values 返回服务端要求的重试等待时间，配额是否已经用完，以及配额恢复需要的时间
+func (h *rateLimitHint) values() (retryAfter time.Duration, exhausted bool, reset time.Duration) {
+	if h == nil {
+		return 0, false, 0
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.retryAfter, h.exhausted, h.reset
+}
+
+func (h *rateLimitHint) parse(header http.Header, now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.retryAfter = parseRetryAfter(header, now)
+	h.exhausted, h.reset = false, 0
+	for _, kind := range []string{"requests", "tokens"} {
+		remaining, err := strconv.Atoi(header.Get("x-ratelimit-remaining-" + kind))
+		if err != nil || remaining > 0 {
+			continue
+		}
+
+		h.exhausted = true
+		if reset, err := time.ParseDuration(header.Get("x-ratelimit-reset-" + kind)); err == nil {
+			h.reset = max(h.reset, reset)
+		}
+	}
+}
+
+// parseRetryAfter 解析 retry-after-ms 以及 Retry-After（秒数或者 HTTP 时间格式）
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+
+	return 0
+}
+
+// rateLimitTransport 读取响应头中的限流信息写入请求上下文中的 rateLimitHint
+type rateLimitTransport struct {
+	base http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+
+	if hint, ok := req.Context().Value(rateLimitHintKey{}).(*rateLimitHint); ok {
+		hint.parse(res.Header, time.Now())
+	}
+
+	return res, nil
+}
@@ -0,0 +1,104 @@
+package openai
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/openimsdk/tools/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+// RetryPolicy 请求失败后的重试策略，请求频率超限（429）、服务端错误（5xx）、超时以及网络错误时按照指数退避重试，
+// 其它 4xx 错误不重试。重试全部失败后，ClientImpl 才会切换到备用服务
+type RetryPolicy struct {
+	// MaxAttempts 最多尝试次数，包括第一次请求，为 1 时不重试
+	MaxAttempts int
+	// InitialBackoff 第一次重试前的等待时间，之后每次翻倍
+	InitialBackoff time.Duration
+	// MaxBackoff 单次等待时间的上限
+	MaxBackoff time.Duration
+	// MaxElapsed 包括重试在内的总耗时上限，剩余时间不够等待时不再重试
+	MaxElapsed time.Duration
+}
+
+// DefaultRetryPolicy 默认的重试策略
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     8 * time.Second,
+	MaxElapsed:     60 * time.Second,
+}
+
+// withDefaults 未设置的字段使用 DefaultRetryPolicy 中的值
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+
+	if p.MaxElapsed <= 0 {
+		p.MaxElapsed = DefaultRetryPolicy.MaxElapsed
+	}
+
+	return p
+}
+
+// backoff 第 attempt 次重试（从 1 开始）前的等待时间，在指数退避的基础上增加随机抖动，避免多个客户端同时重试
+// 等待时间在 [d/2, d) 之间，d 为 InitialBackoff * 2^(attempt-1)，不超过 MaxBackoff
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	d = min(d, p.MaxBackoff)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// withRetry 通过负载均衡选择 Client 执行 fn，失败后按照重试策略重试，每次重试都会重新选择 Client
+// 服务端返回了 Retry-After 等限流响应头时，等待时间不小于服务端要求的时间
+func withRetry[T any](ctx context.Context, client *realClientImpl, model string, fn func(ctx context.Context, c *openai.Client) (T, error)) (res T, err error) {
+	policy := client.retryPolicy()
+	deadline := time.Now().Add(policy.MaxElapsed)
+	for attempt := 1; ; attempt++ {
+		var hint *rateLimitHint
+		res, hint, err = withEndpoint(ctx, client.balancer, model, fn)
+		kind := classifyError(err)
+		if err == nil || attempt >= policy.MaxAttempts || !kind.retryable() || ctx.Err() != nil {
+			return res, err
+		}
+
+		wait := policy.backoff(attempt)
+		if retryAfter, _, _ := hint.values(); retryAfter > wait {
+			wait = retryAfter
+		}
+
+		if time.Now().Add(wait).After(deadline) {
+			log.ZWarn(ctx, "openai request failed, retry budget exhausted", err, "model", model, "attempt", attempt, "kind", kind.String(), "wait", wait)
+			return res, err
+		}
+
+		log.ZWarn(ctx, "openai request failed, retry later", err, "model", model, "attempt", attempt, "kind", kind.String(), "wait", wait)
+		select {
+		case <-ctx.Done():
+			return res, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (client *realClientImpl) retryPolicy() RetryPolicy {
+	if client.conf == nil {
+		return DefaultRetryPolicy
+	}
+
+	return client.conf.Retry.withDefaults()
+}
@@ -0,0 +1,178 @@
+package openai
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// fastRetry 测试使用的重试策略，等待时间很短
+var fastRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, MaxElapsed: 5 * time.Second}
+
+func TestRetry_ServerErrorThenSuccess(t *testing.T) {
+	server := newTestServer(t)
+	server.status.Store(http.StatusServiceUnavailable)
+	server.succeedAfter.Store(2)
+
+	client, _ := newTestClient(t, &Config{OpenAIServers: []string{server.URL + "/v1"}, OpenAIKeys: []string{"test-key"}, Retry: fastRetry})
+	if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.hits.Load() != 3 {
+		t.Fatalf("expect 3 attempts, got %d", server.hits.Load())
+	}
+}
+
+func TestRetry_ClientErrorNotRetried(t *testing.T) {
+	server := newTestServer(t)
+	server.status.Store(http.StatusBadRequest)
+
+	client, _ := newTestClient(t, &Config{OpenAIServers: []string{server.URL + "/v1"}, OpenAIKeys: []string{"test-key"}, Retry: fastRetry})
+	if err := chatOnce(client, "gpt-4o-mini"); err == nil || classifyError(err) != kindClient {
+		t.Fatalf("expect a client error, got %v", err)
+	}
+
+	if server.hits.Load() != 1 {
+		t.Fatalf("client errors should not be retried, got %d attempts", server.hits.Load())
+	}
+}
+
+func TestRetry_HonoursRetryAfter(t *testing.T) {
+	server := newTestServer(t)
+	server.status.Store(http.StatusTooManyRequests)
+	server.succeedAfter.Store(1)
+	server.header = http.Header{"Retry-After-Ms": []string{"200"}}
+
+	client, _ := newTestClient(t, &Config{OpenAIServers: []string{server.URL + "/v1"}, OpenAIKeys: []string{"test-key"}, Retry: fastRetry})
+	start := time.Now()
+	if err := chatOnce(client, "gpt-4o-mini"); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("retry should wait for retry-after-ms, waited %s", elapsed)
+	}
+
+	if server.hits.Load() != 2 {
+		t.Fatalf("expect 2 attempts, got %d", server.hits.Load())
+	}
+}
+
+func TestRetry_MaxElapsed(t *testing.T) {
+	server := newTestServer(t)
+	server.status.Store(http.StatusTooManyRequests)
+	server.header = http.Header{"Retry-After": []string{"10"}}
+
+	policy := fastRetry
+	policy.MaxElapsed = time.Second
+	client, _ := newTestClient(t, &Config{OpenAIServers: []string{server.URL + "/v1"}, OpenAIKeys: []string{"test-key"}, Retry: policy})
+
+	start := time.Now()
+	if err := chatOnce(client, "gpt-4o-mini"); classifyError(err) != kindRateLimited {
+		t.Fatalf("expect a rate limit error, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > policy.MaxElapsed || server.hits.Load() != 1 {
+		t.Fatalf("retry should give up when Retry-After exceeds the budget, got %d attempts in %s", server.hits.Load(), elapsed)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, expect := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 10: time.Second} {
+		for i := 0; i < 100; i++ {
+			if wait := policy.backoff(attempt); wait < expect/2 || wait > expect {
+				t.Fatalf("backoff(%d) should be within [%s, %s], got %s", attempt, expect/2, expect, wait)
+			}
+		}
+	}
+}
+
+func TestRateLimitHint_Parse(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		header     http.Header
+		retryAfter time.Duration
+		exhausted  bool
+		reset      time.Duration
+	}{
+		{header: http.Header{"Retry-After": []string{"3"}}, retryAfter: 3 * time.Second},
+		{header: http.Header{"Retry-After": []string{now.Add(time.Minute).Format(http.TimeFormat)}}, retryAfter: time.Minute},
+		{header: http.Header{"Retry-After-Ms": []string{"1500"}, "Retry-After": []string{"3"}}, retryAfter: 1500 * time.Millisecond},
+		{
+			header: http.Header{
+				"X-Ratelimit-Remaining-Requests": []string{"0"},
+				"X-Ratelimit-Reset-Requests":     []string{"1m30s"},
+				"X-Ratelimit-Remaining-Tokens":   []string{"100"},
+				"X-Ratelimit-Reset-Tokens":       []string{"2m"},
+			},
+			exhausted: true,
+			reset:     90 * time.Second,
+		},
+		{header: http.Header{"X-Ratelimit-Remaining-Requests": []string{"10"}, "X-Ratelimit-Reset-Requests": []string{"6m0s"}}},
+	}
+
+	for i, c := range cases {
+		var hint rateLimitHint
+		hint.parse(c.header, now)
+		retryAfter, exhausted, reset := hint.values()
+		if retryAfter != c.retryAfter || exhausted != c.exhausted || reset != c.reset {
+			t.Errorf("case %d: expect (%s, %v, %s), got (%s, %v, %s)", i, c.retryAfter, c.exhausted, c.reset, retryAfter, exhausted, reset)
+		}
+	}
+}
+
+func TestRetry_TranscriptionResendsAudio(t *testing.T) {
+	var (
+		lock   sync.Mutex
+		bodies []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		data, _ := io.ReadAll(file)
+		lock.Lock()
+		bodies = append(bodies, string(data))
+		attempt := len(bodies)
+		lock.Unlock()
+
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"text":"你好"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client, _ := newTestClient(t, &Config{OpenAIServers: []string{server.URL + "/v1"}, OpenAIKeys: []string{"test-key"}, Retry: fastRetry})
+
+	// 不支持 Seek 的 Reader 第一次请求后就被读完，重试时需要重新发送完整的音频内容
+	audio := strings.Repeat("audio", 100)
+	resp, err := client.CreateTranscription(context.Background(), openai.AudioRequest{
+		Model:    openai.Whisper1,
+		FilePath: "audio.mp3",
+		Reader:   io.MultiReader(strings.NewReader(audio)),
+	})
+	if err != nil || resp.Text != "你好" {
+		t.Fatalf("unexpected transcription %+v %v", resp, err)
+	}
+
+	if len(bodies) != 2 || bodies[0] != audio || bodies[1] != audio {
+		t.Fatalf("expect the full audio on every attempt, got %d attempts", len(bodies))
+	}
+}
@@ -0,0 +1,66 @@
+// Package rag 本地知识库：导入的文档切分后通过向量模型生成向量，保存在本地文件中，
+// 对话时检索和用户问题最相关的片段注入到对话上下文中，回复中返回引用的资料
+package rag
+
+import (
+	openai2 "accompany-sdk/ai/openai"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Embedder 把文本转换为向量
+type Embedder interface {
+	// Model 向量模型名称，不同模型生成的向量不能混用
+	Model() string
+	// Embed 生成文本的向量，返回的向量和 texts 一一对应
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// EmbeddingClient 调用 embeddings 接口的客户端，openai.Client 实现了该接口
+type EmbeddingClient interface {
+	CreateEmbeddings(ctx context.Context, request openai.EmbeddingRequest) (openai.EmbeddingResponse, error)
+}
+
+type openAIEmbedder struct {
+	client EmbeddingClient
+	model  string
+}
+
+// NewOpenAIEmbedder 使用 OpenAI 的 embeddings 接口生成向量，model 为空时使用 openai.DefaultEmbeddingModel
+func NewOpenAIEmbedder(client EmbeddingClient, model string) Embedder {
+	if model == "" {
+		model = openai2.DefaultEmbeddingModel
+	}
+
+	return &openAIEmbedder{client: client, model: model}
+}
+
+func (e *openAIEmbedder) Model() string {
+	return e.model
+}
+
+// Embed 超出单次请求限制时由 openai.Client 自动分批请求
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	res, err := e.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: texts,
+		Model: openai.EmbeddingModel(e.model),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create embeddings: %v", err)
+	}
+
+	if len(res.Data) != len(texts) {
+		return nil, fmt.Errorf("create embeddings: expect %d embeddings, got %d", len(texts), len(res.Data))
+	}
+
+	sort.Slice(res.Data, func(i, j int) bool { return res.Data[i].Index < res.Data[j].Index })
+	vectors := make([][]float32, len(res.Data))
+	for i, item := range res.Data {
+		vectors[i] = item.Embedding
+	}
+
+	return vectors, nil
+}
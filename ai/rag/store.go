@@ -0,0 +1,321 @@
+package rag
+
+import (
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/ai/document"
+	"accompany-sdk/pkg/misc"
+	"accompany-sdk/pkg/utils/array"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultChunkTokens 默认每个片段的最大 token 数量
+	DefaultChunkTokens = 300
+	// DefaultTopK 默认检索的片段数量
+	DefaultTopK = 4
+	// indexFileName 知识库索引文件名称
+	indexFileName = "index.gob"
+)
+
+var (
+	ErrDocumentNotFound = errors.New("rag: document not found")
+	ErrEmptyDocument    = errors.New("rag: document is empty")
+	// ErrModelMismatch 知识库中的向量由其它向量模型生成，需要删除文档后重新导入
+	ErrModelMismatch = errors.New("rag: embedding model mismatch")
+)
+
+// Document 知识库中的文档
+type Document struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Chunks 文档切分后的片段数量
+	Chunks int `json:"chunks"`
+	// Tokens 文档的 token 数量
+	Tokens    int   `json:"tokens"`
+	CreatedAt int64 `json:"created_at"`
+}
+
+// passage 文档片段及其向量，向量已经归一化，余弦相似度等于向量点积
+type passage struct {
+	DocumentID string
+	Index      int
+	Text       string
+	Vector     []float32
+}
+
+// index 知识库索引，使用 gob 编码保存在 indexFileName 中
+type index struct {
+	// Model 生成向量使用的模型
+	Model     string
+	Documents []Document
+	Passages  []passage
+}
+
+// Store 文件存储的知识库，每次修改后把整个索引写入文件，检索时在内存中计算相似度
+type Store struct {
+	dir         string
+	embedder    Embedder
+	chunkTokens int
+
+	lock  sync.RWMutex
+	index index
+}
+
+// Open 打开 dir 目录中的知识库，目录不存在时创建
+func Open(dir string, embedder Embedder, chunkTokens int) (*Store, error) {
+	if chunkTokens <= 0 {
+		chunkTokens = DefaultChunkTokens
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{dir: dir, embedder: embedder, chunkTokens: chunkTokens}
+	data, err := os.ReadFile(filepath.Join(dir, indexFileName))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return s, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s.index); err != nil {
+		return nil, fmt.Errorf("decode knowledge index: %v", err)
+	}
+
+	return s, nil
+}
+
+// Documents 返回知识库中的全部文档，按照导入时间排序
+func (s *Store) Documents() []Document {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return array.Map(s.index.Documents, func(item Document, _ int) Document { return item })
+}
+
+// Import 切分文本并生成向量，作为一个新的文档导入到知识库中
+func (s *Store) Import(ctx context.Context, name string, text string) (*Document, error) {
+	if err := s.checkModel(); err != nil {
+		return nil, err
+	}
+
+	chunks, err := document.Split(text, s.embedder.Model(), s.chunkTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chunks) == 0 {
+		return nil, ErrEmptyDocument
+	}
+
+	// 生成向量比较耗时，不持有锁
+	vectors, err := s.embedder.Embed(ctx, array.Map(chunks, func(item document.Chunk, _ int) string { return item.Text }))
+	if err != nil {
+		return nil, err
+	}
+
+	doc := Document{ID: misc.UUID(), Name: name, Chunks: len(chunks), CreatedAt: time.Now().Unix()}
+	passages := make([]passage, len(chunks))
+	for i, chunk := range chunks {
+		doc.Tokens += chunk.Tokens
+		passages[i] = passage{DocumentID: doc.ID, Index: chunk.Index, Text: chunk.Text, Vector: normalize(vectors[i])}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	next := s.index
+	next.Model = s.embedder.Model()
+	next.Documents = append(array.Map(s.index.Documents, func(item Document, _ int) Document { return item }), doc)
+	next.Passages = append(array.Map(s.index.Passages, func(item passage, _ int) passage { return item }), passages...)
+	if err := s.save(next); err != nil {
+		return nil, err
+	}
+
+	s.index = next
+	return &doc, nil
+}
+
+// Delete 从知识库中删除文档
+func (s *Store) Delete(documentID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	next := index{
+		Model:     s.index.Model,
+		Documents: array.Filter(s.index.Documents, func(item Document, _ int) bool { return item.ID != documentID }),
+		Passages:  array.Filter(s.index.Passages, func(item passage, _ int) bool { return item.DocumentID != documentID }),
+	}
+	if len(next.Documents) == len(s.index.Documents) {
+		return ErrDocumentNotFound
+	}
+
+	if len(next.Documents) == 0 {
+		next.Model = ""
+	}
+
+	if err := s.save(next); err != nil {
+		return err
+	}
+
+	s.index = next
+	return nil
+}
+
+// Search 检索和 query 最相关的 k 个片段，按照相似度从高到低排列
+func (s *Store) Search(ctx context.Context, query string, k int) ([]chat.Citation, error) {
+	if err := s.checkModel(); err != nil {
+		return nil, err
+	}
+
+	if k <= 0 || strings.TrimSpace(query) == "" || s.empty() {
+		return nil, nil
+	}
+
+	vectors, err := s.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	queryVector := normalize(vectors[0])
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	scores := make([]float64, len(s.index.Passages))
+	order := make([]int, len(s.index.Passages))
+	for i, p := range s.index.Passages {
+		scores[i] = dot(queryVector, p.Vector)
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+
+	names := make(map[string]string, len(s.index.Documents))
+	for _, doc := range s.index.Documents {
+		names[doc.ID] = doc.Name
+	}
+
+	citations := make([]chat.Citation, 0, k)
+	for _, i := range order[:min(k, len(order))] {
+		p := s.index.Passages[i]
+		citations = append(citations, chat.Citation{
+			Index:        len(citations) + 1,
+			DocumentID:   p.DocumentID,
+			DocumentName: names[p.DocumentID],
+			ChunkIndex:   p.Index,
+			Text:         p.Text,
+			Score:        scores[i],
+		})
+	}
+
+	return citations, nil
+}
+
+// Augment 检索和最后一条用户消息最相关的 k 个片段，作为 system 消息放在对话的最前面
+// 对话的第一条消息是 system 消息时合并到该消息中，兼容只支持一条 system 消息的服务提供商
+// 返回新的请求以及注入的资料，不修改原请求
+func (s *Store) Augment(ctx context.Context, req chat.Request, k int) (chat.Request, []chat.Citation, error) {
+	citations, err := s.Search(ctx, req.Messages.LastUserText(), k)
+	if err != nil || len(citations) == 0 {
+		return req, nil, err
+	}
+
+	texts := make([]string, 0, len(citations)+1)
+	texts = append(texts, "以下是从知识库中检索到的资料，回答时请优先参考这些资料，引用资料时使用 [编号] 标注来源，资料和问题无关时请忽略：")
+	for _, c := range citations {
+		texts = append(texts, fmt.Sprintf("[%d] 《%s》\n%s", c.Index, c.DocumentName, c.Text))
+	}
+
+	knowledge := strings.Join(texts, "\n\n")
+	messages := make(chat.Messages, 0, len(req.Messages)+1)
+	if req.Messages[0].Role == "system" && len(req.Messages[0].MultipartContents) == 0 {
+		system := req.Messages[0]
+		system.Content = knowledge + "\n\n" + system.Content
+		messages = append(append(messages, system), req.Messages[1:]...)
+	} else {
+		messages = append(append(messages, chat.Message{Role: "system", Content: knowledge}), req.Messages...)
+	}
+
+	req.Messages = messages
+	return req, citations, nil
+}
+
+func (s *Store) empty() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return len(s.index.Passages) == 0
+}
+
+func (s *Store) checkModel() error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.index.Model != "" && s.index.Model != s.embedder.Model() {
+		return fmt.Errorf("%w: index is built with %s, current model is %s", ErrModelMismatch, s.index.Model, s.embedder.Model())
+	}
+
+	return nil
+}
+
+// save 先写入临时文件再重命名，避免写入过程中崩溃导致索引文件损坏
+func (s *Store) save(idx index) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(idx); err != nil {
+		return fmt.Errorf("encode knowledge index: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, indexFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, indexFileName))
+}
+
+func normalize(vector []float32) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+
+	if norm == 0 {
+		return vector
+	}
+
+	norm = math.Sqrt(norm)
+	return array.Map(vector, func(item float32, _ int) float32 { return float32(float64(item) / norm) })
+}
+
+func dot(a []float32, b []float32) float64 {
+	var sum float64
+	for i := range a[:min(len(a), len(b))] {
+		sum += float64(a[i]) * float64(b[i])
+	}
+
+	return sum
+}
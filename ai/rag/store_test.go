@@ -0,0 +1,225 @@
+package rag
+
+import (
+	"accompany-sdk/ai/chat"
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// testModel 向量模型名称，同时决定切分文档使用的 Tokenizer，这里使用百度的 Tokenizer，避免依赖 tiktoken 编码文件
+const testModel = "model_ernie_bot"
+
+// keywordEmbedder 按照关键词出现的次数生成向量，用于验证检索结果的排序
+type keywordEmbedder struct {
+	model    string
+	keywords []string
+	calls    int
+}
+
+func newKeywordEmbedder(model string) *keywordEmbedder {
+	return &keywordEmbedder{model: model, keywords: []string{"猫", "狗", "鱼"}}
+}
+
+func (e *keywordEmbedder) Model() string {
+	return e.model
+}
+
+func (e *keywordEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(e.keywords))
+		for j, keyword := range e.keywords {
+			vectors[i][j] = float32(strings.Count(text, keyword))
+		}
+	}
+
+	return vectors, nil
+}
+
+func openTestStore(t *testing.T, dir string, embedder Embedder) *Store {
+	store, err := Open(dir, embedder, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func TestStore_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir, newKeywordEmbedder(testModel))
+
+	pets, err := store.Import(context.Background(), "宠物", "猫喜欢吃鱼\n狗喜欢啃骨头")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fish, err := store.Import(context.Background(), "鱼类", "鱼生活在水里")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pets.Chunks != 2 || pets.Tokens != 11 || fish.Chunks != 1 {
+		t.Errorf("unexpected documents %+v %+v", pets, fish)
+	}
+
+	// 重新打开后文档和片段都还在
+	reopened := openTestStore(t, dir, newKeywordEmbedder(testModel))
+	if docs := reopened.Documents(); len(docs) != 2 || docs[0] != *pets || docs[1] != *fish {
+		t.Fatalf("unexpected documents after reopen: %+v", docs)
+	}
+
+	if citations, err := reopened.Search(context.Background(), "狗", 1); err != nil || len(citations) != 1 || citations[0].DocumentName != "宠物" {
+		t.Errorf("unexpected search result after reopen: %+v %v", citations, err)
+	}
+
+	if err := reopened.Delete(pets.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reopened.Delete(pets.ID); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("expect ErrDocumentNotFound, got %v", err)
+	}
+
+	reopened = openTestStore(t, dir, newKeywordEmbedder(testModel))
+	if docs := reopened.Documents(); len(docs) != 1 || docs[0].ID != fish.ID || len(reopened.index.Passages) != 1 {
+		t.Errorf("deleted document should not be loaded: %+v", docs)
+	}
+
+	if _, err := reopened.Import(context.Background(), "空白", " \n\n "); !errors.Is(err, ErrEmptyDocument) {
+		t.Errorf("expect ErrEmptyDocument, got %v", err)
+	}
+}
+
+func TestStore_Search(t *testing.T) {
+	store := openTestStore(t, t.TempDir(), newKeywordEmbedder(testModel))
+	for _, text := range []string{"猫猫猫狗", "狗狗狗狗", "鱼鱼鱼鱼", "猫猫狗狗"} {
+		if _, err := store.Import(context.Background(), text, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	citations, err := store.Search(context.Background(), "狗", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 余弦相似度：狗狗狗狗 1，猫猫狗狗 0.707，猫猫猫狗 0.316
+	want := []struct {
+		text  string
+		score float64
+	}{
+		{text: "狗狗狗狗", score: 1},
+		{text: "猫猫狗狗", score: 1 / math.Sqrt2},
+		{text: "猫猫猫狗", score: 1 / math.Sqrt(10)},
+	}
+
+	if len(citations) != len(want) {
+		t.Fatalf("want %d citations, got %+v", len(want), citations)
+	}
+
+	for i, c := range citations {
+		if c.Index != i+1 || c.Text != want[i].text || c.DocumentName != want[i].text || math.Abs(c.Score-want[i].score) > 1e-6 {
+			t.Errorf("citation %d: want %s %.3f, got %+v", i, want[i].text, want[i].score, c)
+		}
+	}
+
+	if citations, _ := store.Search(context.Background(), "  ", 3); len(citations) != 0 {
+		t.Errorf("empty query should not be searched, got %+v", citations)
+	}
+}
+
+func TestStore_Augment(t *testing.T) {
+	embedder := newKeywordEmbedder(testModel)
+	store := openTestStore(t, t.TempDir(), embedder)
+	if _, err := store.Import(context.Background(), "宠物", "狗喜欢啃骨头"); err != nil {
+		t.Fatal(err)
+	}
+
+	question := chat.Message{Role: "user", Content: "狗喜欢吃什么？"}
+	cases := []struct {
+		name     string
+		messages chat.Messages
+		// merged 资料合并到原有的第一条 system 消息中
+		merged bool
+	}{
+		{name: "merge into system message", messages: chat.Messages{{Role: "system", Content: "你是一个助手"}, question}, merged: true},
+		{name: "insert system message", messages: chat.Messages{question}},
+		{name: "multipart system message", messages: chat.Messages{{Role: "system", MultipartContents: []*chat.MultipartContent{{Type: "text", Text: "你是一个助手"}}}, question}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := chat.Request{Messages: c.messages}
+			original := c.messages[0].Content
+			augmented, citations, err := store.Augment(context.Background(), req, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(citations) != 1 || !strings.Contains(augmented.Messages[0].Content, "[1] 《宠物》\n狗喜欢啃骨头") {
+				t.Fatalf("unexpected augmented messages %+v", augmented.Messages)
+			}
+
+			wantLen := len(c.messages) + 1
+			if c.merged {
+				wantLen = len(c.messages)
+				if !strings.HasSuffix(augmented.Messages[0].Content, "\n\n你是一个助手") {
+					t.Errorf("system prompt should be kept after the knowledge: %q", augmented.Messages[0].Content)
+				}
+			}
+
+			if len(augmented.Messages) != wantLen || augmented.Messages[len(augmented.Messages)-1].Content != question.Content {
+				t.Errorf("unexpected messages %+v", augmented.Messages)
+			}
+
+			// 不修改原请求
+			if c.messages[0].Content != original {
+				t.Errorf("original request is modified")
+			}
+		})
+	}
+
+	// 没有用户问题时不检索，也不修改请求
+	req := chat.Request{Messages: chat.Messages{{Role: "system", Content: "你是一个助手"}}}
+	calls := embedder.calls
+	if augmented, citations, err := store.Augment(context.Background(), req, 2); err != nil || len(citations) != 0 || augmented.Messages[0].Content != "你是一个助手" || embedder.calls != calls {
+		t.Errorf("unexpected augment result %+v %+v %v", augmented, citations, err)
+	}
+}
+
+func TestStore_ModelMismatch(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir, newKeywordEmbedder(testModel))
+	doc, err := store.Import(context.Background(), "宠物", "狗喜欢啃骨头")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := newKeywordEmbedder("model_ernie_speed_8k")
+	mismatched := openTestStore(t, dir, other)
+	if _, err := mismatched.Import(context.Background(), "鱼类", "鱼生活在水里"); !errors.Is(err, ErrModelMismatch) {
+		t.Errorf("import: expect ErrModelMismatch, got %v", err)
+	}
+
+	if _, err := mismatched.Search(context.Background(), "狗", 1); !errors.Is(err, ErrModelMismatch) {
+		t.Errorf("search: expect ErrModelMismatch, got %v", err)
+	}
+
+	if other.calls != 0 {
+		t.Errorf("embedder should not be called with mismatched model, got %d calls", other.calls)
+	}
+
+	// 删除全部文档后可以使用新的模型重新导入
+	if err := mismatched.Delete(doc.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mismatched.Import(context.Background(), "鱼类", "鱼生活在水里"); err != nil {
+		t.Errorf("import after clearing the store: %v", err)
+	}
+}
@@ -0,0 +1,296 @@
+package zhipu
+
+import (
+	"accompany-sdk/ai/catalog"
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/misc"
+	"accompany-sdk/pkg/ternary"
+	"accompany-sdk/pkg/utils/array"
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	ModelGLM4      = "glm-4"
+	ModelGLM4V     = "glm-4v"
+	ModelGLM3Turbo = "glm-3-turbo"
+)
+
+// samplingLimits 智谱 AI 采样参数取值范围，不支持 presence_penalty 和 frequency_penalty
+// https://open.bigmodel.cn/dev/api#glm-4
+var samplingLimits = chat.SamplingLimits{
+	TemperatureMin: 0.01, TemperatureMax: 0.99,
+	TopPMin: 0.01, TopPMax: 0.99,
+	MaxStop: 1,
+}
+
+// ZhipuChat 基于智谱 AI 的对话接口实现 chat.Chat 接口
+type ZhipuChat struct {
+	ai *ZhipuAI
+}
+
+func NewZhipuChat(ai *ZhipuAI) *ZhipuChat {
+	return &ZhipuChat{ai: ai}
+}
+
+// isVisionModel 是否为支持图片输入的模型，优先使用模型目录中的配置
+func isVisionModel(model string) bool {
+	if m, ok := catalog.Lookup(model); ok {
+		return m.Vision
+	}
+
+	return strings.HasPrefix(model, ModelGLM4V)
+}
+
+// supportSystemMessage 是否支持系统消息，优先使用模型目录中的配置
+func supportSystemMessage(model string) bool {
+	if m, ok := catalog.Lookup(model); ok {
+		return m.SystemMessage
+	}
+
+	return !strings.HasPrefix(model, ModelGLM4V)
+}
+
+func (zc *ZhipuChat) initRequest(req chat.Request) (*ChatRequest, error) {
+	req.Model = strings.TrimPrefix(req.Model, "zhipu:")
+	req = req.ClampSampling(samplingLimits)
+	if err := chat.CheckToolSupport(req); err != nil {
+		return nil, err
+	}
+
+	if err := chat.CheckSingleChoice(req); err != nil {
+		return nil, err
+	}
+
+	vision := isVisionModel(req.Model)
+	systemSupported := supportSystemMessage(req.Model)
+
+	var systemMessages []string
+	messages := make([]ChatMessage, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		// GLM-4V 不支持 system 角色的消息，合并到第一条用户消息中
+		if !systemSupported && msg.Role == "system" {
+			systemMessages = append(systemMessages, msg.Content)
+			continue
+		}
+
+		if len(msg.MultipartContents) == 0 {
+			messages = append(messages, ChatMessage{
+				Role:       msg.Role,
+				Content:    msg.Content,
+				ToolCalls:  toToolCalls(msg.ToolCalls),
+				ToolCallID: msg.ToolCallID,
+			})
+			continue
+		}
+
+		// 非视觉模型只保留文本内容
+		if !vision {
+			texts := make([]string, 0, len(msg.MultipartContents))
+			for _, part := range msg.MultipartContents {
+				if part.Type == "text" && part.Text != "" {
+					texts = append(texts, part.Text)
+				}
+			}
+
+			messages = append(messages, ChatMessage{Role: msg.Role, Content: strings.Join(texts, "\n")})
+			continue
+		}
+
+		contents := make([]MultipartContent, 0, len(msg.MultipartContents))
+		for _, part := range msg.MultipartContents {
+			switch part.Type {
+			case "text":
+				contents = append(contents, MultipartContent{Type: "text", Text: part.Text})
+			case "image_url":
+				if part.ImageURL == nil || part.ImageURL.URL == "" {
+					continue
+				}
+
+				url := part.ImageURL.URL
+				// 智谱 AI 的 base64 图片数据不能包含 data:image/xxx;base64, 前缀
+				if strings.HasPrefix(url, "data:") {
+					url = misc.RemoveImageBase64Prefix(url)
+				}
+
+				contents = append(contents, MultipartContent{Type: "image_url", ImageURL: &ImageURL{URL: url}})
+			}
+		}
+
+		messages = append(messages, ChatMessage{Role: msg.Role, Content: contents})
+	}
+
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("zhipu: messages is empty")
+	}
+
+	if len(systemMessages) > 0 {
+		mergeSystemMessages(messages, strings.Join(systemMessages, "\n\n"))
+	}
+
+	ret := &ChatRequest{
+		Model:     req.Model,
+		Messages:  messages,
+		MaxTokens: req.MaxTokens,
+
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stop:        req.Stop,
+	}
+
+	// 智谱 AI 只支持由模型决定是否调用工具，none 时不传递工具，required 以及指定函数名称时由模型自行决定
+	if req.ToolChoice != chat.ToolChoiceNone {
+		ret.Tools = array.Map(
+			array.Filter(req.Tools, func(item chat.Tool, _ int) bool { return item.Function != nil }),
+			func(item chat.Tool, _ int) Tool {
+				return Tool{Type: chat.ToolTypeFunction, Function: &Function{
+					Name:        item.Function.Name,
+					Description: item.Function.Description,
+					Parameters:  item.Function.Parameters,
+				}}
+			},
+		)
+	}
+
+	if len(ret.Tools) > 0 {
+		ret.ToolChoice = chat.ToolChoiceAuto
+	}
+
+	return ret, nil
+}
+
+func toToolCalls(calls []chat.ToolCall) []ToolCall {
+	return array.Map(calls, func(item chat.ToolCall, _ int) ToolCall {
+		return ToolCall{
+			ID:       item.ID,
+			Type:     ternary.If(item.Type != "", item.Type, chat.ToolTypeFunction),
+			Function: FunctionCall{Name: item.Function.Name, Arguments: item.Function.Arguments},
+		}
+	})
+}
+
+func fromToolCalls(calls []ToolCall) []chat.ToolCall {
+	return array.Map(calls, func(item ToolCall, _ int) chat.ToolCall {
+		return chat.ToolCall{
+			Index:    item.Index,
+			ID:       item.ID,
+			Type:     item.Type,
+			Function: chat.FunctionCall{Name: item.Function.Name, Arguments: item.Function.Arguments},
+		}
+	})
+}
+
+// mergeSystemMessages 把系统提示语合并到第一条用户消息的开头
+func mergeSystemMessages(messages []ChatMessage, system string) {
+	for i, msg := range messages {
+		if msg.Role != "user" {
+			continue
+		}
+
+		switch content := msg.Content.(type) {
+		case string:
+			messages[i].Content = system + "\n\n" + content
+		case []MultipartContent:
+			messages[i].Content = append([]MultipartContent{{Type: "text", Text: system}}, content...)
+		}
+
+		return
+	}
+}
+
+func (zc *ZhipuChat) Chat(ctx context.Context, req chat.Request) (*chat.Response, error) {
+	zhipuReq, err := zc.initRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := zc.ai.Chat(ctx, *zhipuReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.Choices) == 0 || res.Choices[0].Message == nil {
+		return nil, fmt.Errorf("zhipu: empty response")
+	}
+
+	text, _ := res.Choices[0].Message.Content.(string)
+	return &chat.Response{
+		Text:         text,
+		ToolCalls:    fromToolCalls(res.Choices[0].Message.ToolCalls),
+		FinishReason: res.Choices[0].FinishReason,
+		InputTokens:  res.Usage.PromptTokens,
+		OutputTokens: res.Usage.CompletionTokens,
+	}, nil
+}
+
+func (zc *ZhipuChat) ChatStream(ctx context.Context, req chat.Request) (<-chan chat.Response, error) {
+	zhipuReq, err := zc.initRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := zc.ai.ChatStream(ctx, *zhipuReq)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(chan chat.Response)
+	go func() {
+		defer close(res)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case data, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				if data.Error != nil {
+					select {
+					case <-ctx.Done():
+					case res <- chat.Response{Error: data.Error.Message, ErrorCode: data.Error.Code}:
+					}
+					return
+				}
+
+				var text, finishReason string
+				var toolCalls []chat.ToolCall
+				if len(data.Choices) > 0 {
+					finishReason = data.Choices[0].FinishReason
+					if data.Choices[0].Delta != nil {
+						text, _ = data.Choices[0].Delta.Content.(string)
+						toolCalls = fromToolCalls(data.Choices[0].Delta.ToolCalls)
+					}
+				}
+
+				// 智谱 AI 只在最后一个数据块中返回 usage
+				if text == "" && len(toolCalls) == 0 && finishReason == "" && data.Usage.TotalTokens == 0 {
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case res <- chat.Response{
+					Text:         text,
+					ToolCalls:    toolCalls,
+					FinishReason: finishReason,
+					InputTokens:  data.Usage.PromptTokens,
+					OutputTokens: data.Usage.CompletionTokens,
+				}:
+				}
+			}
+		}
+	}()
+
+	return res, nil
+}
+
+// MaxContextLength 从模型目录中获取模型的最大上下文长度，未知模型返回 8000
+// https://open.bigmodel.cn/dev/howuse/model
+func (zc *ZhipuChat) MaxContextLength(model string) int {
+	return catalog.ContextWindow(strings.TrimPrefix(model, "zhipu:"), 8000)
+}
@@ -0,0 +1,129 @@
+package zhipu
+
+import (
+	"accompany-sdk/ai/chat"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestServer(t *testing.T, handler func(w http.ResponseWriter, req ChatRequest)) *ZhipuChat {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		handler(w, req)
+	}))
+	t.Cleanup(server.Close)
+
+	ai, err := New(server.URL, "test-id.test-secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewZhipuChat(ai)
+}
+
+var weatherTool = chat.Tool{Type: chat.ToolTypeFunction, Function: &chat.FunctionDefinition{Name: "get_weather", Parameters: map[string]any{"type": "object"}}}
+
+func TestZhipuChat_Tools(t *testing.T) {
+	zc := newTestServer(t, func(w http.ResponseWriter, req ChatRequest) {
+		if len(req.Tools) != 1 || req.Tools[0].Function.Name != "get_weather" || req.ToolChoice != chat.ToolChoiceAuto {
+			t.Errorf("unexpected tools: %+v %q", req.Tools, req.ToolChoice)
+		}
+
+		if len(req.Messages) != 3 || len(req.Messages[1].ToolCalls) != 1 || req.Messages[1].ToolCalls[0].ID != "call_1" || req.Messages[2].ToolCallID != "call_1" {
+			t.Fatalf("unexpected messages: %+v", req.Messages)
+		}
+
+		_, _ = w.Write([]byte(`{"choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","content":"","tool_calls":[{"id":"call_2","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"上海\"}"}}]}}],"usage":{"prompt_tokens":20,"completion_tokens":8}}`))
+	})
+
+	res, err := zc.Chat(context.Background(), chat.Request{
+		Model: "zhipu:glm-4",
+		Messages: chat.Messages{
+			{Role: "user", Content: "北京天气怎么样？"},
+			{Role: "assistant", ToolCalls: []chat.ToolCall{{ID: "call_1", Function: chat.FunctionCall{Name: "get_weather", Arguments: `{"city":"北京"}`}}}},
+			{Role: "tool", ToolCallID: "call_1", Content: "晴"},
+		},
+		Tools: []chat.Tool{weatherTool},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.FinishReason != chat.FinishReasonToolCalls || len(res.ToolCalls) != 1 || res.ToolCalls[0].Function.Arguments != `{"city":"上海"}` {
+		t.Errorf("unexpected response: %+v", res)
+	}
+}
+
+func TestZhipuChat_ChatStreamTools(t *testing.T) {
+	zc := newTestServer(t, func(w http.ResponseWriter, req ChatRequest) {
+		chunks := []string{
+			`{"choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"北京\"}"}}]}}]}`,
+			`{"choices":[{"index":0,"finish_reason":"tool_calls","delta":{"role":"assistant","content":""}}],"usage":{"prompt_tokens":20,"completion_tokens":8,"total_tokens":28}}`,
+		}
+		for _, chunk := range chunks {
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+
+	stream, err := zc.ChatStream(context.Background(), chat.Request{
+		Model:    "glm-4",
+		Messages: chat.Messages{{Role: "user", Content: "北京天气怎么样？"}},
+		Tools:    []chat.Tool{weatherTool},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []chat.ToolCall
+	var reason string
+	for data := range stream {
+		calls = chat.MergeToolCallDeltas(calls, data.ToolCalls)
+		if data.FinishReason != "" {
+			reason = data.FinishReason
+		}
+	}
+
+	if reason != chat.FinishReasonToolCalls || len(calls) != 1 || calls[0].ID != "call_1" || calls[0].Function.Arguments != `{"city":"北京"}` {
+		t.Errorf("unexpected tool calls %+v, finish reason %q", calls, reason)
+	}
+}
+
+func TestZhipuChat_ToolChoiceNone(t *testing.T) {
+	zc := newTestServer(t, func(w http.ResponseWriter, req ChatRequest) {
+		if len(req.Tools) != 0 || req.ToolChoice != "" {
+			t.Errorf("tools should not be sent with tool choice none, got %+v", req.Tools)
+		}
+
+		_, _ = w.Write([]byte(`{"choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"你好"}}]}`))
+	})
+
+	res, err := zc.Chat(context.Background(), chat.Request{
+		Model:      "glm-4",
+		Messages:   chat.Messages{{Role: "user", Content: "你好"}},
+		Tools:      []chat.Tool{weatherTool},
+		ToolChoice: chat.ToolChoiceNone,
+	})
+	if err != nil || res.Text != "你好" {
+		t.Fatalf("unexpected response: %+v %v", res, err)
+	}
+
+	// GLM-4V 不支持工具调用
+	_, err = zc.Chat(context.Background(), chat.Request{
+		Model:    ModelGLM4V,
+		Messages: chat.Messages{{Role: "user", Content: "你好"}},
+		Tools:    []chat.Tool{weatherTool},
+	})
+	if !errors.Is(err, chat.ErrToolNotSupported) {
+		t.Errorf("expect ErrToolNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+package zhipu
+
+import "accompany-sdk/ai/chat"
+
+// imageTokens GLM-4V 每张图片固定占用的 token 数量
+const imageTokens = 1047
+
+// Tokenizer 智谱 GLM 模型的 Tokenizer，文本使用 tiktoken 近似计算，图片按照固定的 token 数量计算
+type Tokenizer struct {
+	chat.TiktokenTokenizer
+}
+
+func (Tokenizer) CountImage(string, *chat.ImageURL) int {
+	return imageTokens
+}
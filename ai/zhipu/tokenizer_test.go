@@ -0,0 +1,26 @@
+package zhipu
+
+import (
+	"accompany-sdk/ai/chat"
+	"testing"
+)
+
+func TestTokenizer(t *testing.T) {
+	// 图片按照固定的 token 数量计算，不需要获取图片尺寸
+	if got := (Tokenizer{}).CountImage(ModelGLM4V, &chat.ImageURL{URL: "https://example.com/a.png"}); got != imageTokens {
+		t.Errorf("want %d, got %d", imageTokens, got)
+	}
+
+	got, err := Tokenizer{}.CountText(ModelGLM4V, "你好，世界")
+	if err != nil {
+		t.Skipf("encoding is not available: %v", err)
+	}
+
+	if want, _ := (chat.TiktokenTokenizer{}).CountText(ModelGLM4V, "你好，世界"); got != want || got == 0 {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	if (Tokenizer{}).MessageOverhead(ModelGLM4V) != (chat.TiktokenTokenizer{}).MessageOverhead(ModelGLM4V) {
+		t.Errorf("unexpected message overhead")
+	}
+}
@@ -0,0 +1,334 @@
+package zhipu
+
+import (
+	"accompany-sdk/pkg/misc"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultServer 智谱 AI 默认服务地址
+	DefaultServer = "https://open.bigmodel.cn/api/paas/v4"
+	// tokenTTL 鉴权 token 有效期
+	tokenTTL = 30 * time.Minute
+)
+
+var ErrInvalidAPIKey = errors.New("zhipu: invalid api key, should be in format {id}.{secret}")
+
+type ZhipuAI struct {
+	server string
+	id     string
+	secret string
+	client *http.Client
+
+	lock        sync.Mutex
+	token       string
+	tokenExpire time.Time
+}
+
+// New 创建智谱 AI 客户端，apiKey 格式为 {id}.{secret}，server 为空时使用默认服务地址
+func New(server, apiKey string, client *http.Client) (*ZhipuAI, error) {
+	segs := strings.SplitN(apiKey, ".", 2)
+	if len(segs) != 2 || segs[0] == "" || segs[1] == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if server == "" {
+		server = DefaultServer
+	}
+
+	if client == nil {
+		client = misc.StreamHTTPClient(nil)
+	}
+
+	return &ZhipuAI{
+		server: strings.TrimSuffix(server, "/"),
+		id:     segs[0],
+		secret: segs[1],
+		client: client,
+	}, nil
+}
+
+// authToken 生成 JWT 鉴权 token，token 在过期前会被复用
+// https://open.bigmodel.cn/dev/api#nosdk
+func (ai *ZhipuAI) authToken() (string, error) {
+	ai.lock.Lock()
+	defer ai.lock.Unlock()
+
+	now := time.Now()
+	if ai.token != "" && now.Add(time.Minute).Before(ai.tokenExpire) {
+		return ai.token, nil
+	}
+
+	expire := now.Add(tokenTTL)
+	header, err := json.Marshal(map[string]any{"alg": "HS256", "sign_type": "SIGN"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"api_key":   ai.id,
+		"exp":       expire.UnixMilli(),
+		"timestamp": now.UnixMilli(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, []byte(ai.secret))
+	mac.Write([]byte(unsigned))
+
+	ai.token = unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	ai.tokenExpire = expire
+
+	return ai.token, nil
+}
+
+type ChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream,omitempty"`
+	// MaxTokens 模型输出最大 tokens
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// Temperature 采样温度，取值范围 (0, 1)，不能等于 0 或 1
+	Temperature *float64 `json:"temperature,omitempty"`
+	// TopP 核采样概率，取值范围 (0, 1)，不能等于 0 或 1
+	TopP *float64 `json:"top_p,omitempty"`
+	// Stop 停止词，目前仅支持单个停止词
+	Stop []string `json:"stop,omitempty"`
+	// Tools 模型可以调用的工具
+	Tools []Tool `json:"tools,omitempty"`
+	// ToolChoice 工具调用策略，目前只支持 auto
+	ToolChoice string `json:"tool_choice,omitempty"`
+}
+
+// Tool 工具定义，目前只支持 function 类型
+type Tool struct {
+	Type     string    `json:"type"`
+	Function *Function `json:"function,omitempty"`
+}
+
+type Function struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Parameters 函数参数，JSON Schema 格式
+	Parameters any `json:"parameters,omitempty"`
+}
+
+type ChatMessage struct {
+	// Role 可选值为 system/user/assistant/tool
+	Role string `json:"role"`
+	// Content 普通消息为 string，GLM-4V 的多模态消息为 []MultipartContent
+	Content any `json:"content"`
+	// ToolCalls assistant 消息中模型发起的工具调用
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID tool 消息对应的工具调用 ID
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+type ToolCall struct {
+	// Index 流式响应中工具调用的序号
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name string `json:"name,omitempty"`
+	// Arguments JSON 格式的函数参数
+	Arguments string `json:"arguments,omitempty"`
+}
+
+type MultipartContent struct {
+	// Type 可选值为 text/image_url
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+type ImageURL struct {
+	// URL 图片地址或者 base64 编码的图片数据（不包含 data:image/xxx;base64, 前缀）
+	URL string `json:"url"`
+}
+
+type ChatResponse struct {
+	ID      string       `json:"id,omitempty"`
+	Created int64        `json:"created,omitempty"`
+	Model   string       `json:"model,omitempty"`
+	Choices []ChatChoice `json:"choices,omitempty"`
+	Usage   Usage        `json:"usage,omitempty"`
+	Error   *Error       `json:"error,omitempty"`
+}
+
+type ChatChoice struct {
+	Index int `json:"index"`
+	// FinishReason 可选值为 stop/length/tool_calls/sensitive/network_error
+	FinishReason string       `json:"finish_reason,omitempty"`
+	Message      *ChatMessage `json:"message,omitempty"`
+	Delta        *ChatMessage `json:"delta,omitempty"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens,omitempty"`
+	CompletionTokens int `json:"completion_tokens,omitempty"`
+	TotalTokens      int `json:"total_tokens,omitempty"`
+}
+
+type Error struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("zhipu: [%s] %s", e.Code, e.Message)
+}
+
+func (ai *ZhipuAI) newRequest(ctx context.Context, req ChatRequest) (*http.Request, error) {
+	token, err := ai.authToken()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, ai.server+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	return httpReq, nil
+}
+
+func parseErrorResponse(resp *http.Response) error {
+	data, _ := io.ReadAll(resp.Body)
+
+	var ret ChatResponse
+	if err := json.Unmarshal(data, &ret); err == nil && ret.Error != nil {
+		return ret.Error
+	}
+
+	return fmt.Errorf("zhipu: request failed, status code: %d, body: %s", resp.StatusCode, string(data))
+}
+
+// Chat 以请求-响应的方式进行对话
+func (ai *ZhipuAI) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	req.Stream = false
+	httpReq, err := ai.newRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpResp, err := ai.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, parseErrorResponse(httpResp)
+	}
+
+	var ret ChatResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&ret); err != nil {
+		return nil, fmt.Errorf("zhipu: decode response failed: %w", err)
+	}
+
+	return &ret, nil
+}
+
+// ChatStream 以流的方式进行对话，返回的 channel 在流结束或者出错后关闭
+func (ai *ZhipuAI) ChatStream(ctx context.Context, req ChatRequest) (<-chan ChatResponse, error) {
+	req.Stream = true
+	httpReq, err := ai.newRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("Cache-Control", "no-cache")
+
+	httpResp, err := ai.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		defer httpResp.Body.Close()
+		return nil, parseErrorResponse(httpResp)
+	}
+
+	res := make(chan ChatResponse)
+	go func() {
+		defer func() {
+			_ = httpResp.Body.Close()
+			close(res)
+		}()
+
+		reader := bufio.NewReader(httpResp.Body)
+		for {
+			data, err := reader.ReadBytes('\n')
+			if err != nil {
+				if err == io.EOF {
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+				case res <- ChatResponse{Error: &Error{Code: "READ_STREAM_FAILED", Message: err.Error()}}:
+				}
+				return
+			}
+
+			dataStr := strings.TrimSpace(string(data))
+			if !strings.HasPrefix(dataStr, "data:") {
+				continue
+			}
+
+			dataStr = strings.TrimSpace(dataStr[5:])
+			if dataStr == "[DONE]" {
+				return
+			}
+
+			var chatResponse ChatResponse
+			if err := json.Unmarshal([]byte(dataStr), &chatResponse); err != nil {
+				select {
+				case <-ctx.Done():
+				case res <- ChatResponse{Error: &Error{Code: "INVALID_STREAM_DATA", Message: err.Error()}}:
+				}
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case res <- chatResponse:
+				if chatResponse.Error != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return res, nil
+}
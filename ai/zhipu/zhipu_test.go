@@ -0,0 +1,172 @@
+package zhipu
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew_InvalidAPIKey(t *testing.T) {
+	for _, key := range []string{"", "no-dot", ".secret", "id."} {
+		if _, err := New("", key, nil); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("%q: expect ErrInvalidAPIKey, got %v", key, err)
+		}
+	}
+
+	ai, err := New("", "id.secret.with.dots", nil)
+	if err != nil || ai.server != DefaultServer || ai.id != "id" || ai.secret != "secret.with.dots" {
+		t.Fatalf("unexpected client %+v %v", ai, err)
+	}
+}
+
+// verifyToken 校验 JWT 签名并返回 header 和 payload
+func verifyToken(t *testing.T, token, secret string) (map[string]any, map[string]any) {
+	segs := strings.Split(token, ".")
+	if len(segs) != 3 {
+		t.Fatalf("invalid token %q", token)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(segs[0] + "." + segs[1]))
+	if base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) != segs[2] {
+		t.Fatal("invalid token signature")
+	}
+
+	var header, payload map[string]any
+	for i, v := range []*map[string]any{&header, &payload} {
+		data, err := base64.RawURLEncoding.DecodeString(segs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return header, payload
+}
+
+func TestZhipuAI_AuthToken(t *testing.T) {
+	ai, _ := New("", "test-id.test-secret", nil)
+
+	start := time.Now()
+	token, err := ai.authToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, payload := verifyToken(t, token, "test-secret")
+	if header["alg"] != "HS256" || header["sign_type"] != "SIGN" {
+		t.Errorf("unexpected header %v", header)
+	}
+
+	// 时间戳为毫秒
+	timestamp, exp := int64(payload["timestamp"].(float64)), int64(payload["exp"].(float64))
+	if payload["api_key"] != "test-id" || timestamp < start.UnixMilli() || exp-timestamp != tokenTTL.Milliseconds() {
+		t.Errorf("unexpected payload %v", payload)
+	}
+
+	// 过期前复用 token，即将过期时重新生成
+	if again, _ := ai.authToken(); again != token {
+		t.Error("token should be reused before it expires")
+	}
+
+	ai.tokenExpire = time.Now().Add(30 * time.Second)
+	if _, err := ai.authToken(); err != nil || time.Until(ai.tokenExpire) < tokenTTL-time.Minute {
+		t.Errorf("token should be renewed before it expires, expires at %s", ai.tokenExpire)
+	}
+}
+
+func newStreamServer(t *testing.T, handler func(w http.ResponseWriter)) *ZhipuAI {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+
+		verifyToken(t, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), "test-secret")
+		handler(w)
+	}))
+	t.Cleanup(server.Close)
+
+	ai, err := New(server.URL+"/", "test-id.test-secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ai
+}
+
+func readChatStream(t *testing.T, ai *ZhipuAI) []ChatResponse {
+	stream, err := ai.ChatStream(context.Background(), ChatRequest{Model: "glm-4", Messages: []ChatMessage{{Role: "user", Content: "你好"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var responses []ChatResponse
+	for data := range stream {
+		responses = append(responses, data)
+	}
+
+	return responses
+}
+
+func TestZhipuAI_ChatStream(t *testing.T) {
+	ai := newStreamServer(t, func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, text := range []string{"你", "好"} {
+			_, _ = fmt.Fprintf(w, "data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":%q}}]}\n\n", text)
+		}
+
+		// 非 data 行被忽略，[DONE] 之后的内容不再读取
+		_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"finish_reason\":\"stop\",\"delta\":{\"role\":\"assistant\",\"content\":\"\"}}],\"usage\":{\"prompt_tokens\":6,\"completion_tokens\":2}}\n\n")
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"id\":\"2\"}\n\n")
+	})
+
+	responses := readChatStream(t, ai)
+	if len(responses) != 3 {
+		t.Fatalf("expect 3 responses, got %+v", responses)
+	}
+
+	var text string
+	for _, res := range responses {
+		text += res.Choices[0].Delta.Content.(string)
+	}
+
+	if last := responses[2]; text != "你好" || last.Choices[0].FinishReason != "stop" || last.Usage.PromptTokens != 6 {
+		t.Errorf("unexpected stream %q %+v", text, last)
+	}
+}
+
+func TestZhipuAI_ChatStreamError(t *testing.T) {
+	// 无法解析的数据返回错误后结束
+	ai := newStreamServer(t, func(w http.ResponseWriter) {
+		_, _ = fmt.Fprint(w, "data: {invalid\n\n")
+	})
+
+	if responses := readChatStream(t, ai); len(responses) != 1 || responses[0].Error == nil || responses[0].Error.Code != "INVALID_STREAM_DATA" {
+		t.Errorf("expect an invalid stream data error, got %+v", responses)
+	}
+
+	// 建立连接时返回的错误解析为 *Error
+	ai = newStreamServer(t, func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = fmt.Fprint(w, `{"error":{"code":"1302","message":"您当前使用该API的并发数过高"}}`)
+	})
+
+	var apiErr *Error
+	if _, err := ai.ChatStream(context.Background(), ChatRequest{Model: "glm-4"}); !errors.As(err, &apiErr) || apiErr.Code != "1302" {
+		t.Errorf("expect an api error, got %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+package ai_struct
+
+// AnthropicConfig Anthropic Claude 相关的配置选项
+type AnthropicConfig struct {
+	// EnableAnthropic 控制是否启用 Anthropic Claude 服务。为 true 时表示启用。
+	EnableAnthropic bool `json:"enable_anthropic" yaml:"enable_anthropic"`
+
+	// AnthropicServer Anthropic 服务地址，为空时使用默认地址 https://api.anthropic.com。
+	AnthropicServer string `json:"anthropic_server" yaml:"anthropic_server"`
+
+	// AnthropicAPIKey Anthropic API 的密钥。
+	AnthropicAPIKey string `json:"anthropic_api_key" yaml:"anthropic_api_key"`
+
+	// AnthropicAutoProxy 控制是否为 Anthropic 启用自动代理，代理配置使用 OpenAiConfig 中的 ProxyConfig。
+	AnthropicAutoProxy bool `json:"anthropic_auto_proxy" yaml:"anthropic_auto_proxy"`
+}
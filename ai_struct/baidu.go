@@ -0,0 +1,19 @@
+package ai_struct
+
+// BaiduConfig 百度文心千帆相关的配置选项
+type BaiduConfig struct {
+	// EnableBaiduWXAI 控制是否启用百度文心千帆服务。为 true 时表示启用。
+	EnableBaiduWXAI bool `json:"enable_baiduwx_ai" yaml:"enable_baiduwx_ai"`
+
+	// BaiduWXKey 百度文心千帆应用的 API Key。
+	BaiduWXKey string `json:"baiduwx_key" yaml:"baiduwx_key"`
+
+	// BaiduWXSecret 百度文心千帆应用的 Secret Key。
+	BaiduWXSecret string `json:"baiduwx_secret" yaml:"baiduwx_secret"`
+
+	// BaiduWXModels 启用的模型列表（如 model_ernie_bot），为空时启用全部支持的模型。
+	BaiduWXModels []string `json:"baiduwx_models" yaml:"baiduwx_models"`
+
+	// BaiduWXServer 百度文心千帆服务地址，为空时使用默认地址 https://aip.baidubce.com。
+	BaiduWXServer string `json:"baiduwx_server" yaml:"baiduwx_server"`
+}
@@ -0,0 +1,52 @@
+package ai_struct
+
+// CatalogConfig 模型目录配置，用于新增模型或者覆盖内置模型的元数据
+type CatalogConfig struct {
+	// Models 自定义的模型列表，与内置模型同名时覆盖内置模型
+	Models []ModelConfig `json:"models" yaml:"models"`
+}
+
+// ModelConfig 单个模型的元数据
+type ModelConfig struct {
+	// ID 模型名称，不包含服务提供商前缀
+	ID string `json:"id" yaml:"id"`
+
+	// Provider 服务提供商，如 openai、baidu、anthropic、zhipu 或者本地服务的名称。
+	Provider string `json:"provider" yaml:"provider"`
+
+	// ContextWindow 可用于输入的最大上下文长度（需要为输出预留 token）。
+	ContextWindow int `json:"context_window" yaml:"context_window"`
+
+	// MaxOutput 最大输出 token 数。
+	MaxOutput int `json:"max_output" yaml:"max_output"`
+
+	// Vision 是否支持图片输入。
+	Vision bool `json:"vision" yaml:"vision"`
+
+	// SystemMessage 是否支持系统消息。
+	SystemMessage bool `json:"system_message" yaml:"system_message"`
+
+	// Tools 是否支持工具调用。
+	Tools bool `json:"tools" yaml:"tools"`
+
+	// Endpoint 模型对应的服务端点，百度文心千帆的模型必须配置，可以为完整的 URL。
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+
+	// InputPrice 每千输入 tokens 的价格。
+	InputPrice float64 `json:"input_price" yaml:"input_price"`
+
+	// OutputPrice 每千输出 tokens 的价格。
+	OutputPrice float64 `json:"output_price" yaml:"output_price"`
+
+	// Currency 价格的货币单位，USD 或者 CNY。
+	Currency string `json:"currency" yaml:"currency"`
+
+	// Aliases 模型别名。
+	Aliases []string `json:"aliases" yaml:"aliases"`
+
+	// Downgrade 上下文较短时可以替换使用的更便宜的模型。
+	Downgrade string `json:"downgrade" yaml:"downgrade"`
+
+	// DowngradeThreshold token 数不超过该值时使用替换模型，为 0 时使用替换模型的上下文长度。
+	DowngradeThreshold int `json:"downgrade_threshold" yaml:"downgrade_threshold"`
+}
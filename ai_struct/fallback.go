@@ -0,0 +1,23 @@
+package ai_struct
+
+// FallbackConfig 对话降级链配置，请求按顺序在各个层级之间降级，每一层可以使用不同服务提供商的模型。
+type FallbackConfig struct {
+	// FallbackTiers 降级链的层级，为空时不使用降级链。第一层通常不指定模型，即使用请求中的模型。
+	FallbackTiers []FallbackTierConfig `json:"fallback_tiers" yaml:"fallback_tiers"`
+}
+
+// FallbackTierConfig 降级链中的一层
+type FallbackTierConfig struct {
+	// Name 层级名称，对话结果中的 tier 为返回结果的层级名称。
+	Name string `json:"name" yaml:"name"`
+
+	// Model 本层使用的模型，可以带服务提供商前缀，如 anthropic:claude-3-5-sonnet-20240620，为空时使用请求中的模型。
+	Model string `json:"model" yaml:"model"`
+
+	// FallbackOn 本层出现哪些分类的错误时切换到下一层，可选值为 rate_limited、quota_exceeded、auth、server、timeout、network、client。
+	// 为空时除了请求参数错误（client）之外的错误都会切换。
+	FallbackOn []string `json:"fallback_on" yaml:"fallback_on"`
+
+	// LatencyBudget 本层返回结果的最长等待时间，单位毫秒，超时后取消请求并切换到下一层，为 0 时不限制。流式对话只限制建立连接的时间。
+	LatencyBudget int `json:"latency_budget" yaml:"latency_budget"`
+}
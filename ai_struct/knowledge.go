@@ -0,0 +1,13 @@
+package ai_struct
+
+// KnowledgeConfig 本地知识库相关的配置选项，知识库保存在 SDKConfig.DataDir 下，每个用户一个目录，需要启用 OpenAI 生成向量。
+type KnowledgeConfig struct {
+	// EnableKnowledge 控制是否启用本地知识库。
+	EnableKnowledge bool `json:"enable_knowledge" yaml:"enable_knowledge"`
+
+	// EmbeddingModel 生成向量使用的模型，为空时使用 text-embedding-3-small。更换模型后需要重新导入文档。
+	EmbeddingModel string `json:"embedding_model" yaml:"embedding_model"`
+
+	// KnowledgeTopK 对话时默认检索的资料数量，请求中指定 retrieval_top_k 时以请求为准，为 0 时不检索。
+	KnowledgeTopK int `json:"knowledge_top_k" yaml:"knowledge_top_k"`
+}
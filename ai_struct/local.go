@@ -0,0 +1,28 @@
+package ai_struct
+
+// LocalConfig 兼容 OpenAI 接口的本地/私有化部署模型服务配置，如 Ollama、vLLM、LM Studio 等
+type LocalConfig struct {
+	// LocalProviders 本地模型服务列表，每一项对应一个服务提供商
+	LocalProviders []LocalProvider `json:"local_providers" yaml:"local_providers"`
+}
+
+// LocalProvider 单个兼容 OpenAI 接口的模型服务
+type LocalProvider struct {
+	// Name 服务提供商名称，同时作为模型前缀使用，如 ollama 对应前缀 ollama:
+	Name string `json:"name" yaml:"name"`
+
+	// Server 服务地址，需要包含版本路径，如 http://127.0.0.1:11434/v1。
+	Server string `json:"server" yaml:"server"`
+
+	// Key 访问服务使用的 API Key，大部分本地服务不需要。
+	Key string `json:"key" yaml:"key"`
+
+	// Models 静态配置的模型列表，模型发现失败或者服务不支持 /models 接口时使用。
+	Models []string `json:"models" yaml:"models"`
+
+	// DefaultContextLength 服务未返回模型上下文长度时使用的默认值，为 0 时使用 4096。
+	DefaultContextLength int `json:"default_context_length" yaml:"default_context_length"`
+
+	// AutoProxy 控制是否为该服务启用自动代理，代理配置使用 OpenAiConfig 中的 ProxyConfig。
+	AutoProxy bool `json:"auto_proxy" yaml:"auto_proxy"`
+}
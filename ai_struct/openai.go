@@ -1,7 +1,15 @@
 package ai_struct
 
 type AiConfig struct {
-	OpenAiConfig `json:"openAiConfig"`
+	OpenAiConfig    `json:"openAiConfig"`
+	BaiduConfig     `json:"baiduConfig"`
+	AnthropicConfig `json:"anthropicConfig"`
+	ZhipuConfig     `json:"zhipuConfig"`
+	LocalConfig     `json:"localConfig"`
+	CatalogConfig   `json:"catalogConfig"`
+	SummaryConfig   `json:"summaryConfig"`
+	KnowledgeConfig `json:"knowledgeConfig"`
+	FallbackConfig  `json:"fallbackConfig"`
 }
 
 // OpenAiConfig 配置结构体包含与 OpenAI、DALL·E 以及 Fallback 相关的配置选项。
@@ -28,6 +36,36 @@ type OpenAiConfig struct {
 	// OpenAIKeys 存储 OpenAI API 的密钥，支持配置多个密钥以便在不同环境下使用。
 	OpenAIKeys []string `json:"openai_keys" yaml:"openai_keys"`
 
+	// OpenAIServerModels 指定服务器可以使用的模型，服务器地址 -> 模型列表，支持 * 结尾的通配符，如 gpt-4o*。
+	// 未配置的服务器可以使用所有模型，用于不同的部署提供不同模型的场景。
+	OpenAIServerModels map[string][]string `json:"openai_server_models" yaml:"openai_server_models"`
+
+	// OpenAIServerWeights 指定服务器的权重，服务器地址 -> 权重，未配置的服务器权重为 1。
+	// 负载均衡时按照权重以及服务器的健康状况（成功率、延迟）分配请求。
+	OpenAIServerWeights map[string]int `json:"openai_server_weights" yaml:"openai_server_weights"`
+
+	// OpenAIRetryMaxAttempts 请求失败后最多尝试的次数（包括第一次请求），为 0 时使用默认值 3，为 1 时不重试。
+	// 只有请求频率超限（429）、服务端错误（5xx）、超时以及网络错误会重试，主服务重试全部失败后才会切换到备用服务。
+	OpenAIRetryMaxAttempts int `json:"openai_retry_max_attempts" yaml:"openai_retry_max_attempts"`
+
+	// OpenAIRetryInitialBackoff 第一次重试前的等待时间，单位毫秒，之后每次翻倍并增加随机抖动，为 0 时使用默认值 500。
+	// 服务端通过 Retry-After 等响应头要求了等待时间时，使用两者中较长的一个。
+	OpenAIRetryInitialBackoff int `json:"openai_retry_initial_backoff" yaml:"openai_retry_initial_backoff"`
+
+	// OpenAIRetryMaxBackoff 单次重试等待时间的上限，单位毫秒，为 0 时使用默认值 8000。
+	OpenAIRetryMaxBackoff int `json:"openai_retry_max_backoff" yaml:"openai_retry_max_backoff"`
+
+	// OpenAIRetryMaxElapsed 包括重试在内的总耗时上限，单位秒，剩余时间不够等待时不再重试，为 0 时使用默认值 60。
+	OpenAIRetryMaxElapsed int `json:"openai_retry_max_elapsed" yaml:"openai_retry_max_elapsed"`
+
+	// OpenAIFallbackOn 主服务出现哪些分类的错误时切换到备用服务，可选值为 rate_limited、quota_exceeded、auth、server、timeout、network、client。
+	// 为空时除了请求参数错误（client）之外的错误都会切换，只有启用了备用服务时有效。
+	OpenAIFallbackOn []string `json:"openai_fallback_on" yaml:"openai_fallback_on"`
+
+	// OpenAILatencyBudget 主服务返回结果的最长等待时间，单位毫秒，超时后取消请求并切换到备用服务，为 0 时不限制。
+	// 流式对话只限制建立连接的时间。
+	OpenAILatencyBudget int `json:"openai_latency_budget" yaml:"openai_latency_budget"`
+
 	// EnableOpenAIDalle 控制是否启用 DALL·E 服务（用于生成图像的 OpenAI 模型）。为 true 时表示启用。
 	EnableOpenAIDalle bool `json:"enable_openai_dalle" yaml:"enable_openai_dalle"`
 
@@ -0,0 +1,13 @@
+package ai_struct
+
+// SummaryConfig 对话上下文摘要相关的配置选项，对话上下文超出模型限制时，被丢弃的历史消息会被压缩为摘要。
+type SummaryConfig struct {
+	// SummaryModel 生成摘要使用的模型，为空时使用 OpenAI 的 QuickAsk 生成摘要。
+	SummaryModel string `json:"summary_model" yaml:"summary_model"`
+
+	// SummaryMaxTokens 摘要的最大 token 数，为 0 时使用默认值 500。
+	SummaryMaxTokens int `json:"summary_max_tokens" yaml:"summary_max_tokens"`
+
+	// SummaryCacheSize 最多缓存多少个会话的摘要，为 0 时使用默认值 1000。
+	SummaryCacheSize int `json:"summary_cache_size" yaml:"summary_cache_size"`
+}
@@ -0,0 +1,13 @@
+package ai_struct
+
+// ZhipuConfig 智谱 AI 相关的配置选项
+type ZhipuConfig struct {
+	// EnableZhipuAI 控制是否启用智谱 AI 服务。为 true 时表示启用。
+	EnableZhipuAI bool `json:"enable_zhipu_ai" yaml:"enable_zhipu_ai"`
+
+	// ZhipuAIServer 智谱 AI 服务地址，为空时使用默认地址 https://open.bigmodel.cn/api/paas/v4。
+	ZhipuAIServer string `json:"zhipu_ai_server" yaml:"zhipu_ai_server"`
+
+	// ZhipuAIKey 智谱 AI 的 API Key，格式为 {id}.{secret}。
+	ZhipuAIKey string `json:"zhipu_ai_key" yaml:"zhipu_ai_key"`
+}
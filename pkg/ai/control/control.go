@@ -3,7 +3,10 @@ package control
 import "context"
 
 type Control struct {
+	// PreferBackup 跳过降级链的第一层，直接从第二层开始
 	PreferBackup bool `json:"prefer_backup"`
+	// PreferTier 从指定名称的层级开始，优先于 PreferBackup，名称不存在时忽略
+	PreferTier string `json:"prefer_tier,omitempty"`
 }
 
 const controlContextKey = "chat-control"
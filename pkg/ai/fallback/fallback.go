@@ -0,0 +1,283 @@
+// Package fallback 按顺序在多个层级（Client 或者服务提供商）之间降级，前一层失败并且满足切换条件时使用下一层
+package fallback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"accompany-sdk/pkg/ai/control"
+	"accompany-sdk/pkg/utils/array"
+	"github.com/openimsdk/tools/log"
+)
+
+// 错误分类，和 openai 包中的错误分类一致
+const (
+	ClassCanceled      = "canceled"
+	ClassRateLimited   = "rate_limited"
+	ClassQuotaExceeded = "quota_exceeded"
+	ClassAuth          = "auth"
+	ClassServer        = "server"
+	ClassTimeout       = "timeout"
+	ClassNetwork       = "network"
+	ClassClient        = "client"
+)
+
+var (
+	// ErrNoTier 没有可以使用的层级
+	ErrNoTier = errors.New("no fallback tier available")
+	// ErrLatencyBudget 层级在延迟预算内没有返回结果
+	ErrLatencyBudget = errors.New("latency budget exceeded")
+)
+
+// Tier 降级链中的一层，切换条件针对本层的失败，决定是否继续尝试下一层
+type Tier struct {
+	Name string
+	// FallbackOn 本层失败时切换到下一层的错误分类，为空时除了调用方取消和请求参数错误（client）之外的错误都切换
+	FallbackOn []string
+	// LatencyBudget 本层返回结果的最长等待时间，超时后取消本层请求并切换到下一层，为 0 时不限制
+	// 流式请求只限制建立连接的时间，建立连接后不再限制
+	LatencyBudget time.Duration
+}
+
+// ShouldFallback 本层出现 class 分类的错误后是否切换到下一层
+func (t Tier) ShouldFallback(class string) bool {
+	if len(t.FallbackOn) == 0 {
+		return class != ClassCanceled && class != ClassClient
+	}
+
+	return array.In(class, t.FallbackOn)
+}
+
+// Attempt 一层的失败记录
+type Attempt struct {
+	Tier  string
+	Class string
+	Err   error
+}
+
+// ExhaustedError 所有层级都失败
+type ExhaustedError struct {
+	Attempts []Attempt
+}
+
+func (e *ExhaustedError) Error() string {
+	if len(e.Attempts) == 0 {
+		return ErrNoTier.Error()
+	}
+
+	msgs := array.Map(e.Attempts, func(item Attempt, _ int) string {
+		return fmt.Sprintf("%s(%s): %v", item.Tier, item.Class, item.Err)
+	})
+	return "all fallback tiers failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap 返回每一层的错误，没有尝试任何层级时返回 ErrNoTier
+func (e *ExhaustedError) Unwrap() []error {
+	if len(e.Attempts) == 0 {
+		return []error{ErrNoTier}
+	}
+
+	return array.Map(e.Attempts, func(item Attempt, _ int) error { return item.Err })
+}
+
+// Classifier 错误分类
+type Classifier func(err error) string
+
+// Run 从 start 层开始依次调用 fn，返回结果以及返回结果的层级名称
+// 本层的错误不满足切换条件时直接返回该错误，所有层级都失败时返回 *ExhaustedError
+// 本层成功后取消传给 fn 的上下文，适用于一次性返回结果的请求，流式响应使用 RunStream 或者 RunCloser
+func Run[T any](ctx context.Context, tiers []Tier, start int, classify Classifier, fn func(ctx context.Context, index int) (T, error)) (T, string, error) {
+	return run(ctx, tiers, start, classify, fn, settlement[T]{
+		keep: func(_ context.Context, res T, cancel context.CancelFunc) T {
+			cancel()
+			return res
+		},
+		discard: closeResult[T],
+	})
+}
+
+// RunStream 和 Run 相同，fn 返回流式响应，返回的流读取结束或者 ctx 结束后才取消传给 fn 的上下文
+func RunStream[T any](ctx context.Context, tiers []Tier, start int, classify Classifier, fn func(ctx context.Context, index int) (<-chan T, error)) (<-chan T, string, error) {
+	return run(ctx, tiers, start, classify, fn, settlement[<-chan T]{keep: relay[T], discard: func(stream <-chan T) { go drain(stream) }})
+}
+
+// RunCloser 和 Run 相同，fn 返回需要关闭的结果，bind 把取消本层上下文的 cancel 绑定到结果上，在结果关闭时调用
+func RunCloser[T io.Closer](ctx context.Context, tiers []Tier, start int, classify Classifier, fn func(ctx context.Context, index int) (T, error), bind func(res T, cancel context.CancelFunc) T) (T, string, error) {
+	return run(ctx, tiers, start, classify, fn, settlement[T]{
+		keep: func(_ context.Context, res T, cancel context.CancelFunc) T {
+			return bind(res, cancel)
+		},
+		discard: closeResult[T],
+	})
+}
+
+// settlement 设置了延迟预算的层级成功后如何处理结果
+type settlement[T any] struct {
+	// keep 结果被采用，接管本层上下文的 cancel，ctx 为传给 fn 的上下文
+	keep func(ctx context.Context, res T, cancel context.CancelFunc) T
+	// discard 延迟预算在 fn 返回的同时用完，结果被丢弃，需要释放结果占用的资源
+	discard func(res T)
+}
+
+func run[T any](ctx context.Context, tiers []Tier, start int, classify Classifier, fn func(ctx context.Context, index int) (T, error), settle settlement[T]) (res T, tier string, err error) {
+	var attempts []Attempt
+	for i := max(start, 0); i < len(tiers); i++ {
+		res, err = try(ctx, tiers[i], i, fn, settle)
+		if err == nil {
+			if len(attempts) > 0 {
+				log.ZInfo(ctx, "fallback tier answered", "tier", tiers[i].Name, "failed", len(attempts))
+			}
+
+			record(ctx, tiers[i].Name)
+			return res, tiers[i].Name, nil
+		}
+
+		if ctx.Err() != nil {
+			return res, "", err
+		}
+
+		class := classify(err)
+		if errors.Is(err, ErrLatencyBudget) {
+			class = ClassTimeout
+		}
+
+		if !tiers[i].ShouldFallback(class) {
+			return res, "", err
+		}
+
+		attempts = append(attempts, Attempt{Tier: tiers[i].Name, Class: class, Err: err})
+		if i+1 < len(tiers) {
+			log.ZWarn(ctx, "fallback to next tier", err, "tier", tiers[i].Name, "class", class, "next", tiers[i+1].Name)
+		}
+	}
+
+	var zero T
+	return zero, "", &ExhaustedError{Attempts: attempts}
+}
+
+func try[T any](ctx context.Context, tier Tier, index int, fn func(ctx context.Context, index int) (T, error), settle settlement[T]) (T, error) {
+	if tier.LatencyBudget <= 0 {
+		return fn(ctx, index)
+	}
+
+	tctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(tier.LatencyBudget, func() { cancel(ErrLatencyBudget) })
+	res, err := fn(tctx, index)
+	if timer.Stop() && err == nil {
+		return settle.keep(tctx, res, func() { cancel(nil) }), nil
+	}
+
+	exceeded := errors.Is(context.Cause(tctx), ErrLatencyBudget)
+	cancel(nil)
+	if err == nil {
+		// 本层的上下文已经取消，返回的结果不能再使用
+		settle.discard(res)
+
+		var zero T
+		res, err = zero, context.Cause(tctx)
+	}
+
+	if exceeded {
+		return res, fmt.Errorf("%w: %s", ErrLatencyBudget, tier.LatencyBudget)
+	}
+
+	return res, err
+}
+
+// relay 转发本层的流，流读取结束或者 ctx 结束后取消本层的上下文，ctx 结束后继续读取剩余的响应，保证写入流的协程可以退出
+func relay[T any](ctx context.Context, stream <-chan T, cancel context.CancelFunc) <-chan T {
+	res := make(chan T)
+	go func() {
+		defer close(res)
+		defer cancel()
+
+		for data := range stream {
+			select {
+			case <-ctx.Done():
+				cancel()
+				drain(stream)
+				return
+			case res <- data:
+			}
+		}
+	}()
+
+	return res
+}
+
+// drain 读取并丢弃流中剩余的响应，直到流被关闭
+func drain[T any](stream <-chan T) {
+	for range stream {
+	}
+}
+
+// closeResult 关闭被丢弃的结果
+func closeResult[T any](res T) {
+	if closer, ok := any(res).(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// Start 根据上下文中的 control.Control 决定从哪一层开始，PreferTier 指定的层级优先，PreferBackup 从第二层开始
+func Start(ctx context.Context, tiers []Tier) int {
+	ctl := control.FromContext(ctx)
+	if ctl.PreferTier != "" {
+		for i, tier := range tiers {
+			if tier.Name == ctl.PreferTier {
+				return i
+			}
+		}
+	}
+
+	if ctl.PreferBackup && len(tiers) > 1 {
+		return 1
+	}
+
+	return 0
+}
+
+// Record 记录返回结果的层级，嵌套的降级链中记录最内层的层级
+type Record struct {
+	lock sync.Mutex
+	tier string
+}
+
+type recordKey struct{}
+
+// WithRecord 在上下文中记录返回结果的层级
+func WithRecord(ctx context.Context) (context.Context, *Record) {
+	record := &Record{}
+	return context.WithValue(ctx, recordKey{}, record), record
+}
+
+// Tier 返回结果的层级名称，没有经过降级链时为空
+func (r *Record) Tier() string {
+	if r == nil {
+		return ""
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.tier
+}
+
+// record 记录返回结果的层级，已经记录过时保留已有的层级
+func record(ctx context.Context, tier string) {
+	r, ok := ctx.Value(recordKey{}).(*Record)
+	if !ok {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.tier == "" {
+		r.tier = tier
+	}
+}
@@ -0,0 +1,312 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"accompany-sdk/pkg/ai/control"
+)
+
+var (
+	errServer = errors.New("server error")
+	errClient = errors.New("client error")
+)
+
+func testClassify(err error) string {
+	switch {
+	case errors.Is(err, errServer):
+		return ClassServer
+	case errors.Is(err, errClient):
+		return ClassClient
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	}
+
+	return ClassNetwork
+}
+
+func TestRun_FallbackToNextTier(t *testing.T) {
+	tiers := []Tier{{Name: "main"}, {Name: "backup"}, {Name: "last"}}
+	ctx, record := WithRecord(context.Background())
+
+	var called []string
+	res, tier, err := Run(ctx, tiers, 0, testClassify, func(ctx context.Context, index int) (string, error) {
+		called = append(called, tiers[index].Name)
+		if index == 0 {
+			return "", errServer
+		}
+
+		return "ok", nil
+	})
+	if err != nil || res != "ok" || tier != "backup" || record.Tier() != "backup" {
+		t.Fatalf("expect backup to answer, got %q %q %v", res, tier, err)
+	}
+
+	if len(called) != 2 {
+		t.Fatalf("last tier should not be called, got %v", called)
+	}
+}
+
+func TestRun_Conditions(t *testing.T) {
+	tiers := []Tier{{Name: "main", FallbackOn: []string{ClassRateLimited}}, {Name: "backup"}}
+
+	var calls int
+	_, _, err := Run(context.Background(), tiers, 0, testClassify, func(ctx context.Context, index int) (string, error) {
+		calls++
+		return "", errServer
+	})
+	if !errors.Is(err, errServer) || calls != 1 {
+		t.Fatalf("server error should not fall back when only rate_limited is allowed, got %v after %d calls", err, calls)
+	}
+
+	// 默认不切换请求参数错误
+	tiers[0].FallbackOn = nil
+	calls = 0
+	_, _, err = Run(context.Background(), tiers, 0, testClassify, func(ctx context.Context, index int) (string, error) {
+		calls++
+		return "", errClient
+	})
+	if !errors.Is(err, errClient) || calls != 1 {
+		t.Fatalf("client error should not fall back by default, got %v after %d calls", err, calls)
+	}
+}
+
+func TestRun_Exhausted(t *testing.T) {
+	tiers := []Tier{{Name: "main"}, {Name: "backup"}}
+	_, _, err := Run(context.Background(), tiers, 0, testClassify, func(ctx context.Context, index int) (string, error) {
+		return "", errServer
+	})
+
+	var exhausted *ExhaustedError
+	if !errors.As(err, &exhausted) || len(exhausted.Attempts) != 2 || !errors.Is(err, errServer) {
+		t.Fatalf("expect an exhausted error with 2 attempts, got %v", err)
+	}
+
+	_, _, err = Run(context.Background(), nil, 0, testClassify, func(ctx context.Context, index int) (string, error) {
+		t.Fatal("should not be called")
+		return "", nil
+	})
+	if !errors.As(err, &exhausted) || !errors.Is(err, ErrNoTier) {
+		t.Fatalf("expect ErrNoTier without any tier, got %v", err)
+	}
+}
+
+func TestRun_LatencyBudget(t *testing.T) {
+	tiers := []Tier{{Name: "slow", LatencyBudget: 50 * time.Millisecond, FallbackOn: []string{ClassTimeout}}, {Name: "fast"}}
+
+	var fastCtx context.Context
+	start := time.Now()
+	_, tier, err := Run(context.Background(), tiers, 0, testClassify, func(ctx context.Context, index int) (string, error) {
+		if index == 0 {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+
+		fastCtx = ctx
+		return "ok", nil
+	})
+	if err != nil || tier != "fast" {
+		t.Fatalf("slow tier should be abandoned after the budget, got %q %v", tier, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("latency budget is not enforced, took %s", elapsed)
+	}
+
+	if fastCtx.Err() != nil {
+		t.Fatal("context of the answering tier should not be canceled")
+	}
+}
+
+func TestRun_LatencyBudgetCancelOnSuccess(t *testing.T) {
+	tiers := []Tier{{Name: "main", LatencyBudget: time.Second}}
+
+	var tierCtx context.Context
+	res, _, err := Run(context.Background(), tiers, 0, testClassify, func(ctx context.Context, index int) (string, error) {
+		tierCtx = ctx
+		return "ok", nil
+	})
+	if err != nil || res != "ok" {
+		t.Fatalf("unexpected result %q %v", res, err)
+	}
+
+	// 一次性返回结果的请求成功后立即释放本层的上下文
+	if tierCtx.Err() == nil {
+		t.Fatal("context of the answering tier should be canceled after Run returns")
+	}
+}
+
+// testCloser 记录是否被关闭
+type testCloser struct {
+	closed chan struct{}
+}
+
+func (c *testCloser) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestRun_LatencyBudgetDiscardsLateResult(t *testing.T) {
+	tiers := []Tier{{Name: "slow", LatencyBudget: 20 * time.Millisecond}, {Name: "fast"}}
+
+	// 延迟预算用完的同时 fn 返回了结果，结果被丢弃并关闭
+	late := &testCloser{closed: make(chan struct{})}
+	res, tier, err := Run(context.Background(), tiers, 0, testClassify, func(ctx context.Context, index int) (*testCloser, error) {
+		if index == 0 {
+			<-ctx.Done()
+			return late, nil
+		}
+
+		return &testCloser{closed: make(chan struct{})}, nil
+	})
+	if err != nil || tier != "fast" || res == late {
+		t.Fatalf("expect the fast tier to answer, got %q %v", tier, err)
+	}
+
+	select {
+	case <-late.closed:
+	case <-time.After(time.Second):
+		t.Fatal("discarded result should be closed")
+	}
+
+	// 本层的流被丢弃后继续读取剩余的响应，写入流的协程可以退出
+	exited := make(chan struct{})
+	_, _, err = RunStream(context.Background(), tiers[:1], 0, testClassify, func(ctx context.Context, index int) (<-chan int, error) {
+		<-ctx.Done()
+
+		stream := make(chan int)
+		go func() {
+			defer close(exited)
+			defer close(stream)
+
+			stream <- 1
+		}()
+		return stream, nil
+	})
+	if !errors.Is(err, ErrLatencyBudget) {
+		t.Fatalf("expect a latency budget error, got %v", err)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("producer of the discarded stream is blocked")
+	}
+}
+
+func TestRunStream_CancelOnClose(t *testing.T) {
+	tiers := []Tier{{Name: "main", LatencyBudget: time.Second}}
+
+	var tierCtx context.Context
+	produce := make(chan int)
+	stream, _, err := RunStream(context.Background(), tiers, 0, testClassify, func(ctx context.Context, index int) (<-chan int, error) {
+		tierCtx = ctx
+		return produce, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 流读取结束之前不取消本层的上下文
+	go func() {
+		defer close(produce)
+
+		for i := 0; i < 3; i++ {
+			produce <- i
+		}
+	}()
+
+	var received []int
+	for data := range stream {
+		// 最后一条响应之前写入流的协程还没有关闭流
+		if data < 2 && tierCtx.Err() != nil {
+			t.Fatal("context should not be canceled while the stream is being read")
+		}
+
+		received = append(received, data)
+	}
+
+	if len(received) != 3 || tierCtx.Err() == nil {
+		t.Fatalf("context should be canceled after the stream is closed, got %v", received)
+	}
+}
+
+func TestRunStream_CallerGone(t *testing.T) {
+	tiers := []Tier{{Name: "main", LatencyBudget: time.Second}}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// 写入流的协程不检查上下文，调用方不再读取后也需要可以退出
+	exited := make(chan struct{})
+	stream, _, err := RunStream(ctx, tiers, 0, testClassify, func(ctx context.Context, index int) (<-chan int, error) {
+		res := make(chan int)
+		go func() {
+			defer close(exited)
+			defer close(res)
+
+			for i := 0; i < 100; i++ {
+				res <- i
+			}
+		}()
+		return res, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-stream
+	cancel()
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("producer is blocked after the caller is gone")
+	}
+}
+
+func TestRunCloser_CancelOnClose(t *testing.T) {
+	tiers := []Tier{{Name: "main", LatencyBudget: time.Second}}
+
+	var tierCtx context.Context
+	var released bool
+	closer, _, err := RunCloser(context.Background(), tiers, 0, testClassify, func(ctx context.Context, index int) (*testCloser, error) {
+		tierCtx = ctx
+		return &testCloser{closed: make(chan struct{})}, nil
+	}, func(res *testCloser, cancel context.CancelFunc) *testCloser {
+		go func() {
+			<-res.closed
+			released = true
+			cancel()
+		}()
+		return res
+	})
+	if err != nil || tierCtx.Err() != nil {
+		t.Fatalf("context should be kept until the result is closed, got %v", err)
+	}
+
+	_ = closer.Close()
+	<-tierCtx.Done()
+	if !released {
+		t.Fatal("context should be canceled by the bound cancel")
+	}
+}
+
+func TestStart(t *testing.T) {
+	tiers := []Tier{{Name: "main"}, {Name: "backup"}, {Name: "last"}}
+	cases := []struct {
+		ctl   *control.Control
+		start int
+	}{
+		{ctl: &control.Control{}, start: 0},
+		{ctl: &control.Control{PreferBackup: true}, start: 1},
+		{ctl: &control.Control{PreferBackup: true, PreferTier: "last"}, start: 2},
+		{ctl: &control.Control{PreferTier: "unknown"}, start: 0},
+	}
+
+	for i, c := range cases {
+		if start := Start(control.NewContext(context.Background(), c.ctl), tiers); start != c.start {
+			t.Errorf("case %d: expect %d, got %d", i, c.start, start)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package bpe 为 tiktoken 提供离线的 BPE 数据加载，避免首次计算 token 时需要从网络下载 BPE 文件
+//
+// 导入该包时会注册使用内置数据的加载器，SDK 初始化时通过 Register 指定数据目录后，
+// 优先从 {DataDir}/tiktoken/{encoding}.tiktoken 加载，方便在不升级 SDK 的情况下更新 BPE 数据
+package bpe
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// dirName 数据目录中存放 BPE 文件的子目录
+const dirName = "tiktoken"
+
+func init() {
+	Register("")
+}
+
+// Register 注册离线 BPE 加载器，dataDir 为空时只使用内置数据
+func Register(dataDir string) {
+	tiktoken.SetBpeLoader(NewLoader(dataDir))
+}
+
+// Loader 离线 BPE 加载器，优先从数据目录加载，不存在时使用内置数据
+type Loader struct {
+	dir      string
+	embedded tiktoken.BpeLoader
+}
+
+func NewLoader(dataDir string) *Loader {
+	return &Loader{
+		dir:      dataDir,
+		embedded: tiktoken_loader.NewOfflineLoader(),
+	}
+}
+
+// LoadTiktokenBpe 实现 tiktoken.BpeLoader 接口，tiktokenBpeFile 为 BPE 文件的下载地址，只使用其中的文件名
+func (l *Loader) LoadTiktokenBpe(tiktokenBpeFile string) (map[string]int, error) {
+	name := path.Base(tiktokenBpeFile)
+	if l.dir != "" {
+		if contents, err := os.ReadFile(filepath.Join(l.dir, dirName, name)); err == nil {
+			return parse(contents)
+		}
+	}
+
+	ranks, err := l.embedded.LoadTiktokenBpe(name)
+	if err != nil {
+		return nil, fmt.Errorf("load bpe %s: %v", name, err)
+	}
+
+	return ranks, nil
+}
+
+// parse 解析 BPE 文件，每行格式为 "{base64 token} {rank}"
+func parse(contents []byte) (map[string]int, error) {
+	ranks := make(map[string]int)
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line == "" {
+			continue
+		}
+
+		token, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid bpe line: %s", line)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, err
+		}
+
+		ranks[string(decoded)], err = strconv.Atoi(strings.TrimSpace(rank))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ranks, nil
+}
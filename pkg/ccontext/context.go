@@ -30,6 +30,7 @@ type ContextInfo interface {
 	IsExternalExtensions() bool
 
 	OpenAIConfig() *ai_struct.OpenAiConfig
+	BaiduConfig() *ai_struct.BaiduConfig
 }
 
 func Info(ctx context.Context) ContextInfo {
@@ -51,6 +52,11 @@ func WithSendMessageCallback(ctx context.Context, callback sdk_callback.SendMsgC
 	return context.WithValue(ctx, Callback, callback)
 }
 
+func GetSendMessageCallback(ctx context.Context) sdk_callback.SendMsgCallBack {
+	cb, _ := ctx.Value(Callback).(sdk_callback.SendMsgCallBack)
+	return cb
+}
+
 func WithApiErrCode(ctx context.Context, cb ApiErrCodeCallback) context.Context {
 	return context.WithValue(ctx, apiErrCode{}, cb)
 }
@@ -103,6 +109,11 @@ func (i *info) OpenAIConfig() *ai_struct.OpenAiConfig {
 	return &i.conf.AiConfig.OpenAiConfig
 }
 
+// BaiduConfig 返回百度文心千帆的配置
+func (i *info) BaiduConfig() *ai_struct.BaiduConfig {
+	return &i.conf.AiConfig.BaiduConfig
+}
+
 type apiErrCode struct{}
 
 type ApiErrCodeCallback interface {
@@ -9,6 +9,10 @@ import (
 	"fmt"
 	"github.com/Vernacular-ai/godub/converter"
 	"github.com/lithammer/shortuuid/v4"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"math/rand"
 	"mime"
 	"net/http"
@@ -41,6 +45,21 @@ func RestyClient(retryCount int) *resty.Client {
 		})
 }
 
+// responseHeaderTimeout 等待服务端返回响应头的最长时间
+const responseHeaderTimeout = 180 * time.Second
+
+// StreamHTTPClient 创建适合流式响应的 HTTP 客户端，只限制建立连接和等待响应头的时间，
+// 不限制读取响应体的时间（http.Client.Timeout 会截断长时间的 SSE 响应），读取过程由请求的上下文控制
+// transport 为空时使用 http.DefaultTransport 的副本
+func StreamHTTPClient(transport *http.Transport) *http.Client {
+	if transport == nil {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+	return &http.Client{Transport: transport}
+}
+
 // MaskPhoneNumber 隐藏手机号码中间四位
 func MaskPhoneNumber(phone string) string {
 	if len(phone) < 11 {
@@ -301,6 +320,22 @@ func DecodeBase64Image(base64Image string) (data []byte, ext string, err error)
 	return decodedData, ".png", nil
 }
 
+// Base64ImageSize 获取 base64 图片的宽高，只解码图片头部，不解码完整的图片
+func Base64ImageSize(base64Image string) (width int, height int, err error) {
+	// Remove data:image/jpeg;base64, if exist
+	d := strings.SplitN(base64Image, ",", 2)
+	if len(d) == 2 {
+		base64Image = d[1]
+	}
+
+	conf, _, err := image.DecodeConfig(base64.NewDecoder(base64.StdEncoding, strings.NewReader(base64Image)))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return conf.Width, conf.Height, nil
+}
+
 // DecodeBase64ImageWithMime 解码 base64 图片
 func DecodeBase64ImageWithMime(base64Image string) (data []byte, mimeType string, err error) {
 	// Remove data:image/jpeg;base64, if exist
@@ -0,0 +1,37 @@
+package misc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestBase64ImageSize(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+	cases := []struct {
+		name   string
+		image  string
+		width  int
+		height int
+		valid  bool
+	}{
+		{name: "data url", image: "data:image/png;base64," + encoded, width: 30, height: 20, valid: true},
+		{name: "raw base64", image: encoded, width: 30, height: 20, valid: true},
+		{name: "invalid base64", image: "data:image/png;base64,!!!"},
+		{name: "not an image", image: base64.StdEncoding.EncodeToString([]byte("hello"))},
+	}
+
+	for _, c := range cases {
+		width, height, err := Base64ImageSize(c.image)
+		if (err == nil) != c.valid || width != c.width || height != c.height {
+			t.Errorf("%s: want %dx%d valid %v, got %dx%d %v", c.name, c.width, c.height, c.valid, width, height, err)
+		}
+	}
+}
@@ -53,8 +53,50 @@ func RemoveImageFilter(imageURL string) string {
 
 var (
 	ErrFileForbidden = fmt.Errorf("文件违规已被禁用")
+	ErrFileTooLarge  = fmt.Errorf("file is too large")
 )
 
+// DownloadRemoteFileLimited 使用 client 下载文件内容，client 为 nil 时使用 http.DefaultClient
+// 请求受 ctx 控制，文件超过 maxSize 字节时停止读取并返回 ErrFileTooLarge
+func DownloadRemoteFileLimited(ctx context.Context, client *http.Client, remoteURL string, maxSize int64) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, remoteURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		if resp.StatusCode == http.StatusForbidden {
+			return nil, ErrFileForbidden
+		}
+		return nil, fmt.Errorf("download remote file failed: [%d] %s", resp.StatusCode, resp.Status)
+	}
+
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFileTooLarge, resp.ContentLength)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrFileTooLarge, maxSize)
+	}
+
+	return data, nil
+}
+
 // DownloadRemoteFile download remote file to local
 func DownloadRemoteFile(ctx context.Context, remoteURL string) (string, error) {
 	if str.HasSuffixes(strings.ToLower(remoteURL), supportImages) {
@@ -150,7 +192,17 @@ type ImageInfo struct {
 }
 
 func QueryImageInfo(imageURL string) (*ImageInfo, error) {
-	resp, err := http.Get(RemoveImageFilter(imageURL) + "?imageInfo")
+	return QueryImageInfoContext(context.Background(), imageURL)
+}
+
+// QueryImageInfoContext 查询图片信息，请求受 ctx 控制
+func QueryImageInfoContext(ctx context.Context, imageURL string) (*ImageInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, RemoveImageFilter(imageURL)+"?imageInfo", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,186 @@
+package sdk
+
+import (
+	"accompany-sdk/ai/agent"
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/ccontext"
+	"accompany-sdk/pkg/sdkerrs"
+	"accompany-sdk/pkg/ternary"
+	"accompany-sdk/sdk_callback"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/openimsdk/tools/log"
+)
+
+// AgentRequest Agent 运行请求
+type AgentRequest struct {
+	chat.Request
+	// HostTools 由宿主执行的工具，模型调用时通过 AgentCallBack.OnToolCall 通知宿主，宿主通过 SubmitToolResult 提交结果
+	HostTools []chat.Tool `json:"host_tools,omitempty"`
+	// MaxSteps 最大模型调用次数
+	MaxSteps int `json:"max_steps,omitempty"`
+	// TimeoutSeconds 整体超时时间，单位为秒
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// MaxTotalTokens 输入 + 输出的最大 token 数
+	MaxTotalTokens int `json:"max_total_tokens,omitempty"`
+	// MaxCost 最大费用，根据模型目录中的价格计算，模型价格未知或者货币单位不一致时拒绝运行
+	MaxCost float64 `json:"max_cost,omitempty"`
+	// Currency MaxCost 的货币单位，USD 或者 CNY，为空时使用模型价格的货币单位
+	Currency string `json:"currency,omitempty"`
+}
+
+type toolResult struct {
+	output string
+	err    error
+}
+
+// RunAgent 运行 Agent，req 为 JSON 格式的 AgentRequest
+// 每个步骤通过 callback.OnStep 返回，宿主工具通过 callback.OnToolCall 通知，最后通过 OnSuccess 返回 agent.Result
+func RunAgent(callback sdk_callback.AgentCallBack, operationID string, req string) {
+	messageCall(callback, operationID, UserForSDK.RunAgent, req)
+}
+
+// SubmitToolResult 提交宿主工具的执行结果，errMsg 不为空时表示工具执行失败
+func SubmitToolResult(callback sdk_callback.Base, operationID string, toolCallID string, output string, errMsg string) {
+	call(callback, operationID, UserForSDK.SubmitToolResult, toolCallID, output, errMsg)
+}
+
+// RegisterAgentTool 注册由 Go 代码执行的 Agent 工具，所有 Agent 运行时都可以使用
+func (u *LoginMgr) RegisterAgentTool(tool agent.Tool) error {
+	if tool.Name == "" || tool.Handler == nil {
+		return agent.ErrInvalidTool
+	}
+
+	if _, loaded := u.agentTools.LoadOrStore(tool.Name, tool); loaded {
+		return agent.ErrToolExists
+	}
+
+	return nil
+}
+
+// RunAgent 运行 Agent，步骤通过上下文中的 AgentCallBack 推送
+func (u *LoginMgr) RunAgent(ctx context.Context, req AgentRequest) (*agent.Result, error) {
+	cb, ok := ccontext.GetSendMessageCallback(ctx).(sdk_callback.AgentCallBack)
+	if !ok {
+		return nil, sdkerrs.ErrArgs.WithDetail("callback is not an AgentCallBack")
+	}
+
+	if len(req.Messages) == 0 {
+		return nil, sdkerrs.ErrArgs.WithDetail("messages is empty")
+	}
+
+	if err := req.ValidateSampling(); err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+	}
+
+	if _, _, _, err := u.aiChat.Resolve(req.ActualModel()); err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+	}
+
+	backend := u.chatBackend()
+	a := agent.New(backend, agent.Options{
+		MaxSteps:  req.MaxSteps,
+		Timeout:   time.Duration(req.TimeoutSeconds) * time.Second,
+		MaxTokens: req.MaxTotalTokens,
+		MaxCost:   req.MaxCost,
+		Currency:  req.Currency,
+		ResolveModel: func(model string) string {
+			_, _, realModel, _ := u.aiChat.Resolve(model)
+			return realModel
+		},
+	})
+
+	var err error
+	u.agentTools.Range(func(_, value any) bool {
+		err = a.Register(value.(agent.Tool))
+		return err == nil
+	})
+	if err != nil {
+		return nil, sdkerrs.ErrSdkInternal.WithDetail(err.Error())
+	}
+
+	for _, tool := range req.HostTools {
+		if tool.Function == nil {
+			continue
+		}
+
+		if err := a.Register(agent.Tool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+			Handler:     u.hostToolHandler(cb, tool.Function.Name),
+		}); err != nil {
+			return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+		}
+	}
+
+	req.Request = u.expandDocuments(ctx, backend, req.Request)
+	fixed, _, err := req.Request.Fix(ctx, backend, u.summarizer, maxChatContextLength, backend.MaxContextLength(req.ActualModel()))
+	if err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+	}
+
+	res, err := a.Run(ctx, *fixed, agent.ObserverFunc(func(step agent.Step) {
+		data, _ := json.Marshal(step)
+		cb.OnStep(string(data))
+	}))
+	if err != nil {
+		log.ZWarn(ctx, "agent run failed", err, "model", req.Model, "steps", ternary.IfLazy(res != nil, func() int { return res.Steps }, func() int { return 0 }))
+		switch {
+		case errors.Is(err, agent.ErrMaxSteps), errors.Is(err, agent.ErrTokenBudget), errors.Is(err, agent.ErrCostBudget),
+			errors.Is(err, agent.ErrUnknownPrice), errors.Is(err, agent.ErrCurrency):
+			return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+		case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+			return nil, sdkerrs.ErrCtxDeadline.WithDetail(err.Error())
+		}
+
+		return nil, sdkerrs.ErrNetwork.WithDetail(err.Error())
+	}
+
+	return res, nil
+}
+
+// hostToolHandler 宿主工具的执行函数，通知宿主后等待 SubmitToolResult 提交结果
+func (u *LoginMgr) hostToolHandler(cb sdk_callback.AgentCallBack, name string) agent.Handler {
+	return func(ctx context.Context, arguments string) (string, error) {
+		// 模型生成的工具调用 ID 只在本次对话内唯一，这里加上 operationID 作为前缀
+		call, _ := agent.ToolCallFromContext(ctx)
+		toolCallID := ccontext.Info(ctx).OperationID() + ":" + call.ID
+		ch := make(chan toolResult, 1)
+		u.toolResults.Store(toolCallID, ch)
+		defer u.toolResults.Delete(toolCallID)
+
+		cb.OnToolCall(toolCallID, name, arguments)
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case res := <-ch:
+			return res.output, res.err
+		}
+	}
+}
+
+// SubmitToolResult 提交宿主工具的执行结果
+func (u *LoginMgr) SubmitToolResult(_ context.Context, toolCallID string, output string, errMsg string) error {
+	value, ok := u.toolResults.Load(toolCallID)
+	if !ok {
+		return sdkerrs.ErrArgs.WithDetail("tool call not found or already finished")
+	}
+
+	res := toolResult{output: output}
+	if errMsg != "" {
+		res.err = errors.New(errMsg)
+	}
+
+	select {
+	case value.(chan toolResult) <- res:
+	default:
+		return sdkerrs.ErrArgs.WithDetail("tool result already submitted")
+	}
+
+	return nil
+}
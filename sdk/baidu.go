@@ -0,0 +1,38 @@
+package sdk
+
+import (
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/sdkerrs"
+	"accompany-sdk/sdk_callback"
+	"context"
+)
+
+// BaiduChat 使用百度文心千帆进行对话，req 为 JSON 格式的 chat.Request
+func BaiduChat(callback sdk_callback.Base, operationID string, req string) {
+	call(callback, operationID, UserForSDK.BaiduChat, req)
+}
+
+// BaiduChatStream 使用百度文心千帆进行流式对话，req 为 JSON 格式的 chat.Request
+func BaiduChatStream(callback sdk_callback.ChatStreamCallBack, operationID string, req string) {
+	messageCall(callback, operationID, UserForSDK.BaiduChatStream, req)
+}
+
+// BaiduChat 使用百度文心千帆以请求-响应的方式进行对话
+func (u *LoginMgr) BaiduChat(ctx context.Context, req chat.Request) (*chat.Response, error) {
+	backend, err := u.aiChat.Provider(chat.ProviderBaidu)
+	if err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail("baidu ai is not enabled")
+	}
+
+	return u.chat(ctx, backend, req)
+}
+
+// BaiduChatStream 使用百度文心千帆以流的方式进行对话
+func (u *LoginMgr) BaiduChatStream(ctx context.Context, req chat.Request) (*chat.Response, error) {
+	backend, err := u.aiChat.Provider(chat.ProviderBaidu)
+	if err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail("baidu ai is not enabled")
+	}
+
+	return u.chatStream(ctx, backend, req)
+}
@@ -0,0 +1,53 @@
+package sdk
+
+import (
+	"accompany-sdk/ai/baidu"
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/ccontext"
+	"accompany-sdk/pkg/sdkerrs"
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeBaiduAI 按照预设的内容返回文心千帆的流式响应
+type fakeBaiduAI struct {
+	models []baidu.Model
+}
+
+func (ai *fakeBaiduAI) Chat(ctx context.Context, model baidu.Model, req baidu.ChatRequest) (*baidu.ChatResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (ai *fakeBaiduAI) ChatStream(ctx context.Context, model baidu.Model, req baidu.ChatRequest) (<-chan baidu.ChatResponse, error) {
+	ai.models = append(ai.models, model)
+
+	res := make(chan baidu.ChatResponse, 2)
+	res <- baidu.ChatResponse{Result: "你好"}
+	res <- baidu.ChatResponse{Result: "！", IsEND: true, Usage: baidu.Usage{PromptTokens: 3, CompletionTokens: 2}}
+	close(res)
+	return res, nil
+}
+
+func TestBaiduChatStream(t *testing.T) {
+	u := &LoginMgr{info: &ccontext.GlobalConfig{}, aiChat: chat.NewRouter()}
+	req := chat.Request{Model: string(baidu.ModelErnieBot), Messages: chat.Messages{{Role: "user", Content: "你好"}}}
+
+	// 没有启用文心千帆时返回参数错误
+	cb := &recordCallback{}
+	ctx := ccontext.WithSendMessageCallback(context.Background(), cb)
+	if _, err := u.BaiduChatStream(ctx, req); !errors.Is(err, sdkerrs.ErrArgs) {
+		t.Fatalf("expect an args error when baidu is disabled, got %v", err)
+	}
+
+	ai := &fakeBaiduAI{}
+	u.aiChat.Register(chat.ProviderBaidu, chat.NewBaiduAIChat(ai))
+	res, err := u.BaiduChatStream(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Text != "你好！" || len(cb.deltas) != 2 || cb.finished != 1 || len(ai.models) != 1 || ai.models[0] != baidu.ModelErnieBot {
+		t.Fatalf("unexpected result %+v, callbacks %+v", res, cb)
+	}
+}
@@ -0,0 +1,29 @@
+package sdk
+
+import (
+	"accompany-sdk/ai/catalog"
+	"accompany-sdk/sdk_callback"
+	"context"
+)
+
+// ListModels 获取模型目录，provider 为空时返回全部模型
+func ListModels(callback sdk_callback.Base, operationID string, provider string) {
+	call(callback, operationID, UserForSDK.ListModels, provider)
+}
+
+// ListModels 获取模型目录中服务提供商的全部模型，只返回已启用的服务提供商的模型
+func (u *LoginMgr) ListModels(_ context.Context, provider string) ([]catalog.Model, error) {
+	models := catalog.List(provider)
+	if u.aiChat == nil {
+		return models, nil
+	}
+
+	enabled := make([]catalog.Model, 0, len(models))
+	for _, m := range models {
+		if u.aiChat.Has(m.Provider) {
+			enabled = append(enabled, m)
+		}
+	}
+
+	return enabled, nil
+}
@@ -0,0 +1,136 @@
+package sdk
+
+import (
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/ccontext"
+	"accompany-sdk/pkg/sdkerrs"
+	"accompany-sdk/pkg/utils/array"
+	"accompany-sdk/sdk_callback"
+	"context"
+	"encoding/json"
+
+	"github.com/openimsdk/tools/log"
+)
+
+// maxChatContextLength 对话时最多携带的历史对话轮数
+const maxChatContextLength = 10
+
+// ChatStream 流式对话，req 为 JSON 格式的 chat.Request
+// 增量内容通过 callback.OnDelta 返回，结束时回调 OnFinish，最后通过 OnSuccess 返回完整的对话结果
+func ChatStream(callback sdk_callback.ChatStreamCallBack, operationID string, req string) {
+	messageCall(callback, operationID, UserForSDK.ChatStream, req)
+}
+
+// ChatStream 以流的方式进行对话，根据模型名称选择服务提供商，配置了降级链时按照降级链切换模型，增量内容通过上下文中的 ChatStreamCallBack 推送
+func (u *LoginMgr) ChatStream(ctx context.Context, req chat.Request) (*chat.Response, error) {
+	if _, _, _, err := u.aiChat.Resolve(req.ActualModel()); err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+	}
+
+	return u.chatStream(ctx, u.chatBackend(), req)
+}
+
+// chat 使用指定的服务提供商以请求-响应的方式进行对话
+func (u *LoginMgr) chat(ctx context.Context, backend chat.Chat, req chat.Request) (*chat.Response, error) {
+	if len(req.Messages) == 0 {
+		return nil, sdkerrs.ErrArgs.WithDetail("messages is empty")
+	}
+
+	if err := req.ValidateSampling(); err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+	}
+
+	req = u.expandDocuments(ctx, backend, req)
+	req, citations := u.retrieve(ctx, req)
+	fixed, inputTokens, err := req.Fix(ctx, backend, u.summarizer, maxChatContextLength, backend.MaxContextLength(req.ActualModel()))
+	if err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+	}
+
+	res, err := backend.Chat(ctx, *fixed)
+	if err != nil {
+		log.ZError(ctx, "chat failed", err, "model", req.Model)
+		return nil, sdkerrs.ErrNetwork.WithDetail(err.Error())
+	}
+
+	if res.InputTokens == 0 {
+		res.InputTokens = int(inputTokens)
+	}
+
+	res.Choices = res.AllChoices()
+	res.Citations = citations
+
+	return res, nil
+}
+
+// chatStream 使用指定的服务提供商以流的方式进行对话，增量内容通过上下文中的 ChatStreamCallBack 推送
+func (u *LoginMgr) chatStream(ctx context.Context, backend chat.Chat, req chat.Request) (*chat.Response, error) {
+	cb, ok := ccontext.GetSendMessageCallback(ctx).(sdk_callback.ChatStreamCallBack)
+	if !ok {
+		return nil, sdkerrs.ErrArgs.WithDetail("callback is not a ChatStreamCallBack")
+	}
+
+	if len(req.Messages) == 0 {
+		return nil, sdkerrs.ErrArgs.WithDetail("messages is empty")
+	}
+
+	if err := req.ValidateSampling(); err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+	}
+
+	req = u.expandDocuments(ctx, backend, req)
+	req, citations := u.retrieve(ctx, req)
+	fixed, inputTokens, err := req.Fix(ctx, backend, u.summarizer, maxChatContextLength, backend.MaxContextLength(req.ActualModel()))
+	if err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+	}
+
+	stream, err := backend.ChatStream(ctx, *fixed)
+	if err != nil {
+		log.ZError(ctx, "chat stream failed", err, "model", req.Model)
+		return nil, sdkerrs.ErrNetwork.WithDetail(err.Error())
+	}
+
+	result := chat.Response{InputTokens: int(inputTokens), Citations: citations}
+	for data := range stream {
+		if data.ErrorCode != "" {
+			log.ZWarn(ctx, "chat stream response error", nil, "code", data.ErrorCode, "error", data.Error)
+			return nil, sdkerrs.ErrNetwork.WithDetail(data.ErrorCode + ": " + data.Error)
+		}
+
+		result.Choices = chat.MergeChoiceDeltas(result.Choices, data.AllChoices())
+		if data.Tier != "" {
+			result.Tier = data.Tier
+		}
+		if data.Recovered {
+			result.Recovered = true
+		}
+		if data.InputTokens > 0 {
+			result.InputTokens = data.InputTokens
+		}
+		if data.OutputTokens > 0 {
+			result.OutputTokens = data.OutputTokens
+		}
+
+		delta, _ := json.Marshal(data)
+		cb.OnDelta(string(delta))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, sdkerrs.ErrCtxDeadline.WithDetail(err.Error())
+	}
+
+	if len(result.Choices) > 0 {
+		result.Text, result.FinishReason, result.ToolCalls = result.Choices[0].Text, result.Choices[0].FinishReason, result.Choices[0].ToolCalls
+	}
+
+	if result.OutputTokens == 0 {
+		// 部分厂商流式响应不返回 token 用量，这里按照全部候选回复的输出内容估算
+		result.OutputTokens, _ = chat.MessageTokenCount(array.Map(result.Choices, func(item chat.Choice, _ int) chat.Message {
+			return chat.Message{Role: "assistant", Content: item.Text, ToolCalls: item.ToolCalls}
+		}), fixed.ActualModel())
+	}
+
+	cb.OnFinish(result.FinishReason, int32(result.InputTokens), int32(result.OutputTokens))
+	return &result, nil
+}
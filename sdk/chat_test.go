@@ -0,0 +1,105 @@
+package sdk
+
+import (
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/ccontext"
+	"accompany-sdk/pkg/sdkerrs"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// streamChat 按照预设的增量返回流式响应
+type streamChat struct {
+	deltas []chat.Response
+}
+
+func (c *streamChat) Chat(ctx context.Context, req chat.Request) (*chat.Response, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *streamChat) ChatStream(ctx context.Context, req chat.Request) (<-chan chat.Response, error) {
+	res := make(chan chat.Response, len(c.deltas))
+	for _, delta := range c.deltas {
+		res <- delta
+	}
+
+	close(res)
+	return res, nil
+}
+
+func (c *streamChat) MaxContextLength(model string) int {
+	return 8000
+}
+
+// recordCallback 记录收到的回调
+type recordCallback struct {
+	deltas       []string
+	finishReason string
+	inputTokens  int32
+	outputTokens int32
+	finished     int
+}
+
+func (c *recordCallback) OnError(errCode int32, errMsg string) {}
+func (c *recordCallback) OnSuccess(data string)                {}
+func (c *recordCallback) OnProgress(progress int)              {}
+func (c *recordCallback) OnDelta(delta string)                 { c.deltas = append(c.deltas, delta) }
+
+func (c *recordCallback) OnFinish(finishReason string, inputTokens int32, outputTokens int32) {
+	c.finishReason, c.inputTokens, c.outputTokens = finishReason, inputTokens, outputTokens
+	c.finished++
+}
+
+func chatStreamRequest() chat.Request {
+	return chat.Request{Model: "gpt-4o", Messages: chat.Messages{{Role: "user", Content: "静夜思"}}}
+}
+
+func TestChatStream_Callback(t *testing.T) {
+	u := &LoginMgr{info: &ccontext.GlobalConfig{}}
+	backend := &streamChat{deltas: []chat.Response{
+		{Text: "床前"},
+		{Text: "明月光"},
+		{FinishReason: "stop", InputTokens: 12, OutputTokens: 5},
+	}}
+
+	cb := &recordCallback{}
+	res, err := u.chatStream(ccontext.WithSendMessageCallback(context.Background(), cb), backend, chatStreamRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个增量都通过 OnDelta 推送，结束后调用一次 OnFinish
+	if len(cb.deltas) != 3 || cb.finished != 1 || cb.finishReason != "stop" || cb.inputTokens != 12 || cb.outputTokens != 5 {
+		t.Fatalf("unexpected callbacks %+v", cb)
+	}
+
+	var first chat.Response
+	if err := json.Unmarshal([]byte(cb.deltas[0]), &first); err != nil || first.Text != "床前" {
+		t.Fatalf("delta should be a JSON response, got %s %v", cb.deltas[0], err)
+	}
+
+	if res.Text != "床前明月光" || res.FinishReason != "stop" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+func TestChatStream_ResponseError(t *testing.T) {
+	u := &LoginMgr{info: &ccontext.GlobalConfig{}}
+	backend := &streamChat{deltas: []chat.Response{{Text: "床前"}, {ErrorCode: "READ_STREAM_FAILED", Error: "connection reset"}}}
+
+	// 流中返回错误时不再调用 OnFinish
+	cb := &recordCallback{}
+	_, err := u.chatStream(ccontext.WithSendMessageCallback(context.Background(), cb), backend, chatStreamRequest())
+	if !errors.Is(err, sdkerrs.ErrNetwork) || len(cb.deltas) != 1 || cb.finished != 0 {
+		t.Fatalf("expect a network error without OnFinish, got %v %+v", err, cb)
+	}
+}
+
+func TestChatStream_CallbackType(t *testing.T) {
+	u := &LoginMgr{info: &ccontext.GlobalConfig{}}
+	if _, err := u.chatStream(context.Background(), &streamChat{}, chatStreamRequest()); !errors.Is(err, sdkerrs.ErrArgs) {
+		t.Fatalf("expect an args error without a ChatStreamCallBack, got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package sdk
+
+import (
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/ai/document"
+	"context"
+)
+
+// documentBudgetDivisor 上传文件的文本最多占用模型上下文窗口的 1/documentBudgetDivisor
+const documentBudgetDivisor = 2
+
+// expandDocuments 把对话中上传的文件替换为文件中的文本，需要在 Fix 之前调用，保证文件内容也受上下文长度限制
+func (u *LoginMgr) expandDocuments(ctx context.Context, backend chat.Chat, req chat.Request) chat.Request {
+	if u.documents == nil || !document.HasFiles(req.Messages) {
+		return req
+	}
+
+	req.Messages = u.documents.Expand(ctx, req.Messages, req.ActualModel(), backend.MaxContextLength(req.ActualModel())/documentBudgetDivisor)
+	return req
+}
+
+// expandCachedDocuments 和 expandDocuments 相同，但是只使用已经缓存的文件内容，预估请求时不下载文件
+func (u *LoginMgr) expandCachedDocuments(ctx context.Context, backend chat.Chat, req chat.Request) chat.Request {
+	if u.documents == nil || !document.HasFiles(req.Messages) {
+		return req
+	}
+
+	req.Messages = u.documents.ExpandCached(ctx, req.Messages, req.ActualModel(), backend.MaxContextLength(req.ActualModel())/documentBudgetDivisor)
+	return req
+}
@@ -0,0 +1,79 @@
+package sdk
+
+import (
+	openai2 "accompany-sdk/ai/openai"
+	"accompany-sdk/pkg/sdkerrs"
+	"accompany-sdk/sdk_callback"
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/openimsdk/tools/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+// EmbeddingRequest 生成文本向量的请求
+type EmbeddingRequest struct {
+	// Model 向量模型，为空时使用 text-embedding-3-small
+	Model string   `json:"model,omitempty"`
+	Input []string `json:"input"`
+	// Dimensions 向量维度，为 0 时使用模型的默认维度，只有 text-embedding-3 及之后的模型支持
+	Dimensions int `json:"dimensions,omitempty"`
+}
+
+// EmbeddingResponse 生成文本向量的结果
+type EmbeddingResponse struct {
+	Model string `json:"model"`
+	// Vectors 和 Input 一一对应的向量
+	Vectors      [][]float32 `json:"vectors"`
+	PromptTokens int         `json:"prompt_tokens"`
+	TotalTokens  int         `json:"total_tokens"`
+}
+
+// CreateEmbeddings 生成文本向量，req 为 JSON 格式的 EmbeddingRequest，通过 OnSuccess 返回 EmbeddingResponse
+// 客户端可以使用返回的向量实现自己内容的语义检索
+func CreateEmbeddings(callback sdk_callback.Base, operationID string, req string) {
+	call(callback, operationID, UserForSDK.CreateEmbeddings, req)
+}
+
+// CreateEmbeddings 使用 OpenAI 生成文本向量，输入超出单次请求的限制时自动分批请求
+func (u *LoginMgr) CreateEmbeddings(ctx context.Context, req EmbeddingRequest) (*EmbeddingResponse, error) {
+	if !u.info.SDKConfig.AiConfig.EnableOpenAI {
+		return nil, sdkerrs.ErrArgs.WithDetail("openai is not enabled")
+	}
+
+	if len(req.Input) == 0 {
+		return nil, sdkerrs.ErrArgs.WithDetail("input is empty")
+	}
+
+	if req.Model == "" {
+		req.Model = openai2.DefaultEmbeddingModel
+	}
+
+	res, err := u.openAi.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input:      req.Input,
+		Model:      openai.EmbeddingModel(req.Model),
+		Dimensions: req.Dimensions,
+	})
+	if err != nil {
+		log.ZError(ctx, "create embeddings failed", err, "model", req.Model, "inputs", len(req.Input))
+		if errors.Is(err, openai2.ErrEmbeddingInputTooLong) {
+			return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+		}
+
+		return nil, sdkerrs.ErrNetwork.WithDetail(err.Error())
+	}
+
+	sort.Slice(res.Data, func(i, j int) bool { return res.Data[i].Index < res.Data[j].Index })
+	vectors := make([][]float32, len(res.Data))
+	for i, item := range res.Data {
+		vectors[i] = item.Embedding
+	}
+
+	return &EmbeddingResponse{
+		Model:        string(res.Model),
+		Vectors:      vectors,
+		PromptTokens: res.Usage.PromptTokens,
+		TotalTokens:  res.Usage.TotalTokens,
+	}, nil
+}
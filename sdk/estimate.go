@@ -0,0 +1,34 @@
+package sdk
+
+import (
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/pkg/sdkerrs"
+	"accompany-sdk/sdk_callback"
+	"context"
+)
+
+// EstimateRequest 预估对话请求的 token 数量以及费用，req 为 JSON 格式的 chat.Request，通过 OnSuccess 返回 chat.Estimate
+func EstimateRequest(callback sdk_callback.Base, operationID string, req string) {
+	call(callback, operationID, UserForSDK.EstimateRequest, req)
+}
+
+// EstimateRequest 预估对话请求的 token 数量以及费用，和实际发送时使用相同的上下文缩减参数
+// 预估没有副作用：只使用已经缓存的文件内容，不下载文件，也不调用向量接口检索知识库，检索注入的资料不计入预估
+func (u *LoginMgr) EstimateRequest(ctx context.Context, req chat.Request) (*chat.Estimate, error) {
+	if len(req.Messages) == 0 {
+		return nil, sdkerrs.ErrArgs.WithDetail("messages is empty")
+	}
+
+	if _, _, _, err := u.aiChat.Resolve(req.ActualModel()); err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+	}
+
+	backend := u.chatBackend()
+	req = u.expandCachedDocuments(ctx, backend, req)
+	est, err := req.Estimate(ctx, backend, u.summarizer, maxChatContextLength, backend.MaxContextLength(req.ActualModel()))
+	if err != nil {
+		return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+	}
+
+	return est, nil
+}
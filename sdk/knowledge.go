@@ -0,0 +1,149 @@
+package sdk
+
+import (
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/ai/document"
+	"accompany-sdk/ai/rag"
+	"accompany-sdk/pkg/sdkerrs"
+	"accompany-sdk/pkg/ternary"
+	"accompany-sdk/sdk_callback"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/openimsdk/tools/log"
+)
+
+// ImportKnowledgeDocument 下载文件并导入到本地知识库，name 为空时使用文件地址中的文件名，通过 OnSuccess 返回 rag.Document
+func ImportKnowledgeDocument(callback sdk_callback.Base, operationID string, name string, fileURL string) {
+	call(callback, operationID, UserForSDK.ImportKnowledgeDocument, name, fileURL)
+}
+
+// ImportKnowledgeText 把文本作为一个文档导入到本地知识库，通过 OnSuccess 返回 rag.Document
+func ImportKnowledgeText(callback sdk_callback.Base, operationID string, name string, text string) {
+	call(callback, operationID, UserForSDK.ImportKnowledgeText, name, text)
+}
+
+// ListKnowledgeDocuments 获取本地知识库中的全部文档
+func ListKnowledgeDocuments(callback sdk_callback.Base, operationID string) {
+	call(callback, operationID, UserForSDK.ListKnowledgeDocuments)
+}
+
+// DeleteKnowledgeDocument 从本地知识库中删除文档
+func DeleteKnowledgeDocument(callback sdk_callback.Base, operationID string, documentID string) {
+	call(callback, operationID, UserForSDK.DeleteKnowledgeDocument, documentID)
+}
+
+// initKnowledge 打开用户的本地知识库，知识库保存在 DataDir/knowledge/<userID> 目录中，需要启用 OpenAI 生成向量
+func (u *LoginMgr) initKnowledge(ctx context.Context, userID string) {
+	u.knowledge = nil
+
+	aiConf := &u.info.SDKConfig.AiConfig
+	if !aiConf.EnableKnowledge {
+		return
+	}
+
+	if !aiConf.EnableOpenAI || u.info.SDKConfig.DataDir == "" {
+		log.ZWarn(ctx, "knowledge base requires openai and data dir", nil, "data_dir", u.info.SDKConfig.DataDir)
+		return
+	}
+
+	store, err := rag.Open(
+		filepath.Join(u.info.SDKConfig.DataDir, "knowledge", userID),
+		rag.NewOpenAIEmbedder(u.openAi, aiConf.EmbeddingModel),
+		rag.DefaultChunkTokens,
+	)
+	if err != nil {
+		log.ZError(ctx, "open knowledge base failed", err, "user_id", userID)
+		return
+	}
+
+	u.knowledge = store
+	log.ZInfo(ctx, "knowledge base enabled", "documents", len(store.Documents()))
+}
+
+// ImportKnowledgeDocument 下载文件、提取文本并导入到本地知识库
+func (u *LoginMgr) ImportKnowledgeDocument(ctx context.Context, name string, fileURL string) (*rag.Document, error) {
+	if fileURL == "" {
+		return nil, sdkerrs.ErrArgs.WithDetail("file url is empty")
+	}
+
+	file := &chat.FileURL{URL: fileURL, Name: name}
+	text, err := document.Fetch(ctx, buildHTTPClient(&u.info.SDKConfig.AiConfig, true), file)
+	if err != nil {
+		if errors.Is(err, document.ErrUnsupportedType) || errors.Is(err, document.ErrFileTooLarge) {
+			return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+		}
+
+		return nil, sdkerrs.ErrNetwork.WithDetail(err.Error())
+	}
+
+	return u.ImportKnowledgeText(ctx, document.FileName(file), text)
+}
+
+// ImportKnowledgeText 把文本作为一个文档导入到本地知识库
+func (u *LoginMgr) ImportKnowledgeText(ctx context.Context, name string, text string) (*rag.Document, error) {
+	if u.knowledge == nil {
+		return nil, sdkerrs.ErrArgs.WithDetail("knowledge base is not enabled")
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return nil, sdkerrs.ErrArgs.WithDetail("text is empty")
+	}
+
+	doc, err := u.knowledge.Import(ctx, name, text)
+	if err != nil {
+		log.ZError(ctx, "import knowledge document failed", err, "name", name)
+		if errors.Is(err, rag.ErrModelMismatch) || errors.Is(err, rag.ErrEmptyDocument) {
+			return nil, sdkerrs.ErrArgs.WithDetail(err.Error())
+		}
+
+		return nil, sdkerrs.ErrNetwork.WithDetail(err.Error())
+	}
+
+	return doc, nil
+}
+
+// ListKnowledgeDocuments 获取本地知识库中的全部文档，未启用知识库时返回空列表
+func (u *LoginMgr) ListKnowledgeDocuments(_ context.Context) ([]rag.Document, error) {
+	if u.knowledge == nil {
+		return []rag.Document{}, nil
+	}
+
+	return u.knowledge.Documents(), nil
+}
+
+// DeleteKnowledgeDocument 从本地知识库中删除文档
+func (u *LoginMgr) DeleteKnowledgeDocument(_ context.Context, documentID string) error {
+	if u.knowledge == nil {
+		return sdkerrs.ErrArgs.WithDetail("knowledge base is not enabled")
+	}
+
+	if err := u.knowledge.Delete(documentID); err != nil {
+		if errors.Is(err, rag.ErrDocumentNotFound) {
+			return sdkerrs.ErrArgs.WithDetail(err.Error())
+		}
+
+		return sdkerrs.ErrSdkInternal.WithDetail(err.Error())
+	}
+
+	return nil
+}
+
+// retrieve 从本地知识库中检索和用户问题相关的资料注入到对话中，需要在 Fix 之前调用，保证资料也受上下文长度限制
+// 检索失败时不影响对话，返回原请求
+func (u *LoginMgr) retrieve(ctx context.Context, req chat.Request) (chat.Request, []chat.Citation) {
+	topK := ternary.If(req.RetrievalTopK != 0, req.RetrievalTopK, u.info.SDKConfig.AiConfig.KnowledgeTopK)
+	if u.knowledge == nil || topK <= 0 {
+		return req, nil
+	}
+
+	augmented, citations, err := u.knowledge.Augment(ctx, req, topK)
+	if err != nil {
+		log.ZWarn(ctx, "retrieve knowledge failed", err, "top_k", topK)
+		return req, nil
+	}
+
+	return augmented, citations
+}
@@ -0,0 +1,37 @@
+package sdk
+
+import (
+	openai2 "accompany-sdk/ai/openai"
+	"accompany-sdk/sdk_callback"
+	"context"
+	"encoding/json"
+)
+
+// ProviderStatus AI 服务提供商的状态
+type ProviderStatus struct {
+	// Keys OpenAI 主服务、备用服务以及 DALL·E 配置中所有密钥的状态
+	Keys []openai2.KeyStatus `json:"keys"`
+}
+
+// SetProviderStatusListener 设置服务提供商状态监听，密钥被禁用、暂停或者恢复使用时回调，listener 为 nil 时取消监听
+func SetProviderStatusListener(listener sdk_callback.OnProviderStatusListener) {
+	if listener == nil {
+		openai2.SetKeyStateListener(nil)
+		return
+	}
+
+	openai2.SetKeyStateListener(func(status openai2.KeyStatus) {
+		data, _ := json.Marshal(status)
+		listener.OnKeyStateChanged(string(data))
+	})
+}
+
+// GetProviderStatus 获取服务提供商的状态，通过 OnSuccess 返回 ProviderStatus，用于排查哪些密钥已经不可用
+func GetProviderStatus(callback sdk_callback.Base, operationID string) {
+	call(callback, operationID, UserForSDK.GetProviderStatus)
+}
+
+// GetProviderStatus 返回所有密钥的状态，密钥只保留前后 4 位
+func (u *LoginMgr) GetProviderStatus(_ context.Context) (*ProviderStatus, error) {
+	return &ProviderStatus{Keys: openai2.KeyStatuses()}, nil
+}
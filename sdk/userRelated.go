@@ -1,14 +1,31 @@
 package sdk
 
 import (
+	"accompany-sdk/ai/anthropic"
+	"accompany-sdk/ai/baidu"
+	"accompany-sdk/ai/catalog"
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/ai/document"
+	"accompany-sdk/ai/local"
 	"accompany-sdk/ai/openai"
+	"accompany-sdk/ai/rag"
+	"accompany-sdk/ai/zhipu"
+	"accompany-sdk/ai_struct"
 	"accompany-sdk/internal/user"
+	"accompany-sdk/pkg/ai/fallback"
+	"accompany-sdk/pkg/bpe"
 	"accompany-sdk/pkg/ccontext"
+	"accompany-sdk/pkg/misc"
+	"accompany-sdk/pkg/proxy"
 	"accompany-sdk/pkg/sdkerrs"
+	"accompany-sdk/pkg/ternary"
+	"accompany-sdk/pkg/utils/array"
 	"accompany-sdk/sdk_callback"
 	"accompany-sdk/sdk_struct"
 	"context"
+	"fmt"
 	"github.com/openimsdk/tools/log"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -56,7 +73,23 @@ type LoginMgr struct {
 	info      *ccontext.GlobalConfig
 	id2MinSeq map[string]int64
 
-	openAi openai.OpenAi
+	openAi       openai.OpenAi
+	baiduAI      baidu.BaiduAI
+	baiduImageAI *baidu.BaiduImageAI
+	aiChat       *chat.Router
+	// fallbackChat 对话降级链，未配置降级链时为 nil
+	fallbackChat *chat.FallbackChain
+	// documents 把对话中上传的文件转换为文本
+	documents *document.Pipeline
+	// summarizer 对话上下文超出限制时生成历史消息摘要，未配置摘要模型且未启用 OpenAI 时为 nil
+	summarizer *chat.ContextSummarizer
+	// knowledge 用户的本地知识库，未启用时为 nil
+	knowledge *rag.Store
+
+	// agentTools 由 Go 代码注册的 Agent 工具，工具名称 -> agent.Tool
+	agentTools sync.Map
+	// toolResults 等待宿主提交结果的工具调用，工具调用 ID -> chan toolResult
+	toolResults sync.Map
 }
 
 func (u *LoginMgr) getLoginStatus(_ context.Context) int {
@@ -83,6 +116,9 @@ func (u *LoginMgr) InitSDK(config sdk_struct.SDKConfig, listener sdk_callback.On
 	u.info.SDKConfig = config
 	u.connListener = listener
 
+	// 使用离线的 BPE 数据计算 token，数据目录中存在 BPE 文件时优先使用
+	bpe.Register(config.DataDir)
+
 	ctx := ccontext.WithInfo(context.Background(), u.info)
 	u.ctx, u.cancel = context.WithCancel(ctx)
 	u.setLoginStatus(Logged)
@@ -92,11 +128,200 @@ func (u *LoginMgr) InitSDK(config sdk_struct.SDKConfig, listener sdk_callback.On
 func (u *LoginMgr) Login(ctx context.Context, userID, token string) error {
 	u.setLoginStatus(Logged)
 	u.user = user.NewUser(userID)
-	u.openAi = openai.NewOpenAi(&u.info.SDKConfig.AiConfig.OpenAiConfig)
+	u.initAI(ctx)
+	u.initKnowledge(ctx, userID)
 	log.ZInfo(ctx, "login success...", "login cost time: ", time.Since(time.Now()))
 	return nil
 }
 
+// initAI 根据配置初始化各个 AI 服务提供商，并注册到对话路由中
+func (u *LoginMgr) initAI(ctx context.Context) {
+	aiConf := &u.info.SDKConfig.AiConfig
+	catalog.Register(array.Map(aiConf.CatalogConfig.Models, func(item ai_struct.ModelConfig, _ int) catalog.Model {
+		return catalog.FromConfig(item)
+	})...)
+
+	u.openAi = openai.NewOpenAi(&aiConf.OpenAiConfig)
+	u.aiChat = chat.NewRouter().
+		Register(chat.ProviderOpenAI, chat.NewOpenAIChat(u.openAi), "openai:").
+		SetDefault(chat.ProviderOpenAI)
+	chat.SetTokenizerRouter(u.aiChat)
+
+	if aiConf.EnableBaiduWXAI {
+		u.baiduAI = baidu.NewBaiduAI(aiConf.BaiduWXServer, aiConf.BaiduWXKey, aiConf.BaiduWXSecret)
+		u.baiduImageAI = baidu.NewBaiduImageAI(aiConf.BaiduWXServer, aiConf.BaiduWXKey, aiConf.BaiduWXSecret)
+
+		models := aiConf.BaiduWXModels
+		if len(models) == 0 {
+			models = array.Map(baidu.SupportedModels(), func(item baidu.Model, _ int) string { return string(item) })
+		}
+
+		u.aiChat.Register(chat.ProviderBaidu, chat.NewBaiduAIChat(u.baiduAI), "文心千帆:").
+			RegisterModels(chat.ProviderBaidu, models...)
+		log.ZInfo(ctx, "baidu ai enabled", "models", models)
+	}
+
+	if aiConf.EnableAnthropic {
+		u.aiChat.Register(
+			chat.ProviderAnthropic,
+			anthropic.NewAnthropicChat(anthropic.New(aiConf.AnthropicServer, aiConf.AnthropicAPIKey, buildHTTPClient(aiConf, aiConf.AnthropicAutoProxy))),
+			"anthropic:",
+		).RegisterModels(chat.ProviderAnthropic, "claude-*")
+		chat.RegisterTokenizer(chat.ProviderAnthropic, anthropic.Tokenizer{})
+		log.ZInfo(ctx, "anthropic enabled")
+	}
+
+	if aiConf.EnableZhipuAI {
+		zhipuAI, err := zhipu.New(aiConf.ZhipuAIServer, aiConf.ZhipuAIKey, nil)
+		if err != nil {
+			log.ZError(ctx, "init zhipu ai failed", err)
+		} else {
+			u.aiChat.Register(chat.ProviderZhipu, zhipu.NewZhipuChat(zhipuAI), "zhipu:").
+				RegisterModels(chat.ProviderZhipu, "glm-*")
+			chat.RegisterTokenizer(chat.ProviderZhipu, zhipu.Tokenizer{})
+			log.ZInfo(ctx, "zhipu ai enabled")
+		}
+	}
+
+	for _, conf := range aiConf.LocalProviders {
+		if conf.Name == "" || conf.Server == "" || u.aiChat.Has(conf.Name) {
+			log.ZWarn(ctx, "invalid local provider config", nil, "name", conf.Name, "server", conf.Server)
+			continue
+		}
+
+		provider := local.New(conf, buildHTTPClient(aiConf, conf.AutoProxy))
+		u.aiChat.Register(conf.Name, provider, provider.Prefix())
+		u.registerLocalModels(provider)
+		log.ZInfo(ctx, "local provider enabled", "name", conf.Name, "models", provider.ModelIDs())
+
+		go u.discoverLocalModels(provider, conf)
+	}
+
+	u.initFallback(ctx)
+
+	u.documents = document.NewPipeline(document.DefaultChunkTokens, document.DefaultCacheSize, buildHTTPClient(aiConf, true))
+	u.initSummarizer(ctx)
+}
+
+// localDiscoverTimeout 本地模型服务发现的超时时间
+const localDiscoverTimeout = 10 * time.Second
+
+// discoverLocalModels 在后台获取本地模型服务的模型列表并注册，服务不可达时不阻塞登录，只使用静态配置的模型
+func (u *LoginMgr) discoverLocalModels(provider *local.Provider, conf ai_struct.LocalProvider) {
+	ctx, cancel := context.WithTimeout(u.ctx, localDiscoverTimeout)
+	defer cancel()
+
+	if _, err := provider.Discover(ctx); err != nil {
+		log.ZWarn(ctx, "discover local provider models failed", err, "name", conf.Name, "server", conf.Server)
+		return
+	}
+
+	u.registerLocalModels(provider)
+	log.ZInfo(ctx, "local provider models discovered", "name", conf.Name, "models", provider.ModelIDs())
+}
+
+// registerLocalModels 将本地模型服务已知的模型注册到对话路由以及模型目录中，
+// 模型发现后会用服务返回的上下文长度更新之前由该服务注册的模型，不覆盖其它来源的模型：
+// 模型目录中属于其它服务提供商的模型（如本地服务也提供的 gpt-4o）只能通过前缀使用本地服务，不接管原来的路由
+func (u *LoginMgr) registerLocalModels(provider *local.Provider) {
+	for _, m := range provider.Models() {
+		if existing, ok := catalog.Lookup(m.ID); ok && existing.Provider != provider.Name() {
+			continue
+		}
+
+		u.aiChat.RegisterModels(provider.Name(), m.ID)
+		catalog.Register(catalog.Model{ID: m.ID, Provider: provider.Name(), ContextWindow: provider.MaxContextLength(m.ID), SystemMessage: true})
+	}
+}
+
+// initFallback 根据配置创建对话降级链，模型无法解析的层级会被忽略
+func (u *LoginMgr) initFallback(ctx context.Context) {
+	u.fallbackChat = nil
+
+	var tiers []chat.FallbackTier
+	for i, conf := range u.info.SDKConfig.AiConfig.FallbackTiers {
+		if conf.Model != "" {
+			if _, _, _, err := u.aiChat.Resolve(conf.Model); err != nil {
+				log.ZWarn(ctx, "fallback tier model is not available", err, "tier", conf.Name, "model", conf.Model)
+				continue
+			}
+		}
+
+		tiers = append(tiers, chat.FallbackTier{
+			Tier: fallback.Tier{
+				Name:          ternary.If(conf.Name != "", conf.Name, fmt.Sprintf("tier-%d", i+1)),
+				FallbackOn:    conf.FallbackOn,
+				LatencyBudget: time.Duration(conf.LatencyBudget) * time.Millisecond,
+			},
+			Model: conf.Model,
+		})
+	}
+
+	if len(tiers) == 0 {
+		return
+	}
+
+	u.fallbackChat = chat.NewFallbackChain(u.aiChat, tiers...)
+	log.ZInfo(ctx, "chat fallback chain enabled", "tiers", array.Map(tiers, func(item chat.FallbackTier, _ int) string { return item.Name }))
+}
+
+// chatBackend 对话使用的服务，配置了降级链时使用降级链，否则直接根据模型名称选择服务提供商
+func (u *LoginMgr) chatBackend() chat.Chat {
+	if u.fallbackChat != nil {
+		return u.fallbackChat
+	}
+
+	return u.aiChat
+}
+
+// initSummarizer 初始化对话上下文摘要，优先使用配置的摘要模型，否则使用 OpenAI 的 QuickAsk
+func (u *LoginMgr) initSummarizer(ctx context.Context) {
+	aiConf := &u.info.SDKConfig.AiConfig
+	maxTokens := ternary.If(aiConf.SummaryMaxTokens > 0, aiConf.SummaryMaxTokens, chat.DefaultSummaryMaxTokens)
+
+	var summarizer chat.Summarizer
+	switch {
+	case aiConf.SummaryModel != "":
+		if _, _, _, err := u.aiChat.Resolve(aiConf.SummaryModel); err != nil {
+			log.ZWarn(ctx, "summary model is not available", err, "model", aiConf.SummaryModel)
+			return
+		}
+
+		summarizer = chat.NewChatSummarizer(u.aiChat, aiConf.SummaryModel, maxTokens)
+	case aiConf.EnableOpenAI:
+		// QuickAsk 使用 gpt-3.5-turbo，对话按照它的上下文长度截断
+		limit := chat.SummaryInputLimit(catalog.ContextWindow("gpt-3.5-turbo", 3500), maxTokens)
+		summarizer = chat.SummarizerFunc(func(ctx context.Context, messages chat.Messages) (string, error) {
+			return u.openAi.QuickAsk(ctx, chat.SummaryPrompt, chat.TranscriptWithin(messages, "gpt-3.5-turbo", limit), maxTokens)
+		})
+	default:
+		return
+	}
+
+	u.summarizer = chat.NewContextSummarizer(summarizer, maxTokens, aiConf.SummaryCacheSize)
+	log.ZInfo(ctx, "context summarizer enabled", "model", aiConf.SummaryModel, "max_tokens", maxTokens)
+}
+
+// buildHTTPClient 创建访问 AI 服务的 HTTP 客户端，autoProxy 为 true 时使用配置的代理
+// 流式响应可能持续数分钟，只限制建立连接和等待响应头的时间，读取过程由请求的上下文控制
+func buildHTTPClient(aiConf *ai_struct.AiConfig, autoProxy bool) *http.Client {
+	if autoProxy && proxy.ShouldLoad(&aiConf.ProxyConfig) {
+		if pp := proxy.NewProxy(&aiConf.ProxyConfig); pp != nil {
+			return misc.StreamHTTPClient(pp.BuildTransport())
+		}
+	}
+
+	return misc.StreamHTTPClient(nil)
+}
+
 func (u *LoginMgr) OpenAi() openai.OpenAi {
 	return u.openAi
 }
+
+func (u *LoginMgr) Chat() *chat.Router {
+	return u.aiChat
+}
+
+func (u *LoginMgr) BaiduImageAI() *baidu.BaiduImageAI {
+	return u.baiduImageAI
+}
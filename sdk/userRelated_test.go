@@ -0,0 +1,42 @@
+package sdk
+
+import (
+	"accompany-sdk/ai/catalog"
+	"accompany-sdk/ai/chat"
+	"accompany-sdk/ai/local"
+	"accompany-sdk/ai_struct"
+	"testing"
+)
+
+func TestRegisterLocalModels(t *testing.T) {
+	openai := chat.NewOpenAIChat(nil)
+	provider := local.New(ai_struct.LocalProvider{Name: "test-local", Server: "http://127.0.0.1:1/v1", Models: []string{"gpt-4", "test-local-llama"}}, nil)
+
+	u := &LoginMgr{aiChat: chat.NewRouter().
+		Register(chat.ProviderOpenAI, openai, "openai:").
+		Register(provider.Name(), provider, provider.Prefix()).
+		SetDefault(chat.ProviderOpenAI)}
+	u.registerLocalModels(provider)
+
+	// 本地服务也提供的 gpt-4 仍然由 OpenAI 处理，只能通过前缀使用本地服务
+	cases := map[string]string{
+		"gpt-4":                   chat.ProviderOpenAI,
+		"test-local:gpt-4":        provider.Name(),
+		"test-local-llama":        provider.Name(),
+		"test-local:unknown-name": provider.Name(),
+	}
+
+	for model, expect := range cases {
+		if name, _, _, err := u.aiChat.Resolve(model); err != nil || name != expect {
+			t.Errorf("%s: expect %s, got %s %v", model, expect, name, err)
+		}
+	}
+
+	if m, ok := catalog.Lookup("gpt-4"); !ok || m.Provider != catalog.ProviderOpenAI {
+		t.Errorf("catalog entry of gpt-4 should not be overridden, got %+v", m)
+	}
+
+	if m, ok := catalog.Lookup("test-local-llama"); !ok || m.Provider != provider.Name() {
+		t.Errorf("local model should be registered in the catalog, got %+v", m)
+	}
+}
@@ -9,6 +9,24 @@ type SendMsgCallBack interface {
 	OnProgress(progress int)
 }
 
+// ChatStreamCallBack 流式对话回调，OnSuccess 返回完整的对话结果
+type ChatStreamCallBack interface {
+	SendMsgCallBack
+	// OnDelta 收到增量内容，delta 为 JSON 格式的 chat.Response
+	OnDelta(delta string)
+	// OnFinish 对话结束，返回结束原因以及 token 用量
+	OnFinish(finishReason string, inputTokens int32, outputTokens int32)
+}
+
+// AgentCallBack Agent 运行回调，OnSuccess 返回 JSON 格式的 agent.Result
+type AgentCallBack interface {
+	SendMsgCallBack
+	// OnStep 每次模型调用或者工具调用完成后回调，step 为 JSON 格式的 agent.Step
+	OnStep(step string)
+	// OnToolCall 模型调用了宿主注册的工具，宿主执行完成后需要通过 SubmitToolResult 提交结果
+	OnToolCall(toolCallID string, name string, arguments string)
+}
+
 type OnConnListener interface {
 	OnConnecting()
 	OnConnectSuccess()
@@ -16,3 +34,9 @@ type OnConnListener interface {
 	OnKickedOffline()
 	OnUserTokenExpired()
 }
+
+// OnProviderStatusListener AI 服务提供商状态监听
+type OnProviderStatusListener interface {
+	// OnKeyStateChanged 密钥被禁用、暂停或者恢复使用，status 为 JSON 格式的 openai.KeyStatus
+	OnKeyStateChanged(status string)
+}
@@ -29,4 +29,18 @@ func registerFunc() {
 	wrapperInit := wasm_wrapper.NewWrapperInit(globalFuc)
 	js.Global().Set("initSDK", js.FuncOf(wrapperInit.InitSDK))
 	js.Global().Set("login", js.FuncOf(wrapperInit.Login))
+	js.Global().Set("chatStream", js.FuncOf(wrapperInit.ChatStream))
+	js.Global().Set("baiduChat", js.FuncOf(wrapperInit.BaiduChat))
+	js.Global().Set("baiduChatStream", js.FuncOf(wrapperInit.BaiduChatStream))
+	js.Global().Set("listModels", js.FuncOf(wrapperInit.ListModels))
+	js.Global().Set("estimateRequest", js.FuncOf(wrapperInit.EstimateRequest))
+	js.Global().Set("runAgent", js.FuncOf(wrapperInit.RunAgent))
+	js.Global().Set("submitToolResult", js.FuncOf(wrapperInit.SubmitToolResult))
+	js.Global().Set("importKnowledgeDocument", js.FuncOf(wrapperInit.ImportKnowledgeDocument))
+	js.Global().Set("importKnowledgeText", js.FuncOf(wrapperInit.ImportKnowledgeText))
+	js.Global().Set("listKnowledgeDocuments", js.FuncOf(wrapperInit.ListKnowledgeDocuments))
+	js.Global().Set("deleteKnowledgeDocument", js.FuncOf(wrapperInit.DeleteKnowledgeDocument))
+	js.Global().Set("createEmbeddings", js.FuncOf(wrapperInit.CreateEmbeddings))
+	js.Global().Set("setProviderStatusListener", js.FuncOf(wrapperInit.SetProviderStatusListener))
+	js.Global().Set("getProviderStatus", js.FuncOf(wrapperInit.GetProviderStatus))
 }
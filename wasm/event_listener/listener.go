@@ -38,6 +38,19 @@ func (i *ConnCallback) OnSelfInfoUpdated(userInfo string) {
 	i.CallbackWriter.SetEvent(utils.GetSelfFuncName()).SetData(userInfo).SendMessage()
 }
 
+// ProviderStatusCallback 服务提供商状态监听，密钥状态变化以事件的形式推送
+type ProviderStatusCallback struct {
+	CallbackWriter
+}
+
+func NewProviderStatusCallback(funcName string, callback *js.Value) *ProviderStatusCallback {
+	return &ProviderStatusCallback{CallbackWriter: NewEventData(callback).SetEvent(funcName)}
+}
+
+func (p *ProviderStatusCallback) OnKeyStateChanged(status string) {
+	p.CallbackWriter.SetEvent(utils.GetSelfFuncName()).SetData(status).SendMessage()
+}
+
 type BaseCallback struct {
 	CallbackWriter
 }
@@ -56,3 +69,57 @@ func (b *BaseCallback) OnError(errCode int32, errMsg string) {
 func (b *BaseCallback) OnSuccess(data string) {
 	b.CallbackWriter.SetData(data).SendMessage()
 }
+
+// ChatStreamCallback 流式对话回调，增量内容以事件的形式推送，最终结果通过 Promise 返回
+type ChatStreamCallback struct {
+	*BaseCallback
+	event CallbackWriter
+}
+
+func NewChatStreamCallback(funcName string, callback *js.Value) *ChatStreamCallback {
+	return &ChatStreamCallback{
+		BaseCallback: NewBaseCallback(funcName, callback),
+		event:        NewEventData(callback).SetEvent(funcName),
+	}
+}
+
+func (c *ChatStreamCallback) OnProgress(progress int) {}
+
+func (c *ChatStreamCallback) OnDelta(delta string) {
+	c.event.SetEvent(utils.GetSelfFuncName()).SetOperationID(c.GetOperationID()).SetData(delta).SendMessage()
+}
+
+func (c *ChatStreamCallback) OnFinish(finishReason string, inputTokens int32, outputTokens int32) {
+	c.event.SetEvent(utils.GetSelfFuncName()).SetOperationID(c.GetOperationID()).SetData(map[string]any{
+		"finishReason": finishReason,
+		"inputTokens":  inputTokens,
+		"outputTokens": outputTokens,
+	}).SendMessage()
+}
+
+// AgentCallback Agent 运行回调，步骤和宿主工具调用以事件的形式推送，最终结果通过 Promise 返回
+type AgentCallback struct {
+	*BaseCallback
+	event CallbackWriter
+}
+
+func NewAgentCallback(funcName string, callback *js.Value) *AgentCallback {
+	return &AgentCallback{
+		BaseCallback: NewBaseCallback(funcName, callback),
+		event:        NewEventData(callback).SetEvent(funcName),
+	}
+}
+
+func (c *AgentCallback) OnProgress(progress int) {}
+
+func (c *AgentCallback) OnStep(step string) {
+	c.event.SetEvent(utils.GetSelfFuncName()).SetOperationID(c.GetOperationID()).SetData(step).SendMessage()
+}
+
+func (c *AgentCallback) OnToolCall(toolCallID string, name string, arguments string) {
+	c.event.SetEvent(utils.GetSelfFuncName()).SetOperationID(c.GetOperationID()).SetData(map[string]any{
+		"toolCallID": toolCallID,
+		"name":       name,
+		"arguments":  arguments,
+	}).SendMessage()
+}
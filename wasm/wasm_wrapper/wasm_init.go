@@ -52,3 +52,73 @@ func (w *WrapperInit) AskOpenAi(_ js.Value, args []js.Value) interface{} {
 	callback := event_listener.NewBaseCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
 	return event_listener.NewCaller(sdk.AskOpenAi, callback, &args).AsyncCallWithCallback()
 }
+
+func (w *WrapperInit) ChatStream(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewChatStreamCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.ChatStream, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) BaiduChat(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewBaseCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.BaiduChat, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) BaiduChatStream(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewChatStreamCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.BaiduChatStream, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) ListModels(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewBaseCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.ListModels, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) EstimateRequest(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewBaseCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.EstimateRequest, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) RunAgent(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewAgentCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.RunAgent, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) SubmitToolResult(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewBaseCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.SubmitToolResult, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) ImportKnowledgeDocument(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewBaseCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.ImportKnowledgeDocument, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) ImportKnowledgeText(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewBaseCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.ImportKnowledgeText, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) ListKnowledgeDocuments(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewBaseCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.ListKnowledgeDocuments, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) DeleteKnowledgeDocument(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewBaseCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.DeleteKnowledgeDocument, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) CreateEmbeddings(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewBaseCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.CreateEmbeddings, callback, &args).AsyncCallWithCallback()
+}
+
+func (w *WrapperInit) SetProviderStatusListener(_ js.Value, _ []js.Value) interface{} {
+	sdk.SetProviderStatusListener(event_listener.NewProviderStatusCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc))
+	return js.ValueOf(true)
+}
+
+func (w *WrapperInit) GetProviderStatus(_ js.Value, args []js.Value) interface{} {
+	callback := event_listener.NewBaseCallback(utils.FirstLower(utils.GetSelfFuncName()), w.commonFunc)
+	return event_listener.NewCaller(sdk.GetProviderStatus, callback, &args).AsyncCallWithCallback()
+}